@@ -36,9 +36,11 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"os"
 	"sort"
 	"strconv"
+	"strings"
 )
 
 type SynapseStats struct {
@@ -54,11 +56,27 @@ type TracingStats struct {
 	TracedLeaves  int
 }
 
+// addResult counts a tracing result.
+func (stats *TracingStats) addResult(result TracingResult) {
+	if result == Leaves {
+		stats.TracedLeaves++
+	} else if result == Orphan {
+		stats.TracedOrphans++
+	} else if result >= MinAnchor {
+		stats.TracedAnchors++
+	}
+}
+
+// ResultsPercentage returns the percent of tracings ending in anchors,
+// orphans and leaves, or zeros if there are no tracings.
 func (stats TracingStats) ResultsPercentage() (
 	percentAnchored, percentOrphans, percentLeaves float32) {
 
 	totalTracings := float32(stats.TracedAnchors + stats.TracedOrphans +
 		stats.TracedLeaves)
+	if totalTracings == 0 {
+		return
+	}
 	percentAnchored = 100.0 * float32(stats.TracedAnchors) / totalTracings
 	percentOrphans = 100.0 * float32(stats.TracedOrphans) / totalTracings
 	percentLeaves = 100.0 * float32(stats.TracedLeaves) / totalTracings
@@ -77,6 +95,85 @@ func (stats TracingStats) Print() {
 		stats.TracedLeaves)
 }
 
+// Add returns the sum of two tracing stats.
+func (stats TracingStats) Add(other TracingStats) TracingStats {
+	return TracingStats{
+		TracedTbars:   stats.TracedTbars + other.TracedTbars,
+		TracedPsds:    stats.TracedPsds + other.TracedPsds,
+		TracedAnchors: stats.TracedAnchors + other.TracedAnchors,
+		TracedOrphans: stats.TracedOrphans + other.TracedOrphans,
+		TracedLeaves:  stats.TracedLeaves + other.TracedLeaves,
+	}
+}
+
+// WriteCsv writes the tracing stats as a header and a single line.
+func (stats TracingStats) WriteCsv(writer io.Writer) {
+	csvWriter := csv.NewWriter(writer)
+	record := []string{"traced_tbars", "traced_psds", "anchored",
+		"orphans", "leaves", "pct_anchored", "pct_orphans", "pct_leaves"}
+	err := csvWriter.Write(record)
+	if err != nil {
+		log.Fatalln("ERROR: Unable to write header to CSV:", err)
+	}
+	percentAnchored, percentOrphans, percentLeaves := stats.ResultsPercentage()
+	formatPercent := func(percent float32) string {
+		return strconv.FormatFloat(float64(percent), 'f', 2, 32)
+	}
+	record = []string{
+		strconv.Itoa(stats.TracedTbars),
+		strconv.Itoa(stats.TracedPsds),
+		strconv.Itoa(stats.TracedAnchors),
+		strconv.Itoa(stats.TracedOrphans),
+		strconv.Itoa(stats.TracedLeaves),
+		formatPercent(percentAnchored),
+		formatPercent(percentOrphans),
+		formatPercent(percentLeaves),
+	}
+	if err := csvWriter.Write(record); err != nil {
+		log.Fatalln("ERROR: Unable to write line of CSV for tracing stats:",
+			err)
+	}
+	csvWriter.Flush()
+}
+
+// StatChange is the change in one count between two tracing stats.
+type StatChange struct {
+	Before  int
+	After   int
+	Delta   int
+	Percent float64 // Percent change from Before, or 0 if Before is 0
+}
+
+// newStatChange returns the change from before to after.
+func newStatChange(before, after int) StatChange {
+	change := StatChange{Before: before, After: after, Delta: after - before}
+	if before != 0 {
+		change.Percent = 100.0 * float64(change.Delta) / float64(before)
+	}
+	return change
+}
+
+// TracingStatsDiff holds the change in each count of tracing stats.
+type TracingStatsDiff struct {
+	TracedTbars   StatChange
+	TracedPsds    StatChange
+	TracedAnchors StatChange
+	TracedOrphans StatChange
+	TracedLeaves  StatChange
+}
+
+// ComputeStatsDiff returns the change in each count from the before
+// stats to the after stats, e.g., of two annotation file versions.
+func ComputeStatsDiff(before, after TracingStats) TracingStatsDiff {
+	return TracingStatsDiff{
+		TracedTbars:   newStatChange(before.TracedTbars, after.TracedTbars),
+		TracedPsds:    newStatChange(before.TracedPsds, after.TracedPsds),
+		TracedAnchors: newStatChange(before.TracedAnchors, after.TracedAnchors),
+		TracedOrphans: newStatChange(before.TracedOrphans, after.TracedOrphans),
+		TracedLeaves:  newStatChange(before.TracedLeaves, after.TracedLeaves),
+	}
+}
+
 // BodyStats describes postsynapse stats for a given body.
 type BodyStats struct {
 	NumPostSyn          int "Number of postsynaptic sites"
@@ -213,6 +310,172 @@ func (stats NamedStats) GetSortedNames() NameList {
 	return list
 }
 
+// Resolution gives the size of a voxel in nanometers along X, Y, and Z.
+type Resolution [3]float64
+
+// VoxelResolution leaves distances in voxel units.
+var VoxelResolution = Resolution{1.0, 1.0, 1.0}
+
+// BodyPolarity describes the synaptic inputs (PSDs) and outputs (T-bars)
+// of a body and where they are located.  Centroids are only meaningful
+// if the corresponding count is non-zero.
+type BodyPolarity struct {
+	Body           BodyId
+	NumTbars       int
+	NumPsds        int
+	InputCentroid  [3]float64
+	OutputCentroid [3]float64
+}
+
+// PolarityRatio returns the fraction of a body's synapses that are
+// inputs, so 1.0 is purely postsynaptic and 0.0 is purely presynaptic.
+func (polarity BodyPolarity) PolarityRatio() float64 {
+	total := polarity.NumTbars + polarity.NumPsds
+	if total == 0 {
+		return 0.0
+	}
+	return float64(polarity.NumPsds) / float64(total)
+}
+
+// CentroidDistance returns the distance between the input and output
+// centroids scaled by the given resolution.  If the body lacks either
+// inputs or outputs, found is false.
+func (polarity BodyPolarity) CentroidDistance(res Resolution) (
+	distance float64, found bool) {
+
+	if polarity.NumTbars == 0 || polarity.NumPsds == 0 {
+		return
+	}
+	var sqrDistance float64
+	for i := 0; i < 3; i++ {
+		d := (polarity.InputCentroid[i] - polarity.OutputCentroid[i]) * res[i]
+		sqrDistance += d * d
+	}
+	return math.Sqrt(sqrDistance), true
+}
+
+// Compartment is a simple dendrite/axon heuristic: "dendritic" if at
+// least the given fraction of synapses are inputs, "axonal" if at least
+// that fraction are outputs, and "mixed" otherwise.
+func (polarity BodyPolarity) Compartment(fraction float64) string {
+	ratio := polarity.PolarityRatio()
+	switch {
+	case polarity.NumTbars+polarity.NumPsds == 0:
+		return "none"
+	case ratio >= fraction:
+		return "dendritic"
+	case 1.0-ratio >= fraction:
+		return "axonal"
+	}
+	return "mixed"
+}
+
+// BodyPolarityMap maps a body id to its polarity summary
+type BodyPolarityMap map[BodyId]BodyPolarity
+
+// BodyPolarities computes per-body counts and centroids of T-bars and
+// PSDs using the body ids stored in the synapse annotation list.
+func (synapses *JsonSynapses) BodyPolarities() (polarities BodyPolarityMap) {
+	polarities = make(BodyPolarityMap)
+	addPoint := func(sum *[3]float64, pt Point3d) {
+		sum[0] += float64(pt[0])
+		sum[1] += float64(pt[1])
+		sum[2] += float64(pt[2])
+	}
+	for _, synapse := range synapses.Data {
+		polarity := polarities[synapse.Tbar.Body]
+		polarity.Body = synapse.Tbar.Body
+		polarity.NumTbars++
+		addPoint(&polarity.OutputCentroid, synapse.Tbar.Location)
+		polarities[synapse.Tbar.Body] = polarity
+		for _, psd := range synapse.Psds {
+			polarity := polarities[psd.Body]
+			polarity.Body = psd.Body
+			polarity.NumPsds++
+			addPoint(&polarity.InputCentroid, psd.Location)
+			polarities[psd.Body] = polarity
+		}
+	}
+	for bodyId, polarity := range polarities {
+		for i := 0; i < 3; i++ {
+			if polarity.NumPsds > 0 {
+				polarity.InputCentroid[i] /= float64(polarity.NumPsds)
+			}
+			if polarity.NumTbars > 0 {
+				polarity.OutputCentroid[i] /= float64(polarity.NumTbars)
+			}
+		}
+		polarities[bodyId] = polarity
+	}
+	return
+}
+
+// WriteCsv writes body polarities in CSV format sorted by body id.
+// Body names are taken from the namedBodyMap if available, and centroid
+// distances are scaled by the given resolution.
+func (polarities BodyPolarityMap) WriteCsv(writer io.Writer,
+	namedBodyMap NamedBodyMap, res Resolution) {
+
+	csvWriter := csv.NewWriter(writer)
+	record := []string{"Body ID", "Body Name", "# T-bars", "# PSDs",
+		"Polarity ratio", "Input X", "Input Y", "Input Z",
+		"Output X", "Output Y", "Output Z", "Centroid distance"}
+	err := csvWriter.Write(record)
+	if err != nil {
+		log.Fatalln("ERROR: Unable to write header to CSV:", err)
+	}
+
+	formatFloat := func(f float64) string {
+		return strconv.FormatFloat(f, 'f', 2, 64)
+	}
+	bodyList := make(BodyIdList, 0, len(polarities))
+	for bodyId, _ := range polarities {
+		bodyList = append(bodyList, bodyId)
+	}
+	sort.Sort(bodyList)
+	for _, bodyId := range bodyList {
+		polarity := polarities[bodyId]
+		record := make([]string, 12)
+		record[0] = bodyId.String()
+		if namedBody, found := namedBodyMap[bodyId]; found {
+			record[1] = namedBody.Name
+		}
+		record[2] = strconv.Itoa(polarity.NumTbars)
+		record[3] = strconv.Itoa(polarity.NumPsds)
+		record[4] = formatFloat(polarity.PolarityRatio())
+		for i := 0; i < 3; i++ {
+			if polarity.NumPsds > 0 {
+				record[5+i] = formatFloat(polarity.InputCentroid[i])
+			}
+			if polarity.NumTbars > 0 {
+				record[8+i] = formatFloat(polarity.OutputCentroid[i])
+			}
+		}
+		if distance, found := polarity.CentroidDistance(res); found {
+			record[11] = formatFloat(distance)
+		}
+		err := csvWriter.Write(record)
+		if err != nil {
+			log.Fatalln("ERROR: Unable to write line of CSV for ",
+				"body", bodyId, ":", err)
+		}
+	}
+	csvWriter.Flush()
+}
+
+// WriteCsvFile writes body polarities into a CSV file.
+func (polarities BodyPolarityMap) WriteCsvFile(filename string,
+	namedBodyMap NamedBodyMap, res Resolution) {
+
+	file, err := os.Create(filename)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to create body polarity csv file: %s [%s]\n",
+			filename, err)
+	}
+	polarities.WriteCsv(file, namedBodyMap, res)
+	file.Close()
+}
+
 // NamedBody encapsulates data for a segmented body that has enough
 // shape to distinguish its morphology as a likely cell type.
 type NamedBody struct {
@@ -278,7 +541,10 @@ func (list NamedBodyList) Swap(i, j int) {
 	list[i], list[j] = list[j], list[i]
 }
 func (list NamedBodyList) Less(i, j int) bool {
-	return list[i].Name < list[j].Name
+	if list[i].Name != list[j].Name {
+		return list[i].Name < list[j].Name
+	}
+	return list[i].Body < list[j].Body
 }
 
 // SortByName returns a list of NamedBody sorted in ascending order by body name
@@ -313,7 +579,6 @@ func (options NamedBodyOptions) bodyNameSelected(name string) bool {
 // a map from BodyID to NamedBody struct.  The first line is
 // assumed to be a header and is skipped.
 func ReadNamedBodiesCsv(params NamedBodyOptions) (namedBodyMap NamedBodyMap) {
-	namedBodyMap = make(NamedBodyMap)
 	var namedFile *os.File
 	namedFile, err := os.Open(params.Filename)
 	if err != nil {
@@ -321,13 +586,33 @@ func ReadNamedBodiesCsv(params NamedBodyOptions) (namedBodyMap NamedBodyMap) {
 			params.Filename, err)
 	}
 	defer namedFile.Close()
-	reader := csv.NewReader(namedFile)
+	namedBodyMap, err = ReadNamedBodiesCsvFrom(namedFile, params)
+	if err != nil {
+		log.Fatalf("FATAL ERROR: Could not read named bodies file: %s [%s]",
+			params.Filename, err)
+	}
+	log.Println("Read", len(namedBodyMap), "named bodies from file:",
+		params.Filename)
+	return
+}
+
+// ReadNamedBodiesCsvFrom reads named bodies CSV from a reader and returns
+// a map from BodyID to NamedBody struct.  The Filename of the options is
+// ignored.  Unparseable lines are skipped.
+func ReadNamedBodiesCsvFrom(reader io.Reader, params NamedBodyOptions) (
+	namedBodyMap NamedBodyMap, err error) {
+
+	namedBodyMap = make(NamedBodyMap)
+	csvReader := csv.NewReader(reader)
 	dontCheckBodyId := len(params.BodyIds) == 0
 	dontCheckBodyName := len(params.BodyNames) == 0
 	for {
-		items, err := reader.Read()
+		items, err := csvReader.Read()
 		if err == io.EOF {
 			break
+		} else if _, isParseErr := err.(*csv.ParseError); err != nil &&
+			!isParseErr {
+			return nil, err
 		} else if err != nil || items[0] == "" {
 			continue
 		} else if items[0] == "body ID" {
@@ -362,8 +647,6 @@ func ReadNamedBodiesCsv(params NamedBodyOptions) (namedBodyMap NamedBodyMap) {
 			}
 		}
 	}
-	log.Println("Read", len(namedBodyMap), "named bodies from file:",
-		params.Filename)
 	return
 }
 
@@ -394,18 +677,88 @@ func (result TracingResult) String() string {
 // TracingAgent is a unique id that describes a proofreading agent.
 type TracingAgent string
 
+// MinExportCoverage is the minimum fraction of assigned T-bars that must
+// be present in an export for that export to be used in PSD tracing.
+var MinExportCoverage = 0.9
+
+// ExportCoverage returns the fraction of T-bars in an assignment that
+// are also present in the synapse annotations of an export directory.
+// T-bars are matched by uid if present, else by location.
+func ExportCoverage(assignment *JsonSynapses, exportDir string) (
+	coverage float64, err error) {
+
+	filename := StackSynapsesJsonFilename(exportDir)
+	if _, err = os.Stat(filename); err != nil {
+		err = fmt.Errorf("no synapse annotations in export %s: %s",
+			exportDir, err)
+		return
+	}
+	exported, err := ReadSynapsesJson(filename)
+	if err != nil {
+		return
+	}
+	uids := make(map[string]bool, len(exported.Data))
+	locations := make(map[Point3d]bool, len(exported.Data))
+	for _, synapse := range exported.Data {
+		if synapse.Tbar.Uid != "" {
+			uids[synapse.Tbar.Uid] = true
+		}
+		locations[synapse.Tbar.Location] = true
+	}
+	if len(assignment.Data) == 0 {
+		return 1.0, nil
+	}
+	covered := 0
+	for _, synapse := range assignment.Data {
+		if uids[synapse.Tbar.Uid] || locations[synapse.Tbar.Location] {
+			covered++
+		}
+	}
+	coverage = float64(covered) / float64(len(assignment.Data))
+	return
+}
+
+// SuspectChangedFraction is the fraction of compared PSDs whose body must
+// change during proofreading for a PSD tracing run to be trusted.  Runs
+// below this fraction probably come from the wrong exported session.
+var SuspectChangedFraction = 0.05
+
+// PsdTracingSummary describes the outcome of a PSD tracing run.
+type PsdTracingSummary struct {
+	TotalPsds        int
+	ComparedPsds     int     // PSDs with non-zero bodies in both stacks
+	BaseLookupFailed int     // PSDs on a zero superpixel in the base stack
+	ZeroSuperpixel   int     // PSDs on a zero superpixel in exported tiles
+	UnmappedPsds     int     // PSDs on a superpixel missing from the map
+	PsdsChanged      int     // Compared PSDs whose body changed
+	ExportCoverage   float64 // Fraction of assigned T-bars in the export
+	NoBodyAnnotated  int
+	EmptyTbars       int     // T-bars without partners that were skipped
+	EdgeHitsResolved int     // PSDs on body 0 resolved to a nearby body
+	UnresolvedPsds   int     // PSDs on body 0 without any nearby body
+	ChangedFraction  float64 // PsdsChanged / ComparedPsds
+	Suspect          bool    // ChangedFraction < SuspectChangedFraction
+	Warnings         Warnings
+}
+
 // CreatePsdTracing creates a PsdTracing struct by examining each assigned
 // location and determining the exported body ID of the stack for that location.
+// PSDs whose bodies are not annotated or cannot be resolved, as well as a
+// suspect run where too few PSD bodies changed, are anomalies handled per
+// DefaultStrictness.  Batch drivers should not merge tracings from a run
+// whose summary is Suspect.  An error is always returned if the exported
+// stack covers fewer than MinExportCoverage of the assigned T-bars.
 func CreatePsdTracing(stackId StackId, userid string, setnum int,
 	exportedStack *ExportedStack, baseStack *BaseStack) (
-	tracing *JsonSynapses, psdBodies BodySet) {
+	tracing *JsonSynapses, psdBodies BodySet, summary PsdTracingSummary,
+	err error) {
 
 	psdBodies = make(BodySet) // Set of all PSD bodies
 
 	// Make a closure that adds a traced body to a PSD and modifies
 	// the psdBodies set.
-	addTracedBody := func(psd *JsonPsd, bodyId BodyId, bodyNote *JsonBody) (
-		pTracing *JsonTracing) {
+	addTracedBody := func(psd *JsonPsd, bodyId BodyId, bodyNote *JsonBody,
+		superpixel Superpixel, location Point3d) (pTracing *JsonTracing) {
 
 		tracingResult := bodyNote.GetTracingResult(bodyId)
 		if tracingResult > MinAnchor {
@@ -419,6 +772,8 @@ func CreatePsdTracing(stackId StackId, userid string, setnum int,
 		if tracingResult >= MinAnchor {
 			tracing.ExportedBody = bodyId
 		}
+		tracing.Superpixel = &superpixel
+		tracing.LookupLocation = &location
 		numTracings := len(psd.Tracings)
 		if numTracings == 0 {
 			psd.Tracings = []JsonTracing{tracing}
@@ -432,22 +787,49 @@ func CreatePsdTracing(stackId StackId, userid string, setnum int,
 
 	// Read in the assignment JSON: set of PSDs
 	jsonFilename := AssignmentJsonFilename(stackId, userid, setnum)
-	tracing = ReadSynapsesJson(jsonFilename)
+	tracing, err = ReadSynapsesJson(jsonFilename)
+	if err != nil {
+		return nil, nil, summary, err
+	}
 	log.Println("Read assignment Json:", len(tracing.Data), "synapses")
 
+	// Make sure the exported stack actually holds this assignment.
+	summary.ExportCoverage, err = ExportCoverage(tracing, exportedStack.Directory)
+	if err != nil {
+		return nil, nil, summary, err
+	}
+	if summary.ExportCoverage < MinExportCoverage {
+		err = fmt.Errorf("export %s only has %.1f%% of T-bars in %s "+
+			"(candidate exports: %s)", exportedStack.Directory,
+			100.0*summary.ExportCoverage, jsonFilename,
+			strings.Join(AssignmentExportCandidates(stackId, userid), ", "))
+		return nil, nil, summary, err
+	}
+
 	// Read in the exported body annotations to determine whether PSD was
 	// traced to anchor body or it was orphan/leaves.
-	annotations := ReadStackBodyAnnotations(exportedStack)
+	annotations, err := ReadStackBodyAnnotations(exportedStack)
+	if err != nil {
+		return nil, nil, summary, err
+	}
 	log.Println("Read exported bodies Json:", len(annotations), "bodies")
 
 	// For each PSD, find body associated with it using superpixel tiles
 	// and the exported session's map.
-	var noBodyAnnotated int
-	var totalPsds int
-	var psdsChanged int // For quality-control: make sure PSDs actually traced
+	warnings := &summary.Warnings
+	zeroIsEdge := exportedStack.ZeroIsEdge()
 
 	synapses := tracing.Data
 	for s, _ := range synapses {
+		if len(synapses[s].Psds) == 0 {
+			summary.EmptyTbars++
+			err = DefaultStrictness.Note(warnings, "T-bar without partners",
+				"T-bar %s has no PSDs to trace", synapses[s].Tbar.Location)
+			if err != nil {
+				return nil, nil, summary, err
+			}
+			continue
+		}
 		synapses[s].Tbar.Assignment = fmt.Sprintf("%s-%d",
 			StackDescription[stackId], setnum)
 		excludeBodies := make(BodySet)
@@ -455,33 +837,58 @@ func CreatePsdTracing(stackId StackId, userid string, setnum int,
 		tbarBody, _, radius, _ := GetNearestBodyOfLocation(exportedStack,
 			synapses[s].Tbar.Location, excludeBodies, curPsdBodies)
 		if radius > 0 {
-			log.Println("Warning: T-bar", synapses[s].Tbar.Location,
-				"was on ZERO SUPERPIXEL but assigned to body",
-				tbarBody, "at radius", radius, "from T-bar point")
+			if !zeroIsEdge {
+				log.Println("Warning: T-bar", synapses[s].Tbar.Location,
+					"was on ZERO SUPERPIXEL but assigned to body",
+					tbarBody, "at radius", radius, "from T-bar point")
+			}
 			synapses[s].Tbar.UsedBodyRadius = radius
 		}
 		// Make first pass through all PSDs
 		excludeBodies[tbarBody] = true
 		ambiguous := []int{}
 		for p, psd := range synapses[s].Psds {
-			totalPsds++
-			bodyId, _ := GetBodyOfLocation(exportedStack, psd.Location)
+			summary.TotalPsds++
+			bodyId, superpixel, mapped := CheckBodyOfLocation(exportedStack,
+				psd.Location)
 			baseBodyId, _ := GetBodyOfLocation(baseStack, psd.Location)
-			if bodyId != baseBodyId {
-				psdsChanged++
+			if baseBodyId == 0 {
+				summary.BaseLookupFailed++
+			} else if bodyId != 0 {
+				summary.ComparedPsds++
+				if bodyId != baseBodyId {
+					summary.PsdsChanged++
+				}
 			}
-			if bodyId == 0 {
+			if !mapped {
+				summary.UnmappedPsds++
+				synapses[s].Psds[p].BodyIssue = true
+				err = DefaultStrictness.Note(warnings,
+					"superpixel missing from map", "PSD %s is on superpixel %v "+
+						"missing from map of exported stack", psd.Location,
+					superpixel)
+				if err != nil {
+					return nil, nil, summary, err
+				}
+			} else if bodyId == 0 {
+				if superpixel.Label == 0 {
+					summary.ZeroSuperpixel++
+				}
 				ambiguous = append(ambiguous, p)
 			} else {
 				curPsdBodies[bodyId] = true
 				bodyNote, found := annotations[bodyId]
 				if found {
-					_ = addTracedBody(&(synapses[s].Psds[p]), bodyId, &bodyNote)
+					_ = addTracedBody(&(synapses[s].Psds[p]), bodyId, &bodyNote,
+						superpixel, psd.Location)
 				} else {
-					noBodyAnnotated++
-					log.Println("Warning: PSD ", psd.Location, " -> ",
-						"exported body ", bodyId, " cannot be found in",
-						"body annotation file for exported stack... skipping")
+					summary.NoBodyAnnotated++
+					err = DefaultStrictness.Note(warnings, "body not annotated",
+						"PSD %s -> exported body %d cannot be found in body "+
+							"annotation file for exported stack", psd.Location, bodyId)
+					if err != nil {
+						return nil, nil, summary, err
+					}
 				}
 			}
 		}
@@ -489,56 +896,148 @@ func CreatePsdTracing(stackId StackId, userid string, setnum int,
 		if len(ambiguous) > 0 {
 			for _, p := range ambiguous {
 				pPsd := &(synapses[s].Psds[p])
-				bodyId, _, radius, _ := GetNearestBodyOfLocation(exportedStack,
-					pPsd.Location, excludeBodies, curPsdBodies)
+				bodyId, superpixel, radius, finalLocation :=
+					GetNearestBodyOfLocation(exportedStack, pPsd.Location,
+						excludeBodies, curPsdBodies)
 				if bodyId == 0 {
+					summary.UnresolvedPsds++
 					pPsd.BodyIssue = true
+					err = DefaultStrictness.Note(warnings, "unresolved PSD",
+						"PSD %s could not be assigned a body", pPsd.Location)
+					if err != nil {
+						return nil, nil, summary, err
+					}
 				} else {
+					summary.EdgeHitsResolved++
 					if curPsdBodies[bodyId] {
 						log.Println("Flagged: Found body", bodyId, "for PSD",
 							pPsd.Location, "but it is also assigned to",
 							"another PSD.")
-					} else {
+					} else if !zeroIsEdge {
 						log.Println("Found body", bodyId, "for PSD",
 							pPsd.Location, "after search to radius of",
 							radius, "pixels.")
 					}
 					bodyNote, found := annotations[bodyId]
 					if found {
-						pTracing := addTracedBody(pPsd, bodyId, &bodyNote)
+						pTracing := addTracedBody(pPsd, bodyId, &bodyNote,
+							superpixel, finalLocation)
 						pTracing.UsedBodyRadius = radius
 					} else {
-						noBodyAnnotated++
-						log.Println("Warning: Ambiguous PSD", (*pPsd).Location,
-							"-> exported body", bodyId, "cannot be found in",
-							"body annotation file for exported stack... skipping")
+						summary.NoBodyAnnotated++
+						err = DefaultStrictness.Note(warnings, "body not annotated",
+							"ambiguous PSD %s -> exported body %d cannot be found "+
+								"in body annotation file for exported stack",
+							pPsd.Location, bodyId)
+						if err != nil {
+							return nil, nil, summary, err
+						}
 					}
 				}
 			}
 		}
 	}
 
-	if noBodyAnnotated > 0 {
-		log.Println("*** PSD bodies not annotated: ", noBodyAnnotated)
+	if summary.EdgeHitsResolved > 0 || summary.UnresolvedPsds > 0 {
+		log.Println("PSDs on body 0 resolved to nearby bodies:",
+			summary.EdgeHitsResolved, "  Unresolved:", summary.UnresolvedPsds)
+	}
+	if summary.NoBodyAnnotated > 0 {
+		log.Println("*** PSD bodies not annotated: ", summary.NoBodyAnnotated)
 	}
-	if psdsChanged == 0 {
-		log.Println("ERROR: None of", totalPsds,
-			"PSD bodies were changed during proofreading!")
+	if summary.BaseLookupFailed > 0 {
+		log.Println("*** PSDs on zero superpixels in base stack:",
+			summary.BaseLookupFailed)
+	}
+	if summary.ComparedPsds > 0 {
+		summary.ChangedFraction = float64(summary.PsdsChanged) /
+			float64(summary.ComparedPsds)
+	}
+	summary.Suspect = summary.ChangedFraction < SuspectChangedFraction
+	if summary.Suspect {
+		log.Printf("ERROR: Only %d of %d compared PSD bodies (%.1f%%) were "+
+			"changed during proofreading!\n", summary.PsdsChanged,
+			summary.ComparedPsds, 100.0*summary.ChangedFraction)
 		log.Println("  Userid:", userid)
 		log.Println("  Stack:", StackDescription[stackId])
 		log.Println("  Assignment Set:", setnum)
 		log.Println("  Assignment Json:", jsonFilename)
 		log.Println("  Exported Stack:", exportedStack)
+		err = DefaultStrictness.Note(warnings, "suspect PSD tracing run",
+			"only %d of %d PSD bodies changed for %s set %d in %s",
+			summary.PsdsChanged, summary.ComparedPsds, userid, setnum,
+			exportedStack)
+		if err != nil {
+			return nil, nil, summary, err
+		}
 	} else {
-		log.Println("Proofreader altered", psdsChanged, "of", totalPsds,
-			"during synapse-driven proofreading")
+		log.Println("Proofreader altered", summary.PsdsChanged, "of",
+			summary.ComparedPsds, "compared PSDs during synapse-driven",
+			"proofreading")
 	}
 	return
 }
 
-// TransformBodies applies a body->body map to transform any traced bodies.
+// StackRole is the part a stack plays when transforming traced bodies
+// from one stack to another through overlap analysis.
+type StackRole int
+
+const (
+	// ExportRole transforms exported bodies without recording results.
+	ExportRole StackRole = iota
+
+	// BaseColumnRole transforms exported bodies into base column bodies.
+	BaseColumnRole
+
+	// TargetRole transforms base column bodies into the final target
+	// stack, replacing the tracing result.
+	TargetRole
+)
+
+// FieldPolicy describes which JsonTracing fields a transformation reads
+// and writes.
+type FieldPolicy struct {
+	// FromBaseColumn transforms BaseColumnBody instead of ExportedBody.
+	FromBaseColumn bool
+
+	// SetBaseColumn writes BaseColumnBody, ColumnOverlaps, and ExportedSize.
+	SetBaseColumn bool
+
+	// SetResult writes Result and TargetOverlaps.
+	SetResult bool
+}
+
+// Policy returns the fields updated when transforming bodies for a role.
+func (role StackRole) Policy() FieldPolicy {
+	switch role {
+	case BaseColumnRole:
+		return FieldPolicy{SetBaseColumn: true}
+	case TargetRole:
+		return FieldPolicy{FromBaseColumn: true, SetResult: true}
+	}
+	return FieldPolicy{}
+}
+
+// TransformBodies applies a body->body map to transform any traced
+// bodies using the field policy of the stack's role.  See
+// TransformBodiesWithPolicy.
 func (synapses *JsonSynapses) TransformBodies(matchedBodyMap BestOverlapMap,
-	stackId StackId) (psdBodies BodySet) {
+	stackId StackId) (psdBodies BodySet, warnings Warnings, err error) {
+
+	return synapses.TransformBodiesWithPolicy(matchedBodyMap,
+		stackId.Role().Policy())
+}
+
+// TransformBodiesWithPolicy applies a body->body map to transform any
+// traced bodies, reading and writing tracing fields per the policy.
+// Traced bodies missing from the map or only matched to body 0 are flagged
+// with TransformIssue and are anomalies handled according to
+// DefaultStrictness.  Matches that are Ambiguous per AmbiguousOverlapRatio
+// are logged and, if the policy writes any fields, flagged with
+// AmbiguousMatch.  A flag set by an earlier transformation is kept.
+func (synapses *JsonSynapses) TransformBodiesWithPolicy(
+	matchedBodyMap BestOverlapMap, policy FieldPolicy) (psdBodies BodySet,
+	warnings Warnings, err error) {
 
 	psdBodies = make(BodySet)
 	numErrors := 0
@@ -552,18 +1051,31 @@ func (synapses *JsonSynapses) TransformBodies(matchedBodyMap BestOverlapMap,
 					tracing.Result != 0 {
 
 					var origBody BodyId
-					if stackId != Target12k {
-						origBody = tracing.ExportedBody
-					} else {
+					if policy.FromBaseColumn {
 						origBody = tracing.BaseColumnBody
+					} else {
+						origBody = tracing.ExportedBody
 					}
 					match, found := matchedBodyMap[origBody]
 					if !found {
-						log.Println("ERROR: Body->body map does not contain",
-							"body", tracing.Result, "for", tracing.Userid,
-							"tracing PSD", psd.Location)
 						pPsd.TransformIssue = true
 						numErrors++
+						err = DefaultStrictness.Note(&warnings, "body not in map",
+							"body->body map does not contain body %d for %s "+
+								"tracing PSD %s", origBody, tracing.Userid,
+							psd.Location)
+						if err != nil {
+							return
+						}
+					} else if match.ZeroMatch || match.MatchedBody == 0 {
+						pPsd.TransformIssue = true
+						numErrors++
+						err = DefaultStrictness.Note(&warnings, "zero body match",
+							"body %d only matched body 0 for %s tracing PSD %s",
+							origBody, tracing.Userid, psd.Location)
+						if err != nil {
+							return
+						}
 					} else {
 						if origBody != match.MatchedBody {
 							altered++
@@ -572,15 +1084,28 @@ func (synapses *JsonSynapses) TransformBodies(matchedBodyMap BestOverlapMap,
 						} else {
 							unaltered++
 						}
-						switch stackId {
-						case Distal, Proximal:
+						if policy.SetBaseColumn {
 							pPsd.Tracings[t].BaseColumnBody = match.MatchedBody
 							pPsd.Tracings[t].ColumnOverlaps = match.OverlapSize
 							pPsd.Tracings[t].ExportedSize = match.MaxOverlap
-						case Target12k:
+						}
+						if policy.SetResult {
 							pPsd.Tracings[t].Result = TracingResult(match.MatchedBody)
 							pPsd.Tracings[t].TargetOverlaps = match.OverlapSize
 						}
+						ambiguous := match.Ambiguous(AmbiguousOverlapRatio)
+						if ambiguous {
+							log.Printf("** Warning: body %d for %s tracing PSD "+
+								"%s matched body %d (%d overlap) over body %d "+
+								"(%d overlap)\n", origBody, tracing.Userid,
+								psd.Location, match.MatchedBody,
+								match.OverlapSize, match.SecondBody,
+								match.SecondOverlapSize)
+						}
+						if policy.SetBaseColumn || policy.SetResult {
+							pPsd.Tracings[t].AmbiguousMatch =
+								pPsd.Tracings[t].AmbiguousMatch || ambiguous
+						}
 						psdBodies[match.MatchedBody] = true
 					}
 				}
@@ -612,8 +1137,15 @@ type psdIndex struct {
 
 // AddPsdUids modifies a synapse annotation list to include "uid" tags
 // for each PSD, either generated from the PSD location or from a matching
-// PSD's uid in a given synapse file.
-func (synapses *JsonSynapses) AddPsdUids(xformed *JsonSynapses) {
+// PSD's uid in a given synapse file.  T-bars without partners are skipped
+// and handled according to DefaultStrictness.
+func (synapses *JsonSynapses) AddPsdUids(xformed *JsonSynapses) (
+	warnings Warnings, err error) {
+
+	if synapses == nil {
+		return
+	}
+
 	// If we have a transformed synapse list, create an index using
 	// PSD location
 	uidMap := make(map[Point3d]psdIndex)
@@ -628,6 +1160,14 @@ func (synapses *JsonSynapses) AddPsdUids(xformed *JsonSynapses) {
 	// Go through all our PSDs and add uids
 	for s, synapse := range synapses.Data {
 		pSynapse := &(synapses.Data[s])
+		if len(pSynapse.Psds) == 0 {
+			err = DefaultStrictness.Note(&warnings, "T-bar without partners",
+				"T-bar %s has no PSDs to add uids to", synapse.Tbar.Location)
+			if err != nil {
+				return
+			}
+			continue
+		}
 		for p, psd := range pSynapse.Psds {
 			if xformed == nil {
 				pSynapse.Psds[p].Uid = PsdUid(
@@ -644,6 +1184,7 @@ func (synapses *JsonSynapses) AddPsdUids(xformed *JsonSynapses) {
 			}
 		}
 	}
+	return
 }
 
 // TransformSynapses modifies synapse locations (T-bar and PSDs) based
@@ -0,0 +1,729 @@
+// Copyright 2012 HHMI.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//     * Neither the name of HHMI nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+// Author: katzw@janelia.hhmi.org (Bill Katz)
+//  Written as part of the FlyEM Project at Janelia Farm Research Center.
+
+package emdata
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tracedSynapses returns synapses with one PSD traced to the exported body.
+func tracedSynapses(exportedBody BodyId) *JsonSynapses {
+	return &JsonSynapses{Data: []JsonSynapse{{
+		Tbar: JsonTbar{Location: Point3d{10, 10, 1}, Body: 7},
+		Psds: []JsonPsd{{
+			Location: Point3d{12, 10, 1},
+			Tracings: []JsonTracing{{Userid: "tracer",
+				Result: TracingResult(exportedBody), ExportedBody: exportedBody}},
+		}},
+	}}}
+}
+
+func TestTransformBodiesAmbiguousMatch(t *testing.T) {
+	ambiguousMap := BestOverlapMap{
+		1: {MatchedBody: 10, OverlapSize: 10, MaxOverlap: 20,
+			SecondBody: 11, SecondOverlapSize: 9},
+	}
+	clearMap := BestOverlapMap{
+		10: {MatchedBody: 100, OverlapSize: 20, MaxOverlap: 20},
+	}
+
+	synapses := tracedSynapses(1)
+	tracing := &synapses.Data[0].Psds[0].Tracings[0]
+	_, _, err := synapses.TransformBodiesWithPolicy(ambiguousMap,
+		BaseColumnRole.Policy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tracing.AmbiguousMatch || tracing.BaseColumnBody != 10 {
+		t.Fatalf("base column pass: got tracing %+v", *tracing)
+	}
+	_, _, err = synapses.TransformBodiesWithPolicy(clearMap,
+		TargetRole.Policy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tracing.AmbiguousMatch || tracing.Result != 100 {
+		t.Errorf("target pass cleared ambiguity: got tracing %+v", *tracing)
+	}
+
+	synapses = tracedSynapses(1)
+	tracing = &synapses.Data[0].Psds[0].Tracings[0]
+	_, _, err = synapses.TransformBodiesWithPolicy(ambiguousMap,
+		ExportRole.Policy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *tracing != tracedSynapses(1).Data[0].Psds[0].Tracings[0] {
+		t.Errorf("export pass wrote fields: got tracing %+v", *tracing)
+	}
+}
+
+func TestStackRolePolicy(t *testing.T) {
+	roles := map[StackId]StackRole{
+		Distal:    BaseColumnRole,
+		Proximal:  BaseColumnRole,
+		Orig12k:   ExportRole,
+		Target12k: TargetRole,
+	}
+	for stackId, role := range roles {
+		if stackId.Role() != role {
+			t.Errorf("%s: expected role %d, got %d",
+				StackDescription[stackId], role, stackId.Role())
+		}
+	}
+
+	// Exported body 5 and base column body 7 map to different bodies,
+	// so each policy's reads and writes are distinguishable.
+	matchedBodyMap := BestOverlapMap{
+		5: {MatchedBody: 50, OverlapSize: 11, MaxOverlap: 13},
+		7: {MatchedBody: 70, OverlapSize: 17, MaxOverlap: 19},
+	}
+	original := JsonTracing{Userid: "tracer", Result: 5, ExportedBody: 5,
+		BaseColumnBody: 7}
+	tests := []struct {
+		stackId  StackId
+		policy   FieldPolicy
+		psdBody  BodyId
+		expected JsonTracing
+	}{
+		{Orig12k, ExportRole.Policy(), 50, original},
+		{Distal, BaseColumnRole.Policy(), 50, JsonTracing{Userid: "tracer",
+			Result: 5, ExportedBody: 5, BaseColumnBody: 50,
+			ColumnOverlaps: 11, ExportedSize: 13}},
+		{Target12k, TargetRole.Policy(), 70, JsonTracing{Userid: "tracer",
+			Result: 70, ExportedBody: 5, BaseColumnBody: 7,
+			TargetOverlaps: 17}},
+	}
+	for _, test := range tests {
+		name := StackDescription[test.stackId]
+		for _, byStackId := range []bool{true, false} {
+			synapses := tracedSynapses(5)
+			synapses.Data[0].Psds[0].Tracings[0] = original
+			var psdBodies BodySet
+			var err error
+			if byStackId {
+				psdBodies, _, err = synapses.TransformBodies(matchedBodyMap,
+					test.stackId)
+			} else {
+				psdBodies, _, err = synapses.TransformBodiesWithPolicy(
+					matchedBodyMap, test.policy)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tracing := synapses.Data[0].Psds[0].Tracings[0]
+			if tracing != test.expected {
+				t.Errorf("%s: expected tracing %+v, got %+v", name,
+					test.expected, tracing)
+			}
+			if len(psdBodies) != 1 || !psdBodies[test.psdBody] {
+				t.Errorf("%s: expected PSD body %d, got %v", name,
+					test.psdBody, psdBodies)
+			}
+		}
+	}
+
+	// A custom policy can rewrite base column bodies from themselves.
+	synapses := tracedSynapses(5)
+	synapses.Data[0].Psds[0].Tracings[0] = original
+	policy := FieldPolicy{FromBaseColumn: true, SetBaseColumn: true}
+	_, _, err := synapses.TransformBodiesWithPolicy(matchedBodyMap, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := JsonTracing{Userid: "tracer", Result: 5, ExportedBody: 5,
+		BaseColumnBody: 70, ColumnOverlaps: 17, ExportedSize: 19}
+	if tracing := synapses.Data[0].Psds[0].Tracings[0]; tracing != expected {
+		t.Errorf("custom policy: expected %+v, got %+v", expected, tracing)
+	}
+}
+
+// polaritySynapses has body 1 with outputs at z = 0 and inputs at z = 10,
+// body 2 with only inputs, and body 3 with only outputs.
+func polaritySynapses() *JsonSynapses {
+	return &JsonSynapses{Data: []JsonSynapse{
+		{Tbar: JsonTbar{Location: Point3d{0, 0, 0}, Body: 1},
+			Psds: []JsonPsd{
+				{Location: Point3d{10, 0, 0}, Body: 2},
+				{Location: Point3d{0, 0, 10}, Body: 1},
+			}},
+		{Tbar: JsonTbar{Location: Point3d{2, 0, 0}, Body: 1},
+			Psds: []JsonPsd{
+				{Location: Point3d{2, 0, 10}, Body: 1},
+				{Location: Point3d{12, 0, 0}, Body: 2},
+			}},
+		{Tbar: JsonTbar{Location: Point3d{5, 5, 5}, Body: 3},
+			Psds: []JsonPsd{{Location: Point3d{1, 0, 10}, Body: 1}}},
+	}}
+}
+
+func TestBodyPolarities(t *testing.T) {
+	polarities := polaritySynapses().BodyPolarities()
+	body1 := polarities[1]
+	if body1.NumTbars != 2 || body1.NumPsds != 3 {
+		t.Fatalf("body 1: expected 2 T-bars and 3 PSDs, got %+v", body1)
+	}
+	if body1.OutputCentroid != [3]float64{1, 0, 0} ||
+		body1.InputCentroid != [3]float64{1, 0, 10} {
+		t.Errorf("body 1: got centroids %v (in) and %v (out)",
+			body1.InputCentroid, body1.OutputCentroid)
+	}
+	if ratio := body1.PolarityRatio(); ratio != 0.6 {
+		t.Errorf("body 1: expected polarity ratio 0.6, got %f", ratio)
+	}
+	if distance, found := body1.CentroidDistance(VoxelResolution); !found ||
+		distance != 10 {
+		t.Errorf("body 1: expected distance 10 voxels, got %f", distance)
+	}
+	distance, _ := body1.CentroidDistance(Resolution{10, 10, 40})
+	if distance != 400 {
+		t.Errorf("body 1: expected distance 400 nm, got %f", distance)
+	}
+
+	tests := []struct {
+		body        BodyId
+		ratio       float64
+		compartment string
+	}{
+		{1, 0.6, "mixed"},
+		{2, 1, "dendritic"},
+		{3, 0, "axonal"},
+		{4, 0, "none"},
+	}
+	for _, test := range tests {
+		polarity := polarities[test.body]
+		if ratio := polarity.PolarityRatio(); ratio != test.ratio {
+			t.Errorf("body %d: expected ratio %f, got %f", test.body,
+				test.ratio, ratio)
+		}
+		if compartment := polarity.Compartment(0.8); compartment !=
+			test.compartment {
+			t.Errorf("body %d: expected %q, got %q", test.body,
+				test.compartment, compartment)
+		}
+		if test.body != 1 {
+			if _, found := polarity.CentroidDistance(VoxelResolution); found {
+				t.Errorf("body %d: expected no centroid distance", test.body)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	polarities.WriteCsv(&buf, NamedBodyMap{1: {Body: 1, Name: "Mi1"}},
+		VoxelResolution)
+	expected := "Body ID,Body Name,# T-bars,# PSDs,Polarity ratio," +
+		"Input X,Input Y,Input Z,Output X,Output Y,Output Z," +
+		"Centroid distance\n" +
+		"1,Mi1,2,3,0.60,1.00,0.00,10.00,1.00,0.00,0.00,10.00\n" +
+		"2,,0,2,1.00,11.00,0.00,0.00,,,,\n" +
+		"3,,1,0,0.00,,,,5.00,5.00,5.00,\n"
+	if buf.String() != expected {
+		t.Errorf("expected CSV:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+// psdTracingFixture is a synthetic base stack with an assignment for
+// the user "tester" in set 1 and an export of that assignment.
+type psdTracingFixture struct {
+	synth    *SyntheticStack
+	exported *ExportedStack
+	psdSps   []Superpixel        // Superpixel of each assigned PSD
+	spToBody SuperpixelToBodyMap // Map written to the export
+}
+
+// newPsdTracingFixture creates a base stack with 100 single-PSD
+// synapses and an export whose map moves the first numChanged PSD
+// superpixels to new anchor bodies.  The distal medulla paths point to
+// the fixture for the duration of the test.
+func newPsdTracingFixture(t *testing.T, numChanged int) *psdTracingFixture {
+	params := DefaultSyntheticStackParams
+	params.SynapsesPerSlice = 25
+	return newPsdTracingFixtureWith(t, params, numChanged)
+}
+
+// newPsdTracingFixtureWith is newPsdTracingFixture for a base stack
+// with the given parameters.
+func newPsdTracingFixtureWith(t *testing.T, params SyntheticStackParams,
+	numChanged int) *psdTracingFixture {
+
+	dir := t.TempDir()
+	synth, err := CreateSyntheticStack(filepath.Join(dir, "base"), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedStackDir, savedExportDir := DistalStackDir, DistalExportDir
+	t.Cleanup(func() {
+		DistalStackDir, DistalExportDir = savedStackDir, savedExportDir
+	})
+	Configure(Config{DistalStackDir: synth.Directory,
+		DistalExportDir: filepath.Join(dir, "exports")})
+
+	fixture := &psdTracingFixture{synth: synth}
+	assignment := AssignmentJsonFilename(Distal, "tester", 1)
+	exportDir := AssignmentExportDir(Distal, "tester", 1)
+	for _, d := range []string{filepath.Dir(assignment), exportDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := synth.Synapses.WriteJsonFileE(assignment); err != nil {
+		t.Fatal(err)
+	}
+	err = synth.Synapses.WriteJsonFileE(StackSynapsesJsonFilename(exportDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spToBodyMap := make(SuperpixelToBodyMap, len(synth.SpToBodyMap))
+	for superpixel, bodyId := range synth.SpToBodyMap {
+		spToBodyMap[superpixel] = bodyId
+	}
+	bodies := &JsonBodies{Metadata: synth.Bodies.Metadata,
+		Data: append([]JsonBody{}, synth.Bodies.Data...)}
+	for i, synapse := range synth.Synapses.Data {
+		superpixel := synth.SuperpixelAt(synapse.Psds[0].Location)
+		fixture.psdSps = append(fixture.psdSps, superpixel)
+		if i < numChanged {
+			bodyId := BodyId(1000 + i)
+			spToBodyMap[superpixel] = bodyId
+			bodies.Data = append(bodies.Data, JsonBody{Body: bodyId,
+				Status: "Anchor", Anchor: "changed"})
+		}
+	}
+	if err := spToBodyMap.WriteTxtMaps(exportDir); err != nil {
+		t.Fatal(err)
+	}
+	bodies.WriteJsonFile(StackBodiesJsonFilename(exportDir))
+
+	fixture.spToBody = spToBodyMap
+	fixture.exported = CreateExportedStack(exportDir, synth.Directory)
+	fixture.exported.Base.Tiles = synth.Tiles
+	return fixture
+}
+
+// trace runs CreatePsdTracing on the fixture's assignment.
+func (fixture *psdTracingFixture) trace() (*JsonSynapses, PsdTracingSummary,
+	error) {
+
+	tracing, _, summary, err := CreatePsdTracing(Distal, "tester", 1,
+		fixture.exported, &fixture.synth.BaseStack)
+	return tracing, summary, err
+}
+
+func TestCreatePsdTracingChangedFraction(t *testing.T) {
+	setStrictness(t, Lenient)
+	tests := []struct {
+		changed  int
+		fraction float64
+		suspect  bool
+	}{
+		{0, 0.0, true},
+		{1, 0.01, true},
+		{50, 0.5, false},
+	}
+	for _, test := range tests {
+		fixture := newPsdTracingFixture(t, test.changed)
+		_, summary, err := fixture.trace()
+		if err != nil {
+			t.Fatalf("%d changed: %s", test.changed, err)
+		}
+		if summary.TotalPsds != 100 || summary.ComparedPsds != 100 ||
+			summary.PsdsChanged != test.changed {
+			t.Errorf("%d changed: got summary %+v", test.changed, summary)
+		}
+		if summary.ChangedFraction != test.fraction ||
+			summary.Suspect != test.suspect {
+			t.Errorf("%d changed: expected fraction %f (suspect %t), got "+
+				"%f (suspect %t)", test.changed, test.fraction, test.suspect,
+				summary.ChangedFraction, summary.Suspect)
+		}
+		if n := summary.Warnings.Count("suspect PSD tracing run"); n == 0 &&
+			test.suspect {
+			t.Errorf("%d changed: expected suspect run warning", test.changed)
+		}
+	}
+
+	setStrictness(t, Strict)
+	if _, _, err := newPsdTracingFixture(t, 1).trace(); err == nil {
+		t.Error("Strict: expected error for suspect run")
+	}
+	saved := SuspectChangedFraction
+	SuspectChangedFraction = 0.005
+	defer func() { SuspectChangedFraction = saved }()
+	if _, summary, err := newPsdTracingFixture(t, 1).trace(); err != nil ||
+		summary.Suspect {
+		t.Errorf("0.5%% threshold: expected trusted run, got %v (%+v)", err,
+			summary)
+	}
+}
+
+func TestCreatePsdTracingUnmappedAndZeroSuperpixels(t *testing.T) {
+	setStrictness(t, Lenient)
+	params := DefaultSyntheticStackParams
+	params.SynapsesPerSlice = 25
+	params.ZeroBorders = true
+	fixture := newPsdTracingFixtureWith(t, params, 0)
+	synth := fixture.synth
+
+	// Drop the superpixel of the first PSD from the exported map.
+	missing := fixture.psdSps[0]
+	numUnmapped := 0
+	for _, superpixel := range fixture.psdSps {
+		if superpixel == missing {
+			numUnmapped++
+		}
+	}
+	delete(fixture.spToBody, missing)
+	err := fixture.spToBody.WriteTxtMaps(fixture.exported.Directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Move a PSD on another superpixel onto the zero border to its left.
+	assigned := &JsonSynapses{Metadata: synth.Synapses.Metadata,
+		Data: append([]JsonSynapse{}, synth.Synapses.Data...)}
+	moved := -1
+	for i, superpixel := range fixture.psdSps {
+		if superpixel != missing {
+			moved = i
+			break
+		}
+	}
+	psd := assigned.Data[moved].Psds[0]
+	for synth.SuperpixelAt(psd.Location).Label != 0 {
+		psd.Location[0]--
+	}
+	assigned.Data[moved].Psds = []JsonPsd{psd}
+	err = assigned.WriteJsonFileE(AssignmentJsonFilename(Distal, "tester", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracing, summary, err := fixture.trace()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.UnmappedPsds != numUnmapped || summary.ZeroSuperpixel != 1 {
+		t.Errorf("expected %d unmapped and 1 zero superpixel PSDs, got %d "+
+			"and %d", numUnmapped, summary.UnmappedPsds, summary.ZeroSuperpixel)
+	}
+	if n := summary.Warnings.Count("superpixel missing from map"); n !=
+		numUnmapped {
+		t.Errorf("expected %d missing superpixel warnings, got %d",
+			numUnmapped, n)
+	}
+	numIssues := 0
+	for _, synapse := range tracing.Data {
+		for _, psd := range synapse.Psds {
+			if psd.BodyIssue {
+				numIssues++
+			}
+		}
+	}
+	if numIssues != numUnmapped {
+		t.Errorf("expected %d PSDs marked with body issues, got %d",
+			numUnmapped, numIssues)
+	}
+
+	setStrictness(t, Strict)
+	if _, _, err := fixture.trace(); err == nil {
+		t.Error("Strict: expected error for superpixel missing from map")
+	}
+}
+
+// edgeFixture returns a tracing fixture on a stack with zero borders
+// where numEdge PSDs have been moved onto the border to their left.
+func edgeFixture(t *testing.T, numEdge int) *psdTracingFixture {
+	params := DefaultSyntheticStackParams
+	params.SynapsesPerSlice = 25
+	params.ZeroBorders = true
+	fixture := newPsdTracingFixtureWith(t, params, 0)
+	synth := fixture.synth
+	assigned := &JsonSynapses{Metadata: synth.Synapses.Metadata,
+		Data: append([]JsonSynapse{}, synth.Synapses.Data...)}
+	for i := 0; i < numEdge; i++ {
+		psd := assigned.Data[i].Psds[0]
+		for synth.SuperpixelAt(psd.Location).Label != 0 {
+			psd.Location[0]--
+		}
+		assigned.Data[i].Psds = []JsonPsd{psd}
+	}
+	err := assigned.WriteJsonFileE(AssignmentJsonFilename(Distal, "tester", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fixture
+}
+
+// captureLog redirects the standard logger for the rest of a test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func TestCreatePsdTracingEdgeBodyZero(t *testing.T) {
+	setStrictness(t, Lenient)
+	const numEdge = 6
+	for _, edgeBodyZero := range []bool{false, true} {
+		fixture := edgeFixture(t, numEdge)
+		// The base stack is read both through the export and directly.
+		fixture.exported.Base.EdgeBodyZero = edgeBodyZero
+		fixture.synth.EdgeBodyZero = edgeBodyZero
+		if fixture.exported.ZeroIsEdge() != edgeBodyZero {
+			t.Fatalf("exported stack ignores base EdgeBodyZero %t",
+				edgeBodyZero)
+		}
+		logged := captureLog(t)
+		tracing, summary, err := fixture.trace()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if summary.ZeroSuperpixel != numEdge ||
+			summary.EdgeHitsResolved != numEdge ||
+			summary.UnresolvedPsds != 0 {
+			t.Errorf("EdgeBodyZero %t: expected %d resolved edge hits, "+
+				"got %+v", edgeBodyZero, numEdge, summary)
+		}
+		for i := 0; i < numEdge; i++ {
+			psd := tracing.Data[i].Psds[0]
+			if psd.BodyIssue || len(psd.Tracings) != 1 ||
+				psd.Tracings[0].ExportedBody == 0 {
+				t.Errorf("EdgeBodyZero %t: edge PSD %s not resolved: %+v",
+					edgeBodyZero, psd.Location, psd)
+			}
+		}
+		perPoint := strings.Count(logged.String(), "PSD falls in ZERO") +
+			strings.Count(logged.String(), "after search to radius")
+		if edgeBodyZero && perPoint != 0 {
+			t.Errorf("expected no per-PSD logging, got:\n%s", logged)
+		} else if !edgeBodyZero && perPoint < numEdge {
+			t.Errorf("expected per-PSD logging, got:\n%s", logged)
+		}
+		if !strings.Contains(logged.String(), fmt.Sprint("PSDs on body 0 "+
+			"resolved to nearby bodies: ", numEdge, "   Unresolved: 0")) {
+			t.Errorf("missing aggregate edge hit report:\n%s", logged)
+		}
+		if !strings.Contains(summary.Summary(), "6 edge hits resolved, "+
+			"0 unresolved") {
+			t.Errorf("summary does not separate edge hits: %s",
+				summary.Summary())
+		}
+	}
+}
+
+func TestCreatePsdTracingUnresolvedEdge(t *testing.T) {
+	setStrictness(t, Lenient)
+	saved := DefaultNearestBodyOptions
+	DefaultNearestBodyOptions.MaxRadius = 0
+	defer func() { DefaultNearestBodyOptions = saved }()
+	for _, edgeBodyZero := range []bool{false, true} {
+		fixture := edgeFixture(t, 2)
+		fixture.exported.EdgeBodyZero = edgeBodyZero
+		tracing, summary, err := fixture.trace()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if summary.EdgeHitsResolved != 0 || summary.UnresolvedPsds != 2 ||
+			summary.Warnings.Count("unresolved PSD") != 2 {
+			t.Errorf("EdgeBodyZero %t: expected 2 unresolved PSDs, got %+v",
+				edgeBodyZero, summary)
+		}
+		if !tracing.Data[0].Psds[0].BodyIssue ||
+			!tracing.Data[1].Psds[0].BodyIssue {
+			t.Errorf("EdgeBodyZero %t: unresolved PSDs not flagged",
+				edgeBodyZero)
+		}
+	}
+	setStrictness(t, Strict)
+	if _, _, err := edgeFixture(t, 1).trace(); err == nil {
+		t.Error("Strict: expected error for unresolved PSD")
+	}
+}
+
+func TestCreatePsdTracingProvenance(t *testing.T) {
+	setStrictness(t, Lenient)
+	params := DefaultSyntheticStackParams
+	params.SynapsesPerSlice = 25
+	params.ZeroBorders = true
+	fixture := newPsdTracingFixtureWith(t, params, 0)
+	synth := fixture.synth
+
+	// Move the second PSD onto the zero border to its left so its body
+	// must be found within a radius.
+	assigned := &JsonSynapses{Metadata: synth.Synapses.Metadata,
+		Data: append([]JsonSynapse{}, synth.Synapses.Data...)}
+	direct := assigned.Data[0].Psds[0]
+	moved := assigned.Data[1].Psds[0]
+	for synth.SuperpixelAt(moved.Location).Label != 0 {
+		moved.Location[0]--
+	}
+	assigned.Data[1].Psds = []JsonPsd{moved}
+	err := assigned.WriteJsonFileE(AssignmentJsonFilename(Distal, "tester", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracing, _, err := fixture.trace()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracingOf := func(location Point3d) *JsonTracing {
+		for _, synapse := range tracing.Data {
+			for _, psd := range synapse.Psds {
+				if psd.Location == location && len(psd.Tracings) > 0 {
+					return &psd.Tracings[len(psd.Tracings)-1]
+				}
+			}
+		}
+		t.Fatalf("no tracing for PSD %s", location)
+		return nil
+	}
+
+	hit := tracingOf(direct.Location)
+	if hit.Superpixel == nil || *hit.Superpixel != fixture.psdSps[0] ||
+		hit.LookupLocation == nil || *hit.LookupLocation != direct.Location ||
+		hit.UsedBodyRadius != 0 {
+		t.Errorf("direct hit: expected superpixel %v at %s, got %+v",
+			fixture.psdSps[0], direct.Location, hit)
+	}
+	resolved := tracingOf(moved.Location)
+	if resolved.Superpixel == nil || resolved.LookupLocation == nil ||
+		resolved.UsedBodyRadius == 0 {
+		t.Fatalf("radius-resolved PSD: missing provenance in %+v", resolved)
+	}
+	if *resolved.LookupLocation == moved.Location ||
+		synth.SuperpixelAt(*resolved.LookupLocation) != *resolved.Superpixel ||
+		resolved.Superpixel.Label == 0 {
+		t.Errorf("radius-resolved PSD at %s: superpixel %v at %s",
+			moved.Location, *resolved.Superpixel, *resolved.LookupLocation)
+	}
+
+	var buf bytes.Buffer
+	tracing.WriteTracingAuditCsv(&buf)
+	hitLine := fmt.Sprintf(",%d,%d,\"%s\"\n", hit.Superpixel.Slice,
+		hit.Superpixel.Label, direct.Location)
+	if !strings.Contains(buf.String(), hitLine) {
+		t.Errorf("audit CSV missing direct hit %q:\n%s", hitLine, buf.String())
+	}
+}
+
+func TestTracingProvenanceOptional(t *testing.T) {
+	const oldTracing = `{"data": [{"T-bar": {"location": [1, 2, 3]},
+		"partners": [{"location": [4, 5, 3], "tracings": [
+			{"userid": "old", "result": 7, "stack id": "distal",
+			 "assignment set": 1}]}]}]}`
+	synapses, err := ReadSynapsesJsonFrom(strings.NewReader(oldTracing))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracing := synapses.Data[0].Psds[0].Tracings[0]
+	if tracing.Superpixel != nil || tracing.LookupLocation != nil {
+		t.Errorf("expected no provenance in old tracing, got %+v", tracing)
+	}
+	var buf bytes.Buffer
+	if err := synapses.WriteJson(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "superpixel") ||
+		strings.Contains(buf.String(), "lookup location") {
+		t.Errorf("provenance written for old tracing:\n%s", buf.String())
+	}
+	buf.Reset()
+	synapses.WriteTracingAuditCsv(&buf)
+	expected := "T-bar,PSD,Userid,Result,Exported body,Used body radius," +
+		"Superpixel slice,Superpixel label,Lookup location\n" +
+		"\"(1,2,3)\",\"(4,5,3)\",old,7,0,0,,,\n"
+	if buf.String() != expected {
+		t.Errorf("expected audit CSV:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+// setTesterExports sets the export sets of the user "tester" in the
+// distal stack for the duration of a test.
+func setTesterExports(t *testing.T, last int, use []int) {
+	saved := proofreadingExports
+	t.Cleanup(func() { proofreadingExports = saved })
+	proofreadingExports[Distal] = AssignmentMapping{
+		"tester": {Last: last, Use: use},
+	}
+}
+
+func TestResolveAssignmentExport(t *testing.T) {
+	setStrictness(t, Lenient)
+	fixture := newPsdTracingFixture(t, 50)
+
+	setTesterExports(t, 2, []int{1})
+	dir, coverage, err := ResolveAssignmentExport(Distal, "tester", 1)
+	if err != nil || dir != fixture.exported.Directory || coverage != 1 {
+		t.Errorf("expected %s with full coverage, got %s (%f): %v",
+			fixture.exported.Directory, dir, coverage, err)
+	}
+
+	// Set 1 falls back to export set 2, which holds other T-bars.
+	mismatchedDir := AssignmentExportDir(Distal, "tester", 2)
+	if err := os.MkdirAll(mismatchedDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	mismatched := &JsonSynapses{Metadata: fixture.synth.Synapses.Metadata}
+	for _, synapse := range fixture.synth.Synapses.Data {
+		synapse.Tbar.Location[0]++
+		synapse.Tbar.Uid = ""
+		mismatched.Data = append(mismatched.Data, synapse)
+	}
+	err = mismatched.WriteJsonFileE(StackSynapsesJsonFilename(mismatchedDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	setTesterExports(t, 2, []int{})
+	dir, coverage, err = ResolveAssignmentExport(Distal, "tester", 1)
+	if err == nil || dir != mismatchedDir || coverage != 0 {
+		t.Errorf("expected error for %s with no coverage, got %s (%f): %v",
+			mismatchedDir, dir, coverage, err)
+	} else if !strings.Contains(err.Error(), "candidate exports: "+
+		mismatchedDir) {
+		t.Errorf("expected candidate exports in error, got %s", err)
+	}
+
+	fixture.exported.Directory = mismatchedDir
+	if _, _, err = fixture.trace(); err == nil ||
+		!strings.Contains(err.Error(), "only has 0.0%") {
+		t.Errorf("expected coverage error from CreatePsdTracing, got %v", err)
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2012 HHMI.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//     * Neither the name of HHMI nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+// Author: katzw@janelia.hhmi.org (Bill Katz)
+//  Written as part of the FlyEM Project at Janelia Farm Research Center.
+
+package emdata
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// CompletedAssignmentsKey is the synapse metadata key holding the
+// assignments fully incorporated into a synapse file.
+const CompletedAssignmentsKey = "completed assignments"
+
+// AssignmentName returns the T-bar assignment string for a stack
+// description and assignment set, e.g., "Distal-12".
+func AssignmentName(stack string, set int) string {
+	return fmt.Sprintf("%s-%d", stack, set)
+}
+
+// AssignmentStatus describes how much of an assignment set is in a
+// synapse file.
+type AssignmentStatus struct {
+	Stack       string         `json:"stack"`
+	Set         int            `json:"set"`
+	Tbars       int            `json:"tbars"`
+	PsdTracings map[string]int `json:"psd tracings"` // # per userid
+	Complete    bool           `json:"complete"`
+}
+
+// Name returns the assignment string of the status.
+func (status AssignmentStatus) Name() string {
+	return AssignmentName(status.Stack, status.Set)
+}
+
+// AssignmentStatusList is sorted by stack then set.
+type AssignmentStatusList []AssignmentStatus
+
+func (list AssignmentStatusList) Len() int {
+	return len(list)
+}
+
+func (list AssignmentStatusList) Swap(i, j int) {
+	list[i], list[j] = list[j], list[i]
+}
+
+func (list AssignmentStatusList) Less(i, j int) bool {
+	if list[i].Stack != list[j].Stack {
+		return list[i].Stack < list[j].Stack
+	}
+	return list[i].Set < list[j].Set
+}
+
+// AssignmentCounts returns the # of T-bars and PSD tracings of each
+// assignment set present in the synapses.  Completion is not set.
+func (synapses *JsonSynapses) AssignmentCounts() AssignmentStatusList {
+	type assignmentKey struct {
+		stack string
+		set   int
+	}
+	statusMap := make(map[assignmentKey]*AssignmentStatus)
+	getStatus := func(key assignmentKey) *AssignmentStatus {
+		status, found := statusMap[key]
+		if !found {
+			status = &AssignmentStatus{Stack: key.stack, Set: key.set,
+				PsdTracings: make(map[string]int)}
+			statusMap[key] = status
+		}
+		return status
+	}
+	tbarKeys := make(map[string]assignmentKey)
+	for _, synapse := range synapses.Data {
+		for _, psd := range synapse.Psds {
+			for _, tracing := range psd.Tracings {
+				key := assignmentKey{tracing.Stack, tracing.AssignmentSet}
+				getStatus(key).PsdTracings[tracing.Userid]++
+				tbarKeys[AssignmentName(key.stack, key.set)] = key
+			}
+		}
+	}
+	for _, synapse := range synapses.Data {
+		if key, found := tbarKeys[synapse.Tbar.Assignment]; found {
+			getStatus(key).Tbars++
+		}
+	}
+	list := make(AssignmentStatusList, 0, len(statusMap))
+	for _, status := range statusMap {
+		list = append(list, *status)
+	}
+	sort.Sort(list)
+	return list
+}
+
+// CompletedAssignments returns the completed assignments stored in the
+// synapse metadata.  Metadata read from JSON is decoded as needed.
+func (synapses *JsonSynapses) CompletedAssignments() (
+	completed AssignmentStatusList, err error) {
+
+	value, found := synapses.Metadata[CompletedAssignmentsKey]
+	if !found {
+		return
+	}
+	if list, ok := value.(AssignmentStatusList); ok {
+		return list, nil
+	}
+	m, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(m, &completed); err != nil {
+		err = fmt.Errorf("Bad %q in synapse metadata: %s",
+			CompletedAssignmentsKey, err)
+	}
+	return
+}
+
+// MarkAssignmentComplete records an assignment set and its current
+// counts as complete in the synapse metadata.
+func (synapses *JsonSynapses) MarkAssignmentComplete(stack string,
+	set int) error {
+
+	completed, err := synapses.CompletedAssignments()
+	if err != nil {
+		return err
+	}
+	status := AssignmentStatus{Stack: stack, Set: set,
+		PsdTracings: make(map[string]int)}
+	for _, counts := range synapses.AssignmentCounts() {
+		if counts.Stack == stack && counts.Set == set {
+			status = counts
+			break
+		}
+	}
+	status.Complete = true
+
+	replaced := false
+	for i, prior := range completed {
+		if prior.Stack == stack && prior.Set == set {
+			completed[i] = status
+			replaced = true
+		}
+	}
+	if !replaced {
+		completed = append(completed, status)
+	}
+	sort.Sort(completed)
+	if synapses.Metadata == nil {
+		synapses.Metadata = make(map[string]interface{})
+	}
+	synapses.Metadata[CompletedAssignmentsKey] = completed
+	return nil
+}
+
+// IsAssignmentComplete returns true if the synapse metadata records the
+// assignment set as complete.
+func (synapses *JsonSynapses) IsAssignmentComplete(stack string,
+	set int) bool {
+
+	completed, err := synapses.CompletedAssignments()
+	if err != nil {
+		return false
+	}
+	for _, status := range completed {
+		if status.Stack == stack && status.Set == set {
+			return status.Complete
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+// Copyright 2012 HHMI.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//     * Neither the name of HHMI nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+// Author: katzw@janelia.hhmi.org (Bill Katz)
+//  Written as part of the FlyEM Project at Janelia Farm Research Center.
+
+package emdata
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// assignedSynapses has two T-bars of Distal set 1, each traced by two
+// users, and one T-bar of Distal set 2 traced by one user.
+func assignedSynapses() *JsonSynapses {
+	traced := func(x VoxelCoord, set int, userids ...string) JsonSynapse {
+		psd := JsonPsd{Location: Point3d{x + 2, 10, 1}, Body: 2}
+		for _, userid := range userids {
+			psd.Tracings = append(psd.Tracings, JsonTracing{Userid: userid,
+				Result: 2, Stack: "Distal", AssignmentSet: set})
+		}
+		return JsonSynapse{
+			Tbar: JsonTbar{Location: Point3d{x, 10, 1}, Body: 1,
+				Assignment: AssignmentName("Distal", set)},
+			Psds: []JsonPsd{psd},
+		}
+	}
+	return &JsonSynapses{
+		Metadata: map[string]interface{}{"description": "synapse set"},
+		Data: []JsonSynapse{
+			traced(10, 1, "alice", "bob"),
+			traced(20, 1, "alice", "bob"),
+			traced(30, 2, "carol"),
+		},
+	}
+}
+
+func TestAssignmentCounts(t *testing.T) {
+	expected := AssignmentStatusList{
+		{Stack: "Distal", Set: 1, Tbars: 2,
+			PsdTracings: map[string]int{"alice": 2, "bob": 2}},
+		{Stack: "Distal", Set: 2, Tbars: 1,
+			PsdTracings: map[string]int{"carol": 1}},
+	}
+	counts := assignedSynapses().AssignmentCounts()
+	if !reflect.DeepEqual(counts, expected) {
+		t.Errorf("expected counts %+v, got %+v", expected, counts)
+	}
+	if name := counts[1].Name(); name != "Distal-2" {
+		t.Errorf("expected assignment name Distal-2, got %s", name)
+	}
+}
+
+func TestAssignmentCompletionRoundTrip(t *testing.T) {
+	synapses := assignedSynapses()
+	if synapses.IsAssignmentComplete("Distal", 1) {
+		t.Fatal("Distal-1 complete before being marked")
+	}
+	if err := synapses.MarkAssignmentComplete("Distal", 1); err != nil {
+		t.Fatal(err)
+	}
+	if !synapses.IsAssignmentComplete("Distal", 1) ||
+		synapses.IsAssignmentComplete("Distal", 2) {
+		t.Fatal("only Distal-1 should be complete")
+	}
+
+	filename := filepath.Join(t.TempDir(), "synapses.json")
+	if err := synapses.WriteJsonFileE(filename); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadSynapsesJson(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !read.IsAssignmentComplete("Distal", 1) ||
+		read.IsAssignmentComplete("Distal", 2) ||
+		read.IsAssignmentComplete("Proximal", 1) {
+		t.Error("completion not preserved through write and read")
+	}
+	completed, err := read.CompletedAssignments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := AssignmentStatusList{{Stack: "Distal", Set: 1, Tbars: 2,
+		PsdTracings: map[string]int{"alice": 2, "bob": 2}, Complete: true}}
+	if !reflect.DeepEqual(completed, expected) {
+		t.Errorf("expected completed %+v, got %+v", expected, completed)
+	}
+	if read.Metadata["description"] != "synapse set" {
+		t.Errorf("other metadata lost: %v", read.Metadata)
+	}
+
+	// Marking after reading extends the decoded list, and remarking
+	// replaces the earlier counts.
+	read.Data = read.Data[:1]
+	if err = read.MarkAssignmentComplete("Distal", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err = read.MarkAssignmentComplete("Distal", 1); err != nil {
+		t.Fatal(err)
+	}
+	completed, _ = read.CompletedAssignments()
+	if len(completed) != 2 || completed[0].Tbars != 1 ||
+		completed[1].Set != 2 || !completed[1].Complete {
+		t.Errorf("unexpected completed assignments: %+v", completed)
+	}
+}
+
+func TestCompletedAssignmentsBadMetadata(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString(`{"metadata": {"completed assignments": "Distal-1"}}`)
+	synapses, err := ReadSynapsesJsonFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = synapses.CompletedAssignments(); err == nil {
+		t.Error("expected error for malformed completed assignments")
+	}
+	if synapses.IsAssignmentComplete("Distal", 1) {
+		t.Error("malformed metadata reported as complete")
+	}
+	if err = synapses.MarkAssignmentComplete("Distal", 1); err == nil {
+		t.Error("expected error marking with malformed metadata")
+	}
+}
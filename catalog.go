@@ -0,0 +1,257 @@
+// Copyright 2012 HHMI.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//     * Neither the name of HHMI nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+// Author: katzw@janelia.hhmi.org (Bill Katz)
+//  Written as part of the FlyEM Project at Janelia Farm Research Center.
+
+package emdata
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// StackInfo describes what is available in a stack directory.
+type StackInfo struct {
+	Directory        string   `json:"directory"`
+	Bounds           Bounds3d `json:"bounds"`
+	SuperpixelFormat string   `json:"superpixel format"`
+	TileSizes        []int    `json:"tile sizes"`
+	HasMaps          bool     `json:"has maps"`
+	HasBounds        bool     `json:"has superpixel bounds"`
+	HasSynapses      bool     `json:"has synapse annotations"`
+	HasBodies        bool     `json:"has body annotations"`
+	MapLines         int      `json:"estimated map lines,omitempty"`
+	Error            string   `json:"error,omitempty"`
+}
+
+// CatalogOptions controls how much work CatalogStacks does per stack.
+type CatalogOptions struct {
+	// EstimateMapLines estimates the # of superpixel->segment map lines
+	// from the file size and the length of its first lines.  Gzipped
+	// maps are not estimated.
+	EstimateMapLines bool
+}
+
+// CatalogStacks probes each stack directory without estimating map
+// sizes.  See CatalogStacksWithOptions.
+func CatalogStacks(dirs []string) ([]StackInfo, error) {
+	return CatalogStacksWithOptions(dirs, CatalogOptions{})
+}
+
+// CatalogStacksWithOptions describes each stack directory using its tiles
+// metadata and the presence of standard files.  A stack that can't be
+// read has its Error set rather than failing the batch; an error is
+// returned only if every stack failed.
+func CatalogStacksWithOptions(dirs []string, options CatalogOptions) (
+	catalog []StackInfo, err error) {
+
+	failed := 0
+	for _, dir := range dirs {
+		info := probeStack(dir, options)
+		if info.Error != "" {
+			failed++
+		}
+		catalog = append(catalog, info)
+	}
+	if len(dirs) > 0 && failed == len(dirs) {
+		err = fmt.Errorf("None of %d stacks could be read", len(dirs))
+	}
+	return
+}
+
+// fileExists returns true if a regular file exists.
+func fileExists(filename string) bool {
+	info, err := os.Stat(filename)
+	return err == nil && !info.IsDir()
+}
+
+// probeStack returns the information for one stack directory.
+func probeStack(dir string, options CatalogOptions) (info StackInfo) {
+	info.Directory = dir
+	if stat, err := os.Stat(dir); err != nil || !stat.IsDir() {
+		info.Error = fmt.Sprintf("Not a stack directory: %s", dir)
+		return
+	}
+	stack := &BaseStack{Stack{Directory: dir}}
+	bounds, format, err := stack.ReadTilesMetadata()
+	if err != nil {
+		info.Error = err.Error()
+	}
+	info.Bounds = bounds
+	info.SuperpixelFormat = format.String()
+	info.TileSizes = tileSizes(dir)
+
+	// Data files may be gzipped, as accepted by the readers.
+	spSegFilename := textFilename(
+		filepath.Join(dir, SuperpixelToSegmentFilename))
+	info.HasMaps = fileExists(spSegFilename) &&
+		fileExists(textFilename(filepath.Join(dir, SegmentToBodyFilename)))
+	info.HasBounds = fileExists(
+		textFilename(stack.StackSuperpixelBoundsFilename()))
+	info.HasSynapses = fileExists(
+		textFilename(stack.StackSynapsesJsonFilename()))
+	info.HasBodies = fileExists(textFilename(stack.StackBodiesJsonFilename()))
+	if options.EstimateMapLines && info.HasMaps {
+		info.MapLines = estimateLines(spSegFilename)
+	}
+	return
+}
+
+// tileSizes returns the tile sizes present as numbered directories
+// under the stack's tiles directory.
+func tileSizes(dir string) (sizes []int) {
+	file, err := os.Open(filepath.Join(dir, "tiles"))
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	names, _ := file.Readdirnames(-1)
+	for _, name := range names {
+		if size, err := strconv.Atoi(name); err == nil && size > 0 {
+			sizes = append(sizes, size)
+		}
+	}
+	sort.Ints(sizes)
+	return
+}
+
+// estimateLines estimates the # of lines in a text file by sampling the
+// line length at the start of the file.  Gzipped files return 0.
+func estimateLines(filename string) int {
+	if strings.HasSuffix(filename, GzipSuffix) {
+		return 0 // Compressed size says little about the # of lines
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return 0
+	}
+	const sampleLines = 1000
+	lineReader := bufio.NewReader(file)
+	lines, bytesRead := 0, 0
+	for lines < sampleLines {
+		line, err := lineReader.ReadString('\n')
+		if len(line) > 0 {
+			lines++
+			bytesRead += len(line)
+		}
+		if err != nil {
+			return lines // Read whole file
+		}
+	}
+	return int(stat.Size() * int64(lines) / int64(bytesRead))
+}
+
+// WriteStackCatalogCsv writes a stack catalog in CSV format.
+func WriteStackCatalogCsv(writer io.Writer, catalog []StackInfo) {
+	csvWriter := csv.NewWriter(writer)
+	record := []string{"Directory", "Min X", "Min Y", "Min Z", "Max X",
+		"Max Y", "Max Z", "Superpixel format", "Tile sizes", "Has maps",
+		"Has superpixel bounds", "Has synapse annotations",
+		"Has body annotations", "Estimated map lines", "Error"}
+	err := csvWriter.Write(record)
+	if err != nil {
+		log.Fatalln("ERROR: Unable to write header to CSV:", err)
+	}
+	for _, info := range catalog {
+		sizes := make([]string, len(info.TileSizes))
+		for i, size := range info.TileSizes {
+			sizes[i] = strconv.Itoa(size)
+		}
+		record := []string{
+			info.Directory,
+			info.Bounds.MinPt.X().String(),
+			info.Bounds.MinPt.Y().String(),
+			info.Bounds.MinPt.Z().String(),
+			info.Bounds.MaxPt.X().String(),
+			info.Bounds.MaxPt.Y().String(),
+			info.Bounds.MaxPt.Z().String(),
+			info.SuperpixelFormat,
+			strings.Join(sizes, " "),
+			strconv.FormatBool(info.HasMaps),
+			strconv.FormatBool(info.HasBounds),
+			strconv.FormatBool(info.HasSynapses),
+			strconv.FormatBool(info.HasBodies),
+			strconv.Itoa(info.MapLines),
+			info.Error}
+		err := csvWriter.Write(record)
+		if err != nil {
+			log.Fatalln("ERROR: Unable to write line of CSV for stack",
+				info.Directory, ":", err)
+		}
+	}
+	csvWriter.Flush()
+}
+
+// WriteStackCatalogCsvFile writes a stack catalog into a CSV file.
+func WriteStackCatalogCsvFile(filename string, catalog []StackInfo) {
+	file, err := os.Create(filename)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to create stack catalog csv file: %s [%s]\n",
+			filename, err)
+	}
+	WriteStackCatalogCsv(file, catalog)
+	file.Close()
+}
+
+// WriteStackCatalogJson writes a stack catalog as indented JSON.
+func WriteStackCatalogJson(writer io.Writer, catalog []StackInfo) {
+	m, err := json.Marshal(catalog)
+	if err != nil {
+		log.Fatalf("Error in writing json: %s", err)
+	}
+	var buf bytes.Buffer
+	json.Indent(&buf, m, "", "    ")
+	buf.WriteTo(writer)
+}
+
+// WriteStackCatalogJsonFile writes a stack catalog into a JSON file.
+func WriteStackCatalogJsonFile(filename string, catalog []StackInfo) {
+	file, err := os.Create(filename)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to create stack catalog json file: %s [%s]\n",
+			filename, err)
+	}
+	WriteStackCatalogJson(file, catalog)
+	file.Close()
+}
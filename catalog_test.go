@@ -0,0 +1,133 @@
+// Copyright 2012 HHMI.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//     * Neither the name of HHMI nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+// Author: katzw@janelia.hhmi.org (Bill Katz)
+//  Written as part of the FlyEM Project at Janelia Farm Research Center.
+
+package emdata
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// gzipInPlace replaces a file with its gzipped version.
+func gzipInPlace(t *testing.T, filename string) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create(filename + GzipSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := gzip.NewWriter(file)
+	if _, err = writer.Write(data); err == nil {
+		err = writer.Close()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Remove(filename); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCatalogStacks(t *testing.T) {
+	complete := filepath.Join(t.TempDir(), "complete")
+	synth, err := CreateSyntheticStack(complete, DefaultSyntheticStackParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Gzipped data files count as present.
+	gzipInPlace(t, filepath.Join(complete, SegmentToBodyFilename))
+	gzipInPlace(t, synth.StackSynapsesJsonFilename())
+
+	incomplete := filepath.Join(t.TempDir(), "incomplete")
+	if err = os.MkdirAll(filepath.Join(incomplete, "tiles"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	metadata := "width=100\nheight=100\nzmin=0\n"
+	err = os.WriteFile(filepath.Join(incomplete, "tiles", "metadata.txt"),
+		[]byte(metadata), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	catalog, err := CatalogStacks([]string{complete, incomplete})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(catalog) != 2 {
+		t.Fatalf("expected 2 stacks, got %d", len(catalog))
+	}
+	info := catalog[0]
+	if info.Error != "" || !info.HasMaps || !info.HasBounds ||
+		!info.HasSynapses || !info.HasBodies {
+		t.Errorf("complete stack: got %+v", info)
+	}
+	info = catalog[1]
+	if !strings.Contains(info.Error, "zmax not provided") || info.HasMaps ||
+		info.HasBounds || info.HasSynapses || info.HasBodies {
+		t.Errorf("incomplete stack: got %+v", info)
+	}
+}
+
+func TestReadTilesMetadataErrors(t *testing.T) {
+	tests := []struct {
+		metadata string
+		errText  string
+	}{
+		{"width=abc\nheight=10\nzmin=0\nzmax=5\n", "width"},
+		{"width=10\nheight=10\nzmin=0\nzmax=five\n", "zmax"},
+		{"width=10\nheight=10\nzmin=0\n", "zmax not provided"},
+		{"superpixel-format=X\nzmin=0\nzmax=5\n", "superpixel format"},
+	}
+	for _, test := range tests {
+		_, _, err := ReadTilesMetadataFrom(strings.NewReader(test.metadata))
+		if err == nil || !strings.Contains(err.Error(), test.errText) {
+			t.Errorf("%q: expected error with %q, got %v", test.metadata,
+				test.errText, err)
+		}
+	}
+	bounds, _, err := ReadTilesMetadataFrom(
+		strings.NewReader("width=10\r\nheight=20\r\nzmin=3\r\nzmax=5"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bounds.MaxPt[0] != 9 || bounds.MaxPt[1] != 19 || bounds.MinPt[2] != 3 ||
+		bounds.MaxPt[2] != 5 {
+		t.Errorf("got bounds %v", bounds)
+	}
+}
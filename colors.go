@@ -0,0 +1,194 @@
+// Copyright 2012 HHMI.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//     * Neither the name of HHMI nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+// Author: katzw@janelia.hhmi.org (Bill Katz)
+//  Written as part of the FlyEM Project at Janelia Farm Research Center.
+
+package emdata
+
+import (
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"hash/fnv"
+	"image/color"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+)
+
+// BodyColorOptions bounds the saturation and lightness (both 0 to 1 in
+// HSL space) of body colors.  Keeping lightness away from 0 and 1 avoids
+// near-black and near-white colors.
+type BodyColorOptions struct {
+	MinSaturation float64
+	MaxSaturation float64
+	MinLightness  float64
+	MaxLightness  float64
+}
+
+// DefaultBodyColorOptions gives saturated, mid-lightness colors.
+var DefaultBodyColorOptions = BodyColorOptions{
+	MinSaturation: 0.55,
+	MaxSaturation: 0.95,
+	MinLightness:  0.35,
+	MaxLightness:  0.65,
+}
+
+// BodyColor returns a color for a body using DefaultBodyColorOptions.
+func BodyColor(body BodyId) color.NRGBA {
+	return DefaultBodyColorOptions.Color(body)
+}
+
+// Color returns a pseudo-random color for a body computed from a hash
+// of the body id, so a body has the same color across runs and platforms.
+func (options BodyColorOptions) Color(body BodyId) color.NRGBA {
+	var id [8]byte
+	binary.LittleEndian.PutUint64(id[:], uint64(body))
+	return options.hashColor(id[:])
+}
+
+// CellTypeColor returns a pseudo-random color for a cell type computed
+// from a hash of its name.
+func (options BodyColorOptions) CellTypeColor(cellType string) color.NRGBA {
+	return options.hashColor([]byte(cellType))
+}
+
+// hashColor returns a color computed from an FNV-1a hash of data.
+func (options BodyColorOptions) hashColor(data []byte) color.NRGBA {
+	hash := fnv.New64a()
+	hash.Write(data)
+	h := hash.Sum64()
+
+	hue := float64(h%3600) / 10.0
+	satFraction := float64((h>>16)&0xffff) / 65535.0
+	lightFraction := float64((h>>32)&0xffff) / 65535.0
+	s := options.MinSaturation +
+		float64(satFraction*(options.MaxSaturation-options.MinSaturation))
+	l := options.MinLightness +
+		float64(lightFraction*(options.MaxLightness-options.MinLightness))
+	r, g, b := hslToRgb(hue, s, l)
+	return color.NRGBA{r, g, b, 255}
+}
+
+// hslToRgb converts hue in degrees and saturation and lightness in [0,1]
+// to 8-bit RGB.  Products are explicitly rounded to float64 so fused
+// multiply-adds cannot change results between platforms.
+func hslToRgb(hue, s, l float64) (r, g, b uint8) {
+	c := float64((1.0 - math.Abs(float64(2.0*l)-1.0)) * s)
+	x := float64(c * (1.0 - math.Abs(math.Mod(hue/60.0, 2.0)-1.0)))
+	m := l - c/2.0
+	var r1, g1, b1 float64
+	switch {
+	case hue < 60.0:
+		r1, g1, b1 = c, x, 0
+	case hue < 120.0:
+		r1, g1, b1 = x, c, 0
+	case hue < 180.0:
+		r1, g1, b1 = 0, c, x
+	case hue < 240.0:
+		r1, g1, b1 = 0, x, c
+	case hue < 300.0:
+		r1, g1, b1 = x, 0, c
+	default:
+		r1, g1, b1 = c, 0, x
+	}
+	toByte := func(v float64) uint8 {
+		return uint8(math.Floor(float64((v+m)*255.0) + 0.5))
+	}
+	return toByte(r1), toByte(g1), toByte(b1)
+}
+
+// HexColor returns a color as "#rrggbb".
+func HexColor(c color.NRGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// BodyColorMap holds the color of each body.
+type BodyColorMap map[BodyId]color.NRGBA
+
+// BodyColors returns colors for a set of bodies using
+// DefaultBodyColorOptions.
+func BodyColors(bodySet BodySet) BodyColorMap {
+	return DefaultBodyColorOptions.ColorMap(bodySet)
+}
+
+// ColorMap returns colors for a set of bodies.
+func (options BodyColorOptions) ColorMap(bodySet BodySet) BodyColorMap {
+	colors := make(BodyColorMap, len(bodySet))
+	for bodyId, _ := range bodySet {
+		colors[bodyId] = options.Color(bodyId)
+	}
+	return colors
+}
+
+// WriteCsv writes a palette file with the hex color of each body.
+func (colors BodyColorMap) WriteCsv(writer io.Writer) {
+	bodies := make(BodyIdList, 0, len(colors))
+	for bodyId, _ := range colors {
+		bodies = append(bodies, bodyId)
+	}
+	sort.Sort(bodies)
+
+	csvWriter := csv.NewWriter(writer)
+	err := csvWriter.Write([]string{"Body ID", "Color"})
+	if err != nil {
+		log.Fatalln("ERROR: Unable to write header to CSV:", err)
+	}
+	for _, bodyId := range bodies {
+		record := []string{bodyId.String(), HexColor(colors[bodyId])}
+		if err := csvWriter.Write(record); err != nil {
+			log.Fatalln("ERROR: Unable to write line of CSV for body",
+				bodyId, ":", err)
+		}
+	}
+	csvWriter.Flush()
+}
+
+// WriteCsvFile writes a palette CSV file.
+func (colors BodyColorMap) WriteCsvFile(filename string) {
+	file, err := os.Create(filename)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to create body color csv file: %s [%s]\n",
+			filename, err)
+	}
+	colors.WriteCsv(file)
+	file.Close()
+}
+
+// BodyColors returns colors for all named or connected bodies of a
+// connectome for use as a palette alongside its exports.
+func (c Connectome) BodyColors() BodyColorMap {
+	bodySet := make(BodySet)
+	for bodyId, _ := range c.ConnectivityStats() {
+		bodySet[bodyId] = true
+	}
+	return BodyColors(bodySet)
+}
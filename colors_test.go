@@ -0,0 +1,183 @@
+// Copyright 2012 HHMI.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//     * Neither the name of HHMI nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+// Author: katzw@janelia.hhmi.org (Bill Katz)
+//  Written as part of the FlyEM Project at Janelia Farm Research Center.
+
+package emdata
+
+import (
+	"bytes"
+	"image/color"
+	"testing"
+)
+
+func TestBodyColorPinned(t *testing.T) {
+	// Values computed independently from the FNV-1a and HSL definitions.
+	// A change here changes the colors of every published export.
+	pinned := map[BodyId]string{
+		0:         "#6bb5dc",
+		1:         "#a62a54",
+		2:         "#a330d3",
+		1000:      "#301eaa",
+		123456789: "#100dd2",
+		-5:        "#db8a57",
+	}
+	for body, expected := range pinned {
+		if hex := HexColor(BodyColor(body)); hex != expected {
+			t.Errorf("body %d: expected color %s, got %s", body, expected,
+				hex)
+		}
+		if c := BodyColor(body); c.A != 255 {
+			t.Errorf("body %d: expected opaque color, got %v", body, c)
+		}
+	}
+}
+
+func TestHslToRgb(t *testing.T) {
+	tests := []struct {
+		hue, s, l float64
+		expected  color.NRGBA
+	}{
+		{0, 1, 0.5, color.NRGBA{255, 0, 0, 255}},
+		{120, 1, 0.5, color.NRGBA{0, 255, 0, 255}},
+		{240, 1, 0.5, color.NRGBA{0, 0, 255, 255}},
+		{60, 1, 0.5, color.NRGBA{255, 255, 0, 255}},
+		{300, 1, 0.25, color.NRGBA{128, 0, 128, 255}},
+		{200, 0, 0.5, color.NRGBA{128, 128, 128, 255}},
+	}
+	for _, test := range tests {
+		r, g, b := hslToRgb(test.hue, test.s, test.l)
+		if c := (color.NRGBA{r, g, b, 255}); c != test.expected {
+			t.Errorf("hsl(%v, %v, %v): expected %v, got %v", test.hue,
+				test.s, test.l, test.expected, c)
+		}
+	}
+}
+
+func TestBodyColorSpread(t *testing.T) {
+	const numBodies = 1000
+	colors := make(map[color.NRGBA]bool)
+	var hueBins [12]int
+	for body := BodyId(1); body <= numBodies; body++ {
+		c := BodyColor(body)
+		colors[c] = true
+		max, min := c.R, c.R
+		for _, v := range []uint8{c.G, c.B} {
+			if v > max {
+				max = v
+			}
+			if v < min {
+				min = v
+			}
+		}
+		// No near-black or near-white colors.
+		lightness := (float64(max) + float64(min)) / 510.0
+		if lightness < 0.34 || lightness > 0.66 {
+			t.Errorf("body %d has lightness %f: %v", body, lightness, c)
+		}
+		if max-min < 64 {
+			t.Errorf("body %d is nearly gray: %v", body, c)
+		}
+		hueBins[hueBin(c)]++
+	}
+	if len(colors) < numBodies-5 {
+		t.Errorf("expected nearly %d distinct colors, got %d", numBodies,
+			len(colors))
+	}
+	for bin, n := range hueBins {
+		if n < numBodies/24 {
+			t.Errorf("only %d of %d colors in hue bin %d: %v", n,
+				numBodies, bin, hueBins)
+		}
+	}
+}
+
+// hueBin returns which 30 degree range of hue holds a saturated color.
+func hueBin(c color.NRGBA) int {
+	r, g, b := float64(c.R), float64(c.G), float64(c.B)
+	max := r
+	if g > max {
+		max = g
+	}
+	if b > max {
+		max = b
+	}
+	min := r
+	if g < min {
+		min = g
+	}
+	if b < min {
+		min = b
+	}
+	delta := max - min
+	var hue float64
+	switch max {
+	case r:
+		hue = 60 * (g - b) / delta
+		if hue < 0 {
+			hue += 360
+		}
+	case g:
+		hue = 60*(b-r)/delta + 120
+	default:
+		hue = 60*(r-g)/delta + 240
+	}
+	return int(hue/30) % 12
+}
+
+func TestBodyColorMap(t *testing.T) {
+	colors := BodyColors(BodySet{1: true, 1000: true, 2: true})
+	if len(colors) != 3 || colors[1000] != BodyColor(1000) {
+		t.Errorf("unexpected color map: %v", colors)
+	}
+	var buf bytes.Buffer
+	colors.WriteCsv(&buf)
+	expected := "Body ID,Color\n1,#a62a54\n2,#a330d3\n1000,#301eaa\n"
+	if buf.String() != expected {
+		t.Errorf("expected palette:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	options := BodyColorOptions{
+		MinSaturation: 0,
+		MaxSaturation: 0,
+		MinLightness:  0.5,
+		MaxLightness:  0.5,
+	}
+	if c := options.Color(1); c != (color.NRGBA{128, 128, 128, 255}) {
+		t.Errorf("expected gray with zero saturation, got %v", c)
+	}
+	if a, b := BodyColor(7), options.Color(7); a == b {
+		t.Errorf("options ignored: %v", a)
+	}
+	tm3 := DefaultBodyColorOptions.CellTypeColor("Tm3")
+	if tm3 != DefaultBodyColorOptions.CellTypeColor("Tm"+"3") ||
+		tm3 == DefaultBodyColorOptions.CellTypeColor("Mi1") {
+		t.Errorf("cell type colors not determined by name: %v", tm3)
+	}
+}
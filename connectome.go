@@ -37,9 +37,11 @@ import (
 	"encoding/csv"
 	"encoding/gob"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
@@ -97,11 +99,42 @@ func (list ConnectionList) SortByStrength() {
 type ConnectivityMap map[BodyId](map[BodyId]Connection)
 
 // Connectome holds both a catalog of neurons and their connectivity.
+// Connectomes from NewConnectome and the readers always have non-nil
+// maps, and every connection present in Connectivity is non-nil.
 type Connectome struct {
 	Neurons      NamedBodyMap
 	Connectivity ConnectivityMap
 }
 
+// NewConnectome returns an empty connectome with the given neurons.
+func NewConnectome(neurons NamedBodyMap) *Connectome {
+	c := &Connectome{Neurons: neurons}
+	c.normalize()
+	return c
+}
+
+// normalize allocates any nil maps and connections, which Gob decoding
+// returns for empty values.
+func (c *Connectome) normalize() {
+	if c.Neurons == nil {
+		c.Neurons = make(NamedBodyMap)
+	}
+	if c.Connectivity == nil {
+		c.Connectivity = make(ConnectivityMap)
+	}
+	for pre, connections := range c.Connectivity {
+		if connections == nil {
+			connections = make(map[BodyId]Connection)
+			c.Connectivity[pre] = connections
+		}
+		for post, connection := range connections {
+			if connection == nil {
+				connections[post] = Connection{}
+			}
+		}
+	}
+}
+
 // WriteGob writes connectome data in Go Gob format
 func (c Connectome) WriteGob(writer io.Writer) {
 	enc := gob.NewEncoder(writer)
@@ -130,10 +163,11 @@ func ReadGob(reader io.Reader) *Connectome {
 	if err != nil {
 		log.Fatalf("Error in reading connectome gob: %s", err)
 	}
+	connectome.normalize()
 	return &connectome
 }
 
-// ReadGobFile writes connectome data into a CSV file.
+// ReadGobFile reads connectome data from a Gob file.
 func ReadGobFile(filename string) (c *Connectome) {
 	file, err := os.Open(filename)
 	if err != nil {
@@ -152,23 +186,22 @@ func writeJsonLine(writer io.Writer, text string) {
 	}
 }
 
-// WriteJson writes connectome data in JSON format
+// WriteJson writes connectome data in JSON format: a "bodies" list of
+// NamedBody objects sorted by name and a "connections" matrix whose
+// rows and columns follow the same order, as read by ReadConnectomeJson.
 func (c Connectome) WriteJson(writer io.Writer) {
-	numBodies := len(c.Neurons)
+	bodies, matrix := c.AsAdjacencyMatrix()
 	writeJsonLine(writer, "{")
 
 	// Write named body list as object with list of NamedBody objects
 	writeJsonLine(writer, "\"bodies\": [")
-	first := true
-	for _, namedBody := range c.Neurons {
-		m, err := json.Marshal(namedBody)
+	for i, bodyId := range bodies {
+		m, err := json.Marshal(c.Neurons[bodyId])
 		if err != nil {
 			log.Fatalf("Error in writing connectome json: %s", err)
 		}
 		var buf bytes.Buffer
-		if first {
-			first = false
-		} else {
+		if i > 0 {
 			buf.Write([]byte(",\n"))
 		}
 		json.Indent(&buf, m, "", "    ")
@@ -178,18 +211,10 @@ func (c Connectome) WriteJson(writer io.Writer) {
 
 	// Write connections as a matrix (list of lists of ints)
 	writeJsonLine(writer, "\"connections\": [")
-	connectionsList := make([]string, 0, numBodies)
-	for bodyId, _ := range c.Neurons {
-		bodyConnectMap, bodyFound := c.Connectivity[bodyId]
-		strengthsList := make([]string, 0, numBodies)
-		for bodyId2, _ := range c.Neurons {
-			strength := 0
-			if bodyFound {
-				connection, connectFound := bodyConnectMap[bodyId2]
-				if connectFound {
-					strength = len(connection)
-				}
-			}
+	connectionsList := make([]string, 0, len(bodies))
+	for _, strengths := range matrix {
+		strengthsList := make([]string, 0, len(strengths))
+		for _, strength := range strengths {
 			strengthsList = append(strengthsList,
 				fmt.Sprintf("%d", strength))
 		}
@@ -211,6 +236,59 @@ func (c Connectome) WriteJsonFile(filename string) {
 	file.Close()
 }
 
+// ReadConnectomeJson reads a connectome written by WriteJson.  Since
+// only connection strengths are written, each connection is restored
+// as that many synapses holding just the pre- and post-synaptic bodies.
+// Strengths may not exceed MaxReadStrength.
+func ReadConnectomeJson(reader io.Reader) (c Connectome, err error) {
+	var data struct {
+		Bodies      []NamedBody `json:"bodies"`
+		Connections [][]int     `json:"connections"`
+	}
+	if err = json.NewDecoder(reader).Decode(&data); err != nil {
+		return
+	}
+	if len(data.Connections) != len(data.Bodies) {
+		err = fmt.Errorf("connectome JSON has %d bodies but %d rows "+
+			"of connections", len(data.Bodies), len(data.Connections))
+		return
+	}
+	c.Neurons = make(NamedBodyMap, len(data.Bodies))
+	c.Connectivity = make(ConnectivityMap)
+	for _, namedBody := range data.Bodies {
+		c.Neurons[namedBody.Body] = namedBody
+	}
+	for i, strengths := range data.Connections {
+		if len(strengths) != len(data.Bodies) {
+			err = fmt.Errorf("connectome JSON row %d has %d connections "+
+				"for %d bodies", i, len(strengths), len(data.Bodies))
+			return
+		}
+		for j, strength := range strengths {
+			err = c.addStrength(data.Bodies[i].Body, data.Bodies[j].Body,
+				strength)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// ReadConnectomeJsonFile reads a connectome from a JSON file.
+func ReadConnectomeJsonFile(filename string) (Connectome, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return Connectome{}, err
+	}
+	defer file.Close()
+	c, err := ReadConnectomeJson(file)
+	if err != nil {
+		return Connectome{}, jsonFileError(filename, err)
+	}
+	return c, nil
+}
+
 // ConnectionsSortedByName returns a sorted list of NamedConnection
 func (c Connectome) ConnectionsSortedByName() (list ConnectionList) {
 	list = make(ConnectionList, 0, len(c.Neurons))
@@ -237,7 +315,8 @@ func (c Connectome) ConnectionStrength(pre, post BodyId) (
 	strength = 0
 	connections, found := c.Connectivity[pre]
 	if found {
-		connection, found := connections[post]
+		var connection Connection
+		connection, found = connections[post]
 		if found {
 			strength = connection.Strength()
 			if strength == 0 {
@@ -250,24 +329,632 @@ func (c Connectome) ConnectionStrength(pre, post BodyId) (
 
 // AddSynapse adds a synapse to a given connectome.
 func (c *Connectome) AddSynapse(s *Synapse) {
-	if len(c.Connectivity) == 0 {
+	if c.Connectivity == nil {
 		c.Connectivity = make(ConnectivityMap)
 	}
 	preId := s.Pre.Body
 	postId := s.Post.Body
-	connections, preFound := c.Connectivity[preId]
-	if preFound {
-		_, postFound := connections[postId]
-		if postFound {
-			c.Connectivity[preId][postId] = append(
-				c.Connectivity[preId][postId], *s)
-		} else {
-			c.Connectivity[preId][postId] = []Synapse{*s}
+	connections := c.Connectivity[preId]
+	if connections == nil {
+		connections = make(map[BodyId]Connection)
+		c.Connectivity[preId] = connections
+	}
+	connections[postId] = append(connections[postId], *s)
+}
+
+// TracingError describes a PSD whose first two tracings disagree.
+type TracingError struct {
+	Tbar    Point3d
+	Psd     Point3d
+	Results [2]TracingResult
+}
+
+func (e TracingError) Error() string {
+	return fmt.Sprintf("PSD %s of T-bar %s traced to %s and %s",
+		e.Psd, e.Tbar, e.Results[0], e.Results[1])
+}
+
+// ConnectivityFromTracings builds a connectome among the given neurons
+// from traced PSDs, using the T-bar body as the pre-synaptic body and
+// the anchor body reached by the PSD's tracings as the post-synaptic
+// body.  If requireAgreement is true, a PSD contributes a synapse only
+// if its first two tracings reached the same anchor.  Otherwise the
+// first tracing to reach an anchor is used.  PSDs whose first two
+// tracings disagree are returned in either case.
+func (synapses *JsonSynapses) ConnectivityFromTracings(neurons NamedBodyMap,
+	requireAgreement bool) (c Connectome, disagreements []TracingError) {
+
+	c = *NewConnectome(neurons)
+	for _, synapse := range synapses.Data {
+		for _, psd := range synapse.Psds {
+			agreed := false
+			if len(psd.Tracings) >= 2 {
+				results := [2]TracingResult{psd.Tracings[0].Result,
+					psd.Tracings[1].Result}
+				if results[0] != results[1] {
+					disagreements = append(disagreements, TracingError{
+						synapse.Tbar.Location, psd.Location, results})
+				} else {
+					agreed = results[0] >= MinAnchor
+				}
+			}
+			if requireAgreement && !agreed {
+				continue
+			}
+			post := psd
+			post.Body = 0
+			for _, tracing := range psd.Tracings {
+				if tracing.Result >= MinAnchor {
+					post.Body = BodyId(tracing.Result)
+					break
+				}
+			}
+			_, preFound := neurons[synapse.Tbar.Body]
+			_, postFound := neurons[post.Body]
+			if post.Body == 0 || !preFound || !postFound {
+				continue
+			}
+			c.AddSynapse(&Synapse{synapse.Tbar, post})
 		}
-	} else {
-		c.Connectivity[preId] = make(map[BodyId]Connection)
-		c.Connectivity[preId][postId] = []Synapse{*s}
 	}
+	return
+}
+
+// MaxReadStrength is the largest connection strength accepted by the
+// connectome readers.  Each unit of strength is restored as a synapse,
+// so an unbounded strength in an input file could exhaust memory.
+var MaxReadStrength = 100000
+
+// parseStrength parses a connection strength, rounding non-integer
+// values like "2.0" written by other graph tools.
+func parseStrength(value string) (int, error) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(f) || f < 0 || f > float64(MaxReadStrength) {
+		return 0, fmt.Errorf("strength %s not within 0 to %d", value,
+			MaxReadStrength)
+	}
+	return int(math.Floor(f + 0.5)), nil
+}
+
+// addStrength adds the given # of synapses holding only the pre- and
+// post-synaptic bodies, for readers of formats without synapse details.
+// Strengths above MaxReadStrength return an error.
+func (c *Connectome) addStrength(pre, post BodyId, strength int) error {
+	if strength < 0 || strength > MaxReadStrength {
+		return fmt.Errorf("strength %d of connection %d -> %d not within "+
+			"0 to %d", strength, pre, post, MaxReadStrength)
+	}
+	if strength == 0 {
+		return nil
+	}
+	if c.Connectivity == nil {
+		c.Connectivity = make(ConnectivityMap)
+	}
+	connections := c.Connectivity[pre]
+	if connections == nil {
+		connections = make(map[BodyId]Connection)
+		c.Connectivity[pre] = connections
+	}
+	// Grow the connection once rather than appending each synapse.
+	connection := connections[post]
+	grown := make(Connection, len(connection)+strength)
+	copy(grown, connection)
+	for i := len(connection); i < len(grown); i++ {
+		grown[i].Pre.Body = pre
+		grown[i].Post.Body = post
+	}
+	connections[post] = grown
+	return nil
+}
+
+// BodyConnectivityStats holds the synapse and partner counts of a body
+// within a connectome.
+type BodyConnectivityStats struct {
+	PreSynapses  int // # of synapses where body is pre-synaptic
+	PostSynapses int // # of synapses where body is post-synaptic
+	PostPartners int // # of bodies this body synapses onto
+	PrePartners  int // # of bodies synapsing onto this body
+}
+
+// TotalSynapses returns the # of synapses involving the body.
+func (stats BodyConnectivityStats) TotalSynapses() int {
+	return stats.PreSynapses + stats.PostSynapses
+}
+
+// ConnectivityStats returns the connectivity stats of every body that
+// is named or has connections in the connectome.
+func (c Connectome) ConnectivityStats() map[BodyId]BodyConnectivityStats {
+	statsMap := make(map[BodyId]BodyConnectivityStats)
+	for bodyId, _ := range c.Neurons {
+		statsMap[bodyId] = BodyConnectivityStats{}
+	}
+	for pre, connections := range c.Connectivity {
+		for post, connection := range connections {
+			preStats := statsMap[pre]
+			preStats.PreSynapses += connection.Strength()
+			preStats.PostPartners++
+			statsMap[pre] = preStats
+
+			postStats := statsMap[post]
+			postStats.PostSynapses += connection.Strength()
+			postStats.PrePartners++
+			statsMap[post] = postStats
+		}
+	}
+	return statsMap
+}
+
+// InDegree returns the # of pre-synaptic partners of every body that is
+// named or has connections in the connectome.
+func (c Connectome) InDegree() map[BodyId]int {
+	degrees := make(map[BodyId]int)
+	for bodyId, stats := range c.ConnectivityStats() {
+		degrees[bodyId] = stats.PrePartners
+	}
+	return degrees
+}
+
+// OutDegree returns the # of post-synaptic partners of every body that
+// is named or has connections in the connectome.
+func (c Connectome) OutDegree() map[BodyId]int {
+	degrees := make(map[BodyId]int)
+	for bodyId, stats := range c.ConnectivityStats() {
+		degrees[bodyId] = stats.PostPartners
+	}
+	return degrees
+}
+
+// TotalInputSynapses returns the # of synapses where the body is
+// post-synaptic.
+func (c Connectome) TotalInputSynapses(id BodyId) (total int) {
+	for _, connections := range c.Connectivity {
+		total += connections[id].Strength()
+	}
+	return
+}
+
+// TotalOutputSynapses returns the # of synapses where the body is
+// pre-synaptic.
+func (c Connectome) TotalOutputSynapses(id BodyId) (total int) {
+	for _, connection := range c.Connectivity[id] {
+		total += connection.Strength()
+	}
+	return
+}
+
+// InputFraction returns the fraction of a body's synapses that are
+// inputs, or 0 if the body has no synapses.
+func (c Connectome) InputFraction(id BodyId) float64 {
+	inputs := c.TotalInputSynapses(id)
+	total := inputs + c.TotalOutputSynapses(id)
+	if total == 0 {
+		return 0.0
+	}
+	return float64(inputs) / float64(total)
+}
+
+// ReciprocalConnections returns each pair of distinct bodies connected
+// in both directions, with the lower body id first, sorted by body ids.
+func (c Connectome) ReciprocalConnections() (pairs [][2]BodyId) {
+	for pre, connections := range c.Connectivity {
+		for post, connection := range connections {
+			if pre >= post || connection.Strength() == 0 {
+				continue
+			}
+			if c.Connectivity[post][pre].Strength() > 0 {
+				pairs = append(pairs, [2]BodyId{pre, post})
+			}
+		}
+	}
+	sort.Sort(bodyPairList(pairs))
+	return
+}
+
+type bodyPairList [][2]BodyId
+
+func (list bodyPairList) Len() int {
+	return len(list)
+}
+
+func (list bodyPairList) Swap(i, j int) {
+	list[i], list[j] = list[j], list[i]
+}
+
+func (list bodyPairList) Less(i, j int) bool {
+	if list[i][0] != list[j][0] {
+		return list[i][0] < list[j][0]
+	}
+	return list[i][1] < list[j][1]
+}
+
+// BodyPredicate returns true if a body should be kept in a connectome.
+// Bodies that aren't named are passed an empty NamedBody.
+type BodyPredicate func(BodyId, NamedBody, BodyConnectivityStats) bool
+
+// MinTotalSynapses keeps bodies involved in at least n synapses.
+func MinTotalSynapses(n int) BodyPredicate {
+	return func(_ BodyId, _ NamedBody, stats BodyConnectivityStats) bool {
+		return stats.TotalSynapses() >= n
+	}
+}
+
+// RequireNamed keeps bodies that have a name.
+func RequireNamed() BodyPredicate {
+	return func(_ BodyId, namedBody NamedBody, _ BodyConnectivityStats) bool {
+		return namedBody.Name != ""
+	}
+}
+
+// FragmentsBody is the pseudo-body that receives the synapses of pruned
+// bodies when pruning redirects to fragments.
+const FragmentsBody BodyId = -1
+
+// FragmentsName is the name given to FragmentsBody.
+const FragmentsName = "fragments"
+
+// PruneOptions controls how pruned bodies are removed from a connectome.
+type PruneOptions struct {
+	// RedirectToFragments moves the synapses of pruned bodies onto
+	// FragmentsBody so total synapse counts are conserved.
+	RedirectToFragments bool
+}
+
+// PruneBodies removes bodies failing the predicate along with all their
+// connections and returns the # of bodies and synapses removed.
+func (c *Connectome) PruneBodies(predicate BodyPredicate) (
+	removedBodies, removedSynapses int) {
+
+	return c.PruneBodiesWithOptions(predicate, PruneOptions{})
+}
+
+// PruneBodiesWithOptions removes bodies failing the predicate.  If
+// synapses are redirected to fragments, the returned # of synapses
+// is the # redirected rather than removed.
+func (c *Connectome) PruneBodiesWithOptions(predicate BodyPredicate,
+	options PruneOptions) (removedBodies, removedSynapses int) {
+
+	pruned := make(BodySet)
+	for bodyId, stats := range c.ConnectivityStats() {
+		if options.RedirectToFragments && bodyId == FragmentsBody {
+			continue
+		}
+		if !predicate(bodyId, c.Neurons[bodyId], stats) {
+			pruned[bodyId] = true
+		}
+	}
+	removedBodies = len(pruned)
+	if removedBodies == 0 {
+		return
+	}
+
+	var redirected []Synapse
+	for pre, connections := range c.Connectivity {
+		for post, connection := range connections {
+			if !pruned[pre] && !pruned[post] {
+				continue
+			}
+			removedSynapses += connection.Strength()
+			if options.RedirectToFragments {
+				for _, synapse := range connection {
+					if pruned[pre] {
+						synapse.Pre.Body = FragmentsBody
+					}
+					if pruned[post] {
+						synapse.Post.Body = FragmentsBody
+					}
+					redirected = append(redirected, synapse)
+				}
+			}
+			delete(connections, post)
+		}
+		if len(connections) == 0 {
+			delete(c.Connectivity, pre)
+		}
+	}
+	for bodyId, _ := range pruned {
+		delete(c.Neurons, bodyId)
+	}
+	if options.RedirectToFragments {
+		for i := range redirected {
+			c.AddSynapse(&redirected[i])
+		}
+		if c.Neurons != nil {
+			c.Neurons[FragmentsBody] = NamedBody{Body: FragmentsBody,
+				Name: FragmentsName}
+		}
+	}
+	return
+}
+
+// SubgraphByBodies returns a connectome with only the neurons in the
+// given bodies and the connections between them.
+func (c Connectome) SubgraphByBodies(bodies NamedBodyMap) Connectome {
+	return c.subgraph(bodies, func(pre, post bool) bool {
+		return pre && post
+	})
+}
+
+// SubgraphByPreBodies returns a connectome with the connections whose
+// pre-synaptic body is one of the given bodies, along with the neurons
+// of the given bodies and their post-synaptic partners.
+func (c Connectome) SubgraphByPreBodies(bodies NamedBodyMap) Connectome {
+	return c.subgraph(bodies, func(pre, post bool) bool {
+		return pre
+	})
+}
+
+// SubgraphByPostBodies returns a connectome with the connections whose
+// post-synaptic body is one of the given bodies, along with the neurons
+// of the given bodies and their pre-synaptic partners.
+func (c Connectome) SubgraphByPostBodies(bodies NamedBodyMap) Connectome {
+	return c.subgraph(bodies, func(pre, post bool) bool {
+		return post
+	})
+}
+
+// subgraph copies the connections for which keep returns true given
+// whether the pre- and post-synaptic bodies are in the body set, and
+// the neurons of the body set and of kept connections.
+func (c Connectome) subgraph(bodies NamedBodyMap,
+	keep func(pre, post bool) bool) (sub Connectome) {
+
+	sub.Neurons = make(NamedBodyMap)
+	sub.Connectivity = make(ConnectivityMap)
+	addNeuron := func(bodyId BodyId) {
+		if namedBody, found := c.Neurons[bodyId]; found {
+			sub.Neurons[bodyId] = namedBody
+		}
+	}
+	for bodyId, _ := range bodies {
+		addNeuron(bodyId)
+	}
+	for pre, connections := range c.Connectivity {
+		_, preFound := bodies[pre]
+		for post, connection := range connections {
+			_, postFound := bodies[post]
+			if !keep(preFound, postFound) {
+				continue
+			}
+			if sub.Connectivity[pre] == nil {
+				sub.Connectivity[pre] = make(map[BodyId]Connection)
+			}
+			sub.Connectivity[pre][post] = append(Connection(nil),
+				connection...)
+			addNeuron(pre)
+			addNeuron(post)
+		}
+	}
+	return
+}
+
+// NameMergePolicy determines how a name update changes a named body.
+type NameMergePolicy int
+
+const (
+	// MergeLabels changes only the non-empty name, cell type, and
+	// location of the update, keeping stats and other fields.
+	MergeLabels NameMergePolicy = iota
+
+	// ReplaceNamedBody replaces the entire named body with the update.
+	ReplaceNamedBody
+)
+
+// NameUpdateReport describes the result of applying name updates.
+type NameUpdateReport struct {
+	Renamed    int
+	Retyped    int
+	Missing    BodyIdList            // Updated bodies not in connectome
+	Collisions map[string]BodyIdList // Names newly shared by bodies
+}
+
+// bodiesByName returns the sorted body ids that have each name.
+func (bodyMap NamedBodyMap) bodiesByName() map[string]BodyIdList {
+	names := make(map[string]BodyIdList)
+	for bodyId, namedBody := range bodyMap {
+		if namedBody.Name != "" {
+			names[namedBody.Name] = append(names[namedBody.Name], bodyId)
+		}
+	}
+	for _, bodies := range names {
+		sort.Sort(bodies)
+	}
+	return names
+}
+
+// ApplyNames updates the names and cell types of neurons.  Since all
+// writers use the Neurons map, any later output uses the new names.
+// Bodies in the updates that are neither named nor connected in the
+// connectome are skipped and reported, as are names that become shared
+// by more bodies than before.
+func (c *Connectome) ApplyNames(updates NamedBodyMap,
+	policy NameMergePolicy) (report NameUpdateReport) {
+
+	if c.Neurons == nil {
+		c.Neurons = make(NamedBodyMap)
+	}
+	before := c.Neurons.bodiesByName()
+	bodies := c.ConnectivityStats()
+	for bodyId, update := range updates {
+		namedBody, found := c.Neurons[bodyId]
+		if !found {
+			if _, connected := bodies[bodyId]; !connected {
+				report.Missing = append(report.Missing, bodyId)
+				continue
+			}
+			namedBody.Body = bodyId
+		}
+		updated := namedBody
+		switch policy {
+		case ReplaceNamedBody:
+			updated = update
+			updated.Body = bodyId
+		default:
+			if update.Name != "" {
+				updated.Name = update.Name
+			}
+			if update.CellType != "" {
+				updated.CellType = update.CellType
+			}
+			if update.Location != "" {
+				updated.Location = update.Location
+			}
+		}
+		if updated.Name != namedBody.Name {
+			report.Renamed++
+		}
+		if updated.CellType != namedBody.CellType {
+			report.Retyped++
+		}
+		c.Neurons[bodyId] = updated
+	}
+	sort.Sort(report.Missing)
+
+	report.Collisions = make(map[string]BodyIdList)
+	for name, bodies := range c.Neurons.bodiesByName() {
+		if len(bodies) > 1 && len(bodies) > len(before[name]) {
+			report.Collisions[name] = bodies
+		}
+	}
+	return
+}
+
+// NamedBodyChanges returns the named bodies of newer that are not in
+// older or whose name, cell type, or location differ.
+func NamedBodyChanges(older, newer NamedBodyMap) (changes NamedBodyMap) {
+	changes = make(NamedBodyMap)
+	for bodyId, namedBody := range newer {
+		old, found := older[bodyId]
+		if !found || old.Name != namedBody.Name ||
+			old.CellType != namedBody.CellType ||
+			old.Location != namedBody.Location {
+			changes[bodyId] = namedBody
+		}
+	}
+	return
+}
+
+// ApplyNamedBodiesCsvChanges applies the changes between two revisions
+// of a named bodies CSV file to the connectome.
+func (c *Connectome) ApplyNamedBodiesCsvChanges(olderFilename,
+	newerFilename string, policy NameMergePolicy) NameUpdateReport {
+
+	older := ReadNamedBodiesCsv(NamedBodyOptions{Filename: olderFilename})
+	newer := ReadNamedBodiesCsv(NamedBodyOptions{Filename: newerFilename})
+	changes := NamedBodyChanges(older, newer)
+	log.Println("Applying", len(changes), "named body changes from",
+		newerFilename)
+	return c.ApplyNames(changes, policy)
+}
+
+// LockViolation is a connection involving an unlocked body that is too
+// strong to be released.
+type LockViolation struct {
+	Pre        BodyId
+	Post       BodyId
+	Strength   int
+	PreLocked  bool
+	PostLocked bool
+}
+
+// LockViolationList is sorted by descending strength, then pre and post.
+type LockViolationList []LockViolation
+
+func (list LockViolationList) Len() int {
+	return len(list)
+}
+
+func (list LockViolationList) Swap(i, j int) {
+	list[i], list[j] = list[j], list[i]
+}
+
+func (list LockViolationList) Less(i, j int) bool {
+	if list[i].Strength != list[j].Strength {
+		return list[i].Strength > list[j].Strength
+	}
+	if list[i].Pre != list[j].Pre {
+		return list[i].Pre < list[j].Pre
+	}
+	return list[i].Post < list[j].Post
+}
+
+// LockCheck is the result of checking a connectome for release.
+type LockCheck struct {
+	MinStrength int
+	Connections int // # of connections at or above MinStrength
+	Violations  LockViolationList
+	Passed      bool
+}
+
+// CheckLocks verifies that every connection of at least minStrength is
+// between locked bodies.  Bodies not in the named body map are unlocked.
+// Bodies in overrides are treated as locked.
+func (c Connectome) CheckLocks(named NamedBodyMap, minStrength int,
+	overrides BodySet) (check LockCheck) {
+
+	locked := func(bodyId BodyId) bool {
+		return named[bodyId].Locked || overrides[bodyId]
+	}
+	check.MinStrength = minStrength
+	for pre, connections := range c.Connectivity {
+		for post, connection := range connections {
+			strength := connection.Strength()
+			if strength == 0 || strength < minStrength {
+				continue
+			}
+			check.Connections++
+			if !locked(pre) || !locked(post) {
+				check.Violations = append(check.Violations, LockViolation{
+					Pre:        pre,
+					Post:       post,
+					Strength:   strength,
+					PreLocked:  named[pre].Locked,
+					PostLocked: named[post].Locked,
+				})
+			}
+		}
+	}
+	sort.Sort(check.Violations)
+	check.Passed = len(check.Violations) == 0
+	return
+}
+
+// WriteCsv writes the lock violations in CSV format.
+func (check LockCheck) WriteCsv(writer io.Writer) {
+	csvWriter := csv.NewWriter(writer)
+	record := []string{"Pre body", "Post body", "Strength", "Pre locked",
+		"Post locked"}
+	err := csvWriter.Write(record)
+	if err != nil {
+		log.Fatalln("ERROR: Unable to write header to CSV:", err)
+	}
+	for _, violation := range check.Violations {
+		record := []string{
+			violation.Pre.String(),
+			violation.Post.String(),
+			strconv.Itoa(violation.Strength),
+			strconv.FormatBool(violation.PreLocked),
+			strconv.FormatBool(violation.PostLocked)}
+		err := csvWriter.Write(record)
+		if err != nil {
+			log.Fatalln("ERROR: Unable to write line of CSV for bodies",
+				violation.Pre, violation.Post, ":", err)
+		}
+	}
+	csvWriter.Flush()
+}
+
+// WriteCsvFile writes the lock violations into a CSV file.
+func (check LockCheck) WriteCsvFile(filename string) {
+	file, err := os.Create(filename)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to create lock check csv file: %s [%s]\n",
+			filename, err)
+	}
+	check.WriteCsv(file)
+	file.Close()
 }
 
 /*
@@ -439,21 +1126,482 @@ func (c Connectome) WriteNeuroptikonFile(filename string) {
 	file.Close()
 }
 
-// WriteCsv writes connectome data in CSV format with body names as
-// headers for rows/columns
-func (c Connectome) WriteCsv(writer io.Writer) {
+// GraphML attribute keys for body and connection data.
+const (
+	graphmlBodyIdKey   = "body_id"
+	graphmlNameKey     = "name"
+	graphmlCellTypeKey = "cell_type"
+	graphmlLocationKey = "location"
+	graphmlWeightKey   = "weight"
+)
 
-	csvWriter := csv.NewWriter(writer)
+type graphmlDocument struct {
+	XMLName xml.Name     `xml:"graphml"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Keys    []graphmlKey `xml:"key"`
+	Graph   graphmlGraph `xml:"graph"`
+}
+
+type graphmlKey struct {
+	Id   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphmlGraph struct {
+	Id          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphmlNode `xml:"node"`
+	Edges       []graphmlEdge `xml:"edge"`
+}
+
+type graphmlNode struct {
+	Id   string        `xml:"id,attr"`
+	Data []graphmlData `xml:"data"`
+}
+
+type graphmlEdge struct {
+	Id     string        `xml:"id,attr"`
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphmlData `xml:"data"`
+}
+
+type graphmlData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// graphmlNodeId returns the GraphML node id of a body.
+func graphmlNodeId(bodyId BodyId) string {
+	return fmt.Sprintf("n%d", bodyId)
+}
+
+// WriteGraphML writes connectome data as a directed GraphML 1.0
+// document for import into Gephi, yEd or igraph.  Each body is a node
+// and each connection an edge weighted by its strength.  Bodies in
+// connections but not in Neurons get nodes with only a body id.
+func (c Connectome) WriteGraphML(writer io.Writer) {
+	doc := graphmlDocument{
+		Xmlns: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphmlKey{
+			{graphmlBodyIdKey, "node", graphmlBodyIdKey, "long"},
+			{graphmlNameKey, "node", graphmlNameKey, "string"},
+			{graphmlCellTypeKey, "node", graphmlCellTypeKey, "string"},
+			{graphmlLocationKey, "node", graphmlLocationKey, "string"},
+			{graphmlWeightKey, "edge", graphmlWeightKey, "int"},
+		},
+		Graph: graphmlGraph{Id: "connectome", EdgeDefault: "directed"},
+	}
+	bodySet := make(BodySet, len(c.Neurons))
+	for bodyId, _ := range c.Neurons {
+		bodySet[bodyId] = true
+	}
+	for pre, connections := range c.Connectivity {
+		bodySet[pre] = true
+		for post, _ := range connections {
+			bodySet[post] = true
+		}
+	}
+	bodyIds := make(BodyIdList, 0, len(bodySet))
+	for bodyId, _ := range bodySet {
+		bodyIds = append(bodyIds, bodyId)
+	}
+	sort.Sort(bodyIds)
+	for _, bodyId := range bodyIds {
+		node := graphmlNode{Id: graphmlNodeId(bodyId)}
+		node.Data = append(node.Data, graphmlData{graphmlBodyIdKey,
+			strconv.FormatInt(int64(bodyId), 10)})
+		if namedBody, found := c.Neurons[bodyId]; found {
+			node.Data = append(node.Data,
+				graphmlData{graphmlNameKey, namedBody.Name},
+				graphmlData{graphmlCellTypeKey, namedBody.CellType},
+				graphmlData{graphmlLocationKey, namedBody.Location})
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, node)
+	}
+	for _, pre := range bodyIds {
+		connections := c.Connectivity[pre]
+		posts := make(BodyIdList, 0, len(connections))
+		for post, _ := range connections {
+			posts = append(posts, post)
+		}
+		sort.Sort(posts)
+		for _, post := range posts {
+			edge := graphmlEdge{
+				Id:     fmt.Sprintf("e%d", len(doc.Graph.Edges)),
+				Source: graphmlNodeId(pre),
+				Target: graphmlNodeId(post),
+				Data: []graphmlData{{graphmlWeightKey,
+					strconv.Itoa(connections[post].Strength())}},
+			}
+			doc.Graph.Edges = append(doc.Graph.Edges, edge)
+		}
+	}
+	m, err := xml.MarshalIndent(&doc, "", "  ")
+	if err != nil {
+		log.Fatalf("ERROR: Unable to write GraphML: %s", err)
+	}
+	_, err = io.WriteString(writer, xml.Header)
+	if err == nil {
+		_, err = writer.Write(m)
+	}
+	if err == nil {
+		_, err = io.WriteString(writer, "\n")
+	}
+	if err != nil {
+		log.Fatalf("ERROR: Unable to write GraphML: %s", err)
+	}
+}
+
+// WriteGraphMLFile writes connectome data into a GraphML file.
+func (c Connectome) WriteGraphMLFile(filename string) {
+	file, err := os.Create(filename)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to create connectome GraphML file: %s [%s]\n",
+			filename, err)
+	}
+	c.WriteGraphML(file)
+	file.Close()
+}
+
+// DotMaxPenWidth is the pen width of the strongest edge written by
+// WriteDOT.  Weaker edges are scaled down to a minimum width of 1.
+var DotMaxPenWidth = 5.0
+
+// dotEscaper escapes strings for quoted Graphviz DOT ids.
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// WriteDOT writes the connections of at least minStrength as a Graphviz
+// DOT directed graph.  Nodes are bodies with such connections and any
+// named neurons, labeled by name if named and colored by cell type.
+// Edge weights are connection strengths, and pen widths scale with
+// strength up to DotMaxPenWidth.
+func (c Connectome) WriteDOT(writer io.Writer, minStrength int) error {
+	bodySet := make(BodySet, len(c.Neurons))
+	for bodyId, _ := range c.Neurons {
+		bodySet[bodyId] = true
+	}
+	var edges connectionEdgeList
+	maxStrength := 0
+	for pre, connections := range c.Connectivity {
+		for post, connection := range connections {
+			strength := connection.Strength()
+			if strength == 0 || strength < minStrength {
+				continue
+			}
+			edges = append(edges, connectionEdge{pre, post, strength})
+			bodySet[pre] = true
+			bodySet[post] = true
+			if strength > maxStrength {
+				maxStrength = strength
+			}
+		}
+	}
+	sort.Sort(edges)
+	bodyIds := make(BodyIdList, 0, len(bodySet))
+	for bodyId, _ := range bodySet {
+		bodyIds = append(bodyIds, bodyId)
+	}
+	sort.Sort(bodyIds)
+
+	bufWriter := bufio.NewWriter(writer)
+	fmt.Fprintln(bufWriter, "digraph connectome {")
+	for _, bodyId := range bodyIds {
+		namedBody, named := c.Neurons[bodyId]
+		label := bodyId.String()
+		if named && namedBody.Name != "" {
+			label = namedBody.Name
+		}
+		fmt.Fprintf(bufWriter, "    %d [label=\"%s\"", bodyId,
+			dotEscaper.Replace(label))
+		if named && namedBody.CellType != "" {
+			fmt.Fprintf(bufWriter, ", style=filled, fillcolor=\"%s\"",
+				HexColor(DefaultBodyColorOptions.CellTypeColor(
+					namedBody.CellType)))
+		}
+		fmt.Fprintln(bufWriter, "];")
+	}
+	for _, edge := range edges {
+		penWidth := 1.0
+		if maxStrength > 1 {
+			penWidth += (DotMaxPenWidth - 1.0) *
+				float64(edge.strength-1) / float64(maxStrength-1)
+		}
+		fmt.Fprintf(bufWriter, "    %d -> %d [weight=%d, penwidth=%.2f];\n",
+			edge.pre, edge.post, edge.strength, penWidth)
+	}
+	fmt.Fprintln(bufWriter, "}")
+	return bufWriter.Flush()
+}
+
+// WriteDOTFile writes the connectome into a Graphviz DOT file.
+func (c Connectome) WriteDOTFile(filename string, minStrength int) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create connectome DOT file: %s", err)
+	}
+	err = c.WriteDOT(file, minStrength)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write connectome DOT file %s: %s",
+			filename, err)
+	}
+	return nil
+}
+
+// ReadGraphML returns a connectome from a GraphML document.  Node and
+// edge attributes are matched by attribute name, so documents written
+// by other tools may be read as long as they use the same names.
+// Nodes without a body_id use the number in their "n<body id>" node id.
+// Each edge becomes as many synapses as its weight, without locations.
+// Weights are rounded to integers and may not exceed MaxReadStrength.
+func ReadGraphML(reader io.Reader) (c Connectome, err error) {
+	var doc graphmlDocument
+	if err = xml.NewDecoder(reader).Decode(&doc); err != nil {
+		return
+	}
+	keyNames := make(map[string]string, len(doc.Keys))
+	for _, key := range doc.Keys {
+		keyNames[key.Id] = key.Name
+	}
+	attributes := func(data []graphmlData) map[string]string {
+		values := make(map[string]string, len(data))
+		for _, datum := range data {
+			name, found := keyNames[datum.Key]
+			if !found {
+				name = datum.Key
+			}
+			values[name] = strings.TrimSpace(datum.Value)
+		}
+		return values
+	}
+
+	c.Neurons = make(NamedBodyMap)
+	c.Connectivity = make(ConnectivityMap)
+	nodeBodies := make(map[string]BodyId, len(doc.Graph.Nodes))
+	for _, node := range doc.Graph.Nodes {
+		values := attributes(node.Data)
+		idString, found := values[graphmlBodyIdKey]
+		if !found {
+			idString = strings.TrimPrefix(node.Id, "n")
+		}
+		id, parseErr := strconv.ParseInt(idString, 10, 64)
+		if parseErr != nil {
+			err = fmt.Errorf("GraphML node %q has no body id", node.Id)
+			return
+		}
+		bodyId := BodyId(id)
+		nodeBodies[node.Id] = bodyId
+		name, named := values[graphmlNameKey]
+		if named {
+			c.Neurons[bodyId] = NamedBody{
+				Body:     bodyId,
+				Name:     name,
+				CellType: values[graphmlCellTypeKey],
+				Location: values[graphmlLocationKey],
+			}
+		}
+	}
+	for _, edge := range doc.Graph.Edges {
+		pre, preFound := nodeBodies[edge.Source]
+		post, postFound := nodeBodies[edge.Target]
+		if !preFound || !postFound {
+			err = fmt.Errorf("GraphML edge %s -> %s has unknown node",
+				edge.Source, edge.Target)
+			return
+		}
+		weight := 1
+		if value, found := attributes(edge.Data)[graphmlWeightKey]; found {
+			if weight, err = parseStrength(value); err != nil {
+				err = fmt.Errorf("GraphML edge %s -> %s has bad weight: %s",
+					edge.Source, edge.Target, err)
+				return
+			}
+		}
+		if err = c.addStrength(pre, post, weight); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// connectionEdge is a weighted pre- to post-synaptic body edge.
+type connectionEdge struct {
+	pre, post BodyId
+	strength  int
+}
+
+// connectionEdgeList sorts edges by descending strength, then by pre-
+// and post-synaptic body ids.
+type connectionEdgeList []connectionEdge
+
+func (list connectionEdgeList) Len() int {
+	return len(list)
+}
+
+func (list connectionEdgeList) Swap(i, j int) {
+	list[i], list[j] = list[j], list[i]
+}
+
+func (list connectionEdgeList) Less(i, j int) bool {
+	if list[i].strength != list[j].strength {
+		return list[i].strength > list[j].strength
+	}
+	if list[i].pre != list[j].pre {
+		return list[i].pre < list[j].pre
+	}
+	return list[i].post < list[j].post
+}
+
+// edgeListHeader names the columns of an edge list.
+var edgeListHeader = []string{"pre_body_id", "pre_name", "post_body_id",
+	"post_name", "strength"}
+
+// WriteEdgeList writes a tab-separated edge list with a header line and
+// one line per non-zero connection, sorted by descending strength.
+// Unnamed bodies have empty names.
+func (c Connectome) WriteEdgeList(writer io.Writer) error {
+	var edges connectionEdgeList
+	for pre, connections := range c.Connectivity {
+		for post, connection := range connections {
+			if connection.Strength() > 0 {
+				edges = append(edges,
+					connectionEdge{pre, post, connection.Strength()})
+			}
+		}
+	}
+	sort.Sort(edges)
+
+	tsvWriter := csv.NewWriter(writer)
+	tsvWriter.Comma = '\t'
+	if err := tsvWriter.Write(edgeListHeader); err != nil {
+		return err
+	}
+	for _, edge := range edges {
+		record := []string{
+			edge.pre.String(), c.Neurons[edge.pre].Name,
+			edge.post.String(), c.Neurons[edge.post].Name,
+			strconv.Itoa(edge.strength),
+		}
+		if err := tsvWriter.Write(record); err != nil {
+			return err
+		}
+	}
+	tsvWriter.Flush()
+	return tsvWriter.Error()
+}
+
+// ReadEdgeList reads the connectivity of an edge list written by
+// WriteEdgeList.  Neurons are not restored.  The header line is
+// optional, and lines may omit the name columns, giving only the
+// pre-synaptic body, post-synaptic body and strength.  Strengths are
+// rounded to integers and may not exceed MaxReadStrength.
+func ReadEdgeList(reader io.Reader) (c Connectome, err error) {
+	c.Neurons = make(NamedBodyMap)
+	c.Connectivity = make(ConnectivityMap)
+	tsvReader := csv.NewReader(reader)
+	tsvReader.Comma = '\t'
+	tsvReader.FieldsPerRecord = -1
+	for linenum := 1; ; linenum++ {
+		record, readErr := tsvReader.Read()
+		if readErr == io.EOF {
+			break
+		} else if readErr != nil {
+			err = readErr
+			return
+		}
+		var fields []string
+		switch len(record) {
+		case 3:
+			fields = record
+		case 5:
+			fields = []string{record[0], record[2], record[4]}
+		default:
+			err = fmt.Errorf("edge list line %d has %d columns, expected "+
+				"3 or 5", linenum, len(record))
+			return
+		}
+		if linenum == 1 && fields[0] == edgeListHeader[0] {
+			continue
+		}
+		var bodies [2]int64
+		for i, field := range fields[:2] {
+			bodies[i], err = strconv.ParseInt(strings.TrimSpace(field), 10, 64)
+			if err != nil {
+				err = fmt.Errorf("edge list line %d: %s", linenum, err)
+				return
+			}
+		}
+		strength, parseErr := parseStrength(fields[2])
+		if parseErr != nil {
+			err = fmt.Errorf("edge list line %d: %s", linenum, parseErr)
+			return
+		}
+		err = c.addStrength(BodyId(bodies[0]), BodyId(bodies[1]), strength)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// AsAdjacencyMatrix returns the connectome's neurons sorted by name and
+// a square matrix where matrix[i][j] is the strength of the connection
+// from bodies[i] to bodies[j], or 0 if there is none.
+func (c Connectome) AsAdjacencyMatrix() (bodies []BodyId, matrix [][]int) {
 	namedBodyList := c.Neurons.SortByName()
+	bodies = make([]BodyId, len(namedBodyList))
+	for i, namedBody := range namedBodyList {
+		bodies[i] = namedBody.Body
+	}
+	matrix = make([][]int, len(bodies))
+	for i, pre := range bodies {
+		matrix[i] = make([]int, len(bodies))
+		connections := c.Connectivity[pre]
+		for j, post := range bodies {
+			matrix[i][j] = connections[post].Strength()
+		}
+	}
+	return
+}
+
+// AsNormalizedMatrix returns the adjacency matrix with each connection
+// strength divided by the size of the pre-synaptic body.  Rows of bodies
+// with no positive size are left at 0.
+func (c Connectome) AsNormalizedMatrix(bodySizes map[BodyId]int) (
+	bodies []BodyId, matrix [][]float64) {
+
+	bodies, strengths := c.AsAdjacencyMatrix()
+	matrix = make([][]float64, len(bodies))
+	for i, pre := range bodies {
+		matrix[i] = make([]float64, len(bodies))
+		size := bodySizes[pre]
+		if size <= 0 {
+			continue
+		}
+		for j, strength := range strengths[i] {
+			matrix[i][j] = float64(strength) / float64(size)
+		}
+	}
+	return
+}
+
+// writeMatrixCsv writes a matrix in CSV format with body names as
+// headers for rows/columns.
+func (c Connectome) writeMatrixCsv(writer io.Writer, bodies []BodyId,
+	cell func(i, j int) string) {
+
+	csvWriter := csv.NewWriter(writer)
 
 	// Print body names along first row
-	numBodies := len(namedBodyList)
-	numCells := numBodies + 1 // Leave 1 cell for header of row/col
+	numCells := len(bodies) + 1 // Leave 1 cell for header of row/col
 	record := make([]string, numCells)
-	n := 1
-	for _, namedBody := range namedBodyList {
-		record[n] = namedBody.Name
-		n++
+	for n, bodyId := range bodies {
+		record[n+1] = c.Neurons[bodyId].Name
 	}
 	err := csvWriter.Write(record)
 	if err != nil {
@@ -461,32 +1609,31 @@ func (c Connectome) WriteCsv(writer io.Writer) {
 	}
 
 	// For every subsequent row, the first column is body name,
-	// and the rest are the strengths of (pre, post) where pre body
+	// and the rest are the cells of (pre, post) where pre body
 	// name is listed in 1st column.
-	for _, namedBody1 := range namedBodyList {
-		record[0] = namedBody1.Name
-		n := 1
-		for _, namedBody2 := range namedBodyList {
-			strength := 0
-			connections, preFound := c.Connectivity[namedBody1.Body]
-			if preFound {
-				connection, postFound := connections[namedBody2.Body]
-				if postFound {
-					strength = connection.Strength()
-				}
-			}
-			record[n] = strconv.Itoa(strength)
-			n++
+	for i, bodyId := range bodies {
+		record[0] = c.Neurons[bodyId].Name
+		for j, _ := range bodies {
+			record[j+1] = cell(i, j)
 		}
 		err := csvWriter.Write(record)
 		if err != nil {
 			log.Fatalln("ERROR: Unable to write line of CSV for ",
-				"presynaptic body", namedBody1.Name, ":", err)
+				"presynaptic body", record[0], ":", err)
 		}
 	}
 	csvWriter.Flush()
 }
 
+// WriteCsv writes connectome data in CSV format with body names as
+// headers for rows/columns
+func (c Connectome) WriteCsv(writer io.Writer) {
+	bodies, matrix := c.AsAdjacencyMatrix()
+	c.writeMatrixCsv(writer, bodies, func(i, j int) string {
+		return strconv.Itoa(matrix[i][j])
+	})
+}
+
 // WriteCsvFile writes connectome data into a CSV file.
 func (c Connectome) WriteCsvFile(filename string) {
 	file, err := os.Create(filename)
@@ -498,6 +1645,131 @@ func (c Connectome) WriteCsvFile(filename string) {
 	file.Close()
 }
 
+// WriteNormalizedCsv writes the connection strengths normalized by
+// pre-synaptic body size in CSV format with body names as headers for
+// rows/columns.
+func (c Connectome) WriteNormalizedCsv(writer io.Writer,
+	bodySizes map[BodyId]int) {
+
+	bodies, matrix := c.AsNormalizedMatrix(bodySizes)
+	c.writeMatrixCsv(writer, bodies, func(i, j int) string {
+		return strconv.FormatFloat(matrix[i][j], 'g', -1, 64)
+	})
+}
+
+// WriteNormalizedCsvFile writes normalized connection strengths into
+// a CSV file.
+func (c Connectome) WriteNormalizedCsvFile(filename string,
+	bodySizes map[BodyId]int) {
+
+	file, err := os.Create(filename)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to create connectome csv file: %s [%s]\n",
+			filename, err)
+	}
+	c.WriteNormalizedCsv(file, bodySizes)
+	file.Close()
+}
+
+// FeatureVectorMap maps a body id to a vector of connection strengths
+type FeatureVectorMap map[BodyId][]float64
+
+// FeatureVectors returns for each neuron a vector of connection strengths
+// with the reference bodies.  The first len(reference) columns are inputs
+// from each reference body and the next len(reference) columns are outputs
+// to each reference body, both in the order of the reference list.
+func (c Connectome) FeatureVectors(reference NamedBodyList) (
+	vectors FeatureVectorMap) {
+
+	numRefs := len(reference)
+	vectors = make(FeatureVectorMap, len(c.Neurons))
+	for bodyId, _ := range c.Neurons {
+		vector := make([]float64, 2*numRefs)
+		for i, refBody := range reference {
+			if strength, found := c.ConnectionStrength(refBody.Body,
+				bodyId); found {
+				vector[i] = float64(strength)
+			}
+			if strength, found := c.ConnectionStrength(bodyId,
+				refBody.Body); found {
+				vector[numRefs+i] = float64(strength)
+			}
+		}
+		vectors[bodyId] = vector
+	}
+	return
+}
+
+// L1Normalize scales each vector so its elements sum to 1.  Vectors
+// with no connections are left as zeros.
+func (vectors FeatureVectorMap) L1Normalize() {
+	for _, vector := range vectors {
+		var sum float64
+		for _, value := range vector {
+			sum += math.Abs(value)
+		}
+		if sum > 0 {
+			for i, _ := range vector {
+				vector[i] /= sum
+			}
+		}
+	}
+}
+
+// WriteFeatureVectorsCsv writes each neuron's feature vector in CSV format
+// with neurons sorted by name and a header row naming the columns,
+// e.g., "in:Mi1" and "out:Tm3".
+func (c Connectome) WriteFeatureVectorsCsv(writer io.Writer,
+	reference NamedBodyList, normalize bool) {
+
+	vectors := c.FeatureVectors(reference)
+	if normalize {
+		vectors.L1Normalize()
+	}
+
+	csvWriter := csv.NewWriter(writer)
+	numRefs := len(reference)
+	record := make([]string, 2+2*numRefs)
+	record[0] = "Body ID"
+	record[1] = "Body Name"
+	for i, refBody := range reference {
+		record[2+i] = "in:" + refBody.Name
+		record[2+numRefs+i] = "out:" + refBody.Name
+	}
+	err := csvWriter.Write(record)
+	if err != nil {
+		log.Fatalln("ERROR: Unable to write feature vector header as CSV:",
+			err)
+	}
+
+	for _, namedBody := range c.Neurons.SortByName() {
+		record[0] = namedBody.Body.String()
+		record[1] = namedBody.Name
+		for i, value := range vectors[namedBody.Body] {
+			record[2+i] = strconv.FormatFloat(value, 'g', -1, 64)
+		}
+		err := csvWriter.Write(record)
+		if err != nil {
+			log.Fatalln("ERROR: Unable to write feature vector CSV for ",
+				"body", namedBody.Name, ":", err)
+		}
+	}
+	csvWriter.Flush()
+}
+
+// WriteFeatureVectorsCsvFile writes neuron feature vectors into a CSV file.
+func (c Connectome) WriteFeatureVectorsCsvFile(filename string,
+	reference NamedBodyList, normalize bool) {
+
+	file, err := os.Create(filename)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to create feature vector csv file: %s [%s]\n",
+			filename, err)
+	}
+	c.WriteFeatureVectorsCsv(file, reference, normalize)
+	file.Close()
+}
+
 // Write every type of output file for connectome.
 func (c Connectome) WriteFiles(outputDir, baseName string) {
 	c.WriteMatlabFile(filepath.Join(outputDir, baseName+".m"), baseName)
@@ -593,6 +1865,66 @@ func ExtractNamedConnectome(c *Connectome) (nc *NamedConnectome) {
 	return
 }
 
+// WriteJson writes the connectome as an indented JSON object mapping
+// each pre-synaptic name to an object of post-synaptic names and
+// strengths, with names sorted.
+func (nc NamedConnectome) WriteJson(writer io.Writer) error {
+	m, err := json.Marshal(nc)
+	if err != nil {
+		return fmt.Errorf("error in writing named connectome json: %s", err)
+	}
+	var buf bytes.Buffer
+	json.Indent(&buf, m, "", "    ")
+	_, err = buf.WriteTo(writer)
+	return err
+}
+
+// WriteJsonFile writes the connectome into a JSON file.
+func (nc NamedConnectome) WriteJsonFile(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create named connectome JSON file: %s",
+			err)
+	}
+	err = nc.WriteJson(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write named connectome JSON file %s: %s",
+			filename, err)
+	}
+	return nil
+}
+
+// ReadNamedConnectomeJson reads a connectome written by
+// NamedConnectome.WriteJson.
+func ReadNamedConnectomeJson(reader io.Reader) (nc NamedConnectome,
+	err error) {
+
+	if err = json.NewDecoder(reader).Decode(&nc); err != nil {
+		return nil, err
+	}
+	if nc == nil {
+		nc = make(NamedConnectome)
+	}
+	return nc, nil
+}
+
+// ReadNamedConnectomeJsonFile reads a connectome from a JSON file.
+func ReadNamedConnectomeJsonFile(filename string) (NamedConnectome, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	nc, err := ReadNamedConnectomeJson(file)
+	if err != nil {
+		return nil, jsonFileError(filename, err)
+	}
+	return nc, nil
+}
+
 // ReadCsv reads connectome data in CSV format with body names as
 // headers for rows/columns
 func ReadCsv(reader io.Reader) (nc *NamedConnectome) {
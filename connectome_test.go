@@ -0,0 +1,511 @@
+// Copyright 2012 HHMI.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//     * Neither the name of HHMI nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+// Author: katzw@janelia.hhmi.org (Bill Katz)
+//  Written as part of the FlyEM Project at Janelia Farm Research Center.
+
+package emdata
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// floatWeightGraphML is a GraphML document like those written by other
+// tools, with double edge weights and nodes without body ids.
+const floatWeightGraphML = `<?xml version="1.0" encoding="UTF-8"?>
+<graphml xmlns="http://graphml.graphdrawing.org/xmlns">
+  <key id="d0" for="edge" attr.name="weight" attr.type="double"/>
+  <graph id="G" edgedefault="directed">
+    <node id="n1"/>
+    <node id="n2"/>
+    <edge source="n1" target="n2"><data key="d0">2.0</data></edge>
+    <edge source="n2" target="n1"><data key="d0">2.6</data></edge>
+    <edge source="n1" target="n1"/>
+  </graph>
+</graphml>`
+
+func TestReadGraphMLWeights(t *testing.T) {
+	c, err := ReadGraphML(strings.NewReader(floatWeightGraphML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		pre, post BodyId
+		strength  int
+	}{
+		{1, 2, 2},
+		{2, 1, 3},
+		{1, 1, 1},
+	}
+	for _, test := range tests {
+		strength, _ := c.ConnectionStrength(test.pre, test.post)
+		if strength != test.strength {
+			t.Errorf("%d -> %d: expected strength %d, got %d", test.pre,
+				test.post, test.strength, strength)
+		}
+	}
+
+	for _, weight := range []string{"1e9", "-1", "NaN", "heavy"} {
+		doc := strings.Replace(floatWeightGraphML, "2.0", weight, 1)
+		if _, err := ReadGraphML(strings.NewReader(doc)); err == nil {
+			t.Errorf("weight %s: expected error", weight)
+		}
+	}
+}
+
+func TestGraphMLRoundTrip(t *testing.T) {
+	c := NewConnectome(NamedBodyMap{
+		7: {Body: 7, Name: "Mi1", CellType: "Mi1", Location: "home"},
+		9: {Body: 9, Name: "Tm3", CellType: "Tm3"},
+	})
+	c.addStrength(7, 9, 4)
+	c.addStrength(9, 7, 1)
+	var buf bytes.Buffer
+	c.WriteGraphML(&buf)
+	read, err := ReadGraphML(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strength, _ := read.ConnectionStrength(7, 9); strength != 4 {
+		t.Errorf("7 -> 9: expected strength 4, got %d", strength)
+	}
+	if strength, _ := read.ConnectionStrength(9, 7); strength != 1 {
+		t.Errorf("9 -> 7: expected strength 1, got %d", strength)
+	}
+	if read.Neurons[7] != c.Neurons[7] {
+		t.Errorf("expected neuron %v, got %v", c.Neurons[7], read.Neurons[7])
+	}
+}
+
+func TestReadEdgeListStrengths(t *testing.T) {
+	c, err := ReadEdgeList(strings.NewReader("1\t2\t3.0\n2\t1\t1.5\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strength, _ := c.ConnectionStrength(1, 2); strength != 3 {
+		t.Errorf("1 -> 2: expected strength 3, got %d", strength)
+	}
+	if strength, _ := c.ConnectionStrength(2, 1); strength != 2 {
+		t.Errorf("2 -> 1: expected strength 2, got %d", strength)
+	}
+	if _, err = ReadEdgeList(strings.NewReader("1\t2\t1000000000\n")); err == nil {
+		t.Error("expected error for strength above MaxReadStrength")
+	}
+}
+
+func TestNamedConnectomeJsonRoundTrip(t *testing.T) {
+	var nc NamedConnectome
+	nc.AddConnection("Mi1", "Tm3", 12)
+	nc.AddConnection("Mi1", "L1 \"home\"", 3)
+	nc.AddConnection("Tm3", "Mi1", 1)
+	nc.AddConnection("Tm3", "Mi1", 4)
+
+	var buf bytes.Buffer
+	if err := nc.WriteJson(&buf); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadNamedConnectomeJson(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, nc) {
+		t.Errorf("expected %v, got %v", nc, read)
+	}
+	if strength, found := read.ConnectionStrength("Tm3", "Mi1"); !found ||
+		strength != 5 {
+		t.Errorf("expected Tm3->Mi1 strength 5, got %d", strength)
+	}
+
+	filename := filepath.Join(t.TempDir(), "named.json")
+	if err := nc.WriteJsonFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	if read, err = ReadNamedConnectomeJsonFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, nc) {
+		t.Errorf("file: expected %v, got %v", nc, read)
+	}
+
+	empty, err := ReadNamedConnectomeJson(strings.NewReader("null"))
+	if err != nil || empty == nil || len(empty) != 0 {
+		t.Errorf("expected empty connectome for null, got %v (%v)", empty, err)
+	}
+}
+
+func TestFeatureVectors(t *testing.T) {
+	c := NewConnectome(NamedBodyMap{
+		7:  {Body: 7, Name: "Mi1"},
+		9:  {Body: 9, Name: "Tm3"},
+		11: {Body: 11, Name: "L1"},
+	})
+	c.addStrength(7, 9, 4)
+	c.addStrength(9, 7, 1)
+	c.addStrength(7, 7, 2)
+	// Reference order differs from body id and name order.
+	reference := NamedBodyList{{Body: 9, Name: "Tm3"}, {Body: 7, Name: "Mi1"}}
+
+	vectors := c.FeatureVectors(reference)
+	expected := FeatureVectorMap{
+		7:  {1, 2, 4, 2},
+		9:  {0, 4, 0, 1},
+		11: {0, 0, 0, 0},
+	}
+	if !reflect.DeepEqual(vectors, expected) {
+		t.Errorf("expected vectors %v, got %v", expected, vectors)
+	}
+	vectors.L1Normalize()
+	if vectors[7][2] != 4.0/9.0 || vectors[9][1] != 0.8 || vectors[11][0] != 0 {
+		t.Errorf("unexpected normalized vectors %v", vectors)
+	}
+
+	var buf bytes.Buffer
+	c.WriteFeatureVectorsCsv(&buf, reference, false)
+	expectedCsv := "Body ID,Body Name,in:Tm3,in:Mi1,out:Tm3,out:Mi1\n" +
+		"11,L1,0,0,0,0\n" +
+		"7,Mi1,1,2,4,2\n" +
+		"9,Tm3,0,4,0,1\n"
+	if buf.String() != expectedCsv {
+		t.Errorf("expected CSV:\n%s\ngot:\n%s", expectedCsv, buf.String())
+	}
+}
+
+// pruneFixture has named bodies 1 and 2 connected to unnamed fragments
+// 3 and 4.
+func pruneFixture() *Connectome {
+	c := NewConnectome(NamedBodyMap{
+		1: {Body: 1, Name: "Mi1"},
+		2: {Body: 2, Name: "Tm3"},
+	})
+	c.addStrength(1, 2, 10)
+	c.addStrength(2, 1, 4)
+	c.addStrength(1, 3, 2)
+	c.addStrength(3, 2, 1)
+	c.addStrength(4, 3, 1)
+	return c
+}
+
+// strengths returns the strength of every connection in a connectome.
+func strengths(c *Connectome) map[[2]BodyId]int {
+	result := make(map[[2]BodyId]int)
+	for pre, connections := range c.Connectivity {
+		for post, connection := range connections {
+			result[[2]BodyId{pre, post}] = connection.Strength()
+		}
+	}
+	return result
+}
+
+func TestConnectivityStats(t *testing.T) {
+	stats := pruneFixture().ConnectivityStats()
+	expected := map[BodyId]BodyConnectivityStats{
+		1: {PreSynapses: 12, PostSynapses: 4, PostPartners: 2, PrePartners: 1},
+		2: {PreSynapses: 4, PostSynapses: 11, PostPartners: 1, PrePartners: 2},
+		3: {PreSynapses: 1, PostSynapses: 3, PostPartners: 1, PrePartners: 2},
+		4: {PreSynapses: 1, PostPartners: 1},
+	}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Errorf("expected stats %v, got %v", expected, stats)
+	}
+}
+
+func TestPruneBodies(t *testing.T) {
+	const F = FragmentsBody
+	tests := []struct {
+		name      string
+		predicate BodyPredicate
+		redirect  bool
+		bodies    int
+		synapses  int
+		expected  map[[2]BodyId]int
+	}{
+		{"min synapses", MinTotalSynapses(3), false, 1, 1,
+			map[[2]BodyId]int{{1, 2}: 10, {2, 1}: 4, {1, 3}: 2, {3, 2}: 1}},
+		{"named", RequireNamed(), false, 2, 4,
+			map[[2]BodyId]int{{1, 2}: 10, {2, 1}: 4}},
+		{"named to fragments", RequireNamed(), true, 2, 4,
+			map[[2]BodyId]int{{1, 2}: 10, {2, 1}: 4, {1, F}: 2, {F, 2}: 1,
+				{F, F}: 1}},
+		{"min synapses to fragments", MinTotalSynapses(3), true, 1, 1,
+			map[[2]BodyId]int{{1, 2}: 10, {2, 1}: 4, {1, 3}: 2, {3, 2}: 1,
+				{F, 3}: 1}},
+		{"keep all", MinTotalSynapses(1), true, 0, 0,
+			map[[2]BodyId]int{{1, 2}: 10, {2, 1}: 4, {1, 3}: 2, {3, 2}: 1,
+				{4, 3}: 1}},
+	}
+	for _, test := range tests {
+		c := pruneFixture()
+		options := PruneOptions{RedirectToFragments: test.redirect}
+		bodies, synapses := c.PruneBodiesWithOptions(test.predicate, options)
+		if bodies != test.bodies || synapses != test.synapses {
+			t.Errorf("%s: expected %d bodies and %d synapses pruned, got %d "+
+				"and %d", test.name, test.bodies, test.synapses, bodies,
+				synapses)
+		}
+		if got := strengths(c); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: expected connections %v, got %v", test.name,
+				test.expected, got)
+		}
+		fragments, named := c.Neurons[F]
+		if named != (test.redirect && test.bodies > 0) ||
+			(named && fragments.Name != FragmentsName) {
+			t.Errorf("%s: unexpected fragments body %v", test.name, fragments)
+		}
+	}
+
+	// Pruning is the drop mode of PruneBodiesWithOptions, and the
+	// fragments body is never pruned itself.
+	c := pruneFixture()
+	if bodies, synapses := c.PruneBodies(RequireNamed()); bodies != 2 ||
+		synapses != 4 {
+		t.Errorf("PruneBodies: got %d bodies and %d synapses", bodies,
+			synapses)
+	}
+	c = pruneFixture()
+	redirect := PruneOptions{RedirectToFragments: true}
+	c.PruneBodiesWithOptions(RequireNamed(), redirect)
+	if bodies, _ := c.PruneBodiesWithOptions(MinTotalSynapses(100),
+		redirect); bodies != 2 {
+		t.Errorf("expected only named bodies pruned, got %d", bodies)
+	}
+	if _, found := c.Connectivity[F][F]; !found {
+		t.Errorf("fragments body was pruned")
+	}
+}
+
+// namesFixture has a provisional Tm3 name, a named body sharing the
+// intended new name, and an unnamed but connected body 4.
+func namesFixture() *Connectome {
+	c := NewConnectome(NamedBodyMap{
+		1: {Body: 1, Name: "Tm3-like-17", CellType: "Tm3-like",
+			Locked: true},
+		2: {Body: 2, Name: "Mi1-A", CellType: "Mi1"},
+		3: {Body: 3, Name: "Tm3-B", CellType: "Tm3"},
+	})
+	c.addStrength(1, 2, 3)
+	c.addStrength(1, 4, 2)
+	return c
+}
+
+func TestApplyNames(t *testing.T) {
+	c := namesFixture()
+	report := c.ApplyNames(NamedBodyMap{
+		1:  {Name: "Tm3-B", CellType: "Tm3"},
+		2:  {CellType: "Mi1-home"},
+		4:  {Name: "L1-A"},
+		99: {Name: "ghost"},
+	}, MergeLabels)
+	if report.Renamed != 2 || report.Retyped != 2 {
+		t.Errorf("expected 2 renamed and 2 retyped, got %+v", report)
+	}
+	if !reflect.DeepEqual(report.Missing, BodyIdList{99}) {
+		t.Errorf("expected body 99 missing, got %v", report.Missing)
+	}
+	expectedCollisions := map[string]BodyIdList{"Tm3-B": {1, 3}}
+	if !reflect.DeepEqual(report.Collisions, expectedCollisions) {
+		t.Errorf("expected collisions %v, got %v", expectedCollisions,
+			report.Collisions)
+	}
+	expected := NamedBodyMap{
+		1: {Body: 1, Name: "Tm3-B", CellType: "Tm3", Locked: true},
+		2: {Body: 2, Name: "Mi1-A", CellType: "Mi1-home"},
+		3: {Body: 3, Name: "Tm3-B", CellType: "Tm3"},
+		4: {Body: 4, Name: "L1-A"},
+	}
+	if !reflect.DeepEqual(c.Neurons, expected) {
+		t.Errorf("expected neurons %v, got %v", expected, c.Neurons)
+	}
+	if _, found := c.Neurons[99]; found {
+		t.Errorf("missing body 99 was added")
+	}
+
+	// Writers use the new names.
+	var buf bytes.Buffer
+	c.WriteGraphML(&buf)
+	if !strings.Contains(buf.String(), "L1-A") ||
+		strings.Contains(buf.String(), "Tm3-like-17") {
+		t.Errorf("GraphML does not use new names:\n%s", buf.String())
+	}
+
+	// Replacing drops fields not in the update.
+	c = namesFixture()
+	report = c.ApplyNames(NamedBodyMap{1: {Name: "Tm3-C"}}, ReplaceNamedBody)
+	if c.Neurons[1] != (NamedBody{Body: 1, Name: "Tm3-C"}) ||
+		report.Renamed != 1 || report.Retyped != 1 ||
+		len(report.Collisions) != 0 {
+		t.Errorf("replace: got %+v with report %+v", c.Neurons[1], report)
+	}
+}
+
+func TestApplyNamedBodiesCsvChanges(t *testing.T) {
+	dir := t.TempDir()
+	const header = "body ID,name,cell type,location,primary,secondary,lock\n"
+	older := filepath.Join(dir, "older.csv")
+	newer := filepath.Join(dir, "newer.csv")
+	files := map[string]string{
+		older: header + "1,Tm3-like-17,Tm3-like,,,,lock\n2,Mi1-A,Mi1,,,,\n",
+		newer: header + "1,Tm3-B,Tm3,,,,lock\n2,Mi1-A,Mi1,,,,\n",
+	}
+	for filename, text := range files {
+		if err := ioutil.WriteFile(filename, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := namesFixture()
+	report := c.ApplyNamedBodiesCsvChanges(older, newer, MergeLabels)
+	if report.Renamed != 1 || report.Retyped != 1 ||
+		c.Neurons[1].Name != "Tm3-B" || c.Neurons[2].Name != "Mi1-A" {
+		t.Errorf("unexpected report %+v and neurons %v", report, c.Neurons)
+	}
+}
+
+func TestCheckLocks(t *testing.T) {
+	named := NamedBodyMap{
+		1: {Body: 1, Name: "Mi1-A", Locked: true},
+		2: {Body: 2, Name: "Tm3-A", Locked: true},
+		3: {Body: 3, Name: "Tm3-like-17"},
+	}
+	c := NewConnectome(named)
+	c.addStrength(1, 2, 9) // locked to locked
+	c.addStrength(1, 3, 7) // locked to unlocked
+	c.addStrength(4, 2, 5) // unnamed to locked
+	c.addStrength(3, 4, 2) // below threshold
+
+	check := c.CheckLocks(named, 5, nil)
+	expected := LockViolationList{
+		{Pre: 1, Post: 3, Strength: 7, PreLocked: true},
+		{Pre: 4, Post: 2, Strength: 5, PostLocked: true},
+	}
+	if check.Passed || check.Connections != 3 ||
+		!reflect.DeepEqual(check.Violations, expected) {
+		t.Errorf("expected violations %v of 3 connections, got %+v",
+			expected, check)
+	}
+	if !strings.HasPrefix(check.Summary(), "FAILED: 2 of 3") {
+		t.Errorf("unexpected summary: %s", check.Summary())
+	}
+	var buf bytes.Buffer
+	check.WriteCsv(&buf)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || lines[1] != "1,3,7,true,false" {
+		t.Errorf("unexpected CSV:\n%s", buf.String())
+	}
+
+	// Overridden bodies count as locked but are reported as unlocked.
+	check = c.CheckLocks(named, 5, BodySet{3: true})
+	expected = LockViolationList{{Pre: 4, Post: 2, Strength: 5,
+		PostLocked: true}}
+	if check.Passed || !reflect.DeepEqual(check.Violations, expected) {
+		t.Errorf("with override expected %v, got %+v", expected, check)
+	}
+	check = c.CheckLocks(named, 5, BodySet{3: true, 4: true})
+	if !check.Passed || len(check.Violations) != 0 ||
+		!strings.HasPrefix(check.Summary(), "PASSED") {
+		t.Errorf("expected pass with all overrides, got %+v", check)
+	}
+}
+
+// gobRoundTrip writes a connectome in Gob format and reads it back.
+func gobRoundTrip(c Connectome) *Connectome {
+	var buf bytes.Buffer
+	c.WriteGob(&buf)
+	return ReadGob(&buf)
+}
+
+func TestGobRoundTripEmpty(t *testing.T) {
+	for _, c := range []Connectome{{}, *NewConnectome(nil),
+		{Neurons: NamedBodyMap{1: {Body: 1, Name: "Mi1-A"}}}} {
+
+		read := gobRoundTrip(c)
+		if read.Neurons == nil || read.Connectivity == nil {
+			t.Fatalf("nil maps after reading %+v: %+v", c, read)
+		}
+		read.AddSynapse(&Synapse{Pre: JsonTbar{Body: 1},
+			Post: JsonPsd{Body: 2}})
+		if strength, found := read.ConnectionStrength(1, 2); !found ||
+			strength != 1 {
+			t.Errorf("added synapse not found: %d, %v", strength, found)
+		}
+		if len(read.Neurons) != len(c.Neurons) {
+			t.Errorf("expected %d neurons, got %v", len(c.Neurons),
+				read.Neurons)
+		}
+	}
+}
+
+func TestGobRoundTripPartial(t *testing.T) {
+	c := NewConnectome(NamedBodyMap{
+		1: {Body: 1, Name: "Mi1-A", CellType: "Mi1", Locked: true},
+	})
+	c.AddSynapse(&Synapse{
+		Pre:  JsonTbar{Location: Point3d{10, 20, 3}, Body: 1},
+		Post: JsonPsd{Location: Point3d{12, 20, 3}, Body: 2},
+	})
+	c.Connectivity[1][3] = Connection{}             // zero-length
+	c.Connectivity[4] = make(map[BodyId]Connection) // no partners
+
+	read := gobRoundTrip(*c)
+	if !reflect.DeepEqual(read.Neurons, c.Neurons) {
+		t.Errorf("expected neurons %v, got %v", c.Neurons, read.Neurons)
+	}
+	expected := Connection{{
+		Pre:  JsonTbar{Location: Point3d{10, 20, 3}, Body: 1},
+		Post: JsonPsd{Location: Point3d{12, 20, 3}, Body: 2},
+	}}
+	if !reflect.DeepEqual(read.Connectivity[1][2], expected) {
+		t.Errorf("expected connection %v, got %v", expected,
+			read.Connectivity[1][2])
+	}
+
+	// Zero-length connections stay distinct from missing connections.
+	connection, present := read.Connectivity[1][3]
+	if !present || connection == nil || len(connection) != 0 {
+		t.Errorf("expected empty connection 1->3, got %v, %v", connection,
+			present)
+	}
+	if _, found := read.ConnectionStrength(1, 3); found {
+		t.Errorf("zero-length connection reported as found")
+	}
+	if _, found := read.ConnectionStrength(1, 5); found {
+		t.Errorf("missing connection reported as found")
+	}
+	if connections, present := read.Connectivity[4]; !present ||
+		connections == nil {
+		t.Errorf("expected empty partners for body 4, got %v, %v",
+			connections, present)
+	}
+	read.AddSynapse(&Synapse{Pre: JsonTbar{Body: 4}, Post: JsonPsd{Body: 1}})
+	if strength, _ := read.ConnectionStrength(4, 1); strength != 1 {
+		t.Errorf("expected strength 1 for 4->1, got %d", strength)
+	}
+}
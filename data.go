@@ -32,11 +32,14 @@
 package emdata
 
 import (
+	"container/list"
 	"log"
+	"math"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
-	"time"
+	"sync"
 )
 
 type ValueDescription struct {
@@ -111,15 +114,101 @@ func (bodies BodySet) Unset(ids ...BodyId) {
 	}
 }
 
-// String converts a BodySet to a string of body IDs
+// NewBodySetFromSlice returns a BodySet with the given body ids.
+func NewBodySetFromSlice(ids []BodyId) BodySet {
+	bodies := make(BodySet, len(ids))
+	bodies.Set(ids...)
+	return bodies
+}
+
+// Contains returns true if the body id is in the BodySet.
+func (bodies BodySet) Contains(id BodyId) bool {
+	return bodies[id]
+}
+
+// Size returns the # of body ids in the BodySet.
+func (bodies BodySet) Size() int {
+	size := 0
+	for _, member := range bodies {
+		if member {
+			size++
+		}
+	}
+	return size
+}
+
+// Intersect returns a new BodySet of body ids in both sets.
+func (bodies BodySet) Intersect(other BodySet) BodySet {
+	result := make(BodySet)
+	for bodyId, member := range bodies {
+		if member && other[bodyId] {
+			result[bodyId] = true
+		}
+	}
+	return result
+}
+
+// Union returns a new BodySet of body ids in either set.
+func (bodies BodySet) Union(other BodySet) BodySet {
+	result := make(BodySet, len(bodies)+len(other))
+	for bodyId, member := range bodies {
+		if member {
+			result[bodyId] = true
+		}
+	}
+	for bodyId, member := range other {
+		if member {
+			result[bodyId] = true
+		}
+	}
+	return result
+}
+
+// Difference returns a new BodySet of body ids not in the other set.
+func (bodies BodySet) Difference(other BodySet) BodySet {
+	result := make(BodySet)
+	for bodyId, member := range bodies {
+		if member && !other[bodyId] {
+			result[bodyId] = true
+		}
+	}
+	return result
+}
+
+// SortedSlice returns the body ids in ascending order.
+func (bodies BodySet) SortedSlice() []BodyId {
+	list := make(BodyIdList, 0, len(bodies))
+	for bodyId, member := range bodies {
+		if member {
+			list = append(list, bodyId)
+		}
+	}
+	sort.Sort(list)
+	return list
+}
+
+// String converts a BodySet to a string of body IDs in ascending order
 func (bodies BodySet) String() string {
 	items := []string{}
-	for bodyId, _ := range bodies {
+	for _, bodyId := range bodies.SortedSlice() {
 		items = append(items, bodyId.String())
 	}
 	return strings.Join(items, ", ")
 }
 
+// BodyIdList implements sort.Interface
+type BodyIdList []BodyId
+
+func (list BodyIdList) Len() int {
+	return len(list)
+}
+func (list BodyIdList) Swap(i, j int) {
+	list[i], list[j] = list[j], list[i]
+}
+func (list BodyIdList) Less(i, j int) bool {
+	return list[i] < list[j]
+}
+
 // BodyNameSet is a set of body names
 type BodyNameSet map[string]bool
 
@@ -206,7 +295,7 @@ func (p Point2d) PixelsAtRadius(radius, maxX, maxY int) (pixels []Point2d) {
 	r := VoxelCoord(radius)
 	x := p.X()
 	y := p.Y()
-	pixels = make([]Point2d, r*8)
+	pixels = make([]Point2d, 0, r*8)
 	minXCoord := MaxCoord(0, x-r)
 	maxXCoord := MinCoord(VoxelCoord(maxX), x+r)
 	minYCoord := MaxCoord(0, y-r)
@@ -301,6 +390,69 @@ func (pt Point3d) SqrDistance(pt2 Point3d) int {
 	return dx*dx + dy*dy + dz*dz
 }
 
+// Sub returns the point minus the passed point.
+func (pt Point3d) Sub(pt2 Point3d) Point3d {
+	return Point3d{pt[0] - pt2[0], pt[1] - pt2[1], pt[2] - pt2[2]}
+}
+
+// Scale returns the point with each coordinate multiplied by factor and
+// rounded to the nearest voxel.
+func (pt Point3d) Scale(factor float64) Point3d {
+	var scaled Point3d
+	for i := 0; i < 3; i++ {
+		scaled[i] = roundVoxel(float64(pt[i]) * factor)
+	}
+	return scaled
+}
+
+// Distance returns the Euclidean distance between two points
+func (pt Point3d) Distance(pt2 Point3d) float64 {
+	return math.Sqrt(float64(pt.SqrDistance(pt2)))
+}
+
+// ManhattanDistance returns the sum of absolute coordinate differences
+// between two points
+func (pt Point3d) ManhattanDistance(pt2 Point3d) int {
+	distance := 0
+	for i := 0; i < 3; i++ {
+		d := int(pt[i] - pt2[i])
+		if d < 0 {
+			d = -d
+		}
+		distance += d
+	}
+	return distance
+}
+
+// Clamp returns the point with each coordinate limited to the given bounds.
+func (pt Point3d) Clamp(bounds Bounds3d) Point3d {
+	clamped := pt
+	for i := 0; i < 3; i++ {
+		if clamped[i] < bounds.MinPt[i] {
+			clamped[i] = bounds.MinPt[i]
+		}
+		if clamped[i] > bounds.MaxPt[i] {
+			clamped[i] = bounds.MaxPt[i]
+		}
+	}
+	return clamped
+}
+
+// LerpPoint3d linearly interpolates between a (t = 0) and b (t = 1),
+// rounding to the nearest voxel.
+func LerpPoint3d(a, b Point3d, t float64) Point3d {
+	var pt Point3d
+	for i := 0; i < 3; i++ {
+		pt[i] = roundVoxel(float64(a[i]) + t*float64(b[i]-a[i]))
+	}
+	return pt
+}
+
+// roundVoxel rounds a floating point coordinate to the nearest voxel.
+func roundVoxel(x float64) VoxelCoord {
+	return VoxelCoord(math.Floor(x + 0.5))
+}
+
 // String returns representation like "(1,2,3)"
 func (pt Point3d) String() string {
 	return "(" + pt[0].String() + "," + pt[1].String() + "," +
@@ -332,56 +484,231 @@ func (bounds Bounds3d) Include(pt Point3d) bool {
 	return true
 }
 
+// Contains returns true if the other bounds lie entirely within bounds
+func (bounds Bounds3d) Contains(other Bounds3d) bool {
+	return bounds.Include(other.MinPt) && bounds.Include(other.MaxPt)
+}
+
+// Intersect returns the overlap of two bounding boxes.  Since bounds
+// include both MinPt and MaxPt, boxes sharing a face overlap in one
+// plane.  If the boxes do not overlap, ok is false.
+func (bounds Bounds3d) Intersect(other Bounds3d) (overlap Bounds3d, ok bool) {
+	for i := 0; i < 3; i++ {
+		overlap.MinPt[i] = bounds.MinPt[i]
+		if other.MinPt[i] > overlap.MinPt[i] {
+			overlap.MinPt[i] = other.MinPt[i]
+		}
+		overlap.MaxPt[i] = bounds.MaxPt[i]
+		if other.MaxPt[i] < overlap.MaxPt[i] {
+			overlap.MaxPt[i] = other.MaxPt[i]
+		}
+		if overlap.MinPt[i] > overlap.MaxPt[i] {
+			return Bounds3d{}, false
+		}
+	}
+	return overlap, true
+}
+
+// Union returns the smallest bounding box enclosing both bounds
+func (bounds Bounds3d) Union(other Bounds3d) Bounds3d {
+	union := bounds
+	for i := 0; i < 3; i++ {
+		if other.MinPt[i] < union.MinPt[i] {
+			union.MinPt[i] = other.MinPt[i]
+		}
+		if other.MaxPt[i] > union.MaxPt[i] {
+			union.MaxPt[i] = other.MaxPt[i]
+		}
+	}
+	return union
+}
+
+// Expand returns the bounds grown by margin voxels along each axis
+// in both directions.
+func (bounds Bounds3d) Expand(margin VoxelCoord) Bounds3d {
+	expanded := bounds
+	for i := 0; i < 3; i++ {
+		expanded.MinPt[i] -= margin
+		expanded.MaxPt[i] += margin
+	}
+	return expanded
+}
+
+// Volume returns the number of voxels within bounds, including both
+// MinPt and MaxPt, or 0 if MaxPt is less than MinPt along any axis.
+func (bounds Bounds3d) Volume() int64 {
+	volume := int64(1)
+	for i := 0; i < 3; i++ {
+		extent := int64(bounds.MaxPt[i]) - int64(bounds.MinPt[i]) + 1
+		if extent <= 0 {
+			return 0
+		}
+		volume *= extent
+	}
+	return volume
+}
+
 type cacheData struct {
-	data     interface{}
-	accessed time.Time
+	key  string
+	data interface{}
 }
 
+// CacheStats counts cache lookups that found or missed their key.
+type CacheStats struct {
+	Hits   int
+	Misses int
+}
+
+// cacheList is a least-recently-used cache that is safe for concurrent
+// use.  The most recently used item is at the front of the list.
 type cacheList struct {
+	sync.RWMutex
 	varType  string
 	maxItems int
-	dataMap  map[string]cacheData
+	items    *list.List
+	dataMap  map[string]*list.Element
+	stats    CacheStats
 }
 
 // Cache creates a cache for the given type and maximum cache size.
-func Cache(cacheType interface{}, maxSize int) (cache cacheList) {
+func Cache(cacheType interface{}, maxSize int) (cache *cacheList) {
+	cache = new(cacheList)
 	cache.varType = reflect.TypeOf(cacheType).String()
 	cache.maxItems = maxSize
-	cache.dataMap = make(map[string]cacheData, maxSize)
+	cache.items = list.New()
+	cache.dataMap = make(map[string]*list.Element, maxSize)
 	return
 }
 
 // Store inserts a data with given key into the cache.  If the maximum
 // size of the cache (set during initial Cache() call) is exceeded,
-// the oldest item is replaced.
+// the least recently used item is replaced.
 func (cache *cacheList) Store(key string, data interface{}) {
-	if len(cache.dataMap) >= cache.maxItems {
-		var oldestKey string
-		var oldestTime time.Time
-		// Remove the last used data item
-		itemNum := 0
-		for cacheKey, cacheValue := range cache.dataMap {
-			if itemNum == 0 || cacheValue.accessed.Before(oldestTime) {
-				oldestKey = cacheKey
-				oldestTime = cacheValue.accessed
-			}
-			itemNum++
-		}
-		delete(cache.dataMap, oldestKey)
+	cache.Lock()
+	defer cache.Unlock()
+	if element, found := cache.dataMap[key]; found {
+		element.Value.(*cacheData).data = data
+		cache.items.MoveToFront(element)
+		return
+	}
+	for cache.items.Len() > 0 && cache.items.Len() >= cache.maxItems {
+		oldest := cache.items.Back()
+		cache.items.Remove(oldest)
+		delete(cache.dataMap, oldest.Value.(*cacheData).key)
 	}
-	var dataToCache cacheData
-	dataToCache.data = data
-	dataToCache.accessed = time.Now()
-	cache.dataMap[key] = dataToCache
+	cache.dataMap[key] = cache.items.PushFront(&cacheData{key, data})
 }
 
 // Retrieve fetches the cached data with the given key
 func (cache *cacheList) Retrieve(key string) (data interface{}, found bool) {
-	cachedObj, found := cache.dataMap[key]
+	cache.Lock()
+	defer cache.Unlock()
+	element, found := cache.dataMap[key]
 	if found {
-		data = cachedObj.data
-		cachedObj.accessed = time.Now()
-		cache.dataMap[key] = cachedObj
+		data = element.Value.(*cacheData).data
+		cache.items.MoveToFront(element)
+		cache.stats.Hits++
+	} else {
+		cache.stats.Misses++
+	}
+	return
+}
+
+// Len returns the number of items in the cache.
+func (cache *cacheList) Len() int {
+	cache.RLock()
+	defer cache.RUnlock()
+	return cache.items.Len()
+}
+
+// Clear removes all items from the cache and resets its statistics.
+func (cache *cacheList) Clear() {
+	cache.Lock()
+	defer cache.Unlock()
+	cache.items.Init()
+	cache.dataMap = make(map[string]*list.Element, cache.maxItems)
+	cache.stats = CacheStats{}
+}
+
+// Grow raises the maximum cache size to at least maxSize.
+func (cache *cacheList) Grow(maxSize int) {
+	cache.Lock()
+	defer cache.Unlock()
+	if maxSize > cache.maxItems {
+		cache.maxItems = maxSize
+	}
+}
+
+// Stats returns the number of cache hits and misses so far.
+func (cache *cacheList) Stats() CacheStats {
+	cache.RLock()
+	defer cache.RUnlock()
+	return cache.stats
+}
+
+// PointIndex provides fast lookup of the nearest point within some
+// tolerance by bucketing points into cubic cells.
+type PointIndex struct {
+	cellSize VoxelCoord
+	points   []Point3d
+	cells    map[Point3d][]int
+}
+
+// NewPointIndex creates a spatial index for the given points.  The cell
+// size should be on the order of the tolerance used in lookups.
+func NewPointIndex(points []Point3d, cellSize int) *PointIndex {
+	if cellSize < 1 {
+		cellSize = 1
+	}
+	index := &PointIndex{
+		cellSize: VoxelCoord(cellSize),
+		points:   points,
+		cells:    make(map[Point3d][]int),
+	}
+	for i, pt := range points {
+		cell := index.cell(pt)
+		index.cells[cell] = append(index.cells[cell], i)
+	}
+	return index
+}
+
+func (index *PointIndex) cell(pt Point3d) (cell Point3d) {
+	for i := 0; i < 3; i++ {
+		// Floor division so negative coordinates bucket correctly
+		c := pt[i] / index.cellSize
+		if pt[i] < 0 && pt[i]%index.cellSize != 0 {
+			c--
+		}
+		cell[i] = c
+	}
+	return
+}
+
+// Nearest returns the index of the closest point no further than
+// tolerance voxels from pt, and its squared distance.
+func (index *PointIndex) Nearest(pt Point3d, tolerance int) (i int,
+	sqrDistance int, found bool) {
+
+	minCell := index.cell(Point3d{pt[0] - VoxelCoord(tolerance),
+		pt[1] - VoxelCoord(tolerance), pt[2] - VoxelCoord(tolerance)})
+	maxCell := index.cell(Point3d{pt[0] + VoxelCoord(tolerance),
+		pt[1] + VoxelCoord(tolerance), pt[2] + VoxelCoord(tolerance)})
+	maxSqrDistance := tolerance * tolerance
+	i = -1
+	for cz := minCell[2]; cz <= maxCell[2]; cz++ {
+		for cy := minCell[1]; cy <= maxCell[1]; cy++ {
+			for cx := minCell[0]; cx <= maxCell[0]; cx++ {
+				for _, n := range index.cells[Point3d{cx, cy, cz}] {
+					d := pt.SqrDistance(index.points[n])
+					if d <= maxSqrDistance && (!found || d < sqrDistance ||
+						(d == sqrDistance && n < i)) {
+						i = n
+						sqrDistance = d
+						found = true
+					}
+				}
+			}
+		}
 	}
 	return
 }
@@ -0,0 +1,241 @@
+// Copyright 2012 HHMI.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//     * Neither the name of HHMI nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+// Author: katzw@janelia.hhmi.org (Bill Katz)
+//  Written as part of the FlyEM Project at Janelia Farm Research Center.
+
+package emdata
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPoint3dArithmetic(t *testing.T) {
+	a := Point3d{1, 2, 3}
+	b := Point3d{4, -2, 3}
+	if sub := b.Sub(a); sub != (Point3d{3, -4, 0}) {
+		t.Errorf("expected (3,-4,0), got %s", sub)
+	}
+	if a != (Point3d{1, 2, 3}) {
+		t.Errorf("Sub modified its receiver: %s", a)
+	}
+	if d := a.Distance(b); d != 5.0 {
+		t.Errorf("expected distance 5, got %f", d)
+	}
+	if d := a.ManhattanDistance(b); d != 7 {
+		t.Errorf("expected Manhattan distance 7, got %d", d)
+	}
+	if d := (Point3d{1, 1, 1}).Distance(Point3d{}); d != math.Sqrt(3) {
+		t.Errorf("expected distance sqrt(3), got %f", d)
+	}
+
+	// Coordinates round half up, including negative ones.
+	scales := []struct {
+		pt       Point3d
+		factor   float64
+		expected Point3d
+	}{
+		{Point3d{2, 4, 6}, 0.5, Point3d{1, 2, 3}},
+		{Point3d{3, 5, 1}, 0.5, Point3d{2, 3, 1}},
+		{Point3d{-3, -5, -1}, 0.5, Point3d{-1, -2, 0}},
+		{Point3d{-3, 3, 7}, -1, Point3d{3, -3, -7}},
+		{Point3d{10, -10, 4}, 0.26, Point3d{3, -3, 1}},
+		{Point3d{10, -10, 4}, 0, Point3d{}},
+	}
+	for _, test := range scales {
+		if scaled := test.pt.Scale(test.factor); scaled != test.expected {
+			t.Errorf("%s scaled by %v: expected %s, got %s", test.pt,
+				test.factor, test.expected, scaled)
+		}
+	}
+
+	// t outside [0,1] extrapolates along the line.
+	lerps := []struct {
+		a, b     Point3d
+		t        float64
+		expected Point3d
+	}{
+		{Point3d{0, 0, 0}, Point3d{10, -10, 4}, 0, Point3d{0, 0, 0}},
+		{Point3d{0, 0, 0}, Point3d{10, -10, 4}, 1, Point3d{10, -10, 4}},
+		{Point3d{0, 0, 0}, Point3d{10, -10, 4}, 0.5, Point3d{5, -5, 2}},
+		{Point3d{0, 0, 0}, Point3d{3, -3, 1}, 0.5, Point3d{2, -1, 1}},
+		{Point3d{0, 0, 0}, Point3d{10, -10, 4}, -0.5, Point3d{-5, 5, -2}},
+		{Point3d{0, 0, 0}, Point3d{10, -10, 4}, 1.5, Point3d{15, -15, 6}},
+		{Point3d{2, 2, 2}, Point3d{2, 2, 2}, 7, Point3d{2, 2, 2}},
+	}
+	for _, test := range lerps {
+		if pt := LerpPoint3d(test.a, test.b, test.t); pt != test.expected {
+			t.Errorf("lerp %s to %s at %v: expected %s, got %s", test.a,
+				test.b, test.t, test.expected, pt)
+		}
+	}
+
+	bounds := Bounds3d{Point3d{0, 0, 0}, Point3d{10, 20, 5}}
+	clamps := []struct {
+		pt, expected Point3d
+	}{
+		{Point3d{5, 5, 5}, Point3d{5, 5, 5}},
+		{Point3d{-1, 25, 3}, Point3d{0, 20, 3}},
+		{Point3d{11, -4, 9}, Point3d{10, 0, 5}},
+		{Point3d{0, 20, 0}, Point3d{0, 20, 0}},
+	}
+	for _, test := range clamps {
+		if clamped := test.pt.Clamp(bounds); clamped != test.expected {
+			t.Errorf("%s clamped to %s: expected %s, got %s", test.pt,
+				bounds, test.expected, clamped)
+		}
+	}
+}
+
+func TestBounds3d(t *testing.T) {
+	box := func(x0, y0, z0, x1, y1, z1 VoxelCoord) Bounds3d {
+		return Bounds3d{Point3d{x0, y0, z0}, Point3d{x1, y1, z1}}
+	}
+	a := box(0, 0, 0, 9, 9, 9)
+	tests := []struct {
+		name     string
+		other    Bounds3d
+		contains bool // a contains other
+		overlaps bool
+		overlap  Bounds3d
+		union    Bounds3d
+	}{
+		{"identical", a, true, true, a, a},
+		{"nested", box(2, 2, 2, 5, 5, 5), true, true, box(2, 2, 2, 5, 5, 5),
+			a},
+		{"sharing a face", box(9, 0, 0, 19, 9, 9), false, true,
+			box(9, 0, 0, 9, 9, 9), box(0, 0, 0, 19, 9, 9)},
+		{"sharing a corner", box(9, 9, 9, 12, 12, 12), false, true,
+			box(9, 9, 9, 9, 9, 9), box(0, 0, 0, 12, 12, 12)},
+		{"adjacent", box(10, 0, 0, 19, 9, 9), false, false, Bounds3d{},
+			box(0, 0, 0, 19, 9, 9)},
+		{"disjoint", box(20, 20, 20, 30, 30, 30), false, false, Bounds3d{},
+			box(0, 0, 0, 30, 30, 30)},
+		{"disjoint in z only", box(0, 0, -5, 9, 9, -1), false, false,
+			Bounds3d{}, box(0, 0, -5, 9, 9, 9)},
+	}
+	for _, test := range tests {
+		if contains := a.Contains(test.other); contains != test.contains {
+			t.Errorf("%s: expected Contains %t, got %t", test.name,
+				test.contains, contains)
+		}
+		for _, swapped := range []bool{false, true} {
+			b1, b2 := a, test.other
+			if swapped {
+				b1, b2 = b2, b1
+			}
+			overlap, ok := b1.Intersect(b2)
+			if ok != test.overlaps || overlap != test.overlap {
+				t.Errorf("%s (swapped %t): expected overlap %s (%t), got "+
+					"%s (%t)", test.name, swapped, test.overlap,
+					test.overlaps, overlap, ok)
+			}
+			if union := b1.Union(b2); union != test.union {
+				t.Errorf("%s (swapped %t): expected union %s, got %s",
+					test.name, swapped, test.union, union)
+			}
+		}
+	}
+	if !a.Contains(a) || a.Contains(a.Expand(1)) || !a.Expand(1).Contains(a) {
+		t.Errorf("unexpected Contains for expanded bounds")
+	}
+
+	volumes := []struct {
+		bounds Bounds3d
+		volume int64
+	}{
+		{a, 1000},
+		{box(9, 0, 0, 9, 9, 9), 100},
+		{box(3, 3, 3, 3, 3, 3), 1},
+		{a.Expand(1), 1728},
+		{box(-5, -5, -5, -1, -1, -1), 125},
+		{box(5, 0, 0, 4, 9, 9), 0},
+		{box(0, 0, 9, 9, 9, 0), 0},
+		{a.Expand(-5), 0},
+		{box(0, 0, 0, 99999, 99999, 99999), 1000000000000000},
+	}
+	for _, test := range volumes {
+		if volume := test.bounds.Volume(); volume != test.volume {
+			t.Errorf("%s: expected volume %d, got %d", test.bounds,
+				test.volume, volume)
+		}
+	}
+}
+
+func TestPixelsAtRadius(t *testing.T) {
+	const maxX, maxY = 20, 30
+	tests := []struct {
+		pt     Point2d
+		radius int
+		ring   int  // # of distinct pixels expected
+		origin bool // (0,0) is on the ring
+	}{
+		{Point2d{10, 10}, 0, 1, false},
+		{Point2d{10, 10}, 1, 8, false},
+		{Point2d{10, 10}, 4, 32, false},
+		{Point2d{1, 1}, 3, 9, false},   // Clipped at left and top
+		{Point2d{20, 30}, 2, 5, false}, // Clipped at right and bottom
+		{Point2d{0, 15}, 2, 9, false},  // Clipped at left only
+		{Point2d{10, 10}, 40, 0, false},
+		{Point2d{2, 2}, 2, 16, true},
+		{Point2d{0, 0}, 0, 1, true},
+	}
+	for _, test := range tests {
+		pixels := test.pt.PixelsAtRadius(test.radius, maxX, maxY)
+		distinct := make(map[Point2d]bool)
+		for _, pixel := range pixels {
+			distinct[pixel] = true
+			dx, dy := int(pixel.X()-test.pt.X()), int(pixel.Y()-test.pt.Y())
+			if dx < 0 {
+				dx = -dx
+			}
+			if dy < 0 {
+				dy = -dy
+			}
+			if dx != test.radius && dy != test.radius ||
+				dx > test.radius || dy > test.radius {
+				t.Errorf("%s radius %d: pixel %s not on ring", test.pt,
+					test.radius, pixel)
+			}
+			if pixel.X() < 0 || pixel.Y() < 0 || pixel.X() > maxX ||
+				pixel.Y() > maxY {
+				t.Errorf("%s radius %d: pixel %s outside tile", test.pt,
+					test.radius, pixel)
+			}
+		}
+		if len(distinct) != test.ring {
+			t.Errorf("%s radius %d: expected %d pixels, got %d: %v",
+				test.pt, test.radius, test.ring, len(distinct), pixels)
+		}
+		if distinct[Point2d{}] != test.origin {
+			t.Errorf("%s radius %d: expected (0,0) included %t", test.pt,
+				test.radius, test.origin)
+		}
+	}
+}
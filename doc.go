@@ -37,5 +37,21 @@ Constants and code particular to reconstruction of the
 TEM 12k x 12k x 1300 medulla are within the medulla_data.go file.
 Json data routines are mostly (but not entirely) held within jsondata.go.
 General Raveler handling is implemented by raveler.go and tiles.go.
+
+The medulla stack paths default to locations on the HHMI cluster and can
+be overridden with environment variables or Configure:
+
+	EMDATA_DISTAL_STACK_DIR             DistalStackDir
+	EMDATA_DISTAL_EXPORT_DIR            DistalExportDir
+	EMDATA_SEAMLESS_STACK_DIR           SeamlessStackDir
+	EMDATA_SEAMLESS_SYNAPSES_FILE       SeamlessSynapsesFile
+	EMDATA_SEAMLESS_EXPORT_DIR          SeamlessExportDir
+	EMDATA_SIGMUNDC_SECOND_EXPORT_DIR   SigmundcSecondExportDir
+	EMDATA_ORIG12K_STACK_DIR            Orig12kStackDir
+	EMDATA_ORIG12K_SYNAPSES_FILE        Orig12kSynapsesFile
+	EMDATA_12K_STACK_PATTERN            Stack12kPattern
+
+Which exports hold each proofreader's assignment sets can be loaded
+from a JSON file with LoadProofreadingExports.
 */
 package emdata
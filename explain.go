@@ -0,0 +1,176 @@
+// Copyright 2012 HHMI.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//     * Neither the name of HHMI nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+// Author: katzw@janelia.hhmi.org (Bill Katz)
+//  Written as part of the FlyEM Project at Janelia Farm Research Center.
+
+package emdata
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// LocationExplanation traces how a stack location is resolved to a body.
+type LocationExplanation struct {
+	Location         Point3d
+	StackBounds      Bounds3d
+	Error            string // Set if the location could not be resolved
+	TilePath         string // Path of the superpixel tile read
+	FromBase         bool   // True if the tile came from the base stack
+	ImageFormat      string // Image encoding of the tile, e.g., "png"
+	SuperpixelFormat SuperpixelFormat
+	TilePoint        Point2d // Pixel within the tile, Y-flipped from stack
+	Superpixel       Superpixel
+	Mapped           bool // True if the superpixel is in the map
+	Body             BodyId
+
+	// If the location was on body 0 or an unmapped superpixel, the
+	// nearest-body search and its result.
+	NearestSearch     bool
+	Candidates        []NearestCandidate
+	NearestBody       BodyId
+	NearestSuperpixel Superpixel
+	NearestRadius     int
+	NearestLocation   Point3d
+}
+
+// ExplainLocation resolves a stack location to a body the same way as
+// GetBodyOfLocation and GetNearestBodyOfLocation, recording each step.
+// Unlike those functions, a location outside the stack or a missing tile
+// is reported in the explanation rather than being fatal.
+func ExplainLocation(stack TiledJsonStack, pt Point3d) (
+	explanation LocationExplanation) {
+
+	explanation.Location = pt
+	bounds, format := stack.TilesMetadata()
+	explanation.StackBounds = bounds
+	explanation.SuperpixelFormat = format
+	if !bounds.Include(pt) {
+		explanation.Error = fmt.Sprintf("location falls outside stack %s",
+			bounds)
+		return
+	}
+	layout := stackTileLayout(stack)
+	relTilePath := layout.Filename(layout.TileOf(pt))
+	if !tileExists(stack, relTilePath) {
+		explanation.Error = fmt.Sprintf("superpixel tile %s not found",
+			relTilePath)
+		return
+	}
+
+	superpixels, tilePt := GetSuperpixelTilePt(stack, pt)
+	_, imageFormat, filename := ReadSuperpixelTile(stack, relTilePath)
+	explanation.TilePath = filename
+	explanation.FromBase = filename !=
+		filepath.Join(stack.String(), relTilePath)
+	explanation.ImageFormat = imageFormat
+	explanation.TilePoint = tilePt
+
+	explanation.Superpixel.Slice = uint32(pt.Z())
+	explanation.Superpixel.Label = GetSuperpixelId(superpixels,
+		tilePt.IntX(), tilePt.IntY(), format)
+	if explanation.Superpixel.Label == 0 {
+		explanation.Mapped = true
+	} else {
+		explanation.Body, explanation.Mapped =
+			stack.SuperpixelToBodyChecked(explanation.Superpixel)
+	}
+
+	if explanation.Body == 0 {
+		explanation.NearestSearch = true
+		record := func(candidate NearestCandidate) {
+			explanation.Candidates = append(explanation.Candidates, candidate)
+		}
+		explanation.NearestBody, explanation.NearestSuperpixel,
+			explanation.NearestRadius, explanation.NearestLocation, _ =
+			nearestBodyOfLocation(stack, pt, BodySet{}, BodySet{},
+				DefaultNearestBodyOptions, record)
+	}
+	return
+}
+
+// WriteText writes a human-readable explanation.
+func (explanation LocationExplanation) WriteText(writer io.Writer) error {
+	var lines []string
+	add := func(format string, args ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+	add("Location %s in stack bounds %s", explanation.Location,
+		explanation.StackBounds)
+	if explanation.Error != "" {
+		add("  Could not resolve: %s", explanation.Error)
+	} else {
+		source := "stack"
+		if explanation.FromBase {
+			source = "base stack"
+		}
+		add("  Tile: %s (from %s)", explanation.TilePath, source)
+		add("  Tile format: %s image, %s superpixels, Y-flipped pixel %s",
+			explanation.ImageFormat, explanation.SuperpixelFormat,
+			explanation.TilePoint)
+		add("  Superpixel: slice %d, label %d",
+			explanation.Superpixel.Slice, explanation.Superpixel.Label)
+		switch {
+		case explanation.Superpixel.Label == 0:
+			add("  Zero superpixel: no body")
+		case !explanation.Mapped:
+			add("  Superpixel not in superpixel->body map")
+		default:
+			add("  Body: %d", explanation.Body)
+		}
+	}
+	if explanation.NearestSearch {
+		add("  Nearest-body search examined %d superpixels:",
+			len(explanation.Candidates))
+		for _, candidate := range explanation.Candidates {
+			add("    radius %d at %s: superpixel %d -> body %d, %s",
+				candidate.Radius, candidate.Location,
+				candidate.Superpixel.Label, candidate.Body, candidate.Outcome)
+		}
+		if explanation.NearestBody == 0 {
+			add("  Nearest body: none found")
+		} else {
+			add("  Nearest body: %d (superpixel %d at radius %d, %s)",
+				explanation.NearestBody, explanation.NearestSuperpixel.Label,
+				explanation.NearestRadius, explanation.NearestLocation)
+		}
+	}
+	_, err := fmt.Fprintln(writer, strings.Join(lines, "\n"))
+	return err
+}
+
+// String returns the explanation as text.
+func (explanation LocationExplanation) String() string {
+	var buf bytes.Buffer
+	explanation.WriteText(&buf)
+	return buf.String()
+}
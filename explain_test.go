@@ -0,0 +1,185 @@
+// Copyright 2012 HHMI.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//     * Neither the name of HHMI nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+// Author: katzw@janelia.hhmi.org (Bill Katz)
+//  Written as part of the FlyEM Project at Janelia Farm Research Center.
+
+package emdata
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExplainLocationDirectHit(t *testing.T) {
+	dir := t.TempDir()
+	synth, err := CreateSyntheticStack(dir, DefaultSyntheticStackParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	superpixel := Superpixel{Slice: 2, Label: 20}
+	pt := synth.Center(superpixel)
+	explanation := ExplainLocation(&synth.BaseStack, pt)
+	tilePath := filepath.Join(dir, synth.Tiles.Filename(synth.Tiles.TileOf(pt)))
+	if explanation.Error != "" || explanation.TilePath != tilePath ||
+		explanation.FromBase || explanation.ImageFormat != "png" {
+		t.Errorf("expected tile %s from stack, got %+v", tilePath,
+			explanation)
+	}
+	body := synth.SpToBodyMap[superpixel]
+	if explanation.Superpixel != superpixel || !explanation.Mapped ||
+		explanation.Body != body || explanation.NearestSearch {
+		t.Errorf("expected direct hit on %v -> body %d, got %+v",
+			superpixel, body, explanation)
+	}
+	if bodyId, _ := GetBodyOfLocation(&synth.BaseStack, pt); bodyId != body {
+		t.Errorf("GetBodyOfLocation disagrees: body %d", bodyId)
+	}
+	text := explanation.String()
+	for _, expected := range []string{tilePath + " (from stack)",
+		"slice 2, label 20", "Body: " + body.String()} {
+		if !strings.Contains(text, expected) {
+			t.Errorf("expected %q in explanation:\n%s", expected, text)
+		}
+	}
+	if strings.Contains(text, "Nearest") {
+		t.Errorf("direct hit explained with a search:\n%s", text)
+	}
+
+	outside := ExplainLocation(&synth.BaseStack, Point3d{-1, 0, 0})
+	if outside.Error == "" || outside.TilePath != "" {
+		t.Errorf("expected error outside stack, got %+v", outside)
+	}
+	if !strings.Contains(outside.String(), "Could not resolve") {
+		t.Errorf("unexpected text for outside location:\n%s", outside)
+	}
+}
+
+// checkNearestExplanation verifies that an explanation's search agrees
+// with GetNearestBodyOfLocation and ends with the selected candidate.
+func checkNearestExplanation(t *testing.T, stack TiledJsonStack,
+	synth *SyntheticStack, explanation LocationExplanation) {
+
+	pt := explanation.Location
+	bodyId, superpixel, radius, location := GetNearestBodyOfLocation(stack,
+		pt, BodySet{}, BodySet{})
+	if !explanation.NearestSearch || explanation.NearestBody != bodyId ||
+		explanation.NearestSuperpixel != superpixel ||
+		explanation.NearestRadius != radius ||
+		explanation.NearestLocation != location || bodyId == 0 {
+		t.Errorf("%s: expected nearest body %d (%v, radius %d, %s), got %+v",
+			pt, bodyId, superpixel, radius, location, explanation)
+	}
+	n := len(explanation.Candidates)
+	if n == 0 {
+		t.Fatalf("%s: no candidates recorded", pt)
+	}
+	selected := explanation.Candidates[n-1]
+	expected := NearestCandidate{Location: location, Radius: radius,
+		Superpixel: superpixel, Body: bodyId, Outcome: "selected"}
+	if !reflect.DeepEqual(selected, expected) {
+		t.Errorf("%s: expected final candidate %+v, got %+v", pt, expected,
+			selected)
+	}
+	if synth.SuperpixelAt(location) != superpixel {
+		t.Errorf("%s: nearest location %s is not on superpixel %v", pt,
+			location, superpixel)
+	}
+	text := explanation.String()
+	if !strings.Contains(text, "Nearest body: "+bodyId.String()) ||
+		!strings.Contains(text, ", selected") {
+		t.Errorf("%s: search missing from explanation:\n%s", pt, text)
+	}
+}
+
+func TestExplainLocationFallback(t *testing.T) {
+	params := DefaultSyntheticStackParams
+	params.ZeroBorders = true
+	synth, err := CreateSyntheticStack(t.TempDir(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A location on a zero border is resolved by a nearest-body search.
+	pt := synth.Center(Superpixel{Slice: 1, Label: 6})
+	for synth.SuperpixelAt(pt).Label != 0 {
+		pt[0]--
+	}
+	explanation := ExplainLocation(&synth.BaseStack, pt)
+	if explanation.Superpixel.Label != 0 || explanation.Body != 0 {
+		t.Errorf("expected zero superpixel at %s, got %+v", pt, explanation)
+	}
+	checkNearestExplanation(t, &synth.BaseStack, synth, explanation)
+	if !strings.Contains(explanation.String(), "Zero superpixel: no body") {
+		t.Errorf("zero superpixel not explained:\n%s", explanation)
+	}
+
+	// An export without tiles reads them from the base stack, and a
+	// location at the edge of a superpixel dropped from its map falls
+	// back to a search that records the unmapped candidate.
+	exportDir := t.TempDir()
+	unmapped := Superpixel{Slice: 1, Label: 7}
+	spToBodyMap := make(SuperpixelToBodyMap)
+	for superpixel, bodyId := range synth.SpToBodyMap {
+		if superpixel != unmapped {
+			spToBodyMap[superpixel] = bodyId
+		}
+	}
+	if err = spToBodyMap.WriteTxtMaps(exportDir); err != nil {
+		t.Fatal(err)
+	}
+	exported := CreateExportedStack(exportDir, synth.Directory)
+	exported.Base.Tiles = synth.Tiles
+	pt = synth.Center(unmapped)
+	for synth.SuperpixelAt(Point3d{pt[0] - 1, pt[1], pt[2]}).Label != 0 {
+		pt[0]--
+	}
+	explanation = ExplainLocation(exported, pt)
+	if !explanation.FromBase || explanation.Superpixel != unmapped ||
+		explanation.Mapped || explanation.Body != 0 {
+		t.Errorf("expected unmapped %v from base tile, got %+v", unmapped,
+			explanation)
+	}
+	if !strings.HasPrefix(explanation.TilePath, synth.Directory) {
+		t.Errorf("expected base tile path, got %s", explanation.TilePath)
+	}
+	checkNearestExplanation(t, exported, synth, explanation)
+	first := explanation.Candidates[0]
+	if first.Superpixel != unmapped || first.Radius != 0 ||
+		first.Outcome != "not in superpixel->body map" {
+		t.Errorf("expected unmapped first candidate, got %+v", first)
+	}
+	text := explanation.String()
+	if !strings.Contains(text, "(from base stack)") ||
+		!strings.Contains(text, "not in superpixel->body map") {
+		t.Errorf("base tile or unmapped superpixel not explained:\n%s",
+			text)
+	}
+}
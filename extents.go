@@ -0,0 +1,371 @@
+// Copyright 2012 HHMI.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//     * Neither the name of HHMI nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+// Author: katzw@janelia.hhmi.org (Bill Katz)
+//  Written as part of the FlyEM Project at Janelia Farm Research Center.
+
+package emdata
+
+import (
+	"encoding/csv"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// BodyExtent describes the 3d extent and approximate size of a body
+// from the bounds of its superpixels.
+type BodyExtent struct {
+	Bounds        Bounds3d
+	Center        Point3d // Volume-weighted mean of superpixel centers
+	Volume        int     // Sum of superpixel volumes
+	Slices        int     // # of slices with superpixel bounds
+	Superpixels   int     // # of superpixels with bounds
+	MissingBounds int     // # of superpixels without bounds
+}
+
+// bodyExtentSums accumulates a body's extent from superpixel bounds.
+type bodyExtentSums struct {
+	extent          BodyExtent
+	slices          map[uint32]bool
+	sumX, sumY      float64
+	sumZ, sumWeight float64
+}
+
+// BodyExtents computes the extent of every body in a superpixel->body
+// map from superpixel bounds.  Superpixels without bounds are counted
+// in MissingBounds, and a body with no superpixel bounds has zero
+// Slices and zero Bounds.  Zero superpixels are ignored.
+func BodyExtents(spToBodyMap SuperpixelToBodyMap,
+	spBounds SuperpixelBoundsMap) map[BodyId]BodyExtent {
+
+	return bodyExtents(spToBodyMap, spBounds, nil)
+}
+
+// bodyExtents implements BodyExtents for the bodies accepted by include,
+// or all bodies if include is nil.
+func bodyExtents(spToBodyMap SuperpixelToBodyMap,
+	spBounds SuperpixelBoundsMap,
+	include func(BodyId) bool) map[BodyId]BodyExtent {
+
+	sumsMap := make(map[BodyId]*bodyExtentSums)
+	for superpixel, bodyId := range spToBodyMap {
+		if superpixel.Label == 0 || (include != nil && !include(bodyId)) {
+			continue
+		}
+		sums, found := sumsMap[bodyId]
+		if !found {
+			sums = &bodyExtentSums{slices: make(map[uint32]bool)}
+			sumsMap[bodyId] = sums
+		}
+		bound, found := spBounds[superpixel]
+		if !found {
+			sums.extent.MissingBounds++
+			continue
+		}
+		z := VoxelCoord(superpixel.Slice)
+		spExtent := superpixelExtent(superpixel, bound)
+		if sums.extent.Superpixels == 0 {
+			sums.extent.Bounds = spExtent
+		} else {
+			sums.extent.Bounds = sums.extent.Bounds.Union(spExtent)
+		}
+		sums.extent.Superpixels++
+		sums.extent.Volume += bound.Volume
+		sums.slices[superpixel.Slice] = true
+
+		// Weight each superpixel center by volume, or equally if the
+		// bounds file lacks volumes.
+		weight := float64(bound.Volume)
+		if weight <= 0 {
+			weight = 1
+		}
+		sums.sumX += weight * (float64(bound.MinX) + float64(bound.Width-1)/2)
+		sums.sumY += weight * (float64(bound.MinY) + float64(bound.Height-1)/2)
+		sums.sumZ += weight * float64(z)
+		sums.sumWeight += weight
+	}
+
+	extents := make(map[BodyId]BodyExtent, len(sumsMap))
+	for bodyId, sums := range sumsMap {
+		extent := sums.extent
+		extent.Slices = len(sums.slices)
+		if sums.sumWeight > 0 {
+			extent.Center = Point3d{
+				roundVoxel(sums.sumX / sums.sumWeight),
+				roundVoxel(sums.sumY / sums.sumWeight),
+				roundVoxel(sums.sumZ / sums.sumWeight),
+			}
+		}
+		extents[bodyId] = extent
+	}
+	return extents
+}
+
+// superpixelExtent returns the 2d bounds of a superpixel placed on its
+// slice.
+func superpixelExtent(superpixel Superpixel, bound SuperpixelBound) Bounds3d {
+	z := VoxelCoord(superpixel.Slice)
+	return Bounds3d{
+		Point3d{VoxelCoord(bound.MinX), VoxelCoord(bound.MinY), z},
+		Point3d{VoxelCoord(bound.MinX + bound.Width - 1),
+			VoxelCoord(bound.MinY + bound.Height - 1), z},
+	}
+}
+
+// SuperpixelsInZRange returns the set of superpixels in the map whose
+// slice is within [zmin, zmax].  The set can be passed to
+// ReadSuperpixelBounds to limit the bounds read, but an empty set there
+// means all bounds, so callers should skip the read if no superpixels
+// are in range.
+func (spToBodyMap SuperpixelToBodyMap) SuperpixelsInZRange(zmin,
+	zmax uint32) map[Superpixel]bool {
+
+	superpixelSet := make(map[Superpixel]bool)
+	for superpixel, _ := range spToBodyMap {
+		if superpixel.Slice >= zmin && superpixel.Slice <= zmax {
+			superpixelSet[superpixel] = true
+		}
+	}
+	return superpixelSet
+}
+
+// SuperpixelsInBounds returns the set of superpixels whose slice is within
+// the Z range of the bounds and whose 2d bounds overlap the X/Y range.
+// Superpixels touching only the edge of the bounds are included.  As with
+// SuperpixelsInZRange, check for an empty set before using it to limit
+// ReadSuperpixelBounds.
+func (spBoundsMap SuperpixelBoundsMap) SuperpixelsInBounds(
+	bounds Bounds3d) map[Superpixel]bool {
+
+	return spBoundsMap.superpixelsInBounds(bounds, false)
+}
+
+// SuperpixelsWithinBounds is like SuperpixelsInBounds but only includes
+// superpixels whose 2d bounds lie entirely within the bounds.
+func (spBoundsMap SuperpixelBoundsMap) SuperpixelsWithinBounds(
+	bounds Bounds3d) map[Superpixel]bool {
+
+	return spBoundsMap.superpixelsInBounds(bounds, true)
+}
+
+func (spBoundsMap SuperpixelBoundsMap) superpixelsInBounds(bounds Bounds3d,
+	strict bool) map[Superpixel]bool {
+
+	superpixelSet := make(map[Superpixel]bool)
+	for superpixel, bound := range spBoundsMap {
+		spExtent := superpixelExtent(superpixel, bound)
+		var inside bool
+		if strict {
+			inside = bounds.Contains(spExtent)
+		} else {
+			_, inside = bounds.Intersect(spExtent)
+		}
+		if inside {
+			superpixelSet[superpixel] = true
+		}
+	}
+	return superpixelSet
+}
+
+// VoxelCounts returns the voxel count of each body, summed from the
+// volumes of its superpixel bounds.  Superpixels without bounds and zero
+// superpixels add nothing, so a body may have a zero count.
+func (bodyToSpMap BodyToSuperpixelsMap) VoxelCounts(
+	spBounds SuperpixelBoundsMap) map[BodyId]int {
+
+	counts := make(map[BodyId]int, len(bodyToSpMap))
+	for bodyId, superpixels := range bodyToSpMap {
+		count := 0
+		for _, superpixel := range superpixels {
+			if superpixel.Label == 0 {
+				continue
+			}
+			count += spBounds[superpixel].Volume
+		}
+		counts[bodyId] = count
+	}
+	return counts
+}
+
+// BoundingBoxes returns the 3d bounding box of each body, the union of
+// its superpixels' 2d bounds placed on their slices.  Bodies without any
+// superpixel bounds are omitted.  Zero superpixels are ignored.
+func (bodyToSpMap BodyToSuperpixelsMap) BoundingBoxes(
+	spBounds SuperpixelBoundsMap) map[BodyId]Bounds3d {
+
+	boxes := make(map[BodyId]Bounds3d, len(bodyToSpMap))
+	for bodyId, superpixels := range bodyToSpMap {
+		for _, superpixel := range superpixels {
+			bound, found := spBounds[superpixel]
+			if !found || superpixel.Label == 0 {
+				continue
+			}
+			spExtent := superpixelExtent(superpixel, bound)
+			if box, found := boxes[bodyId]; found {
+				boxes[bodyId] = box.Union(spExtent)
+			} else {
+				boxes[bodyId] = spExtent
+			}
+		}
+	}
+	return boxes
+}
+
+// BodyExtents returns the extents of the given bodies, loading the
+// stack's superpixel->body map and superpixel bounds if necessary.
+func (stack *Stack) BodyExtents(bodySet BodySet) (
+	map[BodyId]BodyExtent, error) {
+
+	spToBodyMap, err := stack.loadedMap()
+	if err != nil {
+		return nil, err
+	}
+	if err := stack.ReadSuperpixelBounds(); err != nil {
+		return nil, err
+	}
+	return bodyExtents(spToBodyMap, stack.spBoundsMap, bodySet.Contains), nil
+}
+
+// BodyExtents returns the extents of the given bodies using maps from
+// the export or, if missing, its base stack.
+func (stack *ExportedStack) BodyExtents(bodySet BodySet) (
+	map[BodyId]BodyExtent, error) {
+
+	stack.useBaseMaps()
+	return stack.Stack.BodyExtents(bodySet)
+}
+
+// SetCenters sets the Center of each named body with a known extent,
+// with NumCenterPts giving the # of superpixels averaged.
+func (bodyMap NamedBodyMap) SetCenters(extents map[BodyId]BodyExtent) {
+	for bodyId, namedBody := range bodyMap {
+		extent, found := extents[bodyId]
+		if !found || extent.Superpixels == 0 {
+			continue
+		}
+		namedBody.Center = extent.Center
+		namedBody.NumCenterPts = extent.Superpixels
+		bodyMap[bodyId] = namedBody
+	}
+}
+
+// SliceRange is an inclusive range of slices missing from a body.
+type SliceRange struct {
+	FirstMissing uint32
+	LastMissing  uint32
+}
+
+// Len returns the # of slices in the range.
+func (r SliceRange) Len() int {
+	return int(r.LastMissing-r.FirstMissing) + 1
+}
+
+// SliceGapMap holds the slice gaps of each body, sorted by slice.
+type SliceGapMap map[BodyId][]SliceRange
+
+// BodySliceGaps returns the runs of at least minGap slices that are
+// missing from a body between its first and last slice.  Body 0 and
+// bodies within a single slice are skipped, as are zero superpixels.
+func (spToBodyMap SuperpixelToBodyMap) BodySliceGaps(minGap int) SliceGapMap {
+	bodySlices := make(map[BodyId]map[uint32]bool)
+	for superpixel, bodyId := range spToBodyMap {
+		if bodyId == 0 || superpixel.Label == 0 {
+			continue
+		}
+		slices, found := bodySlices[bodyId]
+		if !found {
+			slices = make(map[uint32]bool)
+			bodySlices[bodyId] = slices
+		}
+		slices[superpixel.Slice] = true
+	}
+
+	gapMap := make(SliceGapMap)
+	for bodyId, sliceSet := range bodySlices {
+		if len(sliceSet) < 2 {
+			continue
+		}
+		slices := make([]int, 0, len(sliceSet))
+		for slice, _ := range sliceSet {
+			slices = append(slices, int(slice))
+		}
+		sort.Ints(slices)
+		for i := 1; i < len(slices); i++ {
+			gap := SliceRange{uint32(slices[i-1] + 1), uint32(slices[i] - 1)}
+			if slices[i]-slices[i-1] > 1 && gap.Len() >= minGap {
+				gapMap[bodyId] = append(gapMap[bodyId], gap)
+			}
+		}
+	}
+	return gapMap
+}
+
+// WriteCsv writes one line per gap, sorted by body id and slice.
+func (gapMap SliceGapMap) WriteCsv(writer io.Writer) {
+	csvWriter := csv.NewWriter(writer)
+	record := []string{"Body", "Gap start", "Gap end", "Gap length"}
+	err := csvWriter.Write(record)
+	if err != nil {
+		log.Fatalln("ERROR: Unable to write header to CSV:", err)
+	}
+	bodies := make(BodyIdList, 0, len(gapMap))
+	for bodyId, _ := range gapMap {
+		bodies = append(bodies, bodyId)
+	}
+	sort.Sort(bodies)
+	for _, bodyId := range bodies {
+		for _, gap := range gapMap[bodyId] {
+			record := []string{
+				bodyId.String(),
+				strconv.FormatUint(uint64(gap.FirstMissing), 10),
+				strconv.FormatUint(uint64(gap.LastMissing), 10),
+				strconv.Itoa(gap.Len()),
+			}
+			err := csvWriter.Write(record)
+			if err != nil {
+				log.Fatalln("ERROR: Unable to write line of CSV for body",
+					bodyId, ":", err)
+			}
+		}
+	}
+	csvWriter.Flush()
+}
+
+// WriteCsvFile writes the slice gaps into a CSV file.
+func (gapMap SliceGapMap) WriteCsvFile(filename string) {
+	file, err := os.Create(filename)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to create slice gap csv file: %s [%s]\n",
+			filename, err)
+	}
+	gapMap.WriteCsv(file)
+	file.Close()
+}
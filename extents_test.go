@@ -0,0 +1,123 @@
+// Copyright 2012 HHMI.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//     * Neither the name of HHMI nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+// Author: katzw@janelia.hhmi.org (Bill Katz)
+//  Written as part of the FlyEM Project at Janelia Farm Research Center.
+
+package emdata
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestBodySliceGaps(t *testing.T) {
+	spToBodyMap := SuperpixelToBodyMap{
+		// Body 10 is missing from slices 3-4 and 6.
+		{1, 1}: 10, {2, 1}: 10, {5, 1}: 10, {7, 1}: 10,
+		// Body 20 is contiguous.
+		{1, 2}: 20, {2, 2}: 20, {3, 2}: 20, {4, 2}: 20,
+		// Body 30 is on a single slice and body 0 is skipped.
+		{4, 3}: 30, {1, 4}: 0, {9, 4}: 0,
+	}
+	tests := []struct {
+		minGap int
+		gaps   SliceGapMap
+	}{
+		{1, SliceGapMap{10: {{3, 4}, {6, 6}}}},
+		{2, SliceGapMap{10: {{3, 4}}}},
+		{3, SliceGapMap{}},
+	}
+	for _, test := range tests {
+		gaps := spToBodyMap.BodySliceGaps(test.minGap)
+		if len(gaps) != len(test.gaps) ||
+			(len(gaps) > 0 && !reflect.DeepEqual(gaps, test.gaps)) {
+			t.Errorf("min gap %d: expected %v, got %v", test.minGap,
+				test.gaps, gaps)
+		}
+	}
+
+	var buf bytes.Buffer
+	spToBodyMap.BodySliceGaps(1).WriteCsv(&buf)
+	expected := "Body,Gap start,Gap end,Gap length\n10,3,4,2\n10,6,6,1\n"
+	if buf.String() != expected {
+		t.Errorf("expected CSV:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestSuperpixelsInBounds(t *testing.T) {
+	spBoundsMap := SuperpixelBoundsMap{
+		// Inside x, y in [10, 19].
+		{1, 1}: {MinX: 12, MinY: 12, Width: 4, Height: 4, Volume: 16},
+		// Straddles the right edge.
+		{1, 2}: {MinX: 15, MinY: 12, Width: 10, Height: 2, Volume: 20},
+		// Only its last column touches the left edge at x = 10.
+		{1, 3}: {MinX: 5, MinY: 10, Width: 6, Height: 2, Volume: 12},
+		// Starts just past the right edge.
+		{1, 4}: {MinX: 20, MinY: 10, Width: 3, Height: 3, Volume: 9},
+		// Exactly fills the X/Y range.
+		{2, 1}: {MinX: 10, MinY: 10, Width: 10, Height: 10, Volume: 100},
+		// Inside X/Y but outside the Z range.
+		{4, 1}: {MinX: 12, MinY: 12, Width: 2, Height: 2, Volume: 4},
+	}
+	bounds := Bounds3d{Point3d{10, 10, 1}, Point3d{19, 19, 3}}
+	tests := []struct {
+		name     string
+		got      map[Superpixel]bool
+		expected map[Superpixel]bool
+	}{
+		{"overlapping", spBoundsMap.SuperpixelsInBounds(bounds),
+			map[Superpixel]bool{{1, 1}: true, {1, 2}: true, {1, 3}: true,
+				{2, 1}: true}},
+		{"within", spBoundsMap.SuperpixelsWithinBounds(bounds),
+			map[Superpixel]bool{{1, 1}: true, {2, 1}: true}},
+		{"outside", spBoundsMap.SuperpixelsInBounds(
+			Bounds3d{Point3d{100, 100, 1}, Point3d{200, 200, 3}}),
+			map[Superpixel]bool{}},
+	}
+	for _, test := range tests {
+		if !reflect.DeepEqual(test.got, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected,
+				test.got)
+		}
+	}
+}
+
+func TestSuperpixelsInZRange(t *testing.T) {
+	spToBodyMap := testSpToBodyMap()
+	superpixels := spToBodyMap.SuperpixelsInZRange(2, 3)
+	expected := map[Superpixel]bool{{2, 1}: true, {2, 2}: true, {2, 5}: true,
+		{3, 7}: true}
+	if !reflect.DeepEqual(superpixels, expected) {
+		t.Errorf("expected %v, got %v", expected, superpixels)
+	}
+	if superpixels = spToBodyMap.SuperpixelsInZRange(5, 9); len(superpixels) != 0 {
+		t.Errorf("expected no superpixels in slices 5-9, got %v", superpixels)
+	}
+}
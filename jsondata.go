@@ -32,6 +32,7 @@
 package emdata
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
@@ -40,7 +41,11 @@ import (
 	"os"
 	"os/user"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -50,15 +55,150 @@ func CreateMetadata(description string) (
 	user, _ := user.Current()
 	metadata = make(map[string]interface{})
 	metadata["username"] = user.Username
-	metadata["date"] = time.Now().Format("02-January-2006 15:04")
+	metadata["date"] = time.Now().Format(RavelerDateFormat)
 	metadata["computer"], _ = os.Hostname()
 	metadata["software"] = os.Args[0]
 	metadata["parameters"] = os.Args[1:]
 	metadata["description"] = description
-	metadata["file version"] = 1 // Necessary for Raveler
+	metadata["file version"] = DefaultFileVersion // Necessary for Raveler
 	return
 }
 
+// RavelerDateFormat is the layout of metadata dates written by
+// CreateMetadata and expected by Raveler.
+const RavelerDateFormat = "02-January-2006 15:04"
+
+// metadataDateFormats are the date layouts accepted by ParseMetadataDate,
+// including those written by other tools in the pipeline.
+var metadataDateFormats = []string{
+	RavelerDateFormat,
+	time.RFC3339,
+	"02-Jan-2006 15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	time.ANSIC,
+}
+
+// ParseMetadataDate parses a metadata date in the Raveler format, RFC3339,
+// or one of the legacy formats found in older annotation files.
+func ParseMetadataDate(date string) (time.Time, error) {
+	date = strings.TrimSpace(date)
+	for _, layout := range metadataDateFormats {
+		if t, err := time.Parse(layout, date); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Unrecognized metadata date: %q", date)
+}
+
+// NormalizeMetadataDate rewrites the "date" of metadata as RFC3339 and
+// keeps the original value as "original date".  Metadata without a date
+// is left unchanged.
+func NormalizeMetadataDate(metadata map[string]interface{}) error {
+	value, found := metadata["date"]
+	if !found {
+		return nil
+	}
+	date, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("Metadata date is not a string: %v", value)
+	}
+	t, err := ParseMetadataDate(date)
+	if err != nil {
+		return err
+	}
+	normalized := t.Format(time.RFC3339)
+	if normalized != date {
+		if _, found := metadata["original date"]; !found {
+			metadata["original date"] = date
+		}
+		metadata["date"] = normalized
+	}
+	return nil
+}
+
+// DefaultFileVersion is the Raveler file version stamped on new metadata.
+// Raveler version 2 synapse files require uids on all T-bars and PSDs.
+const DefaultFileVersion = 1
+
+// UpdateMetadata returns new metadata for a rewritten annotation file
+// that preserves the file version of the original metadata.  The date
+// of the original is recorded in RFC3339 as "source date".
+func UpdateMetadata(original map[string]interface{}, description string) (
+	metadata map[string]interface{}) {
+
+	metadata = CreateMetadata(description)
+	if version, found := GetFileVersion(original); found {
+		SetFileVersion(metadata, version)
+	}
+	if date, ok := original["date"].(string); ok {
+		if t, err := ParseMetadataDate(date); err == nil {
+			metadata["source date"] = t.Format(time.RFC3339)
+		}
+	}
+	return
+}
+
+// GetFileVersion returns the "file version" from annotation metadata.
+// Versions decoded from JSON as floats or strings are handled.
+func GetFileVersion(metadata map[string]interface{}) (version int,
+	found bool) {
+
+	value, found := metadata["file version"]
+	if !found {
+		return
+	}
+	switch v := value.(type) {
+	case int:
+		version = v
+	case float64:
+		version = int(v)
+	case json.Number:
+		i, err := v.Int64()
+		version, found = int(i), err == nil
+	case string:
+		_, err := fmt.Sscanf(v, "%d", &version)
+		found = err == nil
+	default:
+		found = false
+	}
+	return
+}
+
+// SetFileVersion sets the "file version" of annotation metadata.
+func SetFileVersion(metadata map[string]interface{}, version int) {
+	metadata["file version"] = version
+}
+
+// metadataForWrite returns metadata that is guaranteed to have a file
+// version without modifying the passed metadata.
+func metadataForWrite(metadata map[string]interface{}) map[string]interface{} {
+	if _, found := GetFileVersion(metadata); found {
+		return metadata
+	}
+	stamped := make(map[string]interface{}, len(metadata)+1)
+	for key, value := range metadata {
+		stamped[key] = value
+	}
+	SetFileVersion(stamped, DefaultFileVersion)
+	return stamped
+}
+
+// checkFileVersion notes a violation if metadata has no file version or
+// a version newer than the target Raveler version.
+func checkFileVersion(violations *Warnings, metadata map[string]interface{},
+	version int) {
+
+	fileVersion, found := GetFileVersion(metadata)
+	if !found {
+		violations.Add("no file version", "metadata has no file version")
+	} else if fileVersion > version {
+		violations.Add("file version too new",
+			"file version %d is newer than Raveler version %d",
+			fileVersion, version)
+	}
+}
+
 const (
 	JsonSynapseFilename  = "annotations-synapse.json"
 	JsonBodyFilename     = "annotations-body.json"
@@ -117,38 +257,221 @@ func (bodyNote *JsonBody) GetTracingResult(bodyId BodyId) TracingResult {
 	return tracingResult
 }
 
+// Status words for named bodies in body annotations.  A named body's
+// status is AnchorStatus, preceded by PrimaryStatus and SecondaryStatus
+// for primary and secondary neurons and followed by LockedStatus if the
+// body is locked, e.g., "Primary Anchor Locked".
+const (
+	AnchorStatus    = "Anchor"
+	PrimaryStatus   = "Primary"
+	SecondaryStatus = "Secondary"
+	LockedStatus    = "Locked"
+)
+
+// NamedBodyMapFromJsonBodies returns the bodies with names in a body
+// annotation list.  Status words are matched regardless of case or order.
+func NamedBodyMapFromJsonBodies(bodies *JsonBodies) NamedBodyMap {
+	namedBodyMap := make(NamedBodyMap)
+	for _, bodyNote := range bodies.Data {
+		if bodyNote.Name == "" {
+			continue
+		}
+		namedBody := NamedBody{
+			Body:     bodyNote.Body,
+			Name:     bodyNote.Name,
+			CellType: bodyNote.CellType,
+			Location: bodyNote.Location,
+		}
+		for _, word := range strings.Fields(bodyNote.Status) {
+			switch {
+			case strings.EqualFold(word, PrimaryStatus):
+				namedBody.IsPrimary = true
+			case strings.EqualFold(word, SecondaryStatus):
+				namedBody.IsSecondary = true
+			case strings.EqualFold(word, LockedStatus):
+				namedBody.Locked = true
+			}
+		}
+		namedBodyMap[namedBody.Body] = namedBody
+	}
+	return namedBodyMap
+}
+
+// ToJsonBodies returns a body annotation list of the named bodies,
+// sorted by body id, with metadata using the given description.
+func (bodyMap NamedBodyMap) ToJsonBodies(description string) *JsonBodies {
+	bodies := &JsonBodies{Metadata: CreateMetadata(description)}
+	bodyIds := make(BodyIdList, 0, len(bodyMap))
+	for bodyId, _ := range bodyMap {
+		bodyIds = append(bodyIds, bodyId)
+	}
+	sort.Sort(bodyIds)
+	for _, bodyId := range bodyIds {
+		namedBody := bodyMap[bodyId]
+		var status []string
+		if namedBody.IsPrimary {
+			status = append(status, PrimaryStatus)
+		}
+		if namedBody.IsSecondary {
+			status = append(status, SecondaryStatus)
+		}
+		status = append(status, AnchorStatus)
+		if namedBody.Locked {
+			status = append(status, LockedStatus)
+		}
+		bodies.Data = append(bodies.Data, JsonBody{
+			Body:     bodyId,
+			Status:   strings.Join(status, " "),
+			Name:     namedBody.Name,
+			CellType: namedBody.CellType,
+			Location: namedBody.Location,
+		})
+	}
+	return bodies
+}
+
 // ReadBodiesJson returns a bodies structure corresponding to 
-// a JSON body annotation file.
-func ReadBodiesJson(filename string) (bodies *JsonBodies) {
-	var file *os.File
-	var err error
-	if file, err = os.Open(filename); err != nil {
-		log.Fatalf("FATAL ERROR: Failed to open JSON file: %s [%s]",
-			filename, err)
+// a JSON body annotation file.  Files whose name ends in GzipSuffix
+// are decompressed, and the gzipped file is read if only it exists.
+func ReadBodiesJson(filename string) (bodies *JsonBodies, err error) {
+	file, filename, err := openTextFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open JSON file: %s", err)
 	}
 	defer file.Close()
-	dec := json.NewDecoder(file)
-	if err := dec.Decode(&bodies); err == io.EOF {
-		log.Fatalf("FATAL ERROR: No data in JSON file: %s\n", filename)
+	if bodies, err = ReadBodiesJsonFrom(file); err != nil {
+		return nil, jsonFileError(filename, err)
+	}
+	return bodies, nil
+}
+
+// ReadBodiesJsonGzip reads a gzipped JSON body annotation file,
+// appending GzipSuffix to the file name if it lacks it.
+func ReadBodiesJsonGzip(filename string) (*JsonBodies, error) {
+	return ReadBodiesJson(gzipFilename(filename))
+}
+
+// ReadBodiesJsonOrDie is like ReadBodiesJson but exits on any error.
+func ReadBodiesJsonOrDie(filename string) *JsonBodies {
+	bodies, err := ReadBodiesJson(filename)
+	if err != nil {
+		log.Fatalln("FATAL ERROR:", err)
+	}
+	return bodies
+}
+
+// jsonFileError describes an error decoding a JSON file, including the
+// byte offset of syntax and type errors.
+func jsonFileError(filename string, err error) error {
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		return fmt.Errorf("error reading JSON file (%s) at byte %d: %s",
+			filename, e.Offset, err)
+	case *json.UnmarshalTypeError:
+		return fmt.Errorf("error reading JSON file (%s) at byte %d: %s",
+			filename, e.Offset, err)
+	}
+	if err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("error reading JSON file (%s): truncated JSON: %s",
+			filename, err)
+	}
+	return fmt.Errorf("error reading JSON file (%s): %s", filename, err)
+}
+
+// ReadBodiesJsonFrom returns a bodies structure decoded from a reader.
+func ReadBodiesJsonFrom(reader io.Reader) (bodies *JsonBodies, err error) {
+	dec := json.NewDecoder(reader)
+	if err = dec.Decode(&bodies); err == io.EOF {
+		return nil, fmt.Errorf("no data in JSON")
 	} else if err != nil {
-		log.Fatalf("FATAL ERROR: Error reading JSON file (%s): %s\n",
+		return nil, err
+	}
+	if bodies == nil {
+		return nil, fmt.Errorf("no data in JSON")
+	}
+	return bodies, nil
+}
+
+// CheckRavelerCompatibility returns any violations that would prevent
+// Raveler of the given file version from importing the body annotations.
+func (bodies *JsonBodies) CheckRavelerCompatibility(version int) (
+	violations Warnings) {
+
+	checkFileVersion(&violations, bodies.Metadata, version)
+	seen := make(BodySet, len(bodies.Data))
+	for _, body := range bodies.Data {
+		if body.Body == 0 {
+			violations.Add("zero body id", "body annotation with body 0: %v",
+				body)
+		} else if seen[body.Body] {
+			violations.Add("duplicate body", "body %d annotated more than once",
+				body.Body)
+		}
+		seen[body.Body] = true
+		if body.Status == "" {
+			violations.Add("no status", "body %d has no status", body.Body)
+		}
+	}
+	return
+}
+
+// WriteJson writes indented JSON body annotation list to writer.
+// A default file version is written if the metadata has none.
+func (bodies *JsonBodies) WriteJson(writer io.Writer) {
+	output := *bodies
+	output.Metadata = metadataForWrite(bodies.Metadata)
+	m, err := json.Marshal(&output)
+	if err != nil {
+		log.Fatalf("Error in writing json: %s", err)
+	}
+	var buf bytes.Buffer
+	json.Indent(&buf, m, "", "    ")
+	buf.WriteTo(writer)
+}
+
+// WriteJsonFile writes body annotation file, gzipped if the name ends
+// in GzipSuffix.
+func (bodies *JsonBodies) WriteJsonFile(filename string) {
+	file, err := createTextFile(filename)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to create json bodies file: %s [%s]\n",
 			filename, err)
 	}
-	return bodies
+	bodies.WriteJson(file)
+	file.Close()
+}
+
+// WriteJsonFileGzip writes a gzipped body annotation file, appending
+// GzipSuffix to the file name if it lacks it.
+func (bodies *JsonBodies) WriteJsonFileGzip(filename string) error {
+	filename = gzipFilename(filename)
+	file, err := createTextFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create json bodies file: %s", err)
+	}
+	bodies.WriteJson(file)
+	if err = file.Close(); err != nil {
+		return fmt.Errorf("failed to write json bodies file %s: %s",
+			filename, err)
+	}
+	return nil
 }
 
 // StackAnchorBodySet returns a BodySet a stack's anchor bodies
 // using the default body annotations file of that stack.
-func StackAnchorBodySet(stackDir string) BodySet {
-	anchorBodies := make(BodySet)
+func StackAnchorBodySet(stackDir string) (BodySet, error) {
 	annotationsFilename := StackBodiesJsonFilename(stackDir)
-	jsonBodies := ReadBodiesJson(annotationsFilename)
+	jsonBodies, err := ReadBodiesJson(annotationsFilename)
+	if err != nil {
+		return nil, err
+	}
+	anchorBodies := make(BodySet)
 	for _, jsonBody := range jsonBodies.Data {
 		if jsonBody.AnchorComment() {
 			anchorBodies[jsonBody.Body] = true
 		}
 	}
-	return anchorBodies
+	return anchorBodies, nil
 }
 
 // SynapseIndex provides an index to specific elements within JsonSynapses
@@ -209,24 +532,97 @@ type JsonSynapses struct {
 }
 
 // ReadSynapsesJson returns a synapse structure corresponding to 
-// a JSON synapse annotation file.
-func ReadSynapsesJson(filename string) *JsonSynapses {
-	var file *os.File
-	var err error
-	if file, err = os.Open(filename); err != nil {
-		log.Fatalf("FATAL ERROR: Failed to open JSON file: %s [%s]",
-			filename, err)
+// a JSON synapse annotation file.  T-bars without partners return
+// an error in Strict mode and are logged as warnings in Lenient mode.
+// Files whose name ends in GzipSuffix are decompressed, and the gzipped
+// file is read if only it exists.
+func ReadSynapsesJson(filename string) (*JsonSynapses, error) {
+	file, filename, err := openTextFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open JSON file: %s", err)
 	}
 	defer file.Close()
-	dec := json.NewDecoder(file)
-	var synapses *JsonSynapses
-	if err := dec.Decode(&synapses); err == io.EOF {
-		log.Fatalf("FATAL ERROR: No data in JSON file: %s\n", filename)
+	synapses, err := ReadSynapsesJsonFrom(file)
+	if err != nil {
+		return nil, jsonFileError(filename, err)
+	}
+	return synapses, nil
+}
+
+// ReadSynapsesJsonWithWarnings is like ReadSynapsesJson but returns the
+// anomalies found in Lenient mode instead of logging them.
+func ReadSynapsesJsonWithWarnings(filename string) (synapses *JsonSynapses,
+	warnings Warnings, err error) {
+
+	file, filename, err := openTextFile(filename)
+	if err != nil {
+		return nil, warnings, fmt.Errorf("failed to open JSON file: %s", err)
+	}
+	defer file.Close()
+	synapses, warnings, err = ReadSynapsesJsonFromWithWarnings(file)
+	if err != nil {
+		return nil, warnings, jsonFileError(filename, err)
+	}
+	return synapses, warnings, nil
+}
+
+// ReadSynapsesJsonGzip reads a gzipped JSON synapse annotation file,
+// appending GzipSuffix to the file name if it lacks it.
+func ReadSynapsesJsonGzip(filename string) (*JsonSynapses, error) {
+	return ReadSynapsesJson(gzipFilename(filename))
+}
+
+// ReadSynapsesJsonFrom returns a synapse structure decoded from a
+// reader.  T-bars without partners return an error in Strict mode and
+// are logged as warnings in Lenient mode, with null or missing partners
+// replaced by an empty list.
+func ReadSynapsesJsonFrom(reader io.Reader) (*JsonSynapses, error) {
+	synapses, warnings, err := ReadSynapsesJsonFromWithWarnings(reader)
+	warnings.Log()
+	return synapses, err
+}
+
+// ReadSynapsesJsonFromWithWarnings is like ReadSynapsesJsonFrom but
+// returns the anomalies found in Lenient mode instead of logging them.
+func ReadSynapsesJsonFromWithWarnings(reader io.Reader) (
+	synapses *JsonSynapses, warnings Warnings, err error) {
+
+	dec := json.NewDecoder(reader)
+	if err = dec.Decode(&synapses); err == io.EOF {
+		return nil, warnings, fmt.Errorf("no data in JSON")
 	} else if err != nil {
-		log.Fatalf("FATAL ERROR: Error reading JSON file (%s): %s\n",
-			filename, err)
+		return nil, warnings, err
+	}
+	if synapses == nil {
+		return nil, warnings, fmt.Errorf("no data in JSON")
 	}
-	return synapses
+	for s, synapse := range synapses.Data {
+		if len(synapse.Psds) == 0 {
+			anomaly := DefaultStrictness.Note(&warnings, "T-bar without partners",
+				"T-bar %s", synapse.Tbar.Location)
+			if anomaly != nil {
+				return nil, warnings, anomaly
+			}
+			// Null or missing partners become an empty list.
+			synapses.Data[s].Psds = []JsonPsd{}
+		}
+	}
+	return synapses, warnings, nil
+}
+
+// DropEmptyTbars removes T-bars without any partners and returns the
+// number of T-bars removed.
+func (synapses *JsonSynapses) DropEmptyTbars() (dropped int) {
+	kept := synapses.Data[:0]
+	for _, synapse := range synapses.Data {
+		if len(synapse.Psds) == 0 {
+			dropped++
+		} else {
+			kept = append(kept, synapse)
+		}
+	}
+	synapses.Data = kept
+	return
 }
 
 // ComputeStats traverses synapses and accumulates tracing stats.
@@ -236,22 +632,362 @@ func (synapses *JsonSynapses) ComputeStats() (stats TracingStats) {
 		for _, psd := range synapse.Psds {
 			stats.TracedPsds++
 			for _, tracing := range psd.Tracings {
-				if tracing.Result == Leaves {
-					stats.TracedLeaves++
-				} else if tracing.Result == Orphan {
-					stats.TracedOrphans++
-				} else if tracing.Result >= MinAnchor {
-					stats.TracedAnchors++
+				stats.addResult(tracing.Result)
+			}
+		}
+	}
+	return
+}
+
+// StatsByUser is like ComputeStats but counts each user's tracings
+// separately.  A user's T-bar and PSD counts include only those with at
+// least one PSD tracing by the user.
+func (synapses *JsonSynapses) StatsByUser() map[string]TracingStats {
+	statsMap := make(map[string]TracingStats)
+	for _, synapse := range synapses.Data {
+		tbarUsers := make(map[string]bool)
+		for _, psd := range synapse.Psds {
+			psdUsers := make(map[string]bool)
+			for _, tracing := range psd.Tracings {
+				stats := statsMap[tracing.Userid]
+				if !psdUsers[tracing.Userid] {
+					psdUsers[tracing.Userid] = true
+					stats.TracedPsds++
+				}
+				if !tbarUsers[tracing.Userid] {
+					tbarUsers[tracing.Userid] = true
+					stats.TracedTbars++
+				}
+				stats.addResult(tracing.Result)
+				statsMap[tracing.Userid] = stats
+			}
+		}
+	}
+	return statsMap
+}
+
+// AgreementMatrix returns for each pair of the given users the # of
+// PSDs both traced to the same anchor body, using each user's first
+// tracing of a PSD.  Pairs are keyed in the order the users are given.
+func (synapses *JsonSynapses) AgreementMatrix(users []string) map[[2]string]int {
+	agreements := make(map[[2]string]int)
+	for i := 0; i < len(users); i++ {
+		for j := i + 1; j < len(users); j++ {
+			agreements[[2]string{users[i], users[j]}] = 0
+		}
+	}
+	for _, synapse := range synapses.Data {
+		for p, _ := range synapse.Psds {
+			results := make(map[string]TracingResult)
+			for _, tracing := range synapse.Psds[p].firstTracingPerUser() {
+				results[tracing.Userid] = tracing.Result
+			}
+			for i := 0; i < len(users); i++ {
+				result1, found1 := results[users[i]]
+				if !found1 || result1 < MinAnchor {
+					continue
+				}
+				for j := i + 1; j < len(users); j++ {
+					if result2, found2 := results[users[j]]; found2 &&
+						result2 == result1 {
+						agreements[[2]string{users[i], users[j]}]++
+					}
+				}
+			}
+		}
+	}
+	return agreements
+}
+
+// BodySynapseStats returns for each T-bar body the # of its T-bars and
+// the # of PSDs on those T-bars.
+func (synapses *JsonSynapses) BodySynapseStats() map[BodyId]SynapseStats {
+	statsMap := make(map[BodyId]SynapseStats)
+	for _, synapse := range synapses.Data {
+		stats := statsMap[synapse.Tbar.Body]
+		stats.NumTbars++
+		stats.NumPsds += len(synapse.Psds)
+		statsMap[synapse.Tbar.Body] = stats
+	}
+	return statsMap
+}
+
+// PsdBodyCounts returns the # of PSDs on each body.
+func (synapses *JsonSynapses) PsdBodyCounts() map[BodyId]int {
+	counts := make(map[BodyId]int)
+	for _, synapse := range synapses.Data {
+		for _, psd := range synapse.Psds {
+			counts[psd.Body]++
+		}
+	}
+	return counts
+}
+
+// TbarBodyMap returns for each T-bar body the indices into Data of its
+// synapses, in ascending order.
+func (synapses *JsonSynapses) TbarBodyMap() map[BodyId][]int {
+	indices := make(map[BodyId][]int)
+	for i, synapse := range synapses.Data {
+		indices[synapse.Tbar.Body] = append(indices[synapse.Tbar.Body], i)
+	}
+	return indices
+}
+
+// CheckRavelerCompatibility returns any violations that would prevent
+// Raveler of the given file version from importing the synapse annotations.
+// Version 2 and later requires uids on all T-bars and PSDs.
+func (synapses *JsonSynapses) CheckRavelerCompatibility(version int) (
+	violations Warnings) {
+
+	checkFileVersion(&violations, synapses.Metadata, version)
+	if version < 2 {
+		return
+	}
+	for _, synapse := range synapses.Data {
+		if synapse.Tbar.Uid == "" {
+			violations.Add("T-bar without uid", "T-bar %s",
+				synapse.Tbar.Location)
+		}
+		for _, psd := range synapse.Psds {
+			if psd.Uid == "" {
+				violations.Add("PSD without uid", "PSD %s of T-bar %s",
+					psd.Location, synapse.Tbar.Location)
+			}
+		}
+	}
+	return
+}
+
+// ValidationError describes a structural inconsistency in synapse
+// annotations.  PsdIdx is -1 for errors in a T-bar.
+type ValidationError struct {
+	Kind       string
+	SynapseIdx int
+	PsdIdx     int
+	Message    string
+}
+
+// Error returns the kind and message of the validation error.
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Kind, e.Message)
+}
+
+// Validate checks synapse annotations for duplicate T-bar or PSD uids,
+// PSD uids that do not start with their T-bar's uid as made by PsdUid,
+// PSDs of a synapse that share a location, and confidences outside
+// [0,1].  Empty uids are not checked; see CheckRavelerCompatibility.
+// All errors found are returned.
+func (synapses *JsonSynapses) Validate() (
+	validationErrors []ValidationError) {
+
+	addError := func(kind string, s, p int, format string,
+		args ...interface{}) {
+		validationErrors = append(validationErrors,
+			ValidationError{kind, s, p, fmt.Sprintf(format, args...)})
+	}
+	validConfidence := func(c Confidence) bool {
+		return c >= 0 && c <= 1
+	}
+	tbarUids := make(map[string]int)
+	psdUids := make(map[string]int)
+	for s, synapse := range synapses.Data {
+		tbar := synapse.Tbar
+		if tbar.Uid != "" {
+			if first, found := tbarUids[tbar.Uid]; found {
+				addError("duplicate T-bar uid", s, -1,
+					"T-bar %s has uid %s of synapse %d", tbar.Location,
+					tbar.Uid, first)
+			} else {
+				tbarUids[tbar.Uid] = s
+			}
+		}
+		if !validConfidence(tbar.Confidence) {
+			addError("T-bar confidence out of range", s, -1,
+				"T-bar %s has confidence %g", tbar.Location,
+				float64(tbar.Confidence))
+		}
+		psdLocations := make(map[Point3d]int, len(synapse.Psds))
+		for p, psd := range synapse.Psds {
+			if psd.Uid != "" {
+				if first, found := psdUids[psd.Uid]; found {
+					addError("duplicate PSD uid", s, p,
+						"PSD %s has uid %s of a PSD in synapse %d",
+						psd.Location, psd.Uid, first)
+				} else {
+					psdUids[psd.Uid] = s
 				}
+				if tbar.Uid != "" &&
+					!strings.HasPrefix(psd.Uid, tbar.Uid+"-psyn-") {
+					addError("PSD uid mismatch", s, p,
+						"PSD %s uid %s does not start with T-bar uid %s",
+						psd.Location, psd.Uid, tbar.Uid)
+				}
+			}
+			if first, found := psdLocations[psd.Location]; found {
+				addError("duplicate PSD location", s, p,
+					"PSD %d shares location %s with PSD %d of T-bar %s",
+					p, psd.Location, first, tbar.Location)
+			} else {
+				psdLocations[psd.Location] = p
+			}
+			if !validConfidence(psd.Confidence) {
+				addError("PSD confidence out of range", s, p,
+					"PSD %s has confidence %g", psd.Location,
+					float64(psd.Confidence))
 			}
 		}
 	}
 	return
 }
 
-// WriteJson writes indented JSON synapse annotation list to writer
-func (synapses *JsonSynapses) WriteJson(writer io.Writer) {
-	m, err := json.Marshal(synapses)
+// synapsesFlushInterval is the number of synapses written between
+// flushes of the buffered JSON writer.
+const synapsesFlushInterval = 1000
+
+// WriteJson writes indented JSON synapse annotation list to writer.
+// A default file version is written if the metadata has none.  Synapses
+// are marshaled one at a time, so the output is the same as indenting
+// the marshaled list without holding all of it in memory.
+func (synapses *JsonSynapses) WriteJson(writer io.Writer) error {
+	bufWriter := bufio.NewWriter(writer)
+	var buf bytes.Buffer
+	writeIndented := func(v interface{}, prefix string) error {
+		m, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("error in writing json: %s", err)
+		}
+		buf.Reset()
+		json.Indent(&buf, m, prefix, "    ")
+		_, err = buf.WriteTo(bufWriter)
+		return err
+	}
+	bufWriter.WriteString("{\n    \"metadata\": ")
+	err := writeIndented(metadataForWrite(synapses.Metadata), "    ")
+	if err != nil {
+		return err
+	}
+	if len(synapses.Data) > 0 {
+		bufWriter.WriteString(",\n    \"data\": [\n")
+		for i := range synapses.Data {
+			bufWriter.WriteString("        ")
+			if err := writeIndented(&synapses.Data[i], "        "); err != nil {
+				return err
+			}
+			if i < len(synapses.Data)-1 {
+				bufWriter.WriteString(",")
+			}
+			bufWriter.WriteString("\n")
+			if (i+1)%synapsesFlushInterval == 0 {
+				if err := bufWriter.Flush(); err != nil {
+					return err
+				}
+			}
+		}
+		bufWriter.WriteString("    ]")
+	}
+	bufWriter.WriteString("\n}")
+	return bufWriter.Flush()
+}
+
+// WriteJsonFile writes synapses annotation file, exiting on any error.
+func (synapses *JsonSynapses) WriteJsonFile(filename string) {
+	if err := synapses.WriteJsonFileE(filename); err != nil {
+		log.Fatalln("ERROR:", err)
+	}
+}
+
+// WriteJsonFileE writes synapses annotation file and returns any error.
+// The file is gzipped if the name ends in GzipSuffix.
+func (synapses *JsonSynapses) WriteJsonFileE(filename string) error {
+	file, err := createTextFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create json synapses file: %s", err)
+	}
+	err = synapses.WriteJson(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write json synapses file %s: %s",
+			filename, err)
+	}
+	return nil
+}
+
+// WriteJsonFileGzip writes a gzipped synapse annotation file, appending
+// GzipSuffix to the file name if it lacks it.
+func (synapses *JsonSynapses) WriteJsonFileGzip(filename string) error {
+	return synapses.WriteJsonFileE(gzipFilename(filename))
+}
+
+// FilterByBounds returns the synapses whose T-bar lies within the
+// bounds.  PSDs of those synapses are kept even if outside the bounds.
+func (synapses *JsonSynapses) FilterByBounds(bounds Bounds3d) *JsonSynapses {
+	return synapses.filter(func(synapse *JsonSynapse) bool {
+		return bounds.Include(synapse.Tbar.Location)
+	})
+}
+
+// FilterByBodySet returns the synapses whose T-bar body is in the set.
+func (synapses *JsonSynapses) FilterByBodySet(bodies BodySet) *JsonSynapses {
+	return synapses.filter(func(synapse *JsonSynapse) bool {
+		return bodies[synapse.Tbar.Body]
+	})
+}
+
+// filter returns the synapses passing keep with a copy of the metadata.
+func (synapses *JsonSynapses) filter(keep func(*JsonSynapse) bool) (
+	filtered *JsonSynapses) {
+
+	filtered = new(JsonSynapses)
+	if synapses.Metadata != nil {
+		filtered.Metadata = make(map[string]interface{},
+			len(synapses.Metadata))
+		for key, value := range synapses.Metadata {
+			filtered.Metadata[key] = value
+		}
+	}
+	filtered.Data = []JsonSynapse{}
+	for s, _ := range synapses.Data {
+		if keep(&synapses.Data[s]) {
+			filtered.Data = append(filtered.Data, synapses.Data[s])
+		}
+	}
+	return
+}
+
+// JsonBookmarks is the high-level structure for a Raveler
+// bookmark annotation list.
+type JsonBookmarks struct {
+	Metadata map[string]interface{} `json:"metadata"`
+	Data     []JsonBookmark         `json:"data"`
+}
+
+// JsonBookmark is a location in a stack with a body and descriptive text.
+type JsonBookmark struct {
+	Location Point3d `json:"location"`
+	Body     BodyId  `json:"body ID"`
+	Text     string  `json:"text"`
+}
+
+// CreateBookmarks returns an empty bookmark list with metadata.
+func CreateBookmarks(description string) *JsonBookmarks {
+	return &JsonBookmarks{
+		Metadata: CreateMetadata(description),
+		Data:     []JsonBookmark{},
+	}
+}
+
+// Add appends a bookmark to the list.
+func (bookmarks *JsonBookmarks) Add(location Point3d, bodyId BodyId,
+	text string) {
+	bookmarks.Data = append(bookmarks.Data,
+		JsonBookmark{location, bodyId, text})
+}
+
+// WriteJson writes indented JSON bookmark list to writer
+func (bookmarks *JsonBookmarks) WriteJson(writer io.Writer) {
+	m, err := json.Marshal(bookmarks)
 	if err != nil {
 		log.Fatalf("Error in writing json: %s", err)
 	}
@@ -260,14 +996,14 @@ func (synapses *JsonSynapses) WriteJson(writer io.Writer) {
 	buf.WriteTo(writer)
 }
 
-// WriteJsonFile writes synapses annotation file
-func (synapses *JsonSynapses) WriteJsonFile(filename string) {
+// WriteJsonFile writes a bookmark annotation file
+func (bookmarks *JsonBookmarks) WriteJsonFile(filename string) {
 	file, err := os.Create(filename)
 	if err != nil {
-		log.Fatalf("ERROR: Failed to create json synapses file: %s [%s]\n",
+		log.Fatalf("ERROR: Failed to create json bookmarks file: %s [%s]\n",
 			filename, err)
 	}
-	synapses.WriteJson(file)
+	bookmarks.WriteJson(file)
 	file.Close()
 }
 
@@ -277,8 +1013,12 @@ type JsonSynapse struct {
 	Psds []JsonPsd `json:"partners"`
 }
 
-// GetPsdIndex returns the index of the PSD given a PSD uid. 
+// GetPsdIndex returns the index of the PSD given a PSD uid.  A nil
+// synapse or one without partners returns -1 and false.
 func (synapse *JsonSynapse) GetPsdIndex(psdUid string) (index int, found bool) {
+	if synapse == nil {
+		return -1, false
+	}
 	for i, psd := range synapse.Psds {
 		if psd.Uid == psdUid {
 			return i, true
@@ -287,17 +1027,39 @@ func (synapse *JsonSynapse) GetPsdIndex(psdUid string) (index int, found bool) {
 	return -1, false
 }
 
+// ConfidenceDecimals is the maximum # of decimal places written for
+// confidence values.
+var ConfidenceDecimals = 6
+
+// Confidence is a detection confidence that is written with at most
+// ConfidenceDecimals decimal places so values survive read/write cycles
+// unchanged.  A zero confidence is omitted from JSON.
+type Confidence float64
+
+// MarshalJSON writes the confidence without trailing zeros.  Non-zero
+// values too small for ConfidenceDecimals are written in full.
+func (c Confidence) MarshalJSON() ([]byte, error) {
+	text := strconv.FormatFloat(float64(c), 'f', ConfidenceDecimals, 64)
+	if strings.Contains(text, ".") {
+		text = strings.TrimRight(strings.TrimRight(text, "0"), ".")
+	}
+	if c != 0 && (text == "0" || text == "-0") {
+		text = strconv.FormatFloat(float64(c), 'g', -1, 64)
+	}
+	return []byte(text), nil
+}
+
 // JsonTbar holds various T-bar attributes including a uid and
 // assignment useful for analysis and tracking synapses through
 // transformations.
 type JsonTbar struct {
-	Location       Point3d `json:"location"`
-	Body           BodyId  `json:"body ID"`
-	Confidence     float32 `json:"confidence,omitempty"`
-	Uid            string  `json:"uid,omitempty"`
-	UsedBodyRadius int     `json:"used body radius,omitempty"`
-	Status         string  `json:"status,omitempty"`
-	Assignment     string  `json:"assignment,omitempty"`
+	Location       Point3d    `json:"location"`
+	Body           BodyId     `json:"body ID"`
+	Confidence     Confidence `json:"confidence,omitempty"`
+	Uid            string     `json:"uid,omitempty"`
+	UsedBodyRadius int        `json:"used body radius,omitempty"`
+	Status         string     `json:"status,omitempty"`
+	Assignment     string     `json:"assignment,omitempty"`
 }
 
 // GetLocationAndUid returns location and uid data
@@ -305,16 +1067,89 @@ func (tbar *JsonTbar) GetLocationAndUid() (location Point3d, uid string) {
 	return tbar.Location, tbar.Uid
 }
 
+// JsonTbarStatus is an entry in a Raveler-exported T-bar status file,
+// e.g., marking a T-bar as "reviewed" or "false".
+type JsonTbarStatus struct {
+	Location Point3d `json:"location"`
+	Status   string  `json:"status"`
+}
+
+// ReadTbarStatusJson reads a T-bar status file, which is a simple
+// JSON array of location and status pairs.
+func ReadTbarStatusJson(filename string) (statuses []JsonTbarStatus) {
+	var file *os.File
+	var err error
+	if file, err = os.Open(filename); err != nil {
+		log.Fatalf("FATAL ERROR: Failed to open JSON file: %s [%s]",
+			filename, err)
+	}
+	defer file.Close()
+	dec := json.NewDecoder(file)
+	if err := dec.Decode(&statuses); err == io.EOF {
+		log.Fatalf("FATAL ERROR: No data in JSON file: %s\n", filename)
+	} else if err != nil {
+		log.Fatalf("FATAL ERROR: Error reading JSON file (%s): %s\n",
+			filename, err)
+	}
+	return
+}
+
+// ApplyTbarStatus sets the Status of each T-bar that lies within
+// tolerance voxels of an entry in the status list.  Each T-bar takes the
+// status of at most one entry.  Status entries that could not be matched
+// and the indices (into synapses.Data) of T-bars that received no status
+// are returned.
+func (synapses *JsonSynapses) ApplyTbarStatus(statuses []JsonTbarStatus,
+	tolerance int) (unmatchedStatuses []JsonTbarStatus, unmatchedTbars []int) {
+
+	locations := make([]Point3d, len(synapses.Data))
+	for s, synapse := range synapses.Data {
+		locations[s] = synapse.Tbar.Location
+	}
+	index := NewPointIndex(locations, tolerance)
+
+	matched := make([]bool, len(synapses.Data))
+	for _, status := range statuses {
+		s, _, found := index.Nearest(status.Location, tolerance)
+		if !found {
+			unmatchedStatuses = append(unmatchedStatuses, status)
+		} else if matched[s] {
+			log.Println("** Warning: T-bar", synapses.Data[s].Tbar.Location,
+				"already has status", synapses.Data[s].Tbar.Status,
+				"so ignoring status", status.Status, "at", status.Location)
+			unmatchedStatuses = append(unmatchedStatuses, status)
+		} else {
+			synapses.Data[s].Tbar.Status = status.Status
+			matched[s] = true
+		}
+	}
+	for s, isMatched := range matched {
+		if !isMatched {
+			unmatchedTbars = append(unmatchedTbars, s)
+		}
+	}
+	log.Printf("Applied %d of %d T-bar statuses: %d T-bars have no status\n",
+		len(statuses)-len(unmatchedStatuses), len(statuses),
+		len(unmatchedTbars))
+	return
+}
+
 // JsonPsd holds information for a post-synaptic density (PSD),
 // including the tracing results for various proofreading agents.
 type JsonPsd struct {
-	Location       Point3d       `json:"location"`
-	Body           BodyId        `json:"body ID"`
-	Confidence     float32       `json:"confidence,omitempty"`
-	Uid            string        `json:"uid,omitempty"`
-	Tracings       []JsonTracing `json:"tracings,omitempty"`
-	TransformIssue bool          `json:"transform issue,omitempty"`
-	BodyIssue      bool          `json:"body issue,omitempty"`
+	Location   Point3d       `json:"location"`
+	Body       BodyId        `json:"body ID"`
+	Confidence Confidence    `json:"confidence,omitempty"`
+	Uid        string        `json:"uid,omitempty"`
+	Tracings   []JsonTracing `json:"tracings,omitempty"`
+
+	// TransformIssue marks a PSD whose location or traced bodies could
+	// not be carried through a stack transformation.
+	TransformIssue bool `json:"transform issue,omitempty"`
+
+	// BodyIssue marks a PSD whose body could not be found in an exported
+	// stack, e.g., its superpixel is unmapped or no nearby body exists.
+	BodyIssue bool `json:"body issue,omitempty"`
 }
 
 // GetLocationAndUid returns location and uid data
@@ -388,21 +1223,51 @@ func (psd *JsonPsd) CheckTracings(namedBodyMap NamedBodyMap) (result PsdTracingR
 	reachedBody BodyId, reachedName string, comment string,
 	numTracesPerBody map[BodyId]int) {
 
+	return psd.CheckTracingsWithOptions(namedBodyMap, DefaultTracingCheckOptions)
+}
+
+// TracingCheckOptions controls which tracings CheckTracingsWithOptions uses.
+type TracingCheckOptions struct {
+	// SkipSameUser uses only the first tracing of each user so that a
+	// duplicated tracing does not count as agreement.
+	SkipSameUser bool
+}
+
+// DefaultTracingCheckOptions uses the first two tracings regardless of user.
+var DefaultTracingCheckOptions = TracingCheckOptions{SkipSameUser: false}
+
+// CheckTracingsWithOptions is CheckTracings with control over which tracings
+// are compared.
+func (psd *JsonPsd) CheckTracingsWithOptions(namedBodyMap NamedBodyMap,
+	options TracingCheckOptions) (result PsdTracingResult,
+	reachedBody BodyId, reachedName string, comment string,
+	numTracesPerBody map[BodyId]int) {
+
 	reachedBody = 0
 	reachedName = "?"
 	comment = ""
-	if len(psd.Tracings) < 2 {
+	numTracesPerBody = map[BodyId]int{}
+	if psd == nil || len(psd.Tracings) == 0 {
+		// Untraced PSDs, e.g., from T-bars whose partners were null,
+		// are expected and not worth a warning.
+		result = PsdNot2Tracings
+		return
+	}
+	tracings := psd.Tracings
+	if options.SkipSameUser {
+		tracings = psd.firstTracingPerUser()
+	}
+	if len(tracings) < 2 {
 		result = PsdNot2Tracings
 		log.Printf("Warning!  Detected %d tracings for psd at location %s\n",
-			len(psd.Tracings), psd.Location)
+			len(tracings), psd.Location)
 		return
 	}
-	numTracesPerBody = map[BodyId]int{}
 
 	prevResult := NoTraces
 	prevReachedBody := BodyId(0)
 	tracingsAnalyzed := 0
-	for _, tracing := range psd.Tracings {
+	for _, tracing := range tracings {
 		if tracingsAnalyzed == 2 {
 			break // If >= 3 tracings, just use first 2
 		}
@@ -486,6 +1351,72 @@ func (psd *JsonPsd) CheckTracings(namedBodyMap NamedBodyMap) (result PsdTracingR
 	return
 }
 
+// firstTracingPerUser returns the PSD's tracings, keeping only the first
+// tracing of each user.
+func (psd *JsonPsd) firstTracingPerUser() (tracings []JsonTracing) {
+	users := make(map[string]bool, len(psd.Tracings))
+	for _, tracing := range psd.Tracings {
+		if !users[tracing.Userid] {
+			users[tracing.Userid] = true
+			tracings = append(tracings, tracing)
+		}
+	}
+	return
+}
+
+// DedupeTracings removes exact duplicate tracings from the PSD and returns
+// the # removed.  Users with more than one remaining tracing that differ in
+// result are returned as conflicts; their tracings are kept.
+func (psd *JsonPsd) DedupeTracings() (removed int, conflicts []string) {
+	results := make(map[string]TracingResult, len(psd.Tracings))
+	conflicted := make(map[string]bool)
+	kept := psd.Tracings[:0]
+	for _, tracing := range psd.Tracings {
+		duplicate := false
+		for _, keptTracing := range kept {
+			if reflect.DeepEqual(tracing, keptTracing) {
+				duplicate = true
+				break
+			}
+		}
+		if duplicate {
+			removed++
+			continue
+		}
+		kept = append(kept, tracing)
+		result, found := results[tracing.Userid]
+		if !found {
+			results[tracing.Userid] = tracing.Result
+		} else if result != tracing.Result && !conflicted[tracing.Userid] {
+			conflicted[tracing.Userid] = true
+			conflicts = append(conflicts, tracing.Userid)
+		}
+	}
+	psd.Tracings = kept
+	return
+}
+
+// DedupeTracings removes exact duplicate tracings from all PSDs and returns
+// the # removed.  PSDs where the same user has conflicting results are kept
+// and reported as "conflicting same-user tracings" warnings.
+func (synapses *JsonSynapses) DedupeTracings() (removed int,
+	conflicts Warnings) {
+
+	for s, synapse := range synapses.Data {
+		for p, _ := range synapse.Psds {
+			psd := &synapses.Data[s].Psds[p]
+			psdRemoved, users := psd.DedupeTracings()
+			removed += psdRemoved
+			for _, userid := range users {
+				conflicts.Add("conflicting same-user tracings",
+					"PSD %s of T-bar %s traced differently by %s",
+					psd.Location, synapse.Tbar.Location, userid)
+			}
+		}
+	}
+	return
+}
+
 // JsonTracing is the data from a single PSD tracing and also
 // holds data useful for quality control to determine if
 // transformations and overlap analysis was correct.
@@ -500,6 +1431,12 @@ type JsonTracing struct {
 	BaseColumnBody BodyId        `json:"base column traced body,omitempty"`
 	ColumnOverlaps int           `json:"export->base overlap,omitempty"`
 	TargetOverlaps int           `json:"orig12k->target overlap,omitempty"`
+	AmbiguousMatch bool          `json:"ambiguous match,omitempty"`
+
+	// Superpixel and location used to find the exported body, which
+	// differs from the PSD location if a nearby body was used.
+	Superpixel     *Superpixel `json:"superpixel,omitempty"`
+	LookupLocation *Point3d    `json:"lookup location,omitempty"`
 }
 
 // TbarUid returns a string T-bar uid for a given 3d point
@@ -536,7 +1473,7 @@ type JsonStack interface {
 
 // ReadStackBodiesJson returns the default body annotation file
 // for a given stack.
-func ReadStackBodiesJson(stack JsonStack) *JsonBodies {
+func ReadStackBodiesJson(stack JsonStack) (*JsonBodies, error) {
 	return ReadBodiesJson(stack.StackBodiesJsonFilename())
 }
 
@@ -544,30 +1481,102 @@ func ReadStackBodiesJson(stack JsonStack) *JsonBodies {
 type BodyAnnotations map[BodyId]JsonBody
 
 // ReadStackBodyAnnotations returns the BodyAnnotations for a given stack
-func ReadStackBodyAnnotations(stack JsonStack) (annotations BodyAnnotations) {
+func ReadStackBodyAnnotations(stack JsonStack) (
+	annotations BodyAnnotations, err error) {
+
+	bodyNotes, err := ReadBodiesJson(stack.StackBodiesJsonFilename())
+	if err != nil {
+		return
+	}
 	annotations = make(BodyAnnotations)
-	bodyNotes := ReadBodiesJson(stack.StackBodiesJsonFilename())
 	for _, bodyNote := range bodyNotes.Data {
 		annotations[bodyNote.Body] = bodyNote
 	}
 	return
 }
 
+// sortedBodies returns the annotated body ids in ascending order.
+func (annotations BodyAnnotations) sortedBodies() BodyIdList {
+	bodies := make(BodyIdList, 0, len(annotations))
+	for bodyId, _ := range annotations {
+		bodies = append(bodies, bodyId)
+	}
+	sort.Sort(bodies)
+	return bodies
+}
+
+// GroupByStatus returns the body annotations of each status, sorted by
+// body id.
+func (annotations BodyAnnotations) GroupByStatus() map[string][]JsonBody {
+	groups := make(map[string][]JsonBody)
+	for _, bodyId := range annotations.sortedBodies() {
+		bodyNote := annotations[bodyId]
+		groups[bodyNote.Status] = append(groups[bodyNote.Status], bodyNote)
+	}
+	return groups
+}
+
+// CountByStatus returns the # of annotated bodies with each status.
+func (annotations BodyAnnotations) CountByStatus() map[string]int {
+	counts := make(map[string]int)
+	for _, bodyNote := range annotations {
+		counts[bodyNote.Status]++
+	}
+	return counts
+}
+
+// Filter returns the body annotations for which predicate returns true.
+func (annotations BodyAnnotations) Filter(
+	predicate func(JsonBody) bool) BodyAnnotations {
+
+	filtered := make(BodyAnnotations)
+	for bodyId, bodyNote := range annotations {
+		if predicate(bodyNote) {
+			filtered[bodyId] = bodyNote
+		}
+	}
+	return filtered
+}
+
+// ToBodySet returns the set of annotated bodies.
+func (annotations BodyAnnotations) ToBodySet() BodySet {
+	bodySet := make(BodySet, len(annotations))
+	for bodyId, _ := range annotations {
+		bodySet[bodyId] = true
+	}
+	return bodySet
+}
+
+// ToBodySetByStatus returns the set of annotated bodies with the given
+// status.
+func (annotations BodyAnnotations) ToBodySetByStatus(status string) BodySet {
+	bodySet := make(BodySet)
+	for bodyId, bodyNote := range annotations {
+		if bodyNote.Status == status {
+			bodySet[bodyId] = true
+		}
+	}
+	return bodySet
+}
+
 // ReadStackSynapsesJson returns the default synapse annotation file
 // for a given stack.
-func ReadStackSynapsesJson(stack JsonStack) *JsonSynapses {
+func ReadStackSynapsesJson(stack JsonStack) (*JsonSynapses, error) {
 	return ReadSynapsesJson(stack.StackSynapsesJsonFilename())
 }
 
 // ReadPsdBodyMap returns a PSD -> Body Id map from a
 // stack's synapse annotation file.
-func ReadPsdBodyMap(stack JsonStack) LocationToBodyMap {
-	synapses := ReadStackSynapsesJson(stack)
+func ReadPsdBodyMap(stack JsonStack) (LocationToBodyMap, error) {
+	synapses, err := ReadStackSynapsesJson(stack)
+	if err != nil {
+		return nil, err
+	}
 	psdToBodyMap := make(LocationToBodyMap)
 	for _, synapse := range synapses.Data {
 		for _, psd := range synapse.Psds {
 			psdToBodyMap[psd.Location] = psd.Body
 		}
 	}
-	return psdToBodyMap
+	return psdToBodyMap, nil
 }
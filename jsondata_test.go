@@ -0,0 +1,799 @@
+// Copyright 2012 HHMI.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//     * Neither the name of HHMI nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+// Author: katzw@janelia.hhmi.org (Bill Katz)
+//  Written as part of the FlyEM Project at Janelia Farm Research Center.
+
+package emdata
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// nullPartnersJson has T-bars with null, missing and populated partners.
+const nullPartnersJson = `{
+    "metadata": {"file version": 1},
+    "data": [
+        {"T-bar": {"location": [10, 20, 30], "body ID": 1}, "partners": null},
+        {"T-bar": {"location": [11, 21, 31], "body ID": 2}},
+        {"T-bar": {"location": [12, 22, 32], "body ID": 3},
+         "partners": [{"location": [13, 23, 32], "body ID": 4}]}
+    ]
+}`
+
+func TestReadSynapsesNullPartners(t *testing.T) {
+	setStrictness(t, Strict)
+	_, _, err := ReadSynapsesJsonFromWithWarnings(
+		strings.NewReader(nullPartnersJson))
+	if _, ok := err.(*AnomalyError); !ok {
+		t.Fatalf("Strict: expected *AnomalyError, got %v", err)
+	}
+
+	DefaultStrictness = Lenient
+	synapses, warnings, err := ReadSynapsesJsonFromWithWarnings(
+		strings.NewReader(nullPartnersJson))
+	if err != nil {
+		t.Fatalf("Lenient: unexpected error: %s", err)
+	}
+	if warnings.Count("T-bar without partners") != 2 {
+		t.Errorf("expected 2 warnings, got %s", warnings)
+	}
+	for s, synapse := range synapses.Data[:2] {
+		if synapse.Psds == nil || len(synapse.Psds) != 0 {
+			t.Errorf("T-bar %d: expected empty partners, got %v", s,
+				synapse.Psds)
+		}
+		if i, found := synapse.GetPsdIndex("any"); found || i != -1 {
+			t.Errorf("T-bar %d: GetPsdIndex returned %d, %t", s, i, found)
+		}
+	}
+
+	// Uids are added only to the populated T-bar.
+	uidWarnings, err := synapses.AddPsdUids(nil)
+	if err != nil {
+		t.Fatalf("AddPsdUids: unexpected error: %s", err)
+	}
+	if uidWarnings.Count("T-bar without partners") != 2 {
+		t.Errorf("AddPsdUids: expected 2 warnings, got %s", uidWarnings)
+	}
+	if synapses.Data[2].Psds[0].Uid == "" {
+		t.Errorf("AddPsdUids: no uid added to populated T-bar")
+	}
+
+	result, _, _, _, numTracesPerBody :=
+		synapses.Data[2].Psds[0].CheckTracings(NamedBodyMap{})
+	if result != PsdNot2Tracings || numTracesPerBody == nil {
+		t.Errorf("CheckTracings of untraced PSD: got %d, %v", result,
+			numTracesPerBody)
+	}
+
+	if dropped := synapses.DropEmptyTbars(); dropped != 2 {
+		t.Errorf("DropEmptyTbars: expected 2 dropped, got %d", dropped)
+	}
+	if len(synapses.Data) != 1 || synapses.Data[0].Tbar.Body != 3 {
+		t.Errorf("DropEmptyTbars: kept %v", synapses.Data)
+	}
+}
+
+func TestReadSynapsesTruncatedJson(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "truncated.json")
+	truncated := nullPartnersJson[:len(nullPartnersJson)/2]
+	if err := os.WriteFile(filename, []byte(truncated), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := ReadSynapsesJson(filename)
+	if err == nil {
+		t.Fatal("expected error for truncated JSON")
+	}
+	if !strings.Contains(err.Error(), io.ErrUnexpectedEOF.Error()) ||
+		!strings.Contains(err.Error(), filename) {
+		t.Errorf("expected unexpected EOF error naming %s, got %q", filename,
+			err)
+	}
+}
+
+// failingWriter returns an error for every write.
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestWriteSynapsesJsonError(t *testing.T) {
+	synapses, err := ReadSynapsesJsonFrom(strings.NewReader(nullPartnersJson))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = synapses.WriteJson(failingWriter{}); err == nil {
+		t.Error("WriteJson: expected error from failing writer")
+	}
+	missingDir := filepath.Join(t.TempDir(), "missing", "synapses.json")
+	if err = synapses.WriteJsonFileE(missingDir); err == nil ||
+		!strings.Contains(err.Error(), missingDir) {
+		t.Errorf("WriteJsonFileE: expected error naming %s, got %v",
+			missingDir, err)
+	}
+}
+
+// marshalAllSynapses is the WriteJson implementation that marshaled and
+// indented the whole synapse list at once, kept for comparison.
+func marshalAllSynapses(synapses *JsonSynapses, writer io.Writer) error {
+	output := *synapses
+	output.Metadata = metadataForWrite(synapses.Metadata)
+	m, err := json.Marshal(&output)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	json.Indent(&buf, m, "", "    ")
+	_, err = buf.WriteTo(writer)
+	return err
+}
+
+// benchmarkSynapses returns synapses with one T-bar and two PSDs each.
+func benchmarkSynapses(num int) *JsonSynapses {
+	synapses := &JsonSynapses{Data: make([]JsonSynapse, num)}
+	for i := range synapses.Data {
+		x := VoxelCoord(i % 1000)
+		y := VoxelCoord(i / 1000)
+		synapses.Data[i] = JsonSynapse{
+			Tbar: JsonTbar{Location: Point3d{x, y, 10}, Body: BodyId(i),
+				Confidence: 0.9, Uid: fmt.Sprintf("tbar-%d", i)},
+			Psds: []JsonPsd{
+				{Location: Point3d{x + 1, y, 10}, Body: BodyId(i + 1)},
+				{Location: Point3d{x, y + 1, 10}, Body: BodyId(i + 2)},
+			},
+		}
+	}
+	return synapses
+}
+
+func TestWriteJsonMatchesMarshalAll(t *testing.T) {
+	synapses := benchmarkSynapses(1000)
+	var streamed, marshaled bytes.Buffer
+	if err := synapses.WriteJson(&streamed); err != nil {
+		t.Fatal(err)
+	}
+	if err := marshalAllSynapses(synapses, &marshaled); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(streamed.Bytes(), marshaled.Bytes()) {
+		t.Error("streamed synapses JSON differs from marshaled list")
+	}
+}
+
+func BenchmarkWriteJsonSynapses(b *testing.B) {
+	synapses := benchmarkSynapses(100000)
+	b.Run("streamed", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := synapses.WriteJson(io.Discard); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("marshal all", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := marshalAllSynapses(synapses, io.Discard); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestApplyTbarStatus(t *testing.T) {
+	synapses := &JsonSynapses{Data: []JsonSynapse{
+		{Tbar: JsonTbar{Location: Point3d{100, 100, 5}}},
+		{Tbar: JsonTbar{Location: Point3d{-20, 40, 6}}},
+		{Tbar: JsonTbar{Location: Point3d{300, 300, 7}}},
+	}}
+	const statusJson = `[
+    {"location": [100, 100, 5], "status": "reviewed"},
+    {"location": [-22, 41, 6], "status": "false"},
+    {"location": [500, 500, 7], "status": "reviewed"}
+]`
+	filename := filepath.Join(t.TempDir(), "tbar-status.json")
+	if err := os.WriteFile(filename, []byte(statusJson), 0644); err != nil {
+		t.Fatal(err)
+	}
+	statuses := ReadTbarStatusJson(filename)
+	if len(statuses) != 3 {
+		t.Fatalf("expected 3 statuses, got %v", statuses)
+	}
+
+	unmatchedStatuses, unmatchedTbars := synapses.ApplyTbarStatus(statuses, 3)
+	expected := []string{"reviewed", "false", ""}
+	for s, status := range expected {
+		if synapses.Data[s].Tbar.Status != status {
+			t.Errorf("T-bar %d: expected status %q, got %q", s, status,
+				synapses.Data[s].Tbar.Status)
+		}
+	}
+	if len(unmatchedStatuses) != 1 ||
+		unmatchedStatuses[0].Location != (Point3d{500, 500, 7}) {
+		t.Errorf("expected status at (500,500,7) unmatched, got %v",
+			unmatchedStatuses)
+	}
+	if len(unmatchedTbars) != 1 || unmatchedTbars[0] != 2 {
+		t.Errorf("expected T-bar 2 unmatched, got %v", unmatchedTbars)
+	}
+}
+
+func TestReadBodiesJsonFrom(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		bodies int
+		failed bool
+	}{
+		{"two bodies", `{"metadata": {"file version": 1}, "data": [
+			{"body ID": 7, "status": "Anchor", "name": "Mi1"},
+			{"body ID": 9, "status": "Leaves"}]}`, 2, false},
+		{"no data", `{"metadata": {}}`, 0, false},
+		{"empty", "", 0, true},
+		{"null", "null", 0, true},
+		{"truncated", `{"data": [{"body ID": 7`, 0, true},
+		{"bad body id", `{"data": [{"body ID": "seven"}]}`, 0, true},
+	}
+	for _, test := range tests {
+		bodies, err := ReadBodiesJsonFrom(strings.NewReader(test.text))
+		if test.failed {
+			if err == nil {
+				t.Errorf("%s: expected error", test.name)
+			}
+		} else if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		} else if len(bodies.Data) != test.bodies {
+			t.Errorf("%s: expected %d bodies, got %d", test.name,
+				test.bodies, len(bodies.Data))
+		}
+	}
+}
+
+func TestReadSynapsesJsonFrom(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		synapses int
+		failed   bool
+	}{
+		{"partners", nullPartnersJson, 3, false},
+		{"no data", `{"metadata": {}}`, 0, false},
+		{"empty", "", 0, true},
+		{"null", "null", 0, true},
+		{"bad location", `{"data": [{"T-bar": {"location": "here"}}]}`, 0,
+			true},
+	}
+	for _, test := range tests {
+		synapses, err := ReadSynapsesJsonFrom(strings.NewReader(test.text))
+		if test.failed {
+			if err == nil {
+				t.Errorf("%s: expected error", test.name)
+			}
+		} else if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		} else if len(synapses.Data) != test.synapses {
+			t.Errorf("%s: expected %d synapses, got %d", test.name,
+				test.synapses, len(synapses.Data))
+		}
+	}
+}
+
+func TestReadNamedBodiesCsvFrom(t *testing.T) {
+	// Rows must match the header's field count; the ragged row is skipped.
+	const namedCsv = "body ID,name,cell type,location,primary,secondary,lock\n" +
+		"7,Mi1-home,Mi1,home,primary,,lock\n" +
+		"9,Tm3-A,Tm3,A,,secondary,\n" +
+		"11,L1,L1,,,,\n" +
+		"13,ragged\n" +
+		"x,bad id,,,,,\n" +
+		",empty id,,,,,\n"
+	tests := []struct {
+		name    string
+		options NamedBodyOptions
+		bodies  []BodyId
+	}{
+		{"all", NamedBodyOptions{}, []BodyId{7, 9, 11}},
+		{"body ids", NamedBodyOptions{BodyIds: BodySet{9: true, 12: true}},
+			[]BodyId{9}},
+		{"body names", NamedBodyOptions{BodyNames: BodyNameSet{"L1": true}},
+			[]BodyId{11}},
+	}
+	for _, test := range tests {
+		namedBodyMap, err := ReadNamedBodiesCsvFrom(strings.NewReader(namedCsv),
+			test.options)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if len(namedBodyMap) != len(test.bodies) {
+			t.Errorf("%s: expected bodies %v, got %v", test.name, test.bodies,
+				namedBodyMap)
+		}
+		for _, bodyId := range test.bodies {
+			if _, found := namedBodyMap[bodyId]; !found {
+				t.Errorf("%s: body %d missing", test.name, bodyId)
+			}
+		}
+	}
+
+	namedBodyMap, _ := ReadNamedBodiesCsvFrom(strings.NewReader(namedCsv),
+		NamedBodyOptions{})
+	expected := NamedBody{Body: 7, Name: "Mi1-home", CellType: "Mi1",
+		Location: "home", IsPrimary: true, Locked: true}
+	if namedBodyMap[7] != expected {
+		t.Errorf("expected %+v, got %+v", expected, namedBodyMap[7])
+	}
+	if !namedBodyMap[9].IsSecondary || namedBodyMap[9].Locked {
+		t.Errorf("expected unlocked secondary body 9, got %+v", namedBodyMap[9])
+	}
+}
+
+func TestJsonFileErrorsNameFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "bad.json")
+	if err := os.WriteFile(filename, []byte(`{"data": 5}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadBodiesJson(filename); err == nil ||
+		!strings.Contains(err.Error(), filename) {
+		t.Errorf("ReadBodiesJson: expected error naming %s, got %v", filename,
+			err)
+	}
+	if _, err := ReadSynapsesJson(filename); err == nil ||
+		!strings.Contains(err.Error(), filename) {
+		t.Errorf("ReadSynapsesJson: expected error naming %s, got %v",
+			filename, err)
+	}
+	missing := filepath.Join(t.TempDir(), "missing.json")
+	if _, err := ReadBodiesJson(missing); err == nil ||
+		!strings.Contains(err.Error(), missing) {
+		t.Errorf("ReadBodiesJson: expected error naming %s, got %v", missing,
+			err)
+	}
+}
+
+func TestGetFileVersion(t *testing.T) {
+	tests := []struct {
+		value   interface{}
+		version int
+		found   bool
+	}{
+		{2, 2, true},
+		{float64(2), 2, true},
+		{json.Number("3"), 3, true},
+		{json.Number("x"), 0, false},
+		{"2", 2, true},
+		{"two", 0, false},
+		{true, 0, false},
+	}
+	for _, test := range tests {
+		metadata := map[string]interface{}{"file version": test.value}
+		version, found := GetFileVersion(metadata)
+		if version != test.version || found != test.found {
+			t.Errorf("%#v: expected (%d, %t), got (%d, %t)", test.value,
+				test.version, test.found, version, found)
+		}
+	}
+	if _, found := GetFileVersion(map[string]interface{}{}); found {
+		t.Errorf("expected no file version in empty metadata")
+	}
+}
+
+func TestFileVersionRoundTrip(t *testing.T) {
+	const version2Json = `{
+    "metadata": {"file version": 2, "description": "synapse annotations"},
+    "data": [
+        {"T-bar": {"location": [10, 20, 30], "body ID": 1, "uid": "t1"},
+         "partners": [{"location": [13, 23, 30], "body ID": 4, "uid": "t1-1"}]}
+    ]
+}`
+	synapses, err := ReadSynapsesJsonFrom(strings.NewReader(version2Json))
+	if err != nil {
+		t.Fatal(err)
+	}
+	synapses.Metadata = UpdateMetadata(synapses.Metadata, "rewritten")
+	var buf bytes.Buffer
+	if err := synapses.WriteJson(&buf); err != nil {
+		t.Fatal(err)
+	}
+	reread, err := ReadSynapsesJsonFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version, _ := GetFileVersion(reread.Metadata); version != 2 {
+		t.Errorf("expected file version 2 after round trip, got %d", version)
+	}
+	if violations := reread.CheckRavelerCompatibility(2); violations.Len() != 0 {
+		t.Errorf("expected no version 2 violations, got %s", violations)
+	}
+
+	// Metadata without a version is stamped with the default on write
+	// but left untouched in memory.
+	bodies := &JsonBodies{Metadata: map[string]interface{}{},
+		Data: []JsonBody{{Body: 7, Status: "Anchor"}}}
+	buf.Reset()
+	bodies.WriteJson(&buf)
+	if _, found := GetFileVersion(bodies.Metadata); found {
+		t.Errorf("WriteJson modified the passed metadata")
+	}
+	rereadBodies, err := ReadBodiesJsonFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, _ := GetFileVersion(rereadBodies.Metadata)
+	if version != DefaultFileVersion {
+		t.Errorf("expected default file version %d, got %d",
+			DefaultFileVersion, version)
+	}
+}
+
+func TestCheckRavelerCompatibility(t *testing.T) {
+	synapses := &JsonSynapses{
+		Metadata: map[string]interface{}{"file version": 2},
+		Data: []JsonSynapse{
+			{Tbar: JsonTbar{Location: Point3d{10, 20, 30}, Uid: "t1"},
+				Psds: []JsonPsd{{Location: Point3d{13, 23, 30}}}},
+			{Tbar: JsonTbar{Location: Point3d{40, 50, 30}},
+				Psds: []JsonPsd{{Location: Point3d{43, 53, 30}, Uid: "p1"}}},
+		},
+	}
+	violations := synapses.CheckRavelerCompatibility(2)
+	if violations.Count("T-bar without uid") != 1 ||
+		violations.Count("PSD without uid") != 1 || violations.Len() != 2 {
+		t.Errorf("version 2: expected one T-bar and one PSD violation, got %s",
+			violations)
+	}
+	violations = synapses.CheckRavelerCompatibility(1)
+	if violations.Count("file version too new") != 1 || violations.Len() != 1 {
+		t.Errorf("version 1: expected only a file version violation, got %s",
+			violations)
+	}
+
+	bodies := &JsonBodies{
+		Data: []JsonBody{{Body: 7, Status: "Anchor"}, {Body: 7},
+			{Body: 0, Status: "Leaves"}},
+	}
+	violations = bodies.CheckRavelerCompatibility(1)
+	for _, category := range []string{"no file version", "duplicate body",
+		"no status", "zero body id"} {
+		if violations.Count(category) != 1 {
+			t.Errorf("bodies: expected one %q violation, got %s", category,
+				violations)
+		}
+	}
+}
+
+func TestDedupeTracings(t *testing.T) {
+	alice := JsonTracing{Userid: "alice", Result: 100, AssignmentSet: 1}
+	bob := JsonTracing{Userid: "bob", Result: 100, AssignmentSet: 1}
+	carol := JsonTracing{Userid: "carol", Result: 100, AssignmentSet: 1}
+	carolOrphan := JsonTracing{Userid: "carol", Result: Orphan,
+		AssignmentSet: 2}
+	synapses := &JsonSynapses{Data: []JsonSynapse{
+		{Tbar: JsonTbar{Location: Point3d{1, 2, 3}}, Psds: []JsonPsd{
+			{Location: Point3d{4, 5, 3},
+				Tracings: []JsonTracing{alice, alice, bob, alice}},
+			{Location: Point3d{6, 7, 3},
+				Tracings: []JsonTracing{carol, carolOrphan, bob, carol}},
+		}},
+		{Tbar: JsonTbar{Location: Point3d{8, 9, 3}}, Psds: []JsonPsd{
+			{Location: Point3d{10, 11, 3}},
+		}},
+	}}
+	removed, conflicts := synapses.DedupeTracings()
+	if removed != 3 {
+		t.Errorf("expected 3 duplicate tracings removed, got %d", removed)
+	}
+	if n := conflicts.Count("conflicting same-user tracings"); n != 1 ||
+		conflicts.Len() != 1 {
+		t.Errorf("expected one same-user conflict, got %s", conflicts)
+	}
+	psds := synapses.Data[0].Psds
+	expected := [][]JsonTracing{{alice, bob}, {carol, carolOrphan, bob}}
+	for i, tracings := range expected {
+		if !reflect.DeepEqual(psds[i].Tracings, tracings) {
+			t.Errorf("PSD %d: expected tracings %v, got %v", i, tracings,
+				psds[i].Tracings)
+		}
+	}
+	if len(synapses.Data[1].Psds[0].Tracings) != 0 {
+		t.Errorf("untraced PSD gained tracings")
+	}
+
+	// Deduping again changes nothing.
+	if removed, conflicts = synapses.DedupeTracings(); removed != 0 ||
+		conflicts.Len() != 1 {
+		t.Errorf("second dedupe: removed %d, conflicts %s", removed, conflicts)
+	}
+}
+
+func TestCheckTracingsSkipSameUser(t *testing.T) {
+	psd := JsonPsd{Location: Point3d{4, 5, 3}, Tracings: []JsonTracing{
+		{Userid: "alice", Result: 100, AssignmentSet: 1},
+		{Userid: "alice", Result: 100, AssignmentSet: 2},
+		{Userid: "bob", Result: Orphan, AssignmentSet: 1},
+	}}
+	result, _, _, _, _ := psd.CheckTracings(NamedBodyMap{})
+	if result != PsdAnchorAgree {
+		t.Errorf("default: expected same-user agreement, got %d", result)
+	}
+	options := TracingCheckOptions{SkipSameUser: true}
+	result, body, _, _, _ := psd.CheckTracingsWithOptions(NamedBodyMap{},
+		options)
+	if result != PsdOrphanAnchor || body != 100 {
+		t.Errorf("skip same user: expected orphan and anchor 100, got %d "+
+			"(body %d)", result, body)
+	}
+
+	psd.Tracings = psd.Tracings[:2]
+	if result, _, _, _, _ = psd.CheckTracingsWithOptions(NamedBodyMap{},
+		options); result != PsdNot2Tracings {
+		t.Errorf("skip same user: expected too few tracings, got %d", result)
+	}
+}
+
+func TestParseMetadataDate(t *testing.T) {
+	expected := time.Date(2012, time.March, 5, 14, 30, 0, 0, time.UTC)
+	accepted := []string{
+		"05-March-2012 14:30",
+		"2012-03-05T14:30:00Z",
+		"05-Mar-2012 14:30",
+		"2012-03-05 14:30:00",
+		"2012-03-05 14:30",
+		"Mon Mar  5 14:30:00 2012",
+		"  05-March-2012 14:30\n",
+	}
+	for _, date := range accepted {
+		parsed, err := ParseMetadataDate(date)
+		if err != nil {
+			t.Errorf("%q: %s", date, err)
+		} else if !parsed.Equal(expected) {
+			t.Errorf("%q: expected %s, got %s", date, expected, parsed)
+		}
+	}
+	offset, err := ParseMetadataDate("2012-03-05T09:30:00-05:00")
+	if err != nil || !offset.Equal(expected) {
+		t.Errorf("RFC3339 with offset: expected %s, got %s (%v)", expected,
+			offset, err)
+	}
+	for _, date := range []string{"", "yesterday", "2012/03/05",
+		"32-March-2012 14:30"} {
+		if _, err := ParseMetadataDate(date); err == nil {
+			t.Errorf("%q: expected error", date)
+		}
+	}
+}
+
+func TestNormalizeMetadataDate(t *testing.T) {
+	metadata := map[string]interface{}{"date": "05-March-2012 14:30"}
+	if err := NormalizeMetadataDate(metadata); err != nil {
+		t.Fatal(err)
+	}
+	if metadata["date"] != "2012-03-05T14:30:00Z" ||
+		metadata["original date"] != "05-March-2012 14:30" {
+		t.Errorf("unexpected normalized metadata: %v", metadata)
+	}
+
+	// Normalizing again keeps the first original date.
+	if err := NormalizeMetadataDate(metadata); err != nil {
+		t.Fatal(err)
+	}
+	if metadata["original date"] != "05-March-2012 14:30" {
+		t.Errorf("original date lost: %v", metadata)
+	}
+
+	undated := map[string]interface{}{"description": "none"}
+	if err := NormalizeMetadataDate(undated); err != nil || len(undated) != 1 {
+		t.Errorf("expected undated metadata unchanged, got %v (%v)", undated,
+			err)
+	}
+	for _, bad := range []interface{}{42, "someday"} {
+		if err := NormalizeMetadataDate(
+			map[string]interface{}{"date": bad}); err == nil {
+			t.Errorf("%v: expected error", bad)
+		}
+	}
+
+	updated := UpdateMetadata(map[string]interface{}{
+		"date": "05-Mar-2012 14:30"}, "rewritten")
+	if updated["source date"] != "2012-03-05T14:30:00Z" {
+		t.Errorf("expected RFC3339 source date, got %v", updated)
+	}
+	date := updated["date"].(string)
+	if _, err := time.Parse(RavelerDateFormat, date); err != nil {
+		t.Errorf("expected Raveler date for new metadata: %s", err)
+	}
+}
+
+func TestConfidenceMarshalJSON(t *testing.T) {
+	tests := []struct {
+		decimals   int
+		confidence Confidence
+		expected   string
+	}{
+		{6, 0.9, "0.9"},
+		{6, 0.123456, "0.123456"},
+		{6, 0.1234567, "0.123457"},
+		{6, 1, "1"},
+		{6, 0, "0"},
+		{6, -0.5, "-0.5"},
+		{6, 1e-9, "1e-09"},
+		{2, 0.123456, "0.12"},
+		{2, 0.004, "0.004"},
+		{0, 0.9, "1"},
+	}
+	saved := ConfidenceDecimals
+	defer func() { ConfidenceDecimals = saved }()
+	for _, test := range tests {
+		ConfidenceDecimals = test.decimals
+		text, err := json.Marshal(test.confidence)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(text) != test.expected {
+			t.Errorf("%v with %d decimals: expected %s, got %s",
+				float64(test.confidence), test.decimals, test.expected, text)
+		}
+	}
+}
+
+func TestConfidenceRoundTrip(t *testing.T) {
+	synapses := &JsonSynapses{
+		Metadata: map[string]interface{}{"description": "synapse set"},
+		Data: []JsonSynapse{{
+			Tbar: JsonTbar{Location: Point3d{10, 10, 1}, Body: 1,
+				Confidence: 0.9},
+			Psds: []JsonPsd{
+				{Location: Point3d{12, 10, 1}, Body: 2,
+					Confidence: 0.123456},
+				{Location: Point3d{8, 10, 1}, Body: 3},
+				{Location: Point3d{10, 12, 1}, Body: 4,
+					Confidence: 1e-7},
+			},
+		}},
+	}
+	var first, second bytes.Buffer
+	if err := synapses.WriteJson(&first); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadSynapsesJsonFrom(bytes.NewReader(first.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = read.WriteJson(&second); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Errorf("confidences changed across write cycles:\n%s\n%s",
+			first.String(), second.String())
+	}
+	text := first.String()
+	for _, expected := range []string{"\"confidence\": 0.9\n",
+		"\"confidence\": 0.123456\n", "\"confidence\": 1e-07\n"} {
+		if !strings.Contains(text, expected) {
+			t.Errorf("expected %s in:\n%s", expected, text)
+		}
+	}
+	if n := strings.Count(text, `"confidence"`); n != 3 {
+		t.Errorf("expected zero confidence omitted, got %d confidences:\n%s",
+			n, text)
+	}
+	psds := read.Data[0].Psds
+	if read.Data[0].Tbar.Confidence != 0.9 || psds[0].Confidence != 0.123456 ||
+		psds[1].Confidence != 0 || psds[2].Confidence != 1e-7 {
+		t.Errorf("unexpected confidences read: %+v", read.Data[0])
+	}
+}
+
+func TestValidate(t *testing.T) {
+	type found struct {
+		kind    string
+		synapse int
+		psd     int
+	}
+	tbar := func(uid string, x VoxelCoord, c Confidence) JsonTbar {
+		return JsonTbar{Location: Point3d{x, 10, 1}, Uid: uid, Confidence: c}
+	}
+	psd := func(uid string, x VoxelCoord, c Confidence) JsonPsd {
+		return JsonPsd{Location: Point3d{x, 12, 1}, Uid: uid, Confidence: c}
+	}
+	valid := []JsonSynapse{
+		{Tbar: tbar("t1", 10, 0.9), Psds: []JsonPsd{
+			psd(PsdUid("t1", Point3d{12, 12, 1}), 12, 1),
+			psd(PsdUid("t1", Point3d{8, 12, 1}), 8, 0)}},
+		{Tbar: tbar("", 40, 1), Psds: []JsonPsd{
+			psd("", 42, 0.5), psd("", 38, 0.5)}},
+	}
+	tests := []struct {
+		name     string
+		data     []JsonSynapse
+		expected []found
+	}{
+		{"valid", valid, nil},
+		{"duplicate T-bar uid", []JsonSynapse{
+			{Tbar: tbar("t1", 10, 0)},
+			{Tbar: tbar("t1", 40, 0)}},
+			[]found{{"duplicate T-bar uid", 1, -1}}},
+		{"duplicate PSD uid", []JsonSynapse{
+			{Tbar: tbar("t1", 10, 0), Psds: []JsonPsd{
+				psd("t1-psyn-1", 12, 0), psd("t1-psyn-1", 8, 0)}}},
+			[]found{{"duplicate PSD uid", 0, 1}}},
+		{"PSD uid mismatch", []JsonSynapse{
+			{Tbar: tbar("t1", 10, 0), Psds: []JsonPsd{
+				psd("t2-psyn-1", 12, 0)}}},
+			[]found{{"PSD uid mismatch", 0, 0}}},
+		{"duplicate PSD location", []JsonSynapse{
+			{Tbar: tbar("", 10, 0), Psds: []JsonPsd{
+				psd("", 12, 0), psd("", 8, 0), psd("", 12, 0)}}},
+			[]found{{"duplicate PSD location", 0, 2}}},
+		{"T-bar confidence out of range", []JsonSynapse{
+			{Tbar: tbar("", 10, 1.5)},
+			{Tbar: tbar("", 40, -0.1)}},
+			[]found{{"T-bar confidence out of range", 0, -1},
+				{"T-bar confidence out of range", 1, -1}}},
+		{"PSD confidence out of range", []JsonSynapse{
+			{Tbar: tbar("", 10, 0), Psds: []JsonPsd{
+				psd("", 12, 0.5), psd("", 8, -0.5)}}},
+			[]found{{"PSD confidence out of range", 0, 1}}},
+		{"all at once", []JsonSynapse{
+			valid[0],
+			{Tbar: tbar("t1", 40, 2), Psds: []JsonPsd{
+				psd(PsdUid("t1", Point3d{12, 12, 1}), 42, 0),
+				psd("t3-psyn-1", 42, -1)}}},
+			[]found{{"duplicate T-bar uid", 1, -1},
+				{"T-bar confidence out of range", 1, -1},
+				{"duplicate PSD uid", 1, 0},
+				{"PSD uid mismatch", 1, 1},
+				{"duplicate PSD location", 1, 1},
+				{"PSD confidence out of range", 1, 1}}},
+	}
+	for _, test := range tests {
+		synapses := &JsonSynapses{Data: test.data}
+		var got []found
+		for _, e := range synapses.Validate() {
+			got = append(got, found{e.Kind, e.SynapseIdx, e.PsdIdx})
+			if e.Message == "" || !strings.HasPrefix(e.Error(), e.Kind+": ") {
+				t.Errorf("%s: bad error text %q", test.name, e.Error())
+			}
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: expected errors %v, got %v", test.name,
+				test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,385 @@
+// Copyright 2012 HHMI.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//     * Neither the name of HHMI nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+// Author: katzw@janelia.hhmi.org (Bill Katz)
+//  Written as part of the FlyEM Project at Janelia Farm Research Center.
+
+package emdata
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// SuperpixelBodyCacheFilename is the binary superpixel->body map cache
+// written into a stack directory.
+const SuperpixelBodyCacheFilename = "superpixel_to_body.bin"
+
+// The binary cache is a 7-byte magic string and a version byte followed
+// by little-endian (slice, label, body) records sorted by (slice, label).
+// Version 1 records hold uint32 bodies; version 2 records hold int64
+// bodies so negative and large body ids survive.
+var spBodyCacheMagic = []byte("SPBODY0")
+
+const (
+	spBodyCacheVersion1 = '1'
+	spBodyCacheVersion2 = '2'
+	spBodyHeaderSize    = 8
+)
+
+// spBodyRecordSize returns the record size of a cache version, or 0 if
+// the version is unknown.
+func spBodyRecordSize(version byte) int {
+	switch version {
+	case spBodyCacheVersion1:
+		return 12
+	case spBodyCacheVersion2:
+		return 16
+	}
+	return 0
+}
+
+// readSpBodyHeader checks the cache header and returns the record size.
+func readSpBodyHeader(header []byte) (recordSize int, err error) {
+	if len(header) != spBodyHeaderSize ||
+		!bytes.Equal(header[:len(spBodyCacheMagic)], spBodyCacheMagic) {
+		return 0, fmt.Errorf("not a superpixel->body cache")
+	}
+	version := header[len(spBodyCacheMagic)]
+	if recordSize = spBodyRecordSize(version); recordSize == 0 {
+		return 0, fmt.Errorf("unknown superpixel->body cache version %q",
+			version)
+	}
+	return
+}
+
+// decodeSpBodyRecord returns the superpixel and body of a cache record.
+func decodeSpBodyRecord(record []byte) (Superpixel, BodyId) {
+	superpixel := Superpixel{
+		Slice: binary.LittleEndian.Uint32(record[0:4]),
+		Label: binary.LittleEndian.Uint32(record[4:8]),
+	}
+	if len(record) == spBodyRecordSize(spBodyCacheVersion1) {
+		return superpixel, BodyId(binary.LittleEndian.Uint32(record[8:12]))
+	}
+	return superpixel, BodyId(int64(binary.LittleEndian.Uint64(record[8:16])))
+}
+
+// SuperpixelBodyStore is anything that can look up the body of a
+// superpixel, returning false if the superpixel is not mapped.
+type SuperpixelBodyStore interface {
+	Get(Superpixel) (BodyId, bool)
+}
+
+// Get returns the body for a superpixel and whether it was mapped.
+func (spToBodyMap SuperpixelToBodyMap) Get(s Superpixel) (BodyId, bool) {
+	bodyId, found := spToBodyMap[s]
+	return bodyId, found
+}
+
+// WriteBinary writes a superpixel->body map in the sorted binary
+// format read by ReadSuperpixelToBodyMapBinary and SuperpixelBodyFile.
+func (spToBodyMap SuperpixelToBodyMap) WriteBinary(writer io.Writer) error {
+	superpixels := make(Superpixels, 0, len(spToBodyMap))
+	for superpixel, _ := range spToBodyMap {
+		superpixels = append(superpixels, superpixel)
+	}
+	sort.Sort(superpixels)
+
+	bufWriter := bufio.NewWriter(writer)
+	header := append(append([]byte{}, spBodyCacheMagic...),
+		spBodyCacheVersion2)
+	if _, err := bufWriter.Write(header); err != nil {
+		return err
+	}
+	record := make([]byte, spBodyRecordSize(spBodyCacheVersion2))
+	for _, superpixel := range superpixels {
+		binary.LittleEndian.PutUint32(record[0:4], superpixel.Slice)
+		binary.LittleEndian.PutUint32(record[4:8], superpixel.Label)
+		binary.LittleEndian.PutUint64(record[8:16],
+			uint64(spToBodyMap[superpixel]))
+		if _, err := bufWriter.Write(record); err != nil {
+			return err
+		}
+	}
+	return bufWriter.Flush()
+}
+
+// WriteBinaryFile writes a superpixel->body map into a binary file.
+func (spToBodyMap SuperpixelToBodyMap) WriteBinaryFile(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err = spToBodyMap.WriteBinary(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// ReadSuperpixelToBodyMapBinary reads a superpixel->body map written by
+// WriteBinary.
+func ReadSuperpixelToBodyMapBinary(reader io.Reader) (
+	spToBodyMap SuperpixelToBodyMap, err error) {
+
+	bufReader := bufio.NewReader(reader)
+	header := make([]byte, spBodyHeaderSize)
+	if _, err = io.ReadFull(bufReader, header); err != nil {
+		return nil, fmt.Errorf("not a superpixel->body cache: %s", err)
+	}
+	recordSize, err := readSpBodyHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	spToBodyMap = make(SuperpixelToBodyMap)
+	record := make([]byte, recordSize)
+	for {
+		_, err = io.ReadFull(bufReader, record)
+		if err == io.EOF {
+			return spToBodyMap, nil
+		} else if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("truncated superpixel->body cache")
+		} else if err != nil {
+			return nil, err
+		}
+		superpixel, bodyId := decodeSpBodyRecord(record)
+		spToBodyMap[superpixel] = bodyId
+	}
+}
+
+// ReadSuperpixelToBodyMapBinaryFile reads a binary superpixel->body
+// map file.
+func ReadSuperpixelToBodyMapBinaryFile(filename string) (
+	SuperpixelToBodyMap, error) {
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	spToBodyMap, err := ReadSuperpixelToBodyMapBinary(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	return spToBodyMap, nil
+}
+
+// SuperpixelBodyFile is a read-only superpixel->body store that binary
+// searches a binary cache file on disk rather than loading it into
+// memory.  Each lookup costs a few reads, but opening is immediate
+// regardless of map size.
+type SuperpixelBodyFile struct {
+	file       *os.File
+	recordSize int
+	numRecords int
+}
+
+// OpenSuperpixelBodyFile opens a binary superpixel->body cache file.
+func OpenSuperpixelBodyFile(filename string) (*SuperpixelBodyFile, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	header := make([]byte, spBodyHeaderSize)
+	_, err = file.ReadAt(header, 0)
+	recordSize, headerErr := readSpBodyHeader(header)
+	if err != nil || headerErr != nil {
+		file.Close()
+		return nil, fmt.Errorf("Not a superpixel->body cache file: %s",
+			filename)
+	}
+	dataSize := info.Size() - spBodyHeaderSize
+	if dataSize%int64(recordSize) != 0 {
+		file.Close()
+		return nil, fmt.Errorf("Truncated superpixel->body cache file: %s",
+			filename)
+	}
+	return &SuperpixelBodyFile{file, recordSize,
+		int(dataSize / int64(recordSize))}, nil
+}
+
+// Len returns the number of superpixels in the cache file.
+func (store *SuperpixelBodyFile) Len() int {
+	return store.numRecords
+}
+
+// Get returns the body for a superpixel and whether it was mapped.
+// Read errors are treated as an unmapped superpixel.
+func (store *SuperpixelBodyFile) Get(s Superpixel) (BodyId, bool) {
+	record := make([]byte, store.recordSize)
+	var readErr error
+	readRecord := func(i int) (Superpixel, bool) {
+		offset := int64(spBodyHeaderSize) + int64(i)*int64(store.recordSize)
+		if _, err := store.file.ReadAt(record, offset); err != nil {
+			readErr = err
+			return Superpixel{}, false
+		}
+		superpixel, _ := decodeSpBodyRecord(record)
+		return superpixel, true
+	}
+	i := sort.Search(store.numRecords, func(i int) bool {
+		superpixel, ok := readRecord(i)
+		if !ok {
+			return true
+		}
+		return superpixel.Slice > s.Slice ||
+			(superpixel.Slice == s.Slice && superpixel.Label >= s.Label)
+	})
+	if readErr != nil || i >= store.numRecords {
+		return 0, false
+	}
+	superpixel, ok := readRecord(i)
+	if !ok || superpixel != s {
+		return 0, false
+	}
+	_, bodyId := decodeSpBodyRecord(record)
+	return bodyId, true
+}
+
+// Close closes the underlying cache file.
+func (store *SuperpixelBodyFile) Close() error {
+	return store.file.Close()
+}
+
+// StackSuperpixelBodyCacheFilename returns the file name of the binary
+// superpixel->body map cache for a given stack.
+func (stack *Stack) StackSuperpixelBodyCacheFilename() string {
+	return filepath.Join(stack.String(), SuperpixelBodyCacheFilename)
+}
+
+// WriteBinaryCache writes the stack's superpixel->body map into the
+// stack's binary cache file for use in low-memory mode and for faster
+// loading by ReadTxtMaps.
+func (stack *Stack) WriteBinaryCache() error {
+	return stack.WriteCachedMap(stack.String())
+}
+
+// WriteCachedMap writes the stack's superpixel->body map into a binary
+// cache file in the given directory, which should be used for only
+// this stack.
+func (stack *Stack) WriteCachedMap(cacheDir string) error {
+	spToBodyMap, err := stack.loadedMap()
+	if err != nil {
+		return err
+	}
+	filename := filepath.Join(cacheDir, SuperpixelBodyCacheFilename)
+	return spToBodyMap.WriteBinaryFile(filename)
+}
+
+// ReadCachedMapIfFresh loads the stack's superpixel->body map from the
+// binary cache file in the given directory if the cache is newer than
+// the stack's map .txt files.  It returns false, leaving the stack
+// unchanged, if the cache is stale, missing or unreadable.
+func (stack *Stack) ReadCachedMapIfFresh(cacheDir string) bool {
+	stack.mapLock.Lock()
+	defer stack.mapLock.Unlock()
+	return stack.readCachedMap(cacheDir)
+}
+
+// readCachedMap loads a fresh binary cache from the given directory.
+// The caller must hold the map lock.
+func (stack *Stack) readCachedMap(cacheDir string) bool {
+	if !stack.cachedMapFresh(cacheDir) {
+		return false
+	}
+	filename := filepath.Join(cacheDir, SuperpixelBodyCacheFilename)
+	log.Println("Loading binary superpixel->body map for stack:\n", filename)
+	spToBodyMap, err := ReadSuperpixelToBodyMapBinaryFile(filename)
+	if err != nil {
+		log.Println("Ignoring binary map cache:", err)
+		return false
+	}
+	stack.spToBodyMap = spToBodyMap
+	stack.mapSources = TxtMapSources{stack.String(), stack.String()}
+	stack.mapLoaded = true
+	return true
+}
+
+// lowMemoryStore opens the stack's binary cache if low-memory mode was
+// requested, returning nil if the in-memory map should be used instead.
+func (stack *Stack) lowMemoryStore() SuperpixelBodyStore {
+	if !stack.LowMemory {
+		return nil
+	}
+	stack.mapLock.RLock()
+	loaded, store := stack.mapLoaded, stack.spBodyFile
+	stack.mapLock.RUnlock()
+	if loaded {
+		return nil
+	}
+	if store != nil {
+		return store
+	}
+	stack.mapLock.Lock()
+	defer stack.mapLock.Unlock()
+	if stack.mapLoaded {
+		return nil
+	}
+	if stack.spBodyFile == nil {
+		store, err := OpenSuperpixelBodyFile(
+			stack.StackSuperpixelBodyCacheFilename())
+		if err != nil {
+			return nil
+		}
+		stack.spBodyFile = store
+	}
+	return stack.spBodyFile
+}
+
+// cachedMapFresh returns true if the given directory has a binary map
+// cache newer than both of the stack's own map .txt files, plain or
+// gzipped.
+func (stack *Stack) cachedMapFresh(cacheDir string) bool {
+	info, err := os.Stat(filepath.Join(cacheDir, SuperpixelBodyCacheFilename))
+	if err != nil {
+		return false
+	}
+	for _, mapFilename := range []string{SuperpixelToSegmentFilename,
+		SegmentToBodyFilename} {
+
+		txtInfo, err := os.Stat(
+			textFilename(filepath.Join(stack.String(), mapFilename)))
+		if err != nil || !info.ModTime().After(txtInfo.ModTime()) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,438 @@
+// Copyright 2012 HHMI.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//     * Neither the name of HHMI nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+// Author: katzw@janelia.hhmi.org (Bill Katz)
+//  Written as part of the FlyEM Project at Janelia Farm Research Center.
+
+package emdata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testBinaryMap has gaps between labels and slices, a body 0 mapping,
+// and records at both ends of the sort order.
+func testBinaryMap() SuperpixelToBodyMap {
+	return SuperpixelToBodyMap{
+		{1, 1}:   10,
+		{1, 3}:   11,
+		{1, 7}:   12,
+		{2, 1}:   20,
+		{2, 2}:   0,
+		{5, 100}: 50,
+	}
+}
+
+// openTestBodyFile writes a map as a binary cache and opens it.
+func openTestBodyFile(t testing.TB, spToBodyMap SuperpixelToBodyMap) (
+	store *SuperpixelBodyFile) {
+
+	filename := filepath.Join(t.TempDir(), SuperpixelBodyCacheFilename)
+	if err := spToBodyMap.WriteBinaryFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	store, err := OpenSuperpixelBodyFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return
+}
+
+func TestSuperpixelBodyFile(t *testing.T) {
+	spToBodyMap := testBinaryMap()
+	store := openTestBodyFile(t, spToBodyMap)
+	if store.Len() != len(spToBodyMap) {
+		t.Errorf("expected %d records, got %d", len(spToBodyMap), store.Len())
+	}
+	for superpixel, _ := range spToBodyMap {
+		expectedBody, expectedFound := spToBodyMap.Get(superpixel)
+		bodyId, found := store.Get(superpixel)
+		if bodyId != expectedBody || found != expectedFound {
+			t.Errorf("superpixel %v: expected (%d, %t), got (%d, %t)",
+				superpixel, expectedBody, expectedFound, bodyId, found)
+		}
+	}
+	absent := []Superpixel{
+		{0, 0},   // Before first record
+		{1, 0},   // Before first label of first slice
+		{1, 2},   // Between labels
+		{1, 8},   // After last label of a slice
+		{3, 1},   // Missing slice between records
+		{5, 99},  // Same slice as last record, different label
+		{5, 101}, // After last record
+		{9, 1},   // After last slice
+	}
+	for _, superpixel := range absent {
+		if bodyId, found := store.Get(superpixel); found || bodyId != 0 {
+			t.Errorf("absent superpixel %v: got (%d, %t)", superpixel,
+				bodyId, found)
+		}
+		if _, found := spToBodyMap.Get(superpixel); found {
+			t.Errorf("absent superpixel %v found in map", superpixel)
+		}
+	}
+
+	empty := openTestBodyFile(t, SuperpixelToBodyMap{})
+	if empty.Len() != 0 {
+		t.Errorf("expected empty cache, got %d records", empty.Len())
+	}
+	if _, found := empty.Get(Superpixel{1, 1}); found {
+		t.Errorf("found superpixel in empty cache")
+	}
+}
+
+func TestOpenSuperpixelBodyFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, SuperpixelBodyCacheFilename)
+	if err := testBinaryMap().WriteBinaryFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"no header", []byte{}},
+		{"bad magic", append([]byte("NOTMAP0"), data[7:]...)},
+		{"truncated", data[:len(data)-3]},
+	}
+	for _, test := range tests {
+		filename := filepath.Join(dir, test.name+".bin")
+		if err := os.WriteFile(filename, test.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if store, err := OpenSuperpixelBodyFile(filename); err == nil {
+			store.Close()
+			t.Errorf("%s: expected error opening cache", test.name)
+		}
+	}
+}
+
+func TestStackLowMemory(t *testing.T) {
+	spToBodyMap := testBinaryMap()
+	stack := writeTestStack(t, spToBodyMap)
+	stack.LowMemory = true
+	if err := spToBodyMap.WriteBinaryFile(
+		stack.StackSuperpixelBodyCacheFilename()); err != nil {
+		t.Fatal(err)
+	}
+	for superpixel, expected := range spToBodyMap {
+		if bodyId := stack.SuperpixelToBody(superpixel); bodyId != expected {
+			t.Errorf("superpixel %v: expected body %d, got %d", superpixel,
+				expected, bodyId)
+		}
+	}
+	if _, found := stack.SuperpixelToBodyChecked(Superpixel{1, 2}); found {
+		t.Errorf("absent superpixel found in low-memory stack")
+	}
+	if stack.mapLoaded || stack.spBodyFile == nil {
+		t.Errorf("expected lookups through the cache file without a loaded map")
+	}
+
+	// Once the full map is loaded it is used instead of the cache file.
+	if err := stack.ReadTxtMaps(); err != nil {
+		t.Fatal(err)
+	}
+	if store := stack.lowMemoryStore(); store != nil {
+		t.Errorf("expected in-memory map after ReadTxtMaps")
+	}
+	stack.ClearTxtMaps()
+	if stack.spBodyFile != nil {
+		t.Errorf("ClearTxtMaps did not close the cache file")
+	}
+
+	// Without a cache file, low-memory lookups fall back to the map.
+	uncached := writeTestStack(t, spToBodyMap)
+	uncached.LowMemory = true
+	if bodyId := uncached.SuperpixelToBody(Superpixel{1, 7}); bodyId != 12 {
+		t.Errorf("expected body 12 without cache file, got %d", bodyId)
+	}
+	if !uncached.mapLoaded {
+		t.Errorf("expected map to be loaded without cache file")
+	}
+}
+
+func TestCachedMapFreshness(t *testing.T) {
+	spToBodyMap := testBinaryMap()
+	stack := writeTestStack(t, spToBodyMap)
+	cacheDir := t.TempDir()
+	if err := stack.WriteCachedMap(cacheDir); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour)
+	for _, name := range []string{SuperpixelToSegmentFilename,
+		SegmentToBodyFilename} {
+		filename := filepath.Join(stack.Directory, name)
+		if err := os.Chtimes(filename, past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A cache newer than the .txt maps is loaded.
+	fresh := &Stack{Directory: stack.Directory}
+	if !fresh.ReadCachedMapIfFresh(cacheDir) {
+		t.Fatal("fresh cache was not read")
+	}
+	if !reflect.DeepEqual(fresh.GetSuperpixelToBodyMap(), spToBodyMap) {
+		t.Errorf("expected cached map %v, got %v", spToBodyMap,
+			fresh.GetSuperpixelToBodyMap())
+	}
+	expectedSources := TxtMapSources{stack.String(), stack.String()}
+	if sources := fresh.MapSources(); sources != expectedSources {
+		t.Errorf("expected sources %+v, got %+v", expectedSources, sources)
+	}
+
+	// Touching either .txt map after the cache makes it stale.
+	for _, name := range []string{SuperpixelToSegmentFilename,
+		SegmentToBodyFilename} {
+		for _, other := range []string{SuperpixelToSegmentFilename,
+			SegmentToBodyFilename} {
+			filename := filepath.Join(stack.Directory, other)
+			if err := os.Chtimes(filename, past, past); err != nil {
+				t.Fatal(err)
+			}
+		}
+		touched := time.Now().Add(time.Hour)
+		filename := filepath.Join(stack.Directory, name)
+		if err := os.Chtimes(filename, touched, touched); err != nil {
+			t.Fatal(err)
+		}
+		stale := &Stack{Directory: stack.Directory}
+		if stale.ReadCachedMapIfFresh(cacheDir) {
+			t.Errorf("%s touched: stale cache was read", name)
+		}
+		if stale.mapLoaded || stale.spToBodyMap != nil ||
+			stale.MapSources() != (TxtMapSources{}) {
+			t.Errorf("%s touched: stack changed by stale cache", name)
+		}
+	}
+
+	missing := &Stack{Directory: stack.Directory}
+	if missing.ReadCachedMapIfFresh(t.TempDir()) || missing.mapLoaded {
+		t.Error("read a missing cache")
+	}
+}
+
+func BenchmarkSuperpixelBodyFileGet(b *testing.B) {
+	spToBodyMap := make(SuperpixelToBodyMap)
+	for slice := uint32(0); slice < 100; slice++ {
+		for label := uint32(1); label <= 1000; label++ {
+			spToBodyMap[Superpixel{slice, label}] = BodyId(label % 97)
+		}
+	}
+	superpixels := make(Superpixels, 0, len(spToBodyMap))
+	for superpixel, _ := range spToBodyMap {
+		superpixels = append(superpixels, superpixel)
+	}
+	store := openTestBodyFile(b, spToBodyMap)
+	stores := []struct {
+		name  string
+		store SuperpixelBodyStore
+	}{
+		{"file", store},
+		{"map", spToBodyMap},
+	}
+	for _, s := range stores {
+		b.Run(s.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				s.store.Get(superpixels[i%len(superpixels)])
+			}
+		})
+	}
+}
+
+// writeTxtMapFiles writes map .txt files with the given contents.
+func writeTxtMapFiles(t testing.TB, dir, spToSegment, segmentToBody string) {
+	files := map[string]string{
+		SuperpixelToSegmentFilename: spToSegment,
+		SegmentToBodyFilename:       segmentToBody,
+	}
+	for name, text := range files {
+		filename := filepath.Join(dir, name)
+		if err := os.WriteFile(filename, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBinaryMapRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	writeTxtMapFiles(t, dir, "1 1 1\n1 2 2\n2 1 3\n2 7 4\n",
+		"1 -5\n2 5000000000\n3 0\n4 4294967295\n")
+	spToBodyMap, err := ReadTxtMaps(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spToBodyMap[Superpixel{1, 2}] != 5000000000 {
+		t.Fatalf("unexpected map from .txt files: %v", spToBodyMap)
+	}
+	var buf bytes.Buffer
+	if err = spToBodyMap.WriteBinary(&buf); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadSuperpixelToBodyMapBinary(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, spToBodyMap) {
+		t.Errorf("expected %v after round trip, got %v", spToBodyMap, read)
+	}
+
+	filename := filepath.Join(dir, SuperpixelBodyCacheFilename)
+	if err = spToBodyMap.WriteBinaryFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	read, err = ReadSuperpixelToBodyMapBinaryFile(filename)
+	if err != nil || !reflect.DeepEqual(read, spToBodyMap) {
+		t.Errorf("file round trip: expected %v, got %v (%v)", spToBodyMap,
+			read, err)
+	}
+}
+
+func TestReadBinaryMapVersions(t *testing.T) {
+	// Version 1 caches hold uint32 bodies.
+	v1 := []byte("SPBODY01")
+	for _, record := range [][3]uint32{{1, 1, 10}, {2, 3, 4294967295}} {
+		var data [12]byte
+		for i, value := range record {
+			binary.LittleEndian.PutUint32(data[4*i:], value)
+		}
+		v1 = append(v1, data[:]...)
+	}
+	read, err := ReadSuperpixelToBodyMapBinary(bytes.NewReader(v1))
+	expected := SuperpixelToBodyMap{{1, 1}: 10, {2, 3}: 4294967295}
+	if err != nil || !reflect.DeepEqual(read, expected) {
+		t.Errorf("version 1: expected %v, got %v (%v)", expected, read, err)
+	}
+
+	var buf bytes.Buffer
+	if err = testBinaryMap().WriteBinary(&buf); err != nil {
+		t.Fatal(err)
+	}
+	v2 := buf.Bytes()
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", []byte{}},
+		{"short header", v2[:5]},
+		{"bad magic", append([]byte("NOTMAP0"), v2[7:]...)},
+		{"unknown version", append([]byte("SPBODY09"), v2[8:]...)},
+		{"truncated", v2[:len(v2)-3]},
+		{"truncated version 1", v1[:len(v1)-1]},
+	}
+	for _, test := range tests {
+		read, err := ReadSuperpixelToBodyMapBinary(bytes.NewReader(test.data))
+		if err == nil || read != nil {
+			t.Errorf("%s: expected error, got %v", test.name, read)
+		}
+	}
+	read, err = ReadSuperpixelToBodyMapBinary(bytes.NewReader(v2[:8]))
+	if err != nil || len(read) != 0 {
+		t.Errorf("header only: expected empty map, got %v (%v)", read, err)
+	}
+}
+
+func TestStackReadTxtMapsPrefersFreshBinary(t *testing.T) {
+	spToBodyMap := testBinaryMap()
+	cached := SuperpixelToBodyMap{{1, 1}: 99}
+	future := time.Now().Add(time.Hour)
+	tests := []struct {
+		name     string
+		modTime  time.Time
+		corrupt  bool
+		expected SuperpixelToBodyMap
+	}{
+		{"fresh", future, false, cached},
+		{"stale", time.Now().Add(-time.Hour), false, spToBodyMap},
+		{"corrupt", future, true, spToBodyMap},
+	}
+	for _, test := range tests {
+		stack := writeTestStack(t, spToBodyMap)
+		filename := stack.StackSuperpixelBodyCacheFilename()
+		if err := cached.WriteBinaryFile(filename); err != nil {
+			t.Fatal(err)
+		}
+		if test.corrupt {
+			err := os.WriteFile(filename, []byte("NOTMAP0"), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.Chtimes(filename, test.modTime, test.modTime); err != nil {
+			t.Fatal(err)
+		}
+		if err := stack.ReadTxtMaps(); err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if read := stack.GetSuperpixelToBodyMap(); !reflect.DeepEqual(read,
+			test.expected) {
+			t.Errorf("%s: expected map %v, got %v", test.name, test.expected,
+				read)
+		}
+	}
+}
+
+func BenchmarkReadBinaryVsTxt(b *testing.B) {
+	dir := writeBenchmarkTxtMaps(b, 200000)
+	spToBodyMap, err := ReadTxtMaps(dir)
+	if err != nil {
+		b.Fatal(err)
+	}
+	filename := filepath.Join(dir, SuperpixelBodyCacheFilename)
+	if err = spToBodyMap.WriteBinaryFile(filename); err != nil {
+		b.Fatal(err)
+	}
+	b.Run("txt", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := ReadTxtMaps(dir); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("binary", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, err := ReadSuperpixelToBodyMapBinaryFile(filename)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
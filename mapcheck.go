@@ -0,0 +1,157 @@
+// Copyright 2012 HHMI.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//     * Neither the name of HHMI nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+// Author: katzw@janelia.hhmi.org (Bill Katz)
+//  Written as part of the FlyEM Project at Janelia Farm Research Center.
+
+package emdata
+
+import (
+	"sort"
+)
+
+// SuperpixelSegment is a superpixel->segment map entry.
+type SuperpixelSegment struct {
+	Superpixel Superpixel `json:"superpixel"`
+	Segment    BodyId     `json:"segment"`
+}
+
+// SegmentConflict lists the different segments given for a superpixel
+// that appears on more than one superpixel->segment map line.
+type SegmentConflict struct {
+	Superpixel Superpixel `json:"superpixel"`
+	Segments   []BodyId   `json:"segments"`
+}
+
+// MapValidationReport describes inconsistencies between a stack's
+// superpixel->segment and segment->body maps.
+type MapValidationReport struct {
+	Stack          string `json:"stack"`
+	Superpixels    int    `json:"superpixels"`
+	Segments       int    `json:"segments"`
+	MalformedLines int    `json:"malformed lines"`
+
+	// Non-zero superpixels whose segment is not in the segment->body map
+	UnknownSegments []SuperpixelSegment `json:"unknown segments,omitempty"`
+
+	// Segments in the segment->body map without any superpixels, other
+	// than segment 0, which WriteTxtMaps always writes
+	UnusedSegments []BodyId `json:"unused segments,omitempty"`
+
+	// Superpixels listed more than once with different segments.  As in
+	// ReadTxtMaps, the last segment listed is used for the other checks.
+	Conflicts []SegmentConflict `json:"conflicts,omitempty"`
+
+	// # of non-zero superpixels whose segment maps to body 0
+	ZeroBodies int `json:"body 0 superpixels"`
+}
+
+// Consistent returns true if the report found no problems.
+func (report MapValidationReport) Consistent() bool {
+	return report.MalformedLines == 0 && len(report.UnknownSegments) == 0 &&
+		len(report.UnusedSegments) == 0 && len(report.Conflicts) == 0 &&
+		report.ZeroBodies == 0
+}
+
+// ValidateTxtMaps reads a stack's superpixel->segment and segment->body
+// map .txt files and reports how they disagree.  Malformed lines are
+// handled according to DefaultStrictness.  Unlike ReadTxtMaps, unknown
+// segments are reported rather than treated as anomalies.
+func ValidateTxtMaps(stackPath string) (report MapValidationReport, err error) {
+	report.Stack = stackPath
+	segmentToBodyMap, warnings, err := readSegmentToBodyMap(stackPath, nil)
+	if err != nil {
+		return
+	}
+	report.Segments = len(segmentToBodyMap)
+
+	spToSegmentMap := make(map[Superpixel]BodyId)
+	conflicts := make(map[Superpixel][]BodyId)
+	usedSegments := make(map[BodyId]bool)
+	spWarnings, err := scanSuperpixelToSegmentMap(stackPath, nil,
+		func(superpixel Superpixel, segment BodyId) error {
+			usedSegments[segment] = true
+			prevSegment, found := spToSegmentMap[superpixel]
+			spToSegmentMap[superpixel] = segment
+			if !found {
+				return nil
+			}
+			segments, conflicted := conflicts[superpixel]
+			if !conflicted {
+				segments = []BodyId{prevSegment}
+			}
+			for _, s := range segments {
+				if s == segment {
+					return nil
+				}
+			}
+			conflicts[superpixel] = append(segments, segment)
+			return nil
+		})
+	if err != nil {
+		return
+	}
+	warnings.Merge(spWarnings)
+	report.MalformedLines = warnings.Count("malformed map line")
+	report.Superpixels = len(spToSegmentMap)
+
+	superpixels := make(Superpixels, 0, len(spToSegmentMap))
+	for superpixel, _ := range spToSegmentMap {
+		superpixels = append(superpixels, superpixel)
+	}
+	sort.Sort(superpixels)
+	for _, superpixel := range superpixels {
+		if segments, found := conflicts[superpixel]; found {
+			report.Conflicts = append(report.Conflicts,
+				SegmentConflict{superpixel, segments})
+		}
+		if superpixel.Label == 0 {
+			continue
+		}
+		segment := spToSegmentMap[superpixel]
+		bodyId, found := segmentToBodyMap[segment]
+		if !found {
+			report.UnknownSegments = append(report.UnknownSegments,
+				SuperpixelSegment{superpixel, segment})
+		} else if bodyId == 0 {
+			report.ZeroBodies++
+		}
+	}
+
+	unused := make(BodyIdList, 0)
+	for segment, _ := range segmentToBodyMap {
+		if segment != 0 && !usedSegments[segment] {
+			unused = append(unused, segment)
+		}
+	}
+	sort.Sort(unused)
+	if len(unused) > 0 {
+		report.UnusedSegments = unused
+	}
+	return
+}
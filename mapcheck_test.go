@@ -0,0 +1,83 @@
+// Copyright 2012 HHMI.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//     * Neither the name of HHMI nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+// Author: katzw@janelia.hhmi.org (Bill Katz)
+//  Written as part of the FlyEM Project at Janelia Farm Research Center.
+
+package emdata
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateTxtMaps(t *testing.T) {
+	// Written maps are consistent even without body 0 superpixels.
+	dir := t.TempDir()
+	spToBodyMap := SuperpixelToBodyMap{{1, 1}: 10, {1, 2}: 20, {2, 1}: 10}
+	if err := spToBodyMap.WriteTxtMaps(dir); err != nil {
+		t.Fatal(err)
+	}
+	report, err := ValidateTxtMaps(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Consistent() {
+		t.Errorf("written maps: expected consistent report, got %+v", report)
+	}
+
+	dir = t.TempDir()
+	files := map[string]string{
+		SuperpixelToSegmentFilename: "1 1 5\n1 2 6\n1 2 7\n1 3 9\n1 4 8\n",
+		SegmentToBodyFilename:       "0 0\n5 50\n6 60\n7 70\n8 0\n11 110\n",
+	}
+	for name, text := range files {
+		err = os.WriteFile(filepath.Join(dir, name), []byte(text), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	report, err = ValidateTxtMaps(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.UnknownSegments) != 1 ||
+		report.UnknownSegments[0].Segment != 9 {
+		t.Errorf("expected unknown segment 9, got %v", report.UnknownSegments)
+	}
+	if len(report.UnusedSegments) != 1 || report.UnusedSegments[0] != 11 {
+		t.Errorf("expected unused segment 11, got %v", report.UnusedSegments)
+	}
+	if len(report.Conflicts) != 1 || len(report.Conflicts[0].Segments) != 2 {
+		t.Errorf("expected one conflict, got %v", report.Conflicts)
+	}
+	if report.ZeroBodies != 1 {
+		t.Errorf("expected 1 body 0 superpixel, got %d", report.ZeroBodies)
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2012 HHMI.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//     * Neither the name of HHMI nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+// Author: katzw@janelia.hhmi.org (Bill Katz)
+//  Written as part of the FlyEM Project at Janelia Farm Research Center.
+
+package emdata
+
+import (
+	"encoding/csv"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// SuperpixelChange is a superpixel whose body differs between two
+// superpixel->body maps.  OldBody is 0 for a superpixel only in the later
+// map, and NewBody is 0 for a superpixel only in the earlier map.
+type SuperpixelChange struct {
+	Superpixel Superpixel
+	OldBody    BodyId
+	NewBody    BodyId
+}
+
+// BodyChange counts the superpixels a body gained and lost between two
+// superpixel->body maps.
+type BodyChange struct {
+	Gained int
+	Lost   int
+}
+
+// MapDiff describes how a superpixel->body map differs from an earlier
+// one.  Changes are sorted by slice then label.
+type MapDiff struct {
+	OnlyInA     []SuperpixelChange
+	OnlyInB     []SuperpixelChange
+	Reassigned  []SuperpixelChange
+	BodyChanges map[BodyId]BodyChange
+}
+
+// DiffSuperpixelToBodyMaps returns the superpixels only in map a, only
+// in map b, and mapped to different bodies, taking a as the earlier map.
+// A superpixel only in a is lost by its body, one only in b is gained by
+// its body, and a reassigned superpixel is lost by its old body and
+// gained by its new body.
+func DiffSuperpixelToBodyMaps(a, b SuperpixelToBodyMap) (diff MapDiff) {
+	diff.BodyChanges = make(map[BodyId]BodyChange)
+	lose := func(bodyId BodyId) {
+		change := diff.BodyChanges[bodyId]
+		change.Lost++
+		diff.BodyChanges[bodyId] = change
+	}
+	gain := func(bodyId BodyId) {
+		change := diff.BodyChanges[bodyId]
+		change.Gained++
+		diff.BodyChanges[bodyId] = change
+	}
+	for superpixel, oldBody := range a {
+		newBody, found := b[superpixel]
+		if !found {
+			diff.OnlyInA = append(diff.OnlyInA,
+				SuperpixelChange{superpixel, oldBody, 0})
+			lose(oldBody)
+		} else if newBody != oldBody {
+			diff.Reassigned = append(diff.Reassigned,
+				SuperpixelChange{superpixel, oldBody, newBody})
+			lose(oldBody)
+			gain(newBody)
+		}
+	}
+	for superpixel, newBody := range b {
+		if _, found := a[superpixel]; !found {
+			diff.OnlyInB = append(diff.OnlyInB,
+				SuperpixelChange{superpixel, 0, newBody})
+			gain(newBody)
+		}
+	}
+	sort.Sort(superpixelChangeList(diff.OnlyInA))
+	sort.Sort(superpixelChangeList(diff.OnlyInB))
+	sort.Sort(superpixelChangeList(diff.Reassigned))
+	return
+}
+
+// superpixelChangeList implements sort.Interface, ordering changes by
+// superpixel.
+type superpixelChangeList []SuperpixelChange
+
+func (list superpixelChangeList) Len() int {
+	return len(list)
+}
+
+func (list superpixelChangeList) Swap(i, j int) {
+	list[i], list[j] = list[j], list[i]
+}
+
+func (list superpixelChangeList) Less(i, j int) bool {
+	a, b := list[i].Superpixel, list[j].Superpixel
+	if a.Slice != b.Slice {
+		return a.Slice < b.Slice
+	}
+	return a.Label < b.Label
+}
+
+// Changed returns true if the maps differ.
+func (diff MapDiff) Changed() bool {
+	return len(diff.OnlyInA) != 0 || len(diff.OnlyInB) != 0 ||
+		len(diff.Reassigned) != 0
+}
+
+// DiffMaps compares the superpixel->body map of this stack, taken as the
+// earlier map, with that of another stack, loading either if necessary.
+func (stack *Stack) DiffMaps(other *Stack) (MapDiff, error) {
+	spToBodyMap, err := stack.loadedMap()
+	if err != nil {
+		return MapDiff{}, err
+	}
+	otherMap, err := other.loadedMap()
+	if err != nil {
+		return MapDiff{}, err
+	}
+	return DiffSuperpixelToBodyMaps(spToBodyMap, otherMap), nil
+}
+
+// WriteCsv writes one line per changed superpixel: superpixels removed,
+// then added, then reassigned.  Missing bodies are left blank.
+func (diff MapDiff) WriteCsv(writer io.Writer) {
+	csvWriter := csv.NewWriter(writer)
+	record := []string{"Slice", "Label", "Change", "Old body", "New body"}
+	err := csvWriter.Write(record)
+	if err != nil {
+		log.Fatalln("ERROR: Unable to write header to CSV:", err)
+	}
+	write := func(changes []SuperpixelChange, kind string) {
+		for _, change := range changes {
+			oldBody, newBody := change.OldBody.String(),
+				change.NewBody.String()
+			if kind == "added" {
+				oldBody = ""
+			} else if kind == "removed" {
+				newBody = ""
+			}
+			record := []string{
+				strconv.FormatUint(uint64(change.Superpixel.Slice), 10),
+				strconv.FormatUint(uint64(change.Superpixel.Label), 10),
+				kind, oldBody, newBody,
+			}
+			err := csvWriter.Write(record)
+			if err != nil {
+				log.Fatalln("ERROR: Unable to write line of CSV for "+
+					"superpixel", change.Superpixel, ":", err)
+			}
+		}
+	}
+	write(diff.OnlyInA, "removed")
+	write(diff.OnlyInB, "added")
+	write(diff.Reassigned, "reassigned")
+	csvWriter.Flush()
+}
+
+// WriteCsvFile writes the map changes into a CSV file.
+func (diff MapDiff) WriteCsvFile(filename string) {
+	file, err := os.Create(filename)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to create map diff csv file: %s [%s]\n",
+			filename, err)
+	}
+	diff.WriteCsv(file)
+	file.Close()
+}
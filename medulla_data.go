@@ -32,9 +32,12 @@
 package emdata
 
 import (
+	"encoding/json"
 	"path/filepath"
 	"fmt"
 	"log"
+	"os"
+	"strings"
 )
 
 type StackId int
@@ -69,60 +72,139 @@ var StackDescriptionToId = map[string]StackId{
 	"Target12k": Target12k,
 }
 
-const (
+// Role returns the part a medulla stack plays when transforming traced
+// bodies.  Distal and Proximal exports are transformed into the base
+// column, and base column bodies are transformed into Target12k.
+func (stackId StackId) Role() StackRole {
+	switch stackId {
+	case Distal, Proximal:
+		return BaseColumnRole
+	case Target12k:
+		return TargetRole
+	}
+	return ExportRole
+}
+
+// The medulla stack paths below default to locations on the HHMI
+// cluster.  Each may be overridden at startup by the environment
+// variable given in its comment or at runtime by Configure.
+var (
 	// DistalStackDir was first 161-610 slice TEM data to be proofread
-	// and was in the non-seamless space.
-	DistalStackDir = "/groups/flyem/proj/data/data_to_be_proofread" +
-		"/medulla.HPF.Leginon.3500x.zhiyuan.fall2008" +
-		"/region.crop4_global_alignment_0161_1460.unreal.161788539746303_40" +
-		"/ms3_1011.1110_4k.4k_09042009" +
-		"/shinya.04132010.after_export_07152010_161.610" +
-		"/03172011_with.anc.bodies"
+	// and was in the non-seamless space.  EMDATA_DISTAL_STACK_DIR
+	DistalStackDir = envOrDefault("EMDATA_DISTAL_STACK_DIR",
+		"/groups/flyem/proj/data/data_to_be_proofread"+
+			"/medulla.HPF.Leginon.3500x.zhiyuan.fall2008"+
+			"/region.crop4_global_alignment_0161_1460.unreal.161788539746303_40"+
+			"/ms3_1011.1110_4k.4k_09042009"+
+			"/shinya.04132010.after_export_07152010_161.610"+
+			"/03172011_with.anc.bodies")
 
 	// DistalExportDir is the parent directory of all proofreader exports
-	// of assigned synapse tracing for distal, non-seamless stack
-	DistalExportDir = "/groups/flyem/proj/data/proofread_data" +
-		"/medulla_synapse_driven_proofreading/medulla_0161_0610_anc"
+	// of assigned synapse tracing for distal, non-seamless stack.
+	// EMDATA_DISTAL_EXPORT_DIR
+	DistalExportDir = envOrDefault("EMDATA_DISTAL_EXPORT_DIR",
+		"/groups/flyem/proj/data/proofread_data"+
+			"/medulla_synapse_driven_proofreading/medulla_0161_0610_anc")
 
-	// SeamlessStackDir is intermediate target stack for all body ID 
-	// renumbering in column proofreading.
-	SeamlessStackDir = "/groups/flyem/proj/data/data_to_be_proofread" +
-		"/medulla.HPF.Leginon.3500x.zhiyuan.fall2008" +
-		"/region.crop4_global_alignment_0161_1460.unreal.161788539746303_40" +
-		"/ms3_1011.1110_4k.4k_09042009/REF_seamless"
+	// SeamlessStackDir is intermediate target stack for all body ID
+	// renumbering in column proofreading.  EMDATA_SEAMLESS_STACK_DIR
+	SeamlessStackDir = envOrDefault("EMDATA_SEAMLESS_STACK_DIR",
+		"/groups/flyem/proj/data/data_to_be_proofread"+
+			"/medulla.HPF.Leginon.3500x.zhiyuan.fall2008"+
+			"/region.crop4_global_alignment_0161_1460.unreal.161788539746303_40"+
+			"/ms3_1011.1110_4k.4k_09042009/REF_seamless")
 
 	// SeamlessSynapsesFile is the transformed synapse annotation file
 	// from the non-seamless distal space to seamless column space.
-	SeamlessSynapsesFile = "/groups/flyem/proj/data/data_to_be_proofread" +
-		"/medulla.HPF.Leginon.3500x.zhiyuan.fall2008" +
-		"/region.crop4_global_alignment_0161_1460.unreal.161788539746303_40" +
-		"/ms3_1011.1110_4k.4k_09042009/REF_seamless" +
-		"/annotations-synapses-xformed2.json"
+	// EMDATA_SEAMLESS_SYNAPSES_FILE
+	SeamlessSynapsesFile = envOrDefault("EMDATA_SEAMLESS_SYNAPSES_FILE",
+		"/groups/flyem/proj/data/data_to_be_proofread"+
+			"/medulla.HPF.Leginon.3500x.zhiyuan.fall2008"+
+			"/region.crop4_global_alignment_0161_1460.unreal.161788539746303_40"+
+			"/ms3_1011.1110_4k.4k_09042009/REF_seamless"+
+			"/annotations-synapses-xformed2.json")
 
 	// SeamlessExportDir is the parent directory of all proofreader exports
-	// of assigned synapse tracing for seamless stack
-	SeamlessExportDir = "/groups/flyem/proj/data/proofread_data" +
-		"/medulla_synapse_driven_proofreading/REF_seamless"
+	// of assigned synapse tracing for seamless stack.
+	// EMDATA_SEAMLESS_EXPORT_DIR
+	SeamlessExportDir = envOrDefault("EMDATA_SEAMLESS_EXPORT_DIR",
+		"/groups/flyem/proj/data/proofread_data"+
+			"/medulla_synapse_driven_proofreading/REF_seamless")
+
+	// SigmundcSecondExportDir holds the re-export of sigmundc's second
+	// seamless assignment set.  EMDATA_SIGMUNDC_SECOND_EXPORT_DIR
+	SigmundcSecondExportDir = envOrDefault("EMDATA_SIGMUNDC_SECOND_EXPORT_DIR",
+		"/groups/flyem/proj/data/proofread_data/pat"+
+			"/sigmundc.synapse2.second_export")
 
-	// Orig12kStackDir is the first 12k x 12k x 1300 stack that should 
+	// Orig12kStackDir is the first 12k x 12k x 1300 stack that should
 	// match body IDs of REF_seamless 5k x 6k stack.
-	Orig12kStackDir = "/groups/flyem/data/medulla-TEM-fall2008" +
-		"/integrate-20110630/data"
+	// EMDATA_ORIG12K_STACK_DIR
+	Orig12kStackDir = envOrDefault("EMDATA_ORIG12K_STACK_DIR",
+		"/groups/flyem/data/medulla-TEM-fall2008/integrate-20110630/data")
 
 	// Orig12kSynapsesFile is the transformed synapse annotation file
 	// for the original 12k x 12k x 1300 stack that has "uid" tags
-	// associated with original T-bars before transformation to 12k space
-	Orig12kSynapsesFile = "/groups/flyem/data/medulla-TEM-fall2008" +
-		"/integrate-20110630/data/annotations-synapses-xformed2.json"
+	// associated with original T-bars before transformation to 12k space.
+	// EMDATA_ORIG12K_SYNAPSES_FILE
+	Orig12kSynapsesFile = envOrDefault("EMDATA_ORIG12K_SYNAPSES_FILE",
+		"/groups/flyem/data/medulla-TEM-fall2008"+
+			"/integrate-20110630/data/annotations-synapses-xformed2.json")
+
+	// Stack12kPattern is a filepath.Match pattern for the data
+	// directories of all 12k x 12k x 1300 stacks.  EMDATA_12K_STACK_PATTERN
+	Stack12kPattern = envOrDefault("EMDATA_12K_STACK_PATTERN",
+		"/groups/flyem/data/medulla-TEM-fall2008/*/data")
 )
 
+// envOrDefault returns the value of an environment variable or the
+// given default if the variable is unset or empty.
+func envOrDefault(name, defaultValue string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// Config holds the configurable medulla paths.  See the package
+// variables of the same name.
+type Config struct {
+	DistalStackDir          string
+	DistalExportDir         string
+	SeamlessStackDir        string
+	SeamlessSynapsesFile    string
+	SeamlessExportDir       string
+	SigmundcSecondExportDir string
+	Orig12kStackDir         string
+	Orig12kSynapsesFile     string
+	Stack12kPattern         string
+}
+
+// Configure sets the medulla paths from cfg.  Empty fields leave the
+// current paths unchanged.
+func Configure(cfg Config) {
+	setPath := func(path *string, value string) {
+		if value != "" {
+			*path = value
+		}
+	}
+	setPath(&DistalStackDir, cfg.DistalStackDir)
+	setPath(&DistalExportDir, cfg.DistalExportDir)
+	setPath(&SeamlessStackDir, cfg.SeamlessStackDir)
+	setPath(&SeamlessSynapsesFile, cfg.SeamlessSynapsesFile)
+	setPath(&SeamlessExportDir, cfg.SeamlessExportDir)
+	setPath(&SigmundcSecondExportDir, cfg.SigmundcSecondExportDir)
+	setPath(&Orig12kStackDir, cfg.Orig12kStackDir)
+	setPath(&Orig12kSynapsesFile, cfg.Orig12kSynapsesFile)
+	setPath(&Stack12kPattern, cfg.Stack12kPattern)
+}
+
 // InitialSuperpixelToBodyMapSize returns a guess of the # of superpixels
 // for a given stack path.
 func InitialSuperpixelToBodyMapSize(path string) int {
 	isDistal, _ := filepath.Match(DistalExportDir+"/*", path)
 	isProximal, _ := filepath.Match(SeamlessExportDir+"/*", path)
-	is12k, _ := filepath.Match("/groups/flyem/data/medulla-TEM-fall2008/*/data",
-		path)
+	is12k, _ := filepath.Match(Stack12kPattern, path)
 	switch {
 	case isDistal || path == DistalStackDir:
 		return DistalSuperpixels
@@ -139,8 +221,7 @@ func InitialSuperpixelToBodyMapSize(path string) int {
 func InitialSegmentToBodyMapSize(path string) int {
 	isDistal, _ := filepath.Match(DistalExportDir+"/*", path)
 	isProximal, _ := filepath.Match(SeamlessExportDir+"/*", path)
-	is12k, _ := filepath.Match("/groups/flyem/data/medulla-TEM-fall2008/*/data",
-		path)
+	is12k, _ := filepath.Match(Stack12kPattern, path)
 	switch {
 	case isDistal || path == DistalStackDir:
 		return DistalSegments
@@ -188,6 +269,47 @@ var proofreadingExports = [2]AssignmentMapping{
 	},
 }
 
+// LoadProofreadingExports replaces the export sets of proofreading
+// assignments with those in a JSON file keyed by stack description and
+// then userid, e.g., {"Distal": {"abeln": {"last": 4, "use": [1, 2]}}}.
+// Stacks missing from the file keep their current export sets.
+func LoadProofreadingExports(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("failed to open proofreading exports file: %s", err)
+	}
+	defer file.Close()
+	var input map[string]map[string]struct {
+		Last int   `json:"last"`
+		Use  []int `json:"use"`
+	}
+	if err = json.NewDecoder(file).Decode(&input); err != nil {
+		return jsonFileError(filename, err)
+	}
+	exports := proofreadingExports
+	for description, users := range input {
+		stackId, found := StackDescriptionToId[description]
+		if !found || int(stackId) >= len(exports) {
+			return fmt.Errorf("proofreading exports file %s has bad stack %q",
+				filename, description)
+		}
+		mapping := make(AssignmentMapping, len(users))
+		for userid, sets := range users {
+			use := sets.Use
+			if use == nil {
+				use = []int{}
+			}
+			mapping[userid] = struct {
+				Last int
+				Use  []int
+			}{sets.Last, use}
+		}
+		exports[stackId] = mapping
+	}
+	proofreadingExports = exports
+	return nil
+}
+
 // NumAssignmentSets returns the last assignment set done by
 // a given proofreader for a substack location
 func LastAssignmentSet(userid string, s StackId) (lastSet int) {
@@ -210,6 +332,52 @@ func UseAssignmentSet(location StackId, userid string,
 	return
 }
 
+// AssignmentExportCandidates returns the export directories on disk that
+// may hold a user's assignments for a given substack location.
+func AssignmentExportCandidates(location StackId, userid string) (
+	dirs []string) {
+
+	setnums := append([]int{}, proofreadingExports[location][userid].Use...)
+	setnums = append(setnums, proofreadingExports[location][userid].Last)
+	for _, setnum := range setnums {
+		dir := AssignmentExportDir(location, userid, setnum)
+		if _, err := os.Stat(dir); err == nil {
+			dirs = append(dirs, dir)
+		}
+	}
+	return
+}
+
+// ResolveAssignmentExport returns the export directory for a proofreading
+// assignment set after checking that the export actually contains the
+// assigned T-bars.  An error listing candidate export directories is
+// returned if coverage is below MinExportCoverage.
+func ResolveAssignmentExport(location StackId, userid string,
+	assignedSet int) (dir string, coverage float64, err error) {
+
+	setnum := UseAssignmentSet(location, userid, assignedSet)
+	dir = AssignmentExportDir(location, userid, setnum)
+	filename := AssignmentJsonFilename(location, userid, assignedSet)
+	if _, err = os.Stat(filename); err != nil {
+		err = fmt.Errorf("no assignment JSON for %s set %d: %s",
+			userid, assignedSet, err)
+		return
+	}
+	assignment, err := ReadSynapsesJson(filename)
+	if err == nil {
+		coverage, err = ExportCoverage(assignment, dir)
+	}
+	if err == nil && coverage < MinExportCoverage {
+		err = fmt.Errorf("export %s only has %.1f%% of T-bars in %s",
+			dir, 100.0*coverage, filename)
+	}
+	if err != nil {
+		err = fmt.Errorf("%s (candidate exports: %s)", err,
+			strings.Join(AssignmentExportCandidates(location, userid), ", "))
+	}
+	return
+}
+
 // BaseStackDir returns the directory of the base stack for
 // a given substack location.
 func BaseStackDir(location StackId) (dir string) {
@@ -238,7 +406,7 @@ func AssignmentExportDir(location StackId, userid string,
 		dir = filepath.Join(DistalExportDir, dir)
 	case Proximal:
 		if userid == "sigmundc" && setnum == 2 {
-			dir = "/groups/flyem/proj/data/proofread_data/pat/sigmundc.synapse2.second_export"
+			dir = SigmundcSecondExportDir
 		} else {
 			dir = filepath.Join(SeamlessExportDir, dir)
 		}
@@ -0,0 +1,485 @@
+// Copyright 2012 HHMI.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//     * Neither the name of HHMI nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+// Author: katzw@janelia.hhmi.org (Bill Katz)
+//  Written as part of the FlyEM Project at Janelia Farm Research Center.
+
+package emdata
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// Add adds count to the overlap of bodyA with bodyB.
+func (overlapsMap OverlapsMap) Add(bodyA, bodyB BodyId, count int) {
+	overlaps, found := overlapsMap[bodyA]
+	if !found {
+		overlaps = make(Overlaps)
+		overlapsMap[bodyA] = overlaps
+	}
+	overlaps[bodyB] += count
+}
+
+// Total returns the sum of all overlaps, including body 0.
+func (overlaps Overlaps) Total() (total int) {
+	for _, count := range overlaps {
+		total += count
+	}
+	return
+}
+
+// best returns the bodies with largest and second largest overlap.
+// Ties go to a non-zero body, then the lower body id.  Body 0 is only
+// considered if includeZero is true.
+func (overlaps Overlaps) best(includeZero bool) (matchedBodyId BodyId,
+	largest int, secondBodyId BodyId, second int) {
+
+	beats := func(bodyId BodyId, count int, otherId BodyId,
+		otherCount int) bool {
+
+		if count == otherCount && bodyId != 0 {
+			return otherId == 0 || bodyId < otherId
+		}
+		return count > otherCount
+	}
+	for bodyId, count := range overlaps {
+		if bodyId == 0 && !includeZero {
+			continue
+		}
+		if beats(bodyId, count, matchedBodyId, largest) {
+			secondBodyId, second = matchedBodyId, largest
+			matchedBodyId, largest = bodyId, count
+		} else if beats(bodyId, count, secondBodyId, second) {
+			secondBodyId, second = bodyId, count
+		}
+	}
+	return
+}
+
+// Best returns the best overlapping body for each body using
+// DefaultOverlapOptions.  See BestWithOptions.
+func (overlapsMap OverlapsMap) Best(
+	maxOverlapLookup func(BodyId) int) BestOverlapMap {
+
+	return overlapsMap.BestWithOptions(maxOverlapLookup,
+		DefaultOverlapOptions)
+}
+
+// BestWithOptions returns the best overlapping body for each body.
+// The maximum possible overlap of a body is given by maxOverlapLookup,
+// or by the body's total overlap if the lookup is nil.  Bodies without
+// a non-zero match are stored with ZeroMatch set.
+func (overlapsMap OverlapsMap) BestWithOptions(
+	maxOverlapLookup func(BodyId) int, options OverlapOptions) (
+	matchingMap BestOverlapMap) {
+
+	matchingMap = make(BestOverlapMap, len(overlapsMap))
+	for bodyId, overlaps := range overlapsMap {
+		matchedBodyId, largest, secondBodyId, second :=
+			overlaps.best(options.IncludeZeroBody)
+		var maximumOverlap int
+		if maxOverlapLookup != nil {
+			maximumOverlap = maxOverlapLookup(bodyId)
+		} else {
+			maximumOverlap = overlaps.Total()
+		}
+		var fraction float64
+		if maximumOverlap > 0 {
+			fraction = float64(largest) / float64(maximumOverlap)
+		}
+		matchingMap[bodyId] = BestOverlap{
+			MatchedBody: matchedBodyId,
+			OverlapSize: largest,
+			MaxOverlap:  maximumOverlap,
+			ZeroOverlap: overlaps[0],
+			ZeroMatch:   matchedBodyId == 0,
+			Fraction:    fraction,
+
+			SecondBody:        secondBodyId,
+			SecondOverlapSize: second,
+		}
+	}
+	return
+}
+
+// jsonOverlap is one pair of overlapping bodies in JSON files.
+type jsonOverlap struct {
+	BodyA BodyId `json:"body A"`
+	BodyB BodyId `json:"body B"`
+	Count int    `json:"count"`
+}
+
+// sortedOverlaps returns all pairs of overlapping bodies sorted by
+// body A then body B.
+func (overlapsMap OverlapsMap) sortedOverlaps() []jsonOverlap {
+	bodies := make(BodyIdList, 0, len(overlapsMap))
+	for bodyA, _ := range overlapsMap {
+		bodies = append(bodies, bodyA)
+	}
+	sort.Sort(bodies)
+	var list []jsonOverlap
+	for _, bodyA := range bodies {
+		overlaps := overlapsMap[bodyA]
+		bodiesB := make(BodyIdList, 0, len(overlaps))
+		for bodyB, _ := range overlaps {
+			bodiesB = append(bodiesB, bodyB)
+		}
+		sort.Sort(bodiesB)
+		for _, bodyB := range bodiesB {
+			list = append(list, jsonOverlap{bodyA, bodyB, overlaps[bodyB]})
+		}
+	}
+	return list
+}
+
+// WriteJson writes overlaps as an indented JSON list of
+// {"body A", "body B", "count"} objects.
+func (overlapsMap OverlapsMap) WriteJson(writer io.Writer) {
+	output := struct {
+		Metadata map[string]interface{} `json:"metadata"`
+		Data     []jsonOverlap          `json:"data"`
+	}{
+		CreateMetadata("Body overlaps"),
+		overlapsMap.sortedOverlaps(),
+	}
+	m, err := json.Marshal(output)
+	if err != nil {
+		log.Fatalf("Error in writing json: %s", err)
+	}
+	var buf bytes.Buffer
+	json.Indent(&buf, m, "", "    ")
+	buf.WriteTo(writer)
+}
+
+// WriteJsonFile writes overlaps into a JSON file.
+func (overlapsMap OverlapsMap) WriteJsonFile(filename string) {
+	file, err := os.Create(filename)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to create json overlaps file: %s [%s]\n",
+			filename, err)
+	}
+	overlapsMap.WriteJson(file)
+	file.Close()
+}
+
+// ReadOverlapsJson reads overlaps written by WriteJson.  Repeated pairs
+// of bodies are summed.
+func ReadOverlapsJson(reader io.Reader) (OverlapsMap, error) {
+	var input struct {
+		Data []jsonOverlap `json:"data"`
+	}
+	if err := json.NewDecoder(reader).Decode(&input); err != nil {
+		return nil, err
+	}
+	overlapsMap := make(OverlapsMap)
+	for _, overlap := range input.Data {
+		overlapsMap.Add(overlap.BodyA, overlap.BodyB, overlap.Count)
+	}
+	return overlapsMap, nil
+}
+
+// WriteCsv writes overlaps as CSV with one line per pair of bodies.
+func (overlapsMap OverlapsMap) WriteCsv(writer io.Writer) {
+	csvWriter := csv.NewWriter(writer)
+	err := csvWriter.Write([]string{"Body A", "Body B", "Count"})
+	if err != nil {
+		log.Fatalln("ERROR: Unable to write header to CSV:", err)
+	}
+	for _, overlap := range overlapsMap.sortedOverlaps() {
+		record := []string{overlap.BodyA.String(), overlap.BodyB.String(),
+			strconv.Itoa(overlap.Count)}
+		if err := csvWriter.Write(record); err != nil {
+			log.Fatalln("ERROR: Unable to write line of CSV for bodies",
+				overlap.BodyA, overlap.BodyB, ":", err)
+		}
+	}
+	csvWriter.Flush()
+}
+
+// WriteCsvFile writes overlaps into a CSV file.
+func (overlapsMap OverlapsMap) WriteCsvFile(filename string) {
+	file, err := os.Create(filename)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to create overlaps csv file: %s [%s]\n",
+			filename, err)
+	}
+	overlapsMap.WriteCsv(file)
+	file.Close()
+}
+
+// ReadOverlapsCsv reads overlaps written by WriteCsv.  A header line is
+// optional and repeated pairs of bodies are summed.
+func ReadOverlapsCsv(reader io.Reader) (OverlapsMap, error) {
+	overlapsMap := make(OverlapsMap)
+	csvReader := csv.NewReader(reader)
+	for line := 1; ; line++ {
+		items, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if line == 1 && items[0] == "Body A" {
+			continue
+		}
+		if len(items) < 3 {
+			return nil, fmt.Errorf("line %d: expected 3 fields, got %d",
+				line, len(items))
+		}
+		var values [3]int64
+		for i := 0; i < 3; i++ {
+			values[i], err = strconv.ParseInt(items[i], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", line, err)
+			}
+		}
+		overlapsMap.Add(BodyId(values[0]), BodyId(values[1]),
+			int(values[2]))
+	}
+	return overlapsMap, nil
+}
+
+// jsonBestOverlap is a BestOverlap in JSON files.
+type jsonBestOverlap struct {
+	MatchedBody       BodyId  `json:"matched body"`
+	OverlapSize       int     `json:"overlap size"`
+	MaxOverlap        int     `json:"max overlap"`
+	ZeroOverlap       int     `json:"zero overlap"`
+	ZeroMatch         bool    `json:"zero match"`
+	Fraction          float64 `json:"fraction"`
+	SecondBody        BodyId  `json:"second body"`
+	SecondOverlapSize int     `json:"second overlap size"`
+}
+
+// jsonBestOverlapMap is the layout of best overlap JSON files.  Body ids
+// are keys of "matches" and so are encoded as strings.
+type jsonBestOverlapMap struct {
+	Metadata map[string]interface{}     `json:"metadata"`
+	Matches  map[string]jsonBestOverlap `json:"matches"`
+}
+
+// OverlapMetadata returns metadata for a best overlap map computed
+// between two stacks with the given options.
+func OverlapMetadata(stack1, stack2 MappedStack, options OverlapOptions) (
+	metadata map[string]interface{}) {
+
+	metadata = CreateMetadata("Best overlap map")
+	metadata["stack 1"] = stack1.String()
+	metadata["stack 2"] = stack2.String()
+	metadata["include zero body"] = options.IncludeZeroBody
+	metadata["weight by volume"] = options.WeightByVolume
+	metadata["ambiguous overlap ratio"] = AmbiguousOverlapRatio
+	if options.CheckBounds != nil {
+		metadata["max bounds fraction"] = options.CheckBounds.MaxFraction
+	}
+	return
+}
+
+// WriteJson writes the best overlaps as indented JSON keyed by body id
+// along with the given metadata.  If metadata is nil, metadata without
+// the compared stacks is created.
+func (matchingMap BestOverlapMap) WriteJson(writer io.Writer,
+	metadata map[string]interface{}) error {
+
+	if metadata == nil {
+		metadata = CreateMetadata("Best overlap map")
+		metadata["ambiguous overlap ratio"] = AmbiguousOverlapRatio
+	}
+	output := jsonBestOverlapMap{
+		Metadata: metadata,
+		Matches:  make(map[string]jsonBestOverlap, len(matchingMap)),
+	}
+	for bodyId, match := range matchingMap {
+		output.Matches[bodyId.String()] = jsonBestOverlap(match)
+	}
+	m, err := json.Marshal(output)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	json.Indent(&buf, m, "", "    ")
+	_, err = buf.WriteTo(writer)
+	return err
+}
+
+// WriteJsonFile writes the best overlaps into a JSON file.  Use
+// WriteJsonFileWithMetadata to record the stacks that were compared.
+func (matchingMap BestOverlapMap) WriteJsonFile(filename string) error {
+	return matchingMap.WriteJsonFileWithMetadata(filename, nil)
+}
+
+// WriteJsonFileWithMetadata writes the best overlaps into a JSON file
+// with the given metadata, e.g., from OverlapMetadata.  The file is
+// gzipped if its name ends in GzipSuffix.
+func (matchingMap BestOverlapMap) WriteJsonFileWithMetadata(filename string,
+	metadata map[string]interface{}) error {
+
+	file, err := createTextFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create best overlap JSON file: %s", err)
+	}
+	err = matchingMap.WriteJson(file, metadata)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write best overlap JSON file %s: %s",
+			filename, err)
+	}
+	return nil
+}
+
+// ReadBestOverlapMapJsonFrom reads best overlaps and their metadata
+// written by BestOverlapMap.WriteJson.
+func ReadBestOverlapMapJsonFrom(reader io.Reader) (matchingMap BestOverlapMap,
+	metadata map[string]interface{}, err error) {
+
+	var input jsonBestOverlapMap
+	if err = json.NewDecoder(reader).Decode(&input); err != nil {
+		return
+	}
+	matchingMap = make(BestOverlapMap, len(input.Matches))
+	for key, match := range input.Matches {
+		var bodyId int64
+		bodyId, err = strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			matchingMap = nil
+			err = fmt.Errorf("bad body id %q in best overlaps: %s", key, err)
+			return
+		}
+		matchingMap[BodyId(bodyId)] = BestOverlap(match)
+	}
+	metadata = input.Metadata
+	return
+}
+
+// ReadBestOverlapMapJson reads a best overlap JSON file written by
+// BestOverlapMap.WriteJsonFile.  The map can be passed directly to
+// TransformBodies.
+func ReadBestOverlapMapJson(filename string) (BestOverlapMap, error) {
+	file, filename, err := openTextFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open JSON file: %s", err)
+	}
+	defer file.Close()
+	matchingMap, _, err := ReadBestOverlapMapJsonFrom(file)
+	if err != nil {
+		return nil, jsonFileError(filename, err)
+	}
+	return matchingMap, nil
+}
+
+// WriteCsv writes a report of best overlaps with one line per body
+// sorted by decreasing percent overlap, then by body id.  Body names
+// are taken from neurons if present.  Trailing "Mean" and "Median"
+// lines give the percent overlap across all bodies.
+func (matchingMap BestOverlapMap) WriteCsv(writer io.Writer,
+	neurons NamedBodyMap) error {
+
+	bodies := make(BodyIdList, 0, len(matchingMap))
+	for bodyId, _ := range matchingMap {
+		bodies = append(bodies, bodyId)
+	}
+	sort.Sort(bodies)
+	fraction := func(match BestOverlap) float64 {
+		return percent(match.OverlapSize, match.MaxOverlap)
+	}
+	sort.Stable(sort.Reverse(byWeakestOverlap{bodies, matchingMap, fraction}))
+
+	formatPercent := func(value float64) string {
+		return strconv.FormatFloat(value, 'f', 2, 64)
+	}
+	csvWriter := csv.NewWriter(writer)
+	err := csvWriter.Write([]string{"Body", "Name", "Matched body",
+		"Overlap size", "Max overlap", "Percent overlap", "Ambiguous"})
+	if err != nil {
+		return err
+	}
+	percents := make([]float64, len(bodies))
+	sum := 0.0
+	for i, bodyId := range bodies {
+		match := matchingMap[bodyId]
+		percents[i] = fraction(match)
+		sum += percents[i]
+		record := []string{
+			bodyId.String(),
+			neurons[bodyId].Name,
+			match.MatchedBody.String(),
+			strconv.Itoa(match.OverlapSize),
+			strconv.Itoa(match.MaxOverlap),
+			formatPercent(percents[i]),
+			strconv.FormatBool(match.Ambiguous(AmbiguousOverlapRatio)),
+		}
+		if err = csvWriter.Write(record); err != nil {
+			return err
+		}
+	}
+	if n := len(percents); n > 0 {
+		// Percents are in decreasing order.
+		median := percents[n/2]
+		if n%2 == 0 {
+			median = (percents[n/2-1] + percents[n/2]) / 2.0
+		}
+		summary := [][]string{
+			{"Mean", "", "", "", "", formatPercent(sum / float64(n)), ""},
+			{"Median", "", "", "", "", formatPercent(median), ""},
+		}
+		if err = csvWriter.WriteAll(summary); err != nil {
+			return err
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// WriteCsvFile writes a report of best overlaps into a CSV file.
+func (matchingMap BestOverlapMap) WriteCsvFile(filename string,
+	neurons NamedBodyMap) error {
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create best overlap csv file: %s", err)
+	}
+	err = matchingMap.WriteCsv(file, neurons)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write best overlap csv file %s: %s",
+			filename, err)
+	}
+	return nil
+}
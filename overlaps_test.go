@@ -0,0 +1,521 @@
+// Copyright 2012 HHMI.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//     * Neither the name of HHMI nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+// Author: katzw@janelia.hhmi.org (Bill Katz)
+//  Written as part of the FlyEM Project at Janelia Farm Research Center.
+
+package emdata
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// writeTestStack writes the map .txt files of a stack to a temporary
+// directory and returns the stack.
+func writeTestStack(t testing.TB, spToBodyMap SuperpixelToBodyMap) *Stack {
+	dir := t.TempDir()
+	if err := spToBodyMap.WriteTxtMaps(dir); err != nil {
+		t.Fatal(err)
+	}
+	return &Stack{Directory: dir}
+}
+
+func TestOverlapWeightByVolume(t *testing.T) {
+	stack1 := writeTestStack(t, SuperpixelToBodyMap{
+		{1, 1}: 1, {1, 2}: 1, {1, 3}: 1,
+	})
+	// Body 1 has more superpixels in body 20 but more voxels in body 30.
+	stack2 := writeTestStack(t, SuperpixelToBodyMap{
+		{1, 1}: 20, {1, 2}: 20, {1, 3}: 30,
+	})
+	bodySet := BodySet{1: true}
+	tests := []struct {
+		name     string
+		options  OverlapOptions
+		expected BestOverlap
+	}{
+		{"count", OverlapOptions{},
+			BestOverlap{MatchedBody: 20, OverlapSize: 2, MaxOverlap: 3,
+				SecondBody: 30, SecondOverlapSize: 1}},
+		{"volume", OverlapOptions{WeightByVolume: true,
+			Bounds1: SuperpixelBoundsMap{
+				{1, 1}: {Width: 2, Height: 5, Volume: 10},
+				{1, 2}: {Width: 2, Height: 5, Volume: 10},
+				{1, 3}: {Width: 20, Height: 25, Volume: 500},
+			}},
+			BestOverlap{MatchedBody: 30, OverlapSize: 500, MaxOverlap: 520,
+				SecondBody: 20, SecondOverlapSize: 20}},
+		{"no volumes", OverlapOptions{WeightByVolume: true,
+			Bounds1: SuperpixelBoundsMap{
+				{1, 1}: {Width: 2, Height: 5},
+				{1, 2}: {Width: 2, Height: 5},
+				{1, 3}: {Width: 20, Height: 25},
+			}},
+			BestOverlap{MatchedBody: 20, OverlapSize: 2, MaxOverlap: 3,
+				SecondBody: 30, SecondOverlapSize: 1}},
+		{"no bounds", OverlapOptions{WeightByVolume: true,
+			Bounds1: SuperpixelBoundsMap{}},
+			BestOverlap{MatchedBody: 20, OverlapSize: 2, MaxOverlap: 3,
+				SecondBody: 30, SecondOverlapSize: 1}},
+	}
+	for _, test := range tests {
+		matchingMap, _, err := OverlapAnalysisWithOptions(stack1, stack2,
+			bodySet, test.options)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		match := matchingMap[1]
+		match.Fraction = 0
+		if match != test.expected {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.expected,
+				match)
+		}
+	}
+}
+
+func TestOverlapAnalysisMutual(t *testing.T) {
+	// Body 1 best matches body 20, but body 20 best matches body 3, which
+	// is not in the body set.  Bodies 2 and 25 match each other.
+	stack1 := writeTestStack(t, SuperpixelToBodyMap{
+		{1, 1}: 1, {1, 2}: 1,
+		{1, 3}: 3, {1, 4}: 3, {1, 5}: 3,
+		{1, 6}: 2,
+	})
+	stack2 := writeTestStack(t, SuperpixelToBodyMap{
+		{1, 1}: 20, {1, 2}: 20, {1, 3}: 20, {1, 4}: 20, {1, 5}: 20,
+		{1, 6}: 25,
+	})
+	matchingMap, nonMutual, _, err := OverlapAnalysisMutual(stack1, stack2,
+		BodySet{1: true, 2: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matchingMap) != 1 || matchingMap[2].MatchedBody != 25 {
+		t.Errorf("expected only 2->25 to be mutual, got %v", matchingMap)
+	}
+	if len(nonMutual) != 1 || !nonMutual[1] {
+		t.Errorf("expected body 1 to be non-mutual, got %v", nonMutual)
+	}
+
+	matchingMap, nonMutual, _, err = OverlapAnalysisMutual(stack1, stack2,
+		BodySet{1: true, 2: true, 3: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matchingMap) != 2 || matchingMap[3].MatchedBody != 20 {
+		t.Errorf("expected 2->25 and 3->20 to be mutual, got %v", matchingMap)
+	}
+	if len(nonMutual) != 1 || !nonMutual[1] {
+		t.Errorf("expected body 1 to be non-mutual, got %v", nonMutual)
+	}
+}
+
+// syntheticOverlapMaps returns a body->superpixels map of stack1 bodies
+// and a superpixel->body map of stack2 that splits each body in two.
+func syntheticOverlapMaps(numSuperpixels, superpixelsPerBody int) (
+	BodyToSuperpixelsMap, SuperpixelToBodyMap) {
+
+	body1ToSpMap := make(BodyToSuperpixelsMap)
+	sp2ToBodyMap := make(SuperpixelToBodyMap, numSuperpixels)
+	for i := 0; i < numSuperpixels; i++ {
+		superpixel := Superpixel{uint32(i / 10000), uint32(i%10000 + 1)}
+		bodyId := BodyId(i/superpixelsPerBody + 1)
+		body1ToSpMap[bodyId] = append(body1ToSpMap[bodyId], superpixel)
+		sp2ToBodyMap[superpixel] = BodyId((i+superpixelsPerBody/3)/
+			superpixelsPerBody + 1)
+	}
+	return body1ToSpMap, sp2ToBodyMap
+}
+
+func TestTallyOverlapsWorkers(t *testing.T) {
+	body1ToSpMap, sp2ToBodyMap := syntheticOverlapMaps(10000, 100)
+	expected, found, notFound := tallyOverlaps(body1ToSpMap, sp2ToBodyMap,
+		nil, 1)
+	if found != 10000 || notFound != 0 || len(expected) != 100 {
+		t.Fatalf("expected 100 bodies and 10000 superpixels found, got %d "+
+			"bodies, %d found, %d not found", len(expected), found, notFound)
+	}
+	for _, workers := range []int{2, 3, 8} {
+		overlapsMap, _, _ := tallyOverlaps(body1ToSpMap, sp2ToBodyMap, nil,
+			workers)
+		if !reflect.DeepEqual(overlapsMap, expected) {
+			t.Errorf("%d workers: overlaps differ from 1 worker", workers)
+		}
+	}
+}
+
+func BenchmarkTallyOverlaps(b *testing.B) {
+	body1ToSpMap, sp2ToBodyMap := syntheticOverlapMaps(1000000, 100)
+	workerCounts := []int{1, 2, 4}
+	if procs := runtime.GOMAXPROCS(0); procs > 4 || procs == 3 {
+		workerCounts = append(workerCounts, procs)
+	}
+	for _, workers := range workerCounts {
+		b.Run(fmt.Sprintf("Workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				tallyOverlaps(body1ToSpMap, sp2ToBodyMap, nil, workers)
+			}
+		})
+	}
+}
+
+// testBestOverlapMap returns best overlaps with and without runner-up
+// bodies, including a zero match.
+func testBestOverlapMap() BestOverlapMap {
+	return BestOverlapMap{
+		1: {MatchedBody: 11, OverlapSize: 5, MaxOverlap: 10, ZeroOverlap: 1,
+			Fraction: 0.5, SecondBody: 12, SecondOverlapSize: 5},
+		2: {MatchedBody: 22, OverlapSize: 9, MaxOverlap: 10, Fraction: 0.9,
+			SecondBody: 23, SecondOverlapSize: 1},
+		3: {MatchedBody: 33, OverlapSize: 10, MaxOverlap: 10, Fraction: 1},
+		4: {OverlapSize: 0, MaxOverlap: 3, ZeroOverlap: 3, ZeroMatch: true},
+	}
+}
+
+func TestBestOverlapMapJsonRoundTrip(t *testing.T) {
+	matchingMap := testBestOverlapMap()
+	var buf bytes.Buffer
+	metadata := map[string]interface{}{"stack 1": "a", "stack 2": "b"}
+	if err := matchingMap.WriteJson(&buf, metadata); err != nil {
+		t.Fatal(err)
+	}
+	read, readMetadata, err := ReadBestOverlapMapJsonFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, matchingMap) {
+		t.Errorf("expected %v, got %v", matchingMap, read)
+	}
+	if readMetadata["stack 2"] != "b" {
+		t.Errorf("expected metadata %v, got %v", metadata, readMetadata)
+	}
+
+	filename := filepath.Join(t.TempDir(), "best.json"+GzipSuffix)
+	if err := matchingMap.WriteJsonFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	if read, err = ReadBestOverlapMapJson(filename); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, matchingMap) {
+		t.Errorf("gzip: expected %v, got %v", matchingMap, read)
+	}
+}
+
+func TestBestOverlapMapWriteCsv(t *testing.T) {
+	matchingMap := testBestOverlapMap()
+	delete(matchingMap, 4)
+	neurons := NamedBodyMap{2: {Body: 2, Name: "Mi1"}}
+	var buf bytes.Buffer
+	if err := matchingMap.WriteCsv(&buf, neurons); err != nil {
+		t.Fatal(err)
+	}
+	expected := "Body,Name,Matched body,Overlap size,Max overlap," +
+		"Percent overlap,Ambiguous\n" +
+		"3,,33,10,10,100.00,false\n" +
+		"2,Mi1,22,9,10,90.00,false\n" +
+		"1,,11,5,10,50.00,true\n" +
+		"Mean,,,,,80.00,\n" +
+		"Median,,,,,90.00,\n"
+	if buf.String() != expected {
+		t.Errorf("expected CSV:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestOverlapAnalysisZeroBody(t *testing.T) {
+	setStrictness(t, Lenient)
+	// Body 1 mostly overlaps body 0 but has body 20 as a runner-up.
+	// Body 2 only overlaps body 0.
+	stack1 := writeTestStack(t, SuperpixelToBodyMap{
+		{1, 1}: 1, {1, 2}: 1, {1, 3}: 1, {1, 4}: 2,
+	})
+	stack2 := writeTestStack(t, SuperpixelToBodyMap{
+		{1, 1}: 0, {1, 2}: 0, {1, 3}: 20, {1, 4}: 0,
+	})
+	bodySet := BodySet{1: true, 2: true}
+
+	matchingMap, warnings, err := OverlapAnalysis(stack1, stack2, bodySet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := BestOverlap{MatchedBody: 20, OverlapSize: 1, MaxOverlap: 3,
+		ZeroOverlap: 2, Fraction: 1.0 / 3.0}
+	if matchingMap[1] != expected {
+		t.Errorf("body 1: expected %+v, got %+v", expected, matchingMap[1])
+	}
+	if match := matchingMap[2]; !match.ZeroMatch || match.MatchedBody != 0 {
+		t.Errorf("body 2: expected zero match, got %+v", match)
+	}
+	if warnings.Count("no overlapping body") != 1 {
+		t.Errorf("expected 1 zero match warning, got %s", warnings)
+	}
+
+	options := OverlapOptions{IncludeZeroBody: true}
+	matchingMap, _, err = OverlapAnalysisWithOptions(stack1, stack2, bodySet,
+		options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if match := matchingMap[1]; !match.ZeroMatch || match.MatchedBody != 0 ||
+		match.SecondBody != 20 {
+		t.Errorf("body 1 with zero body: got %+v", match)
+	}
+
+	synapses := tracedSynapses(2)
+	_, warnings, err = synapses.TransformBodiesWithPolicy(matchingMap,
+		BaseColumnRole.Policy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	psd := synapses.Data[0].Psds[0]
+	if !psd.TransformIssue || psd.Tracings[0].BaseColumnBody != 0 {
+		t.Errorf("expected zero match flagged and not written, got %+v", psd)
+	}
+	if warnings.Count("zero body match") != 1 {
+		t.Errorf("expected 1 zero body match warning, got %s", warnings)
+	}
+
+	setStrictness(t, Strict)
+	if _, _, err = OverlapAnalysis(stack1, stack2, bodySet); err == nil {
+		t.Error("Strict: expected error for body only matching body 0")
+	}
+}
+
+func TestOverlapMatrix(t *testing.T) {
+	stack1 := writeTestStack(t, SuperpixelToBodyMap{
+		{1, 1}: 1, {1, 2}: 1, {1, 3}: 1,
+		{1, 4}: 2, {1, 5}: 2,
+		{1, 7}: 3,
+	})
+	stack2 := writeTestStack(t, SuperpixelToBodyMap{
+		{1, 1}: 10, {1, 2}: 10, {1, 3}: 20, {1, 4}: 20, {1, 5}: 0,
+		{1, 6}: 20, {1, 7}: 10,
+	})
+	spBoundsMap := SuperpixelBoundsMap{
+		{1, 1}: {Volume: 10}, {1, 2}: {Volume: 30}, {1, 3}: {Volume: 60},
+		{1, 4}: {Volume: 40}, {1, 5}: {Volume: 50}, {1, 6}: {Volume: 100},
+		{1, 7}: {Volume: 20},
+	}
+	bodySet := BodySet{1: true, 2: true}
+
+	overlapsMap := SuperpixelOverlaps(stack1, stack2, bodySet)
+	expectedOverlaps := OverlapsMap{
+		1: Overlaps{10: 2, 20: 1},
+		2: Overlaps{20: 1, 0: 1},
+	}
+	if !reflect.DeepEqual(overlapsMap, expectedOverlaps) {
+		t.Errorf("expected superpixel overlaps %v, got %v", expectedOverlaps,
+			overlapsMap)
+	}
+
+	tests := []struct {
+		name        string
+		spBoundsMap SuperpixelBoundsMap
+		minOverlap  int
+		expected    BodyOverlapList
+	}{
+		{"superpixels", nil, 1, BodyOverlapList{
+			{1, 10, 2, 0, 2.0 / 3.0, 2.0 / 3.0},
+			{1, 20, 1, 0, 1.0 / 3.0, 1.0 / 3.0},
+			{2, 20, 1, 0, 0.5, 1.0 / 3.0},
+		}},
+		{"voxels", spBoundsMap, 1, BodyOverlapList{
+			{1, 20, 1, 60, 0.6, 0.3},
+			{1, 10, 2, 40, 0.4, 40.0 / 60.0},
+			{2, 20, 1, 40, 40.0 / 90.0, 0.2},
+		}},
+		{"min overlap", nil, 2, BodyOverlapList{
+			{1, 10, 2, 0, 2.0 / 3.0, 2.0 / 3.0},
+		}},
+	}
+	for _, test := range tests {
+		matrix := OverlapMatrix(stack1, stack2, bodySet, test.spBoundsMap,
+			test.minOverlap)
+		if len(matrix) != len(test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected,
+				matrix)
+			continue
+		}
+		for i, overlap := range matrix {
+			expected := test.expected[i]
+			if overlap.BodyA != expected.BodyA ||
+				overlap.BodyB != expected.BodyB ||
+				overlap.Superpixels != expected.Superpixels ||
+				overlap.Voxels != expected.Voxels ||
+				math.Abs(overlap.FractionA-expected.FractionA) > 1e-9 ||
+				math.Abs(overlap.FractionB-expected.FractionB) > 1e-9 {
+				t.Errorf("%s: entry %d expected %+v, got %+v", test.name, i,
+					expected, overlap)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	OverlapMatrix(stack1, stack2, bodySet, spBoundsMap, 1).WriteCsv(&buf)
+	expectedCsv := "Body A,Body B,Overlapping superpixels,Overlapping voxels," +
+		"Fraction of A,Fraction of B\n" +
+		"1,20,1,60,0.6000,0.3000\n" +
+		"1,10,2,40,0.4000,0.6667\n" +
+		"2,20,1,40,0.4444,0.2000\n"
+	if buf.String() != expectedCsv {
+		t.Errorf("expected CSV:\n%s\ngot:\n%s", expectedCsv, buf.String())
+	}
+}
+
+// inlineBest is the best-match logic OverlapAnalysis used before
+// OverlapsMap.Best: the largest overlap wins, and ties go to a non-zero
+// body, then the lower body id.
+func inlineBest(overlaps Overlaps, includeZero bool) (matched BodyId,
+	largest int) {
+
+	for bodyId, count := range overlaps {
+		if bodyId == 0 && !includeZero {
+			continue
+		}
+		better := count > largest
+		if count == largest && bodyId != 0 {
+			better = matched == 0 || bodyId < matched
+		}
+		if better {
+			largest = count
+			matched = bodyId
+		}
+	}
+	return
+}
+
+func TestOverlapsMapBest(t *testing.T) {
+	tests := []struct {
+		name        string
+		overlaps    Overlaps
+		includeZero bool
+		lookup      func(BodyId) int
+		expected    BestOverlap
+	}{
+		{"tie goes to lower id", Overlaps{5: 3, 3: 3, 9: 1}, false, nil,
+			BestOverlap{MatchedBody: 3, OverlapSize: 3, MaxOverlap: 7,
+				Fraction: 3.0 / 7.0, SecondBody: 5, SecondOverlapSize: 3}},
+		{"tie goes to non-zero", Overlaps{0: 3, 7: 3}, true, nil,
+			BestOverlap{MatchedBody: 7, OverlapSize: 3, MaxOverlap: 6,
+				ZeroOverlap: 3, Fraction: 0.5, SecondBody: 0,
+				SecondOverlapSize: 3}},
+		{"zero dominant excluded", Overlaps{0: 10, 4: 2}, false, nil,
+			BestOverlap{MatchedBody: 4, OverlapSize: 2, MaxOverlap: 12,
+				ZeroOverlap: 10, Fraction: 2.0 / 12.0}},
+		{"zero dominant included", Overlaps{0: 10, 4: 2}, true, nil,
+			BestOverlap{MatchedBody: 0, OverlapSize: 10, MaxOverlap: 12,
+				ZeroOverlap: 10, ZeroMatch: true, Fraction: 10.0 / 12.0,
+				SecondBody: 4, SecondOverlapSize: 2}},
+		{"only zero", Overlaps{0: 5}, false, nil,
+			BestOverlap{MaxOverlap: 5, ZeroOverlap: 5, ZeroMatch: true}},
+		{"lookup", Overlaps{2: 4, 6: 1}, false,
+			func(BodyId) int { return 8 },
+			BestOverlap{MatchedBody: 2, OverlapSize: 4, MaxOverlap: 8,
+				Fraction: 0.5, SecondBody: 6, SecondOverlapSize: 1}},
+		{"zero lookup", Overlaps{2: 4}, false, func(BodyId) int { return 0 },
+			BestOverlap{MatchedBody: 2, OverlapSize: 4}},
+	}
+	for _, test := range tests {
+		overlapsMap := make(OverlapsMap)
+		for bodyB, count := range test.overlaps {
+			// Split counts to check that Add accumulates.
+			overlapsMap.Add(1, bodyB, count-count/2)
+			overlapsMap.Add(1, bodyB, count/2)
+		}
+		options := OverlapOptions{IncludeZeroBody: test.includeZero}
+		best := overlapsMap.BestWithOptions(test.lookup, options)[1]
+		if best != test.expected {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.expected,
+				best)
+		}
+		matched, largest := inlineBest(test.overlaps, test.includeZero)
+		if best.MatchedBody != matched || best.OverlapSize != largest {
+			t.Errorf("%s: inline logic matched %d (%d), got %d (%d)",
+				test.name, matched, largest, best.MatchedBody,
+				best.OverlapSize)
+		}
+		if !test.includeZero {
+			if defaults := overlapsMap.Best(test.lookup)[1]; defaults != best {
+				t.Errorf("%s: Best %+v differs from BestWithOptions %+v",
+					test.name, defaults, best)
+			}
+		}
+	}
+}
+
+func TestOverlapsMapReadWrite(t *testing.T) {
+	overlapsMap := OverlapsMap{
+		1:  Overlaps{10: 5, 0: 2},
+		2:  Overlaps{20: 1},
+		-3: Overlaps{1 << 40: 7},
+	}
+	var buf bytes.Buffer
+	overlapsMap.WriteJson(&buf)
+	reread, err := ReadOverlapsJson(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reread, overlapsMap) {
+		t.Errorf("JSON: expected %v, got %v", overlapsMap, reread)
+	}
+
+	buf.Reset()
+	overlapsMap.WriteCsv(&buf)
+	expected := "Body A,Body B,Count\n-3,1099511627776,7\n" +
+		"1,0,2\n1,10,5\n2,20,1\n"
+	if buf.String() != expected {
+		t.Errorf("expected CSV:\n%s\ngot:\n%s", expected, buf.String())
+	}
+	reread, err = ReadOverlapsCsv(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reread, overlapsMap) {
+		t.Errorf("CSV: expected %v, got %v", overlapsMap, reread)
+	}
+
+	reread, err = ReadOverlapsCsv(strings.NewReader("1,10,2\n1,10,3\n"))
+	if err != nil || !reflect.DeepEqual(reread, OverlapsMap{1: {10: 5}}) {
+		t.Errorf("headerless CSV with repeated pair: got %v, %v", reread, err)
+	}
+	for _, bad := range []string{"1,10\n", "1,x,2\n", "Body A,Body B\n1,2,3\n"} {
+		if _, err := ReadOverlapsCsv(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected error reading CSV %q", bad)
+		}
+	}
+	if _, err := ReadOverlapsJson(strings.NewReader("{")); err == nil {
+		t.Errorf("expected error reading truncated JSON")
+	}
+}
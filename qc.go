@@ -0,0 +1,611 @@
+// Copyright 2012 HHMI.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//     * Neither the name of HHMI nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+// Author: katzw@janelia.hhmi.org (Bill Katz)
+//  Written as part of the FlyEM Project at Janelia Farm Research Center.
+
+package emdata
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BoundaryDistance returns the distance in pixels from the (x,y) of a
+// point to the nearest edge of a superpixel's bounding rectangle and
+// that distance relative to the largest possible distance within the
+// rectangle, so 1.0 is the center and 0.0 is the edge.  Points outside
+// the rectangle have a negative distance.  Bounds are assumed to be in
+// the same coordinate system as the point.
+func (bound SuperpixelBound) BoundaryDistance(pt Point3d) (distance int,
+	relative float64) {
+
+	x, y := pt.IntX(), pt.IntY()
+	maxX := bound.MinX + bound.Width - 1
+	maxY := bound.MinY + bound.Height - 1
+	distance = x - bound.MinX
+	for _, d := range []int{maxX - x, y - bound.MinY, maxY - y} {
+		if d < distance {
+			distance = d
+		}
+	}
+	halfSize := bound.Width
+	if bound.Height < halfSize {
+		halfSize = bound.Height
+	}
+	halfSize = (halfSize - 1) / 2
+	if halfSize > 0 {
+		relative = float64(distance) / float64(halfSize)
+	} else if distance >= 0 {
+		relative = 1.0
+	}
+	return
+}
+
+// PsdBoundaryFlag describes a PSD that sits suspiciously far from
+// the boundary of its superpixel.
+type PsdBoundaryFlag struct {
+	Location   Point3d
+	Uid        string
+	Body       BodyId
+	Superpixel Superpixel
+	Distance   int
+	Relative   float64
+}
+
+// CheckPsdBoundaryDistances finds the superpixel of each PSD in the given
+// stack and flags PSDs whose relative distance from the edge of their
+// superpixel's bounding rectangle exceeds maxRelative.  This is a cheap
+// proxy for PSDs placed deep inside a body rather than at its membrane.
+func (synapses *JsonSynapses) CheckPsdBoundaryDistances(stack TiledJsonStack,
+	spBoundsMap SuperpixelBoundsMap, maxRelative float64) (
+	flagged []PsdBoundaryFlag) {
+
+	noBounds := 0
+	for _, synapse := range synapses.Data {
+		for _, psd := range synapse.Psds {
+			bodyId, superpixel := GetBodyOfLocation(stack, psd.Location)
+			if superpixel.Label == 0 {
+				continue
+			}
+			bound, found := spBoundsMap[superpixel]
+			if !found {
+				noBounds++
+				continue
+			}
+			distance, relative := bound.BoundaryDistance(psd.Location)
+			if relative > maxRelative {
+				flagged = append(flagged, PsdBoundaryFlag{psd.Location,
+					psd.Uid, bodyId, superpixel, distance, relative})
+			}
+		}
+	}
+	if noBounds > 0 {
+		log.Println("** Warning:", noBounds, "PSD superpixels had no bounds",
+			"and could not be checked for distance to body boundary")
+	}
+	log.Println("Flagged", len(flagged), "PSDs far from superpixel boundary")
+	return
+}
+
+// WritePsdBoundaryCsv writes flagged PSDs in CSV format.
+func WritePsdBoundaryCsv(writer io.Writer, flagged []PsdBoundaryFlag) {
+	csvWriter := csv.NewWriter(writer)
+	record := []string{"X", "Y", "Z", "PSD uid", "Body ID",
+		"Superpixel slice", "Superpixel label", "Distance to boundary",
+		"Relative distance"}
+	err := csvWriter.Write(record)
+	if err != nil {
+		log.Fatalln("ERROR: Unable to write header to CSV:", err)
+	}
+	for _, flag := range flagged {
+		record := []string{
+			flag.Location.X().String(),
+			flag.Location.Y().String(),
+			flag.Location.Z().String(),
+			flag.Uid,
+			flag.Body.String(),
+			strconv.FormatUint(uint64(flag.Superpixel.Slice), 10),
+			strconv.FormatUint(uint64(flag.Superpixel.Label), 10),
+			strconv.Itoa(flag.Distance),
+			strconv.FormatFloat(flag.Relative, 'f', 3, 64)}
+		err := csvWriter.Write(record)
+		if err != nil {
+			log.Fatalln("ERROR: Unable to write line of CSV for PSD",
+				flag.Location, ":", err)
+		}
+	}
+	csvWriter.Flush()
+}
+
+// WritePsdBoundaryCsvFile writes flagged PSDs into a CSV file.
+func WritePsdBoundaryCsvFile(filename string, flagged []PsdBoundaryFlag) {
+	file, err := os.Create(filename)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to create PSD boundary csv file: %s [%s]\n",
+			filename, err)
+	}
+	WritePsdBoundaryCsv(file, flagged)
+	file.Close()
+}
+
+// PsdBoundaryBookmarks returns a bookmark for each flagged PSD.
+func PsdBoundaryBookmarks(flagged []PsdBoundaryFlag) *JsonBookmarks {
+	bookmarks := CreateBookmarks("PSDs far from superpixel boundary")
+	for _, flag := range flagged {
+		bookmarks.Add(flag.Location, flag.Body,
+			fmt.Sprintf("PSD %d pixels from superpixel edge (%.2f)",
+				flag.Distance, flag.Relative))
+	}
+	return bookmarks
+}
+
+// BodyBookmarks returns a bookmark per body in the body set placed at the
+// center of the body's largest superpixel, e.g., for curator review queues.
+// Bookmark text uses the body's name and status from the annotations if
+// available.  Bodies without superpixels in the bounds map are skipped,
+// logged, and returned in sorted order.
+func BodyBookmarks(bodySet BodySet, spBoundsMap SuperpixelBoundsMap,
+	spToBodyMap SuperpixelToBodyMap, annotations BodyAnnotations) (
+	bookmarks *JsonBookmarks, missing BodyIdList) {
+
+	// Find the largest superpixel of each body, breaking ties by the
+	// lowest slice then label so results are reproducible.
+	largest := make(map[BodyId]Superpixel, len(bodySet))
+	for superpixel, bodyId := range spToBodyMap {
+		if !bodySet[bodyId] || superpixel.Label == 0 {
+			continue
+		}
+		bound, found := spBoundsMap[superpixel]
+		if !found {
+			continue
+		}
+		best, found := largest[bodyId]
+		if found {
+			bestBound := spBoundsMap[best]
+			if bound.Volume < bestBound.Volume {
+				continue
+			}
+			if bound.Volume == bestBound.Volume &&
+				(superpixel.Slice > best.Slice ||
+					(superpixel.Slice == best.Slice &&
+						superpixel.Label > best.Label)) {
+				continue
+			}
+		}
+		largest[bodyId] = superpixel
+	}
+
+	bodies := make(BodyIdList, 0, len(bodySet))
+	for bodyId, _ := range bodySet {
+		bodies = append(bodies, bodyId)
+	}
+	sort.Sort(bodies)
+
+	bookmarks = CreateBookmarks("Body review bookmarks")
+	for _, bodyId := range bodies {
+		superpixel, found := largest[bodyId]
+		if !found {
+			missing = append(missing, bodyId)
+			continue
+		}
+		bound := spBoundsMap[superpixel]
+		location := Point3d{
+			VoxelCoord(bound.MinX + bound.Width/2),
+			VoxelCoord(bound.MinY + bound.Height/2),
+			VoxelCoord(superpixel.Slice),
+		}
+		text := fmt.Sprintf("body %d", bodyId)
+		if note, found := annotations[bodyId]; found {
+			if note.Name != "" {
+				text = note.Name
+			}
+			if note.Status != "" {
+				text = fmt.Sprintf("%s (%s)", text, note.Status)
+			}
+		}
+		bookmarks.Add(location, bodyId, text)
+	}
+	if len(missing) > 0 {
+		log.Println("** Warning: No superpixels found for", len(missing),
+			"bodies:", missing)
+	}
+	return
+}
+
+// PartnerHistogram maps a # of partners to the # of T-bars with that
+// many partners.
+type PartnerHistogram map[int]int
+
+// sortedCounts returns the partner counts of a histogram in order.
+func (histogram PartnerHistogram) sortedCounts() []int {
+	numPartners := make([]int, 0, len(histogram))
+	for n, _ := range histogram {
+		numPartners = append(numPartners, n)
+	}
+	sort.Ints(numPartners)
+	return numPartners
+}
+
+// PartnerOutlier is a T-bar with a # of partners outside the expected range.
+type PartnerOutlier struct {
+	Location    Point3d
+	Uid         string
+	Body        BodyId
+	NumPartners int
+}
+
+// PartnerCounts accumulates the distribution of partners per T-bar,
+// overall and per slice, and the T-bars outside a [Min, Max] range.
+// Synapses can be added one at a time so very large synapse files need
+// not be held in memory.
+type PartnerCounts struct {
+	Min      int
+	Max      int
+	Overall  PartnerHistogram
+	BySlice  map[VoxelCoord]PartnerHistogram
+	Outliers []PartnerOutlier
+}
+
+// NewPartnerCounts returns an empty partner distribution that flags
+// T-bars with fewer than min or more than max partners.
+func NewPartnerCounts(min, max int) *PartnerCounts {
+	return &PartnerCounts{
+		Min:     min,
+		Max:     max,
+		Overall: make(PartnerHistogram),
+		BySlice: make(map[VoxelCoord]PartnerHistogram),
+	}
+}
+
+// Add tallies the partners of a synapse.
+func (counts *PartnerCounts) Add(synapse JsonSynapse) {
+	n := len(synapse.Psds)
+	z := synapse.Tbar.Location.Z()
+	counts.Overall[n]++
+	if counts.BySlice[z] == nil {
+		counts.BySlice[z] = make(PartnerHistogram)
+	}
+	counts.BySlice[z][n]++
+	if n < counts.Min || n > counts.Max {
+		counts.Outliers = append(counts.Outliers, PartnerOutlier{
+			synapse.Tbar.Location, synapse.Tbar.Uid, synapse.Tbar.Body, n})
+	}
+}
+
+// PartnerCounts returns the distribution of partners per T-bar and flags
+// T-bars with fewer than min or more than max partners.
+func (synapses *JsonSynapses) PartnerCounts(min, max int) *PartnerCounts {
+	counts := NewPartnerCounts(min, max)
+	for _, synapse := range synapses.Data {
+		counts.Add(synapse)
+	}
+	return counts
+}
+
+// WriteCsv writes the partner histograms in CSV format, with the overall
+// histogram first using "all" for the slice.
+func (counts *PartnerCounts) WriteCsv(writer io.Writer) {
+	csvWriter := csv.NewWriter(writer)
+	err := csvWriter.Write([]string{"Slice", "# partners", "# T-bars"})
+	if err != nil {
+		log.Fatalln("ERROR: Unable to write header to CSV:", err)
+	}
+	writeHistogram := func(slice string, histogram PartnerHistogram) {
+		for _, n := range histogram.sortedCounts() {
+			record := []string{slice, strconv.Itoa(n),
+				strconv.Itoa(histogram[n])}
+			if err := csvWriter.Write(record); err != nil {
+				log.Fatalln("ERROR: Unable to write line of CSV for slice",
+					slice, ":", err)
+			}
+		}
+	}
+	writeHistogram("all", counts.Overall)
+	slices := make([]int, 0, len(counts.BySlice))
+	for z, _ := range counts.BySlice {
+		slices = append(slices, int(z))
+	}
+	sort.Ints(slices)
+	for _, z := range slices {
+		writeHistogram(strconv.Itoa(z), counts.BySlice[VoxelCoord(z)])
+	}
+	csvWriter.Flush()
+}
+
+// WriteCsvFile writes the partner histograms into a CSV file.
+func (counts *PartnerCounts) WriteCsvFile(filename string) {
+	file, err := os.Create(filename)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to create partner count csv file: %s [%s]\n",
+			filename, err)
+	}
+	counts.WriteCsv(file)
+	file.Close()
+}
+
+// WriteOutliersCsv writes T-bars outside the partner range in CSV format.
+func (counts *PartnerCounts) WriteOutliersCsv(writer io.Writer) {
+	csvWriter := csv.NewWriter(writer)
+	record := []string{"X", "Y", "Z", "T-bar uid", "Body ID", "# partners"}
+	err := csvWriter.Write(record)
+	if err != nil {
+		log.Fatalln("ERROR: Unable to write header to CSV:", err)
+	}
+	for _, outlier := range counts.Outliers {
+		record := []string{
+			outlier.Location.X().String(),
+			outlier.Location.Y().String(),
+			outlier.Location.Z().String(),
+			outlier.Uid,
+			outlier.Body.String(),
+			strconv.Itoa(outlier.NumPartners)}
+		err := csvWriter.Write(record)
+		if err != nil {
+			log.Fatalln("ERROR: Unable to write line of CSV for T-bar",
+				outlier.Location, ":", err)
+		}
+	}
+	csvWriter.Flush()
+}
+
+// WriteOutliersCsvFile writes T-bars outside the partner range into a
+// CSV file.
+func (counts *PartnerCounts) WriteOutliersCsvFile(filename string) {
+	file, err := os.Create(filename)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to create partner outlier csv file: %s [%s]\n",
+			filename, err)
+	}
+	counts.WriteOutliersCsv(file)
+	file.Close()
+}
+
+// OutlierBookmarks returns a bookmark for each T-bar outside the
+// partner range.
+func (counts *PartnerCounts) OutlierBookmarks() *JsonBookmarks {
+	bookmarks := CreateBookmarks(fmt.Sprintf(
+		"T-bars with fewer than %d or more than %d partners",
+		counts.Min, counts.Max))
+	for _, outlier := range counts.Outliers {
+		bookmarks.Add(outlier.Location, outlier.Body,
+			fmt.Sprintf("T-bar with %d partners", outlier.NumPartners))
+	}
+	return bookmarks
+}
+
+// TileKeyList is a list of tiles sorted by slice, row, and column.
+type TileKeyList []TileKey
+
+func (list TileKeyList) Len() int {
+	return len(list)
+}
+
+func (list TileKeyList) Swap(i, j int) {
+	list[i], list[j] = list[j], list[i]
+}
+
+func (list TileKeyList) Less(i, j int) bool {
+	if list[i].Slice != list[j].Slice {
+		return list[i].Slice < list[j].Slice
+	}
+	if list[i].Row != list[j].Row {
+		return list[i].Row < list[j].Row
+	}
+	return list[i].Col < list[j].Col
+}
+
+// TileCoverage describes the superpixel tiles needed to assign bodies to
+// a set of synapse locations.
+type TileCoverage struct {
+	Layout   TileLayout
+	Needed   TileKeyList
+	Missing  TileKeyList
+	Affected map[TileKey][]Point3d // T-bar and PSD locations in missing tiles
+}
+
+// SynapseTileCoverage determines the tiles referenced by all T-bar and
+// PSD locations and checks that each exists in the stack.  This is the
+// synapse-driven complement to the whole-stack tile check of RunQC.
+func SynapseTileCoverage(synapses *JsonSynapses,
+	stack TiledJsonStack) (coverage TileCoverage) {
+
+	coverage.Layout = stackTileLayout(stack)
+	coverage.Affected = make(map[TileKey][]Point3d)
+	locations := make(map[TileKey][]Point3d)
+	addLocation := func(pt Point3d) {
+		key := coverage.Layout.TileOf(pt)
+		locations[key] = append(locations[key], pt)
+	}
+	for _, synapse := range synapses.Data {
+		addLocation(synapse.Tbar.Location)
+		for _, psd := range synapse.Psds {
+			addLocation(psd.Location)
+		}
+	}
+	for key, pts := range locations {
+		coverage.Needed = append(coverage.Needed, key)
+		if !tileExists(stack, coverage.Layout.Filename(key)) {
+			coverage.Missing = append(coverage.Missing, key)
+			coverage.Affected[key] = pts
+		}
+	}
+	sort.Sort(coverage.Needed)
+	sort.Sort(coverage.Missing)
+	return
+}
+
+// WriteCsv writes each needed tile, whether it is missing, and the
+// locations within missing tiles.
+func (coverage TileCoverage) WriteCsv(writer io.Writer) {
+	csvWriter := csv.NewWriter(writer)
+	record := []string{"Slice", "Row", "Column", "Tile", "Status",
+		"# locations affected", "Locations"}
+	err := csvWriter.Write(record)
+	if err != nil {
+		log.Fatalln("ERROR: Unable to write header to CSV:", err)
+	}
+	for _, key := range coverage.Needed {
+		status := "present"
+		pts, missing := coverage.Affected[key]
+		if missing {
+			status = "missing"
+		}
+		locations := make([]string, len(pts))
+		for i, pt := range pts {
+			locations[i] = pt.String()
+		}
+		record := []string{
+			key.Slice.String(),
+			strconv.Itoa(key.Row),
+			strconv.Itoa(key.Col),
+			coverage.Layout.Filename(key),
+			status,
+			strconv.Itoa(len(pts)),
+			strings.Join(locations, " ")}
+		err := csvWriter.Write(record)
+		if err != nil {
+			log.Fatalln("ERROR: Unable to write line of CSV for tile",
+				coverage.Layout.Filename(key), ":", err)
+		}
+	}
+	csvWriter.Flush()
+}
+
+// WriteCsvFile writes the tile coverage into a CSV file.
+func (coverage TileCoverage) WriteCsvFile(filename string) {
+	file, err := os.Create(filename)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to create tile coverage csv file: %s [%s]\n",
+			filename, err)
+	}
+	coverage.WriteCsv(file)
+	file.Close()
+}
+
+// WriteGrid writes a compact per-slice grid of needed tiles with rows
+// top to bottom, where 'o' is a present tile, 'X' a missing tile, and
+// '.' a tile not needed.
+func (coverage TileCoverage) WriteGrid(writer io.Writer) error {
+	status := make(map[TileKey]byte, len(coverage.Needed))
+	maxRow, maxCol := 0, 0
+	for _, key := range coverage.Needed {
+		status[key] = 'o'
+		if key.Row > maxRow {
+			maxRow = key.Row
+		}
+		if key.Col > maxCol {
+			maxCol = key.Col
+		}
+	}
+	for _, key := range coverage.Missing {
+		status[key] = 'X'
+	}
+	for i, key := range coverage.Needed {
+		if i > 0 && key.Slice == coverage.Needed[i-1].Slice {
+			continue
+		}
+		if _, err := fmt.Fprintf(writer, "Slice %d:\n", key.Slice); err != nil {
+			return err
+		}
+		line := make([]byte, maxCol+1)
+		for row := 0; row <= maxRow; row++ {
+			for col := 0; col <= maxCol; col++ {
+				line[col] = '.'
+				if c, found := status[TileKey{row, col, key.Slice}]; found {
+					line[col] = c
+				}
+			}
+			if _, err := fmt.Fprintf(writer, "  %s\n", line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WriteTracingAuditCsv writes one line per PSD tracing with the
+// superpixel and location used to find its exported body.  Tracings
+// from older files without this information have empty columns.
+func (synapses *JsonSynapses) WriteTracingAuditCsv(writer io.Writer) {
+	csvWriter := csv.NewWriter(writer)
+	record := []string{"T-bar", "PSD", "Userid", "Result", "Exported body",
+		"Used body radius", "Superpixel slice", "Superpixel label",
+		"Lookup location"}
+	err := csvWriter.Write(record)
+	if err != nil {
+		log.Fatalln("ERROR: Unable to write header to CSV:", err)
+	}
+	for _, synapse := range synapses.Data {
+		for _, psd := range synapse.Psds {
+			for _, tracing := range psd.Tracings {
+				var slice, label, location string
+				if sp := tracing.Superpixel; sp != nil {
+					slice = strconv.FormatUint(uint64(sp.Slice), 10)
+					label = strconv.FormatUint(uint64(sp.Label), 10)
+				}
+				if tracing.LookupLocation != nil {
+					location = tracing.LookupLocation.String()
+				}
+				record := []string{
+					synapse.Tbar.Location.String(),
+					psd.Location.String(),
+					tracing.Userid,
+					tracing.Result.String(),
+					tracing.ExportedBody.String(),
+					strconv.Itoa(tracing.UsedBodyRadius),
+					slice,
+					label,
+					location}
+				err := csvWriter.Write(record)
+				if err != nil {
+					log.Fatalln("ERROR: Unable to write line of CSV for PSD",
+						psd.Location, ":", err)
+				}
+			}
+		}
+	}
+	csvWriter.Flush()
+}
+
+// WriteTracingAuditCsvFile writes the tracing audit into a CSV file.
+func (synapses *JsonSynapses) WriteTracingAuditCsvFile(filename string) {
+	file, err := os.Create(filename)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to create tracing audit csv file: %s [%s]\n",
+			filename, err)
+	}
+	synapses.WriteTracingAuditCsv(file)
+	file.Close()
+}
@@ -0,0 +1,292 @@
+// Copyright 2012 HHMI.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//     * Neither the name of HHMI nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+// Author: katzw@janelia.hhmi.org (Bill Katz)
+//  Written as part of the FlyEM Project at Janelia Farm Research Center.
+
+package emdata
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBoundaryDistance(t *testing.T) {
+	bound := SuperpixelBound{MinX: 10, MinY: 20, Width: 21, Height: 11}
+	tests := []struct {
+		pt       Point3d
+		distance int
+		relative float64
+	}{
+		{Point3d{20, 25, 0}, 5, 1.0},
+		{Point3d{10, 25, 0}, 0, 0.0},
+		{Point3d{12, 25, 0}, 2, 0.4},
+		{Point3d{8, 25, 0}, -2, -0.4},
+	}
+	for _, test := range tests {
+		distance, relative := bound.BoundaryDistance(test.pt)
+		if distance != test.distance || relative != test.relative {
+			t.Errorf("%s: expected %d (%f), got %d (%f)", test.pt,
+				test.distance, test.relative, distance, relative)
+		}
+	}
+}
+
+func TestCheckPsdBoundaryDistances(t *testing.T) {
+	synth, err := CreateSyntheticStack(t.TempDir(), DefaultSyntheticStackParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	superpixel := Superpixel{1, 6}
+	bound := synth.SpBoundsMap[superpixel]
+	centered := synth.Center(superpixel)
+	nearEdge := Point3d{VoxelCoord(bound.MinX + 1), centered[1], centered[2]}
+	synapses := &JsonSynapses{Data: []JsonSynapse{{
+		Tbar: JsonTbar{Location: synth.Center(Superpixel{1, 1})},
+		Psds: []JsonPsd{
+			{Location: centered, Uid: "centered"},
+			{Location: nearEdge, Uid: "near edge"},
+		},
+	}}}
+
+	flagged := synapses.CheckPsdBoundaryDistances(&synth.BaseStack,
+		synth.SpBoundsMap, 0.5)
+	if len(flagged) != 1 {
+		t.Fatalf("expected 1 flagged PSD, got %v", flagged)
+	}
+	flag := flagged[0]
+	if flag.Uid != "centered" || flag.Superpixel != superpixel ||
+		flag.Body != synth.SpToBodyMap[superpixel] || flag.Relative < 0.9 {
+		t.Errorf("expected centered PSD in superpixel %v, got %+v",
+			superpixel, flag)
+	}
+
+	var buf bytes.Buffer
+	WritePsdBoundaryCsv(&buf, flagged)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], ",centered,") {
+		t.Errorf("unexpected CSV:\n%s", buf.String())
+	}
+	if bookmarks := PsdBoundaryBookmarks(flagged); len(bookmarks.Data) != 1 {
+		t.Errorf("expected 1 bookmark, got %d", len(bookmarks.Data))
+	}
+}
+
+func TestBodyBookmarks(t *testing.T) {
+	spToBodyMap := SuperpixelToBodyMap{
+		Superpixel{1, 1}: 10,
+		Superpixel{2, 3}: 10,
+		Superpixel{2, 5}: 20,
+		Superpixel{1, 6}: 20,
+		Superpixel{1, 0}: 30,
+		Superpixel{3, 7}: 40,
+	}
+	spBoundsMap := SuperpixelBoundsMap{
+		Superpixel{1, 1}: SuperpixelBound{0, 0, 10, 10, 50},
+		Superpixel{2, 3}: SuperpixelBound{20, 30, 8, 12, 80},
+		Superpixel{2, 5}: SuperpixelBound{40, 40, 6, 6, 30},
+		Superpixel{1, 6}: SuperpixelBound{60, 10, 6, 6, 30},
+		Superpixel{1, 0}: SuperpixelBound{0, 0, 100, 100, 10000},
+		Superpixel{3, 7}: SuperpixelBound{0, 0, 4, 4, 16},
+	}
+	annotations := BodyAnnotations{
+		10: JsonBody{Body: 10, Status: "Anchor", Name: "Mi1"},
+	}
+	bodySet := BodySet{10: true, 20: true, 30: true}
+	bookmarks, missing := BodyBookmarks(bodySet, spBoundsMap, spToBodyMap,
+		annotations)
+
+	if len(missing) != 1 || missing[0] != 30 {
+		t.Errorf("expected body 30 missing, got %v", missing)
+	}
+	expected := []JsonBookmark{
+		{Point3d{24, 36, 2}, 10, "Mi1 (Anchor)"},
+		{Point3d{63, 13, 1}, 20, "body 20"},
+	}
+	if len(bookmarks.Data) != len(expected) {
+		t.Fatalf("expected %d bookmarks, got %v", len(expected), bookmarks.Data)
+	}
+	for i, bookmark := range bookmarks.Data {
+		if bookmark != expected[i] {
+			t.Errorf("bookmark %d: expected %v, got %v", i, expected[i],
+				bookmark)
+		}
+		superpixel := Superpixel{uint32(bookmark.Location.Z()), 0}
+		for sp, bodyId := range spToBodyMap {
+			if bodyId == bookmark.Body && sp.Slice == superpixel.Slice &&
+				sp.Label != 0 {
+				superpixel = sp
+			}
+		}
+		bound := spBoundsMap[superpixel]
+		x, y := int(bookmark.Location.X()), int(bookmark.Location.Y())
+		if x < bound.MinX || x >= bound.MinX+bound.Width ||
+			y < bound.MinY || y >= bound.MinY+bound.Height {
+			t.Errorf("bookmark %v outside superpixel %v bounds %v",
+				bookmark.Location, superpixel, bound)
+		}
+	}
+}
+
+func TestPartnerCounts(t *testing.T) {
+	synapseWith := func(location Point3d, uid string, body BodyId,
+		partners int) JsonSynapse {
+		synapse := JsonSynapse{Tbar: JsonTbar{Location: location, Uid: uid,
+			Body: body}}
+		for i := 0; i < partners; i++ {
+			synapse.Psds = append(synapse.Psds, JsonPsd{
+				Location: Point3d{location[0] + VoxelCoord(i), location[1],
+					location[2]}})
+		}
+		return synapse
+	}
+	synapses := &JsonSynapses{Data: []JsonSynapse{
+		synapseWith(Point3d{10, 20, 1}, "t1", 5, 1),
+		synapseWith(Point3d{50, 60, 1}, "t2", 6, 4),
+		synapseWith(Point3d{30, 40, 2}, "t3", 7, 12),
+		synapseWith(Point3d{70, 80, 2}, "t4", 8, 4),
+	}}
+	counts := synapses.PartnerCounts(2, 8)
+
+	if !reflect.DeepEqual(counts.Overall, PartnerHistogram{1: 1, 4: 2, 12: 1}) {
+		t.Errorf("unexpected overall histogram: %v", counts.Overall)
+	}
+	expectedSlices := map[VoxelCoord]PartnerHistogram{
+		1: {1: 1, 4: 1},
+		2: {4: 1, 12: 1},
+	}
+	if !reflect.DeepEqual(counts.BySlice, expectedSlices) {
+		t.Errorf("expected slice histograms %v, got %v", expectedSlices,
+			counts.BySlice)
+	}
+	expectedOutliers := []PartnerOutlier{
+		{Point3d{10, 20, 1}, "t1", 5, 1},
+		{Point3d{30, 40, 2}, "t3", 7, 12},
+	}
+	if !reflect.DeepEqual(counts.Outliers, expectedOutliers) {
+		t.Errorf("expected outliers %v, got %v", expectedOutliers,
+			counts.Outliers)
+	}
+
+	var buf bytes.Buffer
+	counts.WriteCsv(&buf)
+	expected := "Slice,# partners,# T-bars\n" +
+		"all,1,1\nall,4,2\nall,12,1\n" +
+		"1,1,1\n1,4,1\n" +
+		"2,4,1\n2,12,1\n"
+	if buf.String() != expected {
+		t.Errorf("expected histogram CSV:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	buf.Reset()
+	counts.WriteOutliersCsv(&buf)
+	expected = "X,Y,Z,T-bar uid,Body ID,# partners\n" +
+		"10,20,1,t1,5,1\n" +
+		"30,40,2,t3,7,12\n"
+	if buf.String() != expected {
+		t.Errorf("expected outlier CSV:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	bookmarks := counts.OutlierBookmarks()
+	if len(bookmarks.Data) != 2 ||
+		bookmarks.Data[1] != (JsonBookmark{Point3d{30, 40, 2}, 7,
+			"T-bar with 12 partners"}) {
+		t.Errorf("unexpected outlier bookmarks: %v", bookmarks.Data)
+	}
+}
+
+func TestSynapseTileCoverage(t *testing.T) {
+	synth, err := CreateSyntheticStack(t.TempDir(), DefaultSyntheticStackParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	missingKey := TileKey{Row: 1, Col: 0, Slice: 2}
+	tile := filepath.Join(synth.Directory, synth.Tiles.Filename(missingKey))
+	if err := os.Remove(tile); err != nil {
+		t.Fatal(err)
+	}
+	synapses := &JsonSynapses{Data: []JsonSynapse{
+		{Tbar: JsonTbar{Location: Point3d{10, 10, 0}},
+			Psds: []JsonPsd{{Location: Point3d{140, 10, 0}}}},
+		{Tbar: JsonTbar{Location: Point3d{20, 200, 2}},
+			Psds: []JsonPsd{{Location: Point3d{30, 210, 2}},
+				{Location: Point3d{200, 200, 2}}}},
+	}}
+
+	coverage := SynapseTileCoverage(synapses, &synth.BaseStack)
+	expectedNeeded := TileKeyList{{0, 0, 0}, {0, 1, 0}, {1, 0, 2}, {1, 1, 2}}
+	if !reflect.DeepEqual(coverage.Needed, expectedNeeded) {
+		t.Errorf("expected needed tiles %v, got %v", expectedNeeded,
+			coverage.Needed)
+	}
+	if !reflect.DeepEqual(coverage.Missing, TileKeyList{missingKey}) {
+		t.Errorf("expected missing tile %v, got %v", missingKey,
+			coverage.Missing)
+	}
+	expectedAffected := map[TileKey][]Point3d{
+		missingKey: {{20, 200, 2}, {30, 210, 2}},
+	}
+	if !reflect.DeepEqual(coverage.Affected, expectedAffected) {
+		t.Errorf("expected affected locations %v, got %v", expectedAffected,
+			coverage.Affected)
+	}
+	expected := "4 tiles needed, 1 missing (25.0%), 2 locations affected"
+	if summary := coverage.Summary(); summary != expected {
+		t.Errorf("expected summary %q, got %q", expected, summary)
+	}
+
+	var buf bytes.Buffer
+	if err := coverage.WriteGrid(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected = "Slice 0:\n  oo\n  ..\nSlice 2:\n  ..\n  Xo\n"
+	if buf.String() != expected {
+		t.Errorf("expected grid:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	buf.Reset()
+	coverage.WriteCsv(&buf)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 5 || !strings.HasPrefix(lines[3],
+		"2,1,0,"+synth.Tiles.Filename(missingKey)+",missing,2,") {
+		t.Errorf("unexpected coverage CSV:\n%s", buf.String())
+	}
+
+	// An export without its own tiles uses the base stack's tiles.
+	exported := CreateExportedStack(t.TempDir(), synth.Directory)
+	exported.Base.Tiles = synth.Tiles
+	exportedCoverage := SynapseTileCoverage(synapses, exported)
+	if !reflect.DeepEqual(exportedCoverage.Missing, coverage.Missing) {
+		t.Errorf("export: expected missing tiles %v, got %v",
+			coverage.Missing, exportedCoverage.Missing)
+	}
+}
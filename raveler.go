@@ -33,13 +33,22 @@ package emdata
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"reflect"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"image"
 	"image/color"
@@ -56,8 +65,8 @@ const (
 // breaks a unique superpixel id into two components: a slice and a
 // unique label within that slice.
 type Superpixel struct {
-	Slice uint32
-	Label uint32
+	Slice uint32 `json:"slice"`
+	Label uint32 `json:"label"`
 }
 
 // SuperpixelBound holds the top left 2d coord, width, height, 
@@ -76,51 +85,406 @@ type Superpixels []Superpixel
 // SuperpixelBoundMap maps a superpixel to its bounds
 type SuperpixelBoundsMap map[Superpixel]SuperpixelBound
 
+// GzipSuffix is appended to text file names compressed with gzip.
+const GzipSuffix = ".gz"
+
+// textFilename returns the given file name if it exists, else its gzip
+// sibling if that exists, else the given file name.
+func textFilename(filename string) string {
+	if _, err := os.Stat(filename); err != nil &&
+		!strings.HasSuffix(filename, GzipSuffix) {
+
+		if _, err := os.Stat(filename + GzipSuffix); err == nil {
+			return filename + GzipSuffix
+		}
+	}
+	return filename
+}
+
+// gzipFile closes both a gzip reader and its underlying file.
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (gzipped gzipFile) Close() error {
+	gzipped.Reader.Close()
+	return gzipped.file.Close()
+}
+
+// openTextFile opens a text file, or its gzip sibling if the file is
+// absent, decompressing files whose name ends in GzipSuffix.  The name
+// of the opened file is returned.
+func openTextFile(filename string) (reader io.ReadCloser, opened string,
+	err error) {
+
+	opened = textFilename(filename)
+	file, err := os.Open(opened)
+	if err != nil {
+		return
+	}
+	if !strings.HasSuffix(opened, GzipSuffix) {
+		return file, opened, nil
+	}
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, opened, fmt.Errorf("%s: %s", opened, err)
+	}
+	return gzipFile{gzipReader, file}, opened, nil
+}
+
+// gzipFilename returns the file name with GzipSuffix appended if it
+// does not already end in it.
+func gzipFilename(filename string) string {
+	if strings.HasSuffix(filename, GzipSuffix) {
+		return filename
+	}
+	return filename + GzipSuffix
+}
+
+// gzipWriteFile closes both a gzip writer and its underlying file.
+type gzipWriteFile struct {
+	*gzip.Writer
+	file *os.File
+}
+
+func (gzipped gzipWriteFile) Close() error {
+	err := gzipped.Writer.Close()
+	if closeErr := gzipped.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// createTextFile creates a file, compressing what is written with gzip
+// if the name ends in GzipSuffix.
+func createTextFile(filename string) (io.WriteCloser, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(filename, GzipSuffix) {
+		return file, nil
+	}
+	return gzipWriteFile{gzip.NewWriter(file), file}, nil
+}
+
 // ReadSuperpixelBounds loads a superpixel bounds file and limits
 // returned superpixels to those in the passed-in superpixelSet.
 // If superpixelSet is empty, then all superpixels are returned.
+// A gzipped file is read if the name ends in GzipSuffix or only
+// the gzipped file exists.
 func ReadSuperpixelBounds(filename string, superpixelSet map[Superpixel]bool) (
 	spBoundsMap SuperpixelBoundsMap, err error) {
 
-	log.Println("Loading superpixel bounds:\n", filename)
+	return ReadSuperpixelBoundsWithProgress(filename, superpixelSet, nil)
+}
 
-	file, err := os.Open(filename)
+// ReadSuperpixelBoundsWithProgress is like ReadSuperpixelBounds but
+// periodically calls progress, if non-nil, with the lines read so far.
+func ReadSuperpixelBoundsWithProgress(filename string,
+	superpixelSet map[Superpixel]bool, progress ProgressFunc) (
+	spBoundsMap SuperpixelBoundsMap, err error) {
+
+	spBoundsMap, warnings, err := readSuperpixelBoundsFile(filename,
+		superpixelSet, progress)
+	warnings.Log()
+	return
+}
+
+// ReadSuperpixelBoundsWithWarnings is like ReadSuperpixelBounds but
+// returns the anomalies found in Lenient mode instead of logging them.
+func ReadSuperpixelBoundsWithWarnings(filename string,
+	superpixelSet map[Superpixel]bool) (spBoundsMap SuperpixelBoundsMap,
+	warnings Warnings, err error) {
+
+	return readSuperpixelBoundsFile(filename, superpixelSet, nil)
+}
+
+func readSuperpixelBoundsFile(filename string,
+	superpixelSet map[Superpixel]bool, progress ProgressFunc) (
+	spBoundsMap SuperpixelBoundsMap, warnings Warnings, err error) {
+
+	file, filename, err := openTextFile(filename)
 	if err != nil {
 		log.Printf("Could not open superpixel bounds: %s\n", filename)
 		return
 	}
 	defer file.Close()
+	log.Println("Loading superpixel bounds:\n", filename)
+	spBoundsMap, warnings, err = readSuperpixelBoundsFrom(file, superpixelSet,
+		lineProgress{progress, "superpixel bounds",
+			InitialSuperpixelToBodyMapSize(filepath.Dir(filename))})
+	if err != nil {
+		err = fmt.Errorf("%s: %s", filename, err)
+	}
+	return
+}
+
+// readTextLine returns the next line of text with leading and trailing
+// white space, including LF or CRLF line endings, removed.  A final line
+// without an ending is returned normally; io.EOF is only returned once
+// no text remains.
+func readTextLine(reader *bufio.Reader) (line string, err error) {
+	line, err = reader.ReadString('\n')
+	if err == io.EOF && len(line) > 0 {
+		err = nil
+	}
+	line = strings.TrimSpace(line)
+	return
+}
+
+// maxLineLength is the longest map or bounds line that can be scanned.
+const maxLineLength = 1024 * 1024
+
+// newLineScanner returns a scanner over the lines of a reader.  Scanned
+// lines keep any leading and trailing white space other than LF or CRLF
+// line endings.
+func newLineScanner(reader io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxLineLength)
+	return scanner
+}
+
+// skipTextLine returns true for blank and comment lines.
+func skipTextLine(line []byte) bool {
+	return len(line) == 0 || line[0] == '#'
+}
+
+// isFieldSpace returns true for bytes that separate integer fields.
+func isFieldSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\v' || c == '\f'
+}
+
+// scanIntFields parses the leading white space separated decimal
+// integers of a line into values.  Like fmt.Sscanf with "%d %d ...",
+// anything after the last integer, including text such as "abc" or
+// ".5" that directly follows it, is ignored.  It avoids the reflection
+// of fmt.Sscanf, which dominates the time to read large maps.
+func scanIntFields(line []byte, values []int64) error {
+	pos := 0
+	for i := range values {
+		for pos < len(line) && isFieldSpace(line[pos]) {
+			pos++
+		}
+		if pos == len(line) {
+			return fmt.Errorf("expected %d integers, found %d",
+				len(values), i)
+		}
+		negative := line[pos] == '-'
+		if negative || line[pos] == '+' {
+			pos++
+		}
+		start := pos
+		var value uint64
+		for pos < len(line) && '0' <= line[pos] && line[pos] <= '9' {
+			digit := uint64(line[pos] - '0')
+			if value > (math.MaxInt64-digit)/10 {
+				return fmt.Errorf("integer %d out of range", i+1)
+			}
+			value = value*10 + digit
+			pos++
+		}
+		last := i == len(values)-1
+		if pos == start || (!last && pos < len(line) && !isFieldSpace(line[pos])) {
+			return fmt.Errorf("expected integer for field %d", i+1)
+		}
+		values[i] = int64(value)
+		if negative {
+			values[i] = -values[i]
+		}
+	}
+	return nil
+}
+
+// checkUint32Fields returns an error if any value does not fit a uint32.
+func checkUint32Fields(values []int64) error {
+	for i, value := range values {
+		if value < 0 || value > math.MaxUint32 {
+			return fmt.Errorf("integer %d out of range", i+1)
+		}
+	}
+	return nil
+}
+
+// ProgressFunc is called periodically during long loads with the stage
+// being read, the number of lines read so far and an approximate total,
+// which may be exceeded.
+type ProgressFunc func(stage string, linesRead, approxTotal int)
+
+// ProgressInterval is the number of lines read between calls to a
+// ProgressFunc.
+const ProgressInterval = 100000
+
+// lineProgress reports lines read to an optional ProgressFunc.
+type lineProgress struct {
+	fn    ProgressFunc
+	stage string
+	total int
+}
+
+// report calls the ProgressFunc, if any, every ProgressInterval lines.
+func (progress lineProgress) report(linenum int) {
+	if progress.fn != nil && linenum%ProgressInterval == 0 {
+		progress.fn(progress.stage, linenum, progress.total)
+	}
+}
+
+// done calls the ProgressFunc, if any, with the final line count.
+func (progress lineProgress) done(linenum int) {
+	if progress.fn != nil {
+		progress.fn(progress.stage, linenum, progress.total)
+	}
+}
+
+// ReadSuperpixelBoundsFrom loads superpixel bounds from a reader and
+// limits returned superpixels to those in the passed-in superpixelSet.
+// If superpixelSet is empty, then all superpixels are returned.
+func ReadSuperpixelBoundsFrom(reader io.Reader,
+	superpixelSet map[Superpixel]bool) (
+	spBoundsMap SuperpixelBoundsMap, err error) {
+
+	spBoundsMap, warnings, err := readSuperpixelBoundsFrom(reader,
+		superpixelSet, lineProgress{})
+	warnings.Log()
+	return
+}
+
+// ReadSuperpixelBoundsFromWithWarnings is like ReadSuperpixelBoundsFrom
+// but returns the anomalies found in Lenient mode instead of logging them.
+func ReadSuperpixelBoundsFromWithWarnings(reader io.Reader,
+	superpixelSet map[Superpixel]bool) (spBoundsMap SuperpixelBoundsMap,
+	warnings Warnings, err error) {
+
+	return readSuperpixelBoundsFrom(reader, superpixelSet, lineProgress{})
+}
+
+func readSuperpixelBoundsFrom(reader io.Reader,
+	superpixelSet map[Superpixel]bool, progress lineProgress) (
+	spBoundsMap SuperpixelBoundsMap, warnings Warnings, err error) {
+
 	spBoundsMap = make(SuperpixelBoundsMap)
 	linenum := 0
-	lineReader := bufio.NewReader(file)
+	scanner := newLineScanner(reader)
 	alwaysSetSuperpixel := len(superpixelSet) == 0
-	for {
-		line, err := lineReader.ReadString('\n')
-		if err != nil {
-			break
-		}
+	var fields [7]int64
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
 		linenum++
-		if line[0] == ' ' || line[0] == '#' || line[0] == '\n' {
+		progress.report(linenum)
+		if skipTextLine(line) {
 			continue
 		}
-		var superpixel Superpixel
-		var bounds SuperpixelBound
-		_, err = fmt.Sscanf(line, "%d %d %d %d %d %d %d",
-			&superpixel.Slice, &superpixel.Label,
-			&bounds.MinX, &bounds.MinY, &bounds.Width, &bounds.Height,
-			&bounds.Volume)
+		err := scanIntFields(line, fields[:])
+		if err == nil {
+			err = checkUint32Fields(fields[:2])
+		}
 		if err != nil {
-			log.Fatalf("FATAL ERROR: Cannot parse line %d in %s: %s",
-				linenum, filename, err)
+			anomaly := DefaultStrictness.Note(&warnings, "malformed bounds line",
+				"cannot parse line %d (%q): %s", linenum, line, err)
+			if anomaly != nil {
+				return nil, warnings, anomaly
+			}
+			continue
 		}
+		superpixel := Superpixel{uint32(fields[0]), uint32(fields[1])}
 		if alwaysSetSuperpixel || superpixelSet[superpixel] {
-			spBoundsMap[superpixel] = bounds
+			spBoundsMap[superpixel] = SuperpixelBound{
+				MinX:   int(fields[2]),
+				MinY:   int(fields[3]),
+				Width:  int(fields[4]),
+				Height: int(fields[5]),
+				Volume: int(fields[6]),
+			}
 		}
 	}
+	if err = scanner.Err(); err != nil {
+		return nil, warnings, err
+	}
+	progress.done(linenum)
 	return
 }
 
-// SuperpixelToBodyMap holds Superpixel -> Body Id mappings
+// Len, Swap and Less implement sort.Interface, ordering superpixels
+// by slice then label.
+func (superpixels Superpixels) Len() int {
+	return len(superpixels)
+}
+
+func (superpixels Superpixels) Swap(i, j int) {
+	superpixels[i], superpixels[j] = superpixels[j], superpixels[i]
+}
+
+func (superpixels Superpixels) Less(i, j int) bool {
+	if superpixels[i].Slice != superpixels[j].Slice {
+		return superpixels[i].Slice < superpixels[j].Slice
+	}
+	return superpixels[i].Label < superpixels[j].Label
+}
+
+// MergeFrom adds the superpixel bounds of another map.  Superpixels
+// already present are only replaced if overwrite is true.
+func (spBoundsMap SuperpixelBoundsMap) MergeFrom(other SuperpixelBoundsMap,
+	overwrite bool) {
+
+	for superpixel, bounds := range other {
+		if _, found := spBoundsMap[superpixel]; found && !overwrite {
+			continue
+		}
+		spBoundsMap[superpixel] = bounds
+	}
+}
+
+// RemoveSlices deletes all superpixel bounds in the given slices.
+func (spBoundsMap SuperpixelBoundsMap) RemoveSlices(slices []uint32) {
+	sliceSet := make(map[uint32]bool, len(slices))
+	for _, slice := range slices {
+		sliceSet[slice] = true
+	}
+	for superpixel, _ := range spBoundsMap {
+		if sliceSet[superpixel.Slice] {
+			delete(spBoundsMap, superpixel)
+		}
+	}
+}
+
+// WriteBounds writes superpixel bounds in the superpixel bounds file
+// format sorted by slice then label.
+func (spBoundsMap SuperpixelBoundsMap) WriteBounds(writer io.Writer) {
+	superpixels := make(Superpixels, 0, len(spBoundsMap))
+	for superpixel, _ := range spBoundsMap {
+		superpixels = append(superpixels, superpixel)
+	}
+	sort.Sort(superpixels)
+	lineWriter := bufio.NewWriter(writer)
+	for _, superpixel := range superpixels {
+		bounds := spBoundsMap[superpixel]
+		_, err := fmt.Fprintf(lineWriter, "%d %d %d %d %d %d %d\n",
+			superpixel.Slice, superpixel.Label, bounds.MinX, bounds.MinY,
+			bounds.Width, bounds.Height, bounds.Volume)
+		if err != nil {
+			log.Fatalln("ERROR: Unable to write superpixel bounds:", err)
+		}
+	}
+	if err := lineWriter.Flush(); err != nil {
+		log.Fatalln("ERROR: Unable to write superpixel bounds:", err)
+	}
+}
+
+// WriteBoundsFile writes a superpixel bounds file.
+func (spBoundsMap SuperpixelBoundsMap) WriteBoundsFile(filename string) {
+	file, err := os.Create(filename)
+	if err != nil {
+		log.Fatalf("FATAL ERROR: Could not create %s: %s", filename, err)
+	}
+	spBoundsMap.WriteBounds(file)
+	file.Close()
+}
+
+// SuperpixelToBodyMap holds Superpixel -> Body Id mappings.  A
+// Superpixel key is two uint32 fields without padding, so it takes the
+// same 8 bytes per entry as a packed uint64 slice<<32 | label key.
 type SuperpixelToBodyMap map[Superpixel]BodyId
 
 // Duplicate returns a copy of the given superpixel->body map
@@ -132,9 +496,136 @@ func (spToBodyMap SuperpixelToBodyMap) Duplicate() SuperpixelToBodyMap {
 	return newMap
 }
 
+// ApplyMerges relabels each absorbed body in merges with the body that
+// survives it, following chains like a->b->c to the final body, and
+// returns the number of superpixels whose body changed.  Self-merges are
+// ignored.  If merges holds a cycle, the map is left unchanged and an
+// error is returned.
+func (spToBodyMap SuperpixelToBodyMap) ApplyMerges(
+	merges map[BodyId]BodyId) (changed int, err error) {
+
+	// Resolve each absorbed body to its final body before relabeling.
+	final := make(map[BodyId]BodyId, len(merges))
+	for absorbed, _ := range merges {
+		visited := BodySet{absorbed: true}
+		body := absorbed
+		for {
+			next, found := merges[body]
+			if !found || next == body {
+				break
+			}
+			if visited[next] {
+				return 0, fmt.Errorf("merge cycle involving body %d", next)
+			}
+			visited[next] = true
+			body = next
+		}
+		if body != absorbed {
+			final[absorbed] = body
+		}
+	}
+	for superpixel, bodyId := range spToBodyMap {
+		if survivor, found := final[bodyId]; found {
+			spToBodyMap[superpixel] = survivor
+			changed++
+		}
+	}
+	return changed, nil
+}
+
+// ApplySplit assigns each superpixel in newAssignments to its listed
+// body and returns the set of bodies that gained or lost superpixels.
+// Superpixels not listed keep their current bodies.
+func (spToBodyMap SuperpixelToBodyMap) ApplySplit(
+	newAssignments SuperpixelToBodyMap) (changedBodies BodySet) {
+
+	changedBodies = make(BodySet)
+	for superpixel, newBody := range newAssignments {
+		oldBody, found := spToBodyMap[superpixel]
+		if found && oldBody == newBody {
+			continue
+		}
+		if found {
+			changedBodies[oldBody] = true
+		}
+		changedBodies[newBody] = true
+		spToBodyMap[superpixel] = newBody
+	}
+	return changedBodies
+}
+
+// RemapBodies returns a new map in which each body that is a key of
+// merges is replaced by its mapped body.  Unlike ApplyMerges, chains are
+// not followed and the original map is left unchanged.
+func (spToBodyMap SuperpixelToBodyMap) RemapBodies(
+	merges map[BodyId]BodyId) SuperpixelToBodyMap {
+
+	newMap := make(SuperpixelToBodyMap, len(spToBodyMap))
+	for superpixel, bodyId := range spToBodyMap {
+		if newBody, found := merges[bodyId]; found {
+			bodyId = newBody
+		}
+		newMap[superpixel] = bodyId
+	}
+	return newMap
+}
+
+// Merge returns a new map holding the superpixels of both maps and the
+// sorted superpixels that the two maps assign to different bodies.
+// Conflicting superpixels keep the body from this map.
+func (spToBodyMap SuperpixelToBodyMap) Merge(other SuperpixelToBodyMap) (
+	SuperpixelToBodyMap, []Superpixel) {
+
+	newMap := spToBodyMap.Duplicate()
+	var conflicts Superpixels
+	for superpixel, otherBody := range other {
+		bodyId, found := newMap[superpixel]
+		if !found {
+			newMap[superpixel] = otherBody
+		} else if bodyId != otherBody {
+			conflicts = append(conflicts, superpixel)
+		}
+	}
+	sort.Sort(conflicts)
+	return newMap, conflicts
+}
+
+// Invert returns the body->superpixels map for all bodies, with each
+// body's superpixels sorted by slice then label.
+func (spToBodyMap SuperpixelToBodyMap) Invert() BodyToSuperpixelsMap {
+	return spToBodyMap.InvertWhere(nil)
+}
+
+// InvertWhere is like Invert but only includes the superpixel->body
+// mappings for which pred returns true.  A nil pred includes all.
+func (spToBodyMap SuperpixelToBodyMap) InvertWhere(
+	pred func(Superpixel, BodyId) bool) BodyToSuperpixelsMap {
+
+	bodyToSpMap := make(BodyToSuperpixelsMap)
+	for superpixel, bodyId := range spToBodyMap {
+		if pred == nil || pred(superpixel, bodyId) {
+			bodyToSpMap[bodyId] = append(bodyToSpMap[bodyId], superpixel)
+		}
+	}
+	for _, superpixels := range bodyToSpMap {
+		sort.Sort(superpixels)
+	}
+	return bodyToSpMap
+}
+
 // BodyToSuperpixelMap holds Body Id -> Superpixel mappings
 type BodyToSuperpixelsMap map[BodyId]Superpixels
 
+// Bodies returns the sorted body ids in the map.
+func (bodyToSpMap BodyToSuperpixelsMap) Bodies() []BodyId {
+	bodies := make(BodyIdList, 0, len(bodyToSpMap))
+	for bodyId, _ := range bodyToSpMap {
+		bodies = append(bodies, bodyId)
+	}
+	sort.Sort(bodies)
+	return bodies
+}
+
 // SuperpixelFormat notes whether superpixel ids, if present, 
 // are in 16-bit or 24-bit values.
 type SuperpixelFormat uint8
@@ -146,6 +637,16 @@ const (
 	Superpixel24Bits SuperpixelFormat = iota
 )
 
+func (format SuperpixelFormat) String() string {
+	switch format {
+	case Superpixel16Bits:
+		return "16-bit"
+	case Superpixel24Bits:
+		return "24-bit"
+	}
+	return "none"
+}
+
 // SuperpixelImage is an image with each pixel encoding a unique
 // superpixel id for that plane.  Superpixel values must be
 // 16-bit grayscale or 32-bit RGBA.
@@ -183,92 +684,323 @@ func GetSuperpixelId(superpixels SuperpixelImage, x int, y int,
 
 // ReadTxtMaps reads superpixel->segment and segment->body map
 // .txt files from a stack directory and returns a superpixel->body map.
-func ReadTxtMaps(stackPath string) (spToBodyMap SuperpixelToBodyMap) {
-	waitchan := make(chan bool)
+// Malformed lines and non-zero superpixels whose segment is missing from
+// the segment->body map return an error in Strict mode.  In Lenient
+// mode, malformed lines are skipped and unknown segments map to body 0,
+// each with a warning.  Use StreamTxtMaps to visit each mapping
+// without holding the whole superpixel->body map in memory.
+func ReadTxtMaps(stackPath string) (spToBodyMap SuperpixelToBodyMap, err error) {
+	spToBodyMap, _, err = ReadTxtMapsWithFallback(stackPath, "")
+	return
+}
+
+// ReadTxtMapsWithWarnings is like ReadTxtMaps but returns the anomalies
+// found in Lenient mode instead of logging them.
+func ReadTxtMapsWithWarnings(stackPath string) (
+	spToBodyMap SuperpixelToBodyMap, warnings Warnings, err error) {
+
+	spToBodyMap, _, warnings, err = readTxtMaps(stackPath, "", nil)
+	return
+}
+
+// TxtMapSources gives the directory each map .txt file was read from.
+type TxtMapSources struct {
+	SuperpixelToSegment string
+	SegmentToBody       string
+}
+
+// txtMapDir returns the stack path if it has the given map file or no
+// fallback is given, else the fallback path.
+func txtMapDir(stackPath, fallbackPath, mapFilename string) string {
+	if fallbackPath == "" {
+		return stackPath
+	}
+	filename := textFilename(filepath.Join(stackPath, mapFilename))
+	if _, err := os.Stat(filename); err == nil {
+		return stackPath
+	}
+	return fallbackPath
+}
+
+// ReadTxtMapsWithFallback is like ReadTxtMaps but reads either map file
+// from the fallback directory, e.g., a base stack, if the stack directory
+// lacks it.  An empty fallback path reads only from the stack directory.
+func ReadTxtMapsWithFallback(stackPath, fallbackPath string) (
+	spToBodyMap SuperpixelToBodyMap, sources TxtMapSources, err error) {
+
+	spToBodyMap, sources, warnings, err := readTxtMaps(stackPath,
+		fallbackPath, nil)
+	warnings.Log()
+	return
+}
+
+// ReadTxtMapsWithProgress is like ReadTxtMaps but periodically calls
+// progress, if non-nil, with the lines read so far from each map file.
+func ReadTxtMapsWithProgress(stackPath string, progress ProgressFunc) (
+	spToBodyMap SuperpixelToBodyMap, err error) {
+
+	spToBodyMap, _, warnings, err := readTxtMaps(stackPath, "", progress)
+	warnings.Log()
+	return
+}
+
+func readTxtMaps(stackPath, fallbackPath string, progress ProgressFunc) (
+	spToBodyMap SuperpixelToBodyMap, sources TxtMapSources,
+	warnings Warnings, err error) {
 
-	// Load superpixel to segment map
 	spToBodyMapSize := InitialSuperpixelToBodyMapSize(stackPath)
 	spToBodyMap = make(SuperpixelToBodyMap, spToBodyMapSize)
 	log.Println("  -- Initializing superpixel->body map to initial size",
 		spToBodyMapSize)
-	go func() {
-		filename := filepath.Join(stackPath, SuperpixelToSegmentFilename)
-		log.Println("Loading superpixel->segment map for stack:\n",
-			filename)
-		file, err := os.Open(filename)
-		if err != nil {
-			log.Fatalf("FATAL ERROR: Could not open %s: %s", filename, err)
-		}
-		defer file.Close()
-		linenum := 0
-		lineReader := bufio.NewReader(file)
-		for {
-			line, err := lineReader.ReadString('\n')
-			if err != nil {
-				break
-			}
-			if line[0] == ' ' || line[0] == '#' {
-				continue
+	sources, warnings, err = streamTxtMaps(stackPath, fallbackPath, progress,
+		func(superpixel Superpixel, body BodyId) error {
+			spToBodyMap[superpixel] = body
+			return nil
+		})
+	if err != nil {
+		return nil, sources, warnings, err
+	}
+	log.Println("Maps loaded and computed.")
+	return
+}
+
+// StreamTxtMaps calls fn with the body of each superpixel listed in a
+// stack's superpixel->segment map.  Only the much smaller segment->body
+// map is held in memory.  A superpixel listed more than once is passed
+// to fn each time.  Iteration stops at the first error returned by fn,
+// which is then returned.
+func StreamTxtMaps(stackPath string,
+	fn func(Superpixel, BodyId) error) error {
+
+	_, err := StreamTxtMapsWithFallback(stackPath, "", fn)
+	return err
+}
+
+// StreamTxtMapsWithWarnings is like StreamTxtMaps but returns the
+// anomalies found in Lenient mode instead of logging them.
+func StreamTxtMapsWithWarnings(stackPath string,
+	fn func(Superpixel, BodyId) error) (warnings Warnings, err error) {
+
+	_, warnings, err = streamTxtMaps(stackPath, "", nil, fn)
+	return
+}
+
+// StreamTxtMapsWithFallback is like StreamTxtMaps but reads either map
+// file from the fallback directory if the stack directory lacks it.
+func StreamTxtMapsWithFallback(stackPath, fallbackPath string,
+	fn func(Superpixel, BodyId) error) (sources TxtMapSources, err error) {
+
+	sources, warnings, err := streamTxtMaps(stackPath, fallbackPath, nil, fn)
+	warnings.Log()
+	return
+}
+
+func streamTxtMaps(stackPath, fallbackPath string, progress ProgressFunc,
+	fn func(Superpixel, BodyId) error) (sources TxtMapSources,
+	warnings Warnings, err error) {
+
+	sources.SuperpixelToSegment = txtMapDir(stackPath, fallbackPath,
+		SuperpixelToSegmentFilename)
+	sources.SegmentToBody = txtMapDir(stackPath, fallbackPath,
+		SegmentToBodyFilename)
+
+	segmentToBodyMap, warnings, err := readSegmentToBodyMap(
+		sources.SegmentToBody, progress)
+	if err != nil {
+		return sources, warnings, err
+	}
+	log.Println("Calculating superpixel->body map...")
+	var joinWarnings Warnings
+	spWarnings, err := scanSuperpixelToSegmentMap(sources.SuperpixelToSegment,
+		progress, func(superpixel Superpixel, segment BodyId) error {
+			bodyId, found := segmentToBodyMap[segment]
+			if !found && superpixel.Label != 0 {
+				anomaly := DefaultStrictness.Note(&joinWarnings,
+					"unknown segment", "superpixel %v has segment %d "+
+						"missing from segment->body map in %s",
+					superpixel, segment, sources.SegmentToBody)
+				if anomaly != nil {
+					return anomaly
+				}
 			}
-			var superpixel Superpixel
-			var segment BodyId
-			if _, err := fmt.Sscanf(line, "%d %d %d", &superpixel.Slice,
-				&superpixel.Label, &segment); err != nil {
-				log.Fatalf("FATAL ERROR: Error line %d in %s",
-					linenum, filename)
+			return fn(superpixel, bodyId)
+		})
+	warnings.Merge(spWarnings)
+	warnings.Merge(joinWarnings)
+	if err != nil {
+		return sources, warnings, err
+	}
+	return sources, warnings, nil
+}
+
+// scanSuperpixelToSegmentMap calls fn for each line of a stack's
+// superpixel->segment map.
+func scanSuperpixelToSegmentMap(stackPath string, progress ProgressFunc,
+	fn func(Superpixel, BodyId) error) (warnings Warnings, err error) {
+
+	file, filename, err := openTextFile(
+		filepath.Join(stackPath, SuperpixelToSegmentFilename))
+	if err != nil {
+		return warnings, fmt.Errorf("could not open %s: %s", filename, err)
+	}
+	defer file.Close()
+	log.Println("Loading superpixel->segment map for stack:\n", filename)
+	return scanSuperpixelToSegmentLines(file, filename,
+		lineProgress{progress, "superpixel->segment map",
+			InitialSuperpixelToBodyMapSize(stackPath)}, fn)
+}
+
+// ReadSuperpixelToSegmentMapFrom reads a superpixel->segment map from a
+// reader.  Malformed lines are handled according to DefaultStrictness.
+func ReadSuperpixelToSegmentMapFrom(reader io.Reader) (
+	spToSegmentMap map[Superpixel]BodyId, err error) {
+
+	spToSegmentMap, warnings, err := ReadSuperpixelToSegmentMapFromWithWarnings(
+		reader)
+	warnings.Log()
+	return
+}
+
+// ReadSuperpixelToSegmentMapFromWithWarnings is like
+// ReadSuperpixelToSegmentMapFrom but returns the anomalies found in
+// Lenient mode instead of logging them.
+func ReadSuperpixelToSegmentMapFromWithWarnings(reader io.Reader) (
+	spToSegmentMap map[Superpixel]BodyId, warnings Warnings, err error) {
+
+	spToSegmentMap = make(map[Superpixel]BodyId)
+	warnings, err = scanSuperpixelToSegmentLines(reader,
+		"superpixel->segment map", lineProgress{},
+		func(superpixel Superpixel, segment BodyId) error {
+			spToSegmentMap[superpixel] = segment
+			return nil
+		})
+	if err != nil {
+		return nil, warnings, err
+	}
+	return spToSegmentMap, warnings, nil
+}
+
+// scanSuperpixelToSegmentLines calls fn for each superpixel->segment
+// line read, naming the source in errors and warnings.
+func scanSuperpixelToSegmentLines(reader io.Reader, source string,
+	progress lineProgress, fn func(Superpixel, BodyId) error) (
+	warnings Warnings, err error) {
+
+	linenum := 0
+	scanner := newLineScanner(reader)
+	var fields [3]int64
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		linenum++
+		progress.report(linenum)
+		if skipTextLine(line) {
+			continue
+		}
+		err := scanIntFields(line, fields[:])
+		if err == nil {
+			err = checkUint32Fields(fields[:2])
+		}
+		if err != nil {
+			anomaly := DefaultStrictness.Note(&warnings, "malformed map line",
+				"line %d in %s (%q): %s", linenum, source, line, err)
+			if anomaly != nil {
+				return warnings, anomaly
 			}
-			spToBodyMap[superpixel] = segment // First pass store segment
-			linenum++
+			continue
 		}
-		waitchan <- true
-	}()
+		superpixel := Superpixel{uint32(fields[0]), uint32(fields[1])}
+		if err := fn(superpixel, BodyId(fields[2])); err != nil {
+			return warnings, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return warnings, fmt.Errorf("error reading %s: %s", source, err)
+	}
+	progress.done(linenum)
+	return warnings, nil
+}
+
+// readSegmentToBodyMap reads a stack's segment->body map.
+func readSegmentToBodyMap(stackPath string, progress ProgressFunc) (
+	segmentToBodyMap map[BodyId]BodyId, warnings Warnings, err error) {
 
-	// Load segment to body map
 	segmentToBodyMapSize := InitialSegmentToBodyMapSize(stackPath)
-	segmentToBodyMap := make(map[BodyId]BodyId, segmentToBodyMapSize)
+	segmentToBodyMap = make(map[BodyId]BodyId, segmentToBodyMapSize)
 	log.Println("  -- Initializing segment->body map to initial size",
 		segmentToBodyMapSize)
-	go func() {
-		filename := filepath.Join(stackPath, SegmentToBodyFilename)
-		log.Println("Loading segment->body map for stack:\n",
-			filename)
-		file, err := os.Open(filename)
-		if err != nil {
-			log.Fatalf("FATAL ERROR: Could not open %s", filename)
+	file, filename, err := openTextFile(
+		filepath.Join(stackPath, SegmentToBodyFilename))
+	if err != nil {
+		return nil, warnings, fmt.Errorf("could not open %s: %s",
+			filename, err)
+	}
+	defer file.Close()
+	log.Println("Loading segment->body map for stack:\n", filename)
+	warnings, err = readSegmentToBodyLines(file, filename,
+		lineProgress{progress, "segment->body map", segmentToBodyMapSize},
+		segmentToBodyMap)
+	if err != nil {
+		return nil, warnings, err
+	}
+	return segmentToBodyMap, warnings, nil
+}
+
+// ReadSegmentToBodyMapFrom reads a segment->body map from a reader.
+// Malformed lines are handled according to DefaultStrictness.
+func ReadSegmentToBodyMapFrom(reader io.Reader) (
+	segmentToBodyMap map[BodyId]BodyId, err error) {
+
+	segmentToBodyMap, warnings, err := ReadSegmentToBodyMapFromWithWarnings(
+		reader)
+	warnings.Log()
+	return
+}
+
+// ReadSegmentToBodyMapFromWithWarnings is like ReadSegmentToBodyMapFrom
+// but returns the anomalies found in Lenient mode instead of logging them.
+func ReadSegmentToBodyMapFromWithWarnings(reader io.Reader) (
+	segmentToBodyMap map[BodyId]BodyId, warnings Warnings, err error) {
+
+	segmentToBodyMap = make(map[BodyId]BodyId)
+	warnings, err = readSegmentToBodyLines(reader, "segment->body map",
+		lineProgress{}, segmentToBodyMap)
+	if err != nil {
+		return nil, warnings, err
+	}
+	return segmentToBodyMap, warnings, nil
+}
+
+// readSegmentToBodyLines adds each segment->body line read to the given
+// map, naming the source in errors and warnings.
+func readSegmentToBodyLines(reader io.Reader, source string,
+	progress lineProgress, segmentToBodyMap map[BodyId]BodyId) (
+	warnings Warnings, err error) {
+
+	linenum := 0
+	scanner := newLineScanner(reader)
+	var fields [2]int64
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		linenum++
+		progress.report(linenum)
+		if skipTextLine(line) {
+			continue
 		}
-		defer file.Close()
-		linenum := 0
-		lineReader := bufio.NewReader(file)
-		for {
-			line, err := lineReader.ReadString('\n')
-			if err != nil {
-				break
+		if err := scanIntFields(line, fields[:]); err != nil {
+			anomaly := DefaultStrictness.Note(&warnings, "malformed map line",
+				"line %d in %s (%q): %s", linenum, source, line, err)
+			if anomaly != nil {
+				return warnings, anomaly
 			}
-			if line[0] == ' ' || line[0] == '#' {
-				continue
-			}
-			var segment, body BodyId
-			if _, err := fmt.Sscanf(line, "%d %d", &segment, &body); err != nil {
-				log.Fatalf("FATAL ERROR: Error line %d in %s",
-					linenum, filename)
-			}
-			segmentToBodyMap[segment] = body
-			linenum++
+			continue
 		}
-		waitchan <- true
-	}()
-
-	// Wait until both maps have been loaded
-	_ = <-waitchan
-	_ = <-waitchan
-
-	// Compute superpixel->body map
-	log.Println("Calculating superpixel->body map...")
-	for superpixel, segment := range spToBodyMap {
-		spToBodyMap[superpixel] = segmentToBodyMap[segment]
+		segmentToBodyMap[BodyId(fields[0])] = BodyId(fields[1])
 	}
-	log.Println("Maps loaded and computed.")
-	return
+	if err := scanner.Err(); err != nil {
+		return warnings, fmt.Errorf("error reading %s: %s", source, err)
+	}
+	progress.done(linenum)
+	return warnings, nil
 }
 
 // segmentId is a Raveler-specific unique body id per plane
@@ -305,6 +1037,23 @@ type bodySegment struct {
 	plane  uint32
 }
 
+type bodySegmentList []bodySegment
+
+func (list bodySegmentList) Len() int {
+	return len(list)
+}
+
+func (list bodySegmentList) Swap(i, j int) {
+	list[i], list[j] = list[j], list[i]
+}
+
+func (list bodySegmentList) Less(i, j int) bool {
+	if list[i].plane != list[j].plane {
+		return list[i].plane < list[j].plane
+	}
+	return list[i].bodyId < list[j].bodyId
+}
+
 type segmentBody struct {
 	segment segmentId
 	body    BodyId
@@ -325,12 +1074,13 @@ func (list segmentBodyList) Less(i, j int) bool {
 }
 
 // makeSegmentMap returns a map of (bodyId, plane) -> unique segment ids.
-// Multiple bodySegment structs will map to the segment 0.
+// Multiple bodySegment structs will map to the segment 0.  Segment ids
+// are allocated in order of plane and then body id, so the same
+// superpixel->body map always produces the same segments.
 func (spToBodyMap SuperpixelToBodyMap) makeSegmentMaps() (
 	bodySegMap map[bodySegment]segmentId, numBodies int) {
 
 	bodySegMap = make(map[bodySegment]segmentId)
-	curSegment := segmentId(1)
 	bodySet := make(map[BodyId]bool)
 	bodySet[0] = true
 	for superpixel, bodyId := range spToBodyMap {
@@ -338,62 +1088,197 @@ func (spToBodyMap SuperpixelToBodyMap) makeSegmentMaps() (
 			bodySegMap[bodySegment{0, superpixel.Slice}] = 0
 		} else {
 			bodySet[bodyId] = true
-			segment := bodySegment{bodyId, superpixel.Slice}
-			_, found := bodySegMap[segment]
-			if !found {
-				bodySegMap[segment] = curSegment
-				curSegment++
-			}
+			bodySegMap[bodySegment{bodyId, superpixel.Slice}] = 0
 		}
 	}
+	segments := make(bodySegmentList, 0, len(bodySegMap))
+	for segment, _ := range bodySegMap {
+		if segment.bodyId != 0 {
+			segments = append(segments, segment)
+		}
+	}
+	sort.Sort(segments)
+	for i, segment := range segments {
+		bodySegMap[segment] = segmentId(i + 1)
+	}
 	numBodies = len(bodySet)
 	return
 }
 
+// SegmentIdReport counts the segment ids kept and newly allocated when
+// rewriting maps with WriteTxtMapsPreservingSegments.
+type SegmentIdReport struct {
+	Preserved int
+	Allocated int
+}
+
+// segmentGroup tracks the existing segments of a (body, plane) group.
+type segmentGroup struct {
+	segment segmentId // Existing segment of the group's superpixels
+	count   int       // # of superpixels in the group
+	mixed   bool      // Superpixels had different or no existing segments
+}
+
+// makePreservedSegmentMaps is like makeSegmentMaps but keeps the existing
+// segment id of each (bodyId, plane) that holds exactly the superpixels of
+// that segment.  Other groups get fresh ids above the largest existing id.
+func (spToBodyMap SuperpixelToBodyMap) makePreservedSegmentMaps(
+	existing map[Superpixel]BodyId) (bodySegMap map[bodySegment]segmentId,
+	numBodies int, report SegmentIdReport) {
+
+	var maxSegment segmentId
+	existingCounts := make(map[segmentId]int)
+	for superpixel, segment := range existing {
+		if superpixel.Label == 0 {
+			continue
+		}
+		existingCounts[segmentId(segment)]++
+		if segmentId(segment) > maxSegment {
+			maxSegment = segmentId(segment)
+		}
+	}
+
+	groups := make(map[bodySegment]*segmentGroup)
+	bodySet := make(map[BodyId]bool)
+	bodySet[0] = true
+	for superpixel, bodyId := range spToBodyMap {
+		if superpixel.Label == 0 || bodyId == 0 {
+			continue
+		}
+		bodySet[bodyId] = true
+		key := bodySegment{bodyId, superpixel.Slice}
+		segment, found := existing[superpixel]
+		group, grouped := groups[key]
+		if !grouped {
+			group = &segmentGroup{segment: segmentId(segment)}
+			groups[key] = group
+		}
+		group.count++
+		if !found || segment == 0 || segmentId(segment) != group.segment {
+			group.mixed = true
+		}
+	}
+
+	bodySegMap = make(map[bodySegment]segmentId, len(groups))
+	for superpixel, bodyId := range spToBodyMap {
+		if superpixel.Label == 0 || bodyId == 0 {
+			bodySegMap[bodySegment{0, superpixel.Slice}] = 0
+		}
+	}
+	var fresh bodySegmentList
+	for key, group := range groups {
+		if !group.mixed && group.count == existingCounts[group.segment] {
+			bodySegMap[key] = group.segment
+			report.Preserved++
+		} else {
+			fresh = append(fresh, key)
+		}
+	}
+	sort.Sort(fresh)
+	for i, key := range fresh {
+		bodySegMap[key] = maxSegment + segmentId(i+1)
+	}
+	report.Allocated = len(fresh)
+	numBodies = len(bodySet)
+	return
+}
+
+// TxtMapOptions controls how map .txt files are written.
+type TxtMapOptions struct {
+	// Gzip compresses the map files and appends GzipSuffix to their names.
+	Gzip bool
+}
+
 // WriteTxtMaps writes superpixel->segment and segment->body map
-// .txt files from a superpixel->body map.
-func (spToBodyMap SuperpixelToBodyMap) WriteTxtMaps(outputDir string) {
-	waitchan := make(chan bool)
+// .txt files from a superpixel->body map.  Lines are sorted by slice and
+// label or by segment id, so equal maps produce identical files.  The
+// two files are written concurrently and the first error from either
+// is returned.
+func (spToBodyMap SuperpixelToBodyMap) WriteTxtMaps(outputDir string) error {
+	return spToBodyMap.WriteTxtMapsWithOptions(outputDir, TxtMapOptions{})
+}
+
+// WriteTxtMapsGzip is like WriteTxtMaps but gzips the map files and
+// appends GzipSuffix to their names.  ReadTxtMaps reads either form.
+func (spToBodyMap SuperpixelToBodyMap) WriteTxtMapsGzip(outputDir string) error {
+	return spToBodyMap.WriteTxtMapsWithOptions(outputDir,
+		TxtMapOptions{Gzip: true})
+}
+
+// WriteTxtMapsWithOptions is like WriteTxtMaps but allows the map files
+// to be compressed.
+func (spToBodyMap SuperpixelToBodyMap) WriteTxtMapsWithOptions(
+	outputDir string, options TxtMapOptions) error {
 
 	// Get mapping of (bodyId, plane) -> unique segment ID
 	segmentMap, numBodies := spToBodyMap.makeSegmentMaps()
+	return spToBodyMap.writeTxtMaps(outputDir, options, segmentMap, numBodies)
+}
+
+// WriteTxtMapsPreservingSegments is like WriteTxtMaps but keeps segment
+// ids from an existing superpixel->segment map, e.g., one read with
+// ReadSuperpixelToSegmentMapFrom, so external references to segments such
+// as Raveler session files stay valid.  A body's superpixels in a plane
+// keep their segment id if they are exactly the superpixels of that
+// segment; other groups get new ids above the largest existing id.
+// Rewriting an unmodified map reproduces the original segment ids.
+func (spToBodyMap SuperpixelToBodyMap) WriteTxtMapsPreservingSegments(
+	outputDir string, existing map[Superpixel]BodyId) (
+	report SegmentIdReport, err error) {
+
+	segmentMap, numBodies, report := spToBodyMap.makePreservedSegmentMaps(
+		existing)
+	log.Printf("Preserved %d segment ids and allocated %d new ids\n",
+		report.Preserved, report.Allocated)
+	err = spToBodyMap.writeTxtMaps(outputDir, TxtMapOptions{}, segmentMap,
+		numBodies)
+	return
+}
+
+// writeTxtMaps writes the map .txt files using the given segment ids.
+func (spToBodyMap SuperpixelToBodyMap) writeTxtMaps(outputDir string,
+	options TxtMapOptions, segmentMap map[bodySegment]segmentId,
+	numBodies int) error {
+
+	errchan := make(chan error)
 
 	// Write superpixel to segment map
 	go func() {
 		// Prepare the sorted mapping file
-		spSegmentList := make(superpixelSegmentList, len(spToBodyMap))
-		i := 0
+		spSegmentList := make(superpixelSegmentList, 0, len(spToBodyMap))
 		for superpixel, bodyId := range spToBodyMap {
-			segment, found := segmentMap[bodySegment{bodyId, superpixel.Slice}]
-			if found {
-				spSegmentList[i] = superpixelSegment{superpixel, segment}
-			} else {
-				log.Fatalf("Error: No segment for body %d in slice %d!",
+			key := bodySegment{bodyId, superpixel.Slice}
+			if superpixel.Label == 0 || bodyId == 0 {
+				key.bodyId = 0
+			}
+			segment, found := segmentMap[key]
+			if !found {
+				errchan <- fmt.Errorf("no segment for body %d in slice %d",
 					bodyId, superpixel.Slice)
+				return
 			}
-			i++
+			spSegmentList = append(spSegmentList,
+				superpixelSegment{superpixel, segment})
 		}
 		sort.Sort(spSegmentList)
 
 		// Write the map
 		filename := filepath.Join(outputDir, SuperpixelToSegmentFilename)
-		log.Println("Writing superpixel->segment map for stack:\n", filename)
-		file, err := os.Create(filename)
-		if err != nil {
-			log.Fatalf("FATAL ERROR: Could not create %s: %s", filename, err)
+		if options.Gzip {
+			filename += GzipSuffix
 		}
-		lineWriter := bufio.NewWriter(file)
-		for _, spSegment := range spSegmentList {
-			_, err := fmt.Fprintf(lineWriter, "%8d %8d %8d\n",
-				spSegment.superpixel.Slice, spSegment.superpixel.Label,
-				spSegment.segment)
-			if err != nil {
-				log.Fatalln("Error: unable to write superpixel->segment map:",
-					err)
+		log.Println("Writing superpixel->segment map for stack:\n", filename)
+		errchan <- writeTxtMapFile(filename, func(writer io.Writer) error {
+			for _, spSegment := range spSegmentList {
+				_, err := fmt.Fprintf(writer, "%8d %8d %8d\n",
+					spSegment.superpixel.Slice, spSegment.superpixel.Label,
+					spSegment.segment)
+				if err != nil {
+					return err
+				}
 			}
-		}
-		file.Close()
-		waitchan <- true
+			return nil
+		})
 	}()
 
 	// Write segment to body map
@@ -407,54 +1292,105 @@ func (spToBodyMap SuperpixelToBodyMap) WriteTxtMaps(outputDir string) {
 
 		// Write the map
 		filename := filepath.Join(outputDir, SegmentToBodyFilename)
-		log.Println("Writing segment->body map for stack:\n", filename)
-		file, err := os.Create(filename)
-		if err != nil {
-			log.Fatalf("FATAL ERROR: Could not create %s: %s", filename, err)
+		if options.Gzip {
+			filename += GzipSuffix
 		}
-		lineWriter := bufio.NewWriter(file)
-		_, err = fmt.Fprintf(lineWriter, "%8d %8d\n", 0, 0)
-		if err != nil {
-			log.Fatalln("Error: unable to write segment->body map:", err)
-		}
-		for _, segBody := range segBodyList {
-			if segBody.segment != 0 {
-				_, err := fmt.Fprintf(lineWriter, "%8d %8d\n",
-					segBody.segment, segBody.body)
-				if err != nil {
-					log.Fatalln("Error: unable to write segment->body map:", err)
+		log.Println("Writing segment->body map for stack:\n", filename)
+		errchan <- writeTxtMapFile(filename, func(writer io.Writer) error {
+			_, err := fmt.Fprintf(writer, "%8d %8d\n", 0, 0)
+			if err != nil {
+				return err
+			}
+			for _, segBody := range segBodyList {
+				if segBody.segment != 0 {
+					_, err := fmt.Fprintf(writer, "%8d %8d\n",
+						segBody.segment, segBody.body)
+					if err != nil {
+						return err
+					}
 				}
 			}
-		}
-		file.Close()
-		waitchan <- true
+			return nil
+		})
 	}()
 
 	// Wait until both maps have been written
-	_ = <-waitchan
-	_ = <-waitchan
-
+	var err error
+	for i := 0; i < 2; i++ {
+		if writeErr := <-errchan; writeErr != nil && err == nil {
+			err = writeErr
+		}
+	}
+	if err != nil {
+		return err
+	}
 	log.Println("Maps written.")
+	return nil
+}
+
+// writeTxtMapFile creates a map file, writes its lines through a
+// buffered writer, and flushes and closes it, returning any error
+// along with the file name.  Files named with GzipSuffix are gzipped.
+func writeTxtMapFile(filename string, write func(io.Writer) error) error {
+	file, err := createTextFile(filename)
+	if err != nil {
+		return fmt.Errorf("could not create %s: %s", filename, err)
+	}
+	lineWriter := bufio.NewWriter(file)
+	err = write(lineWriter)
+	if err == nil {
+		err = lineWriter.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write %s: %s", filename, err)
+	}
+	return nil
 }
 
 // MappedStack is a type that can load mapping files and return maps.
 type MappedStack interface {
 	String() string
 	MapLoaded() bool
-	ReadTxtMaps()
+	ReadTxtMaps() error
+	Err() error
 	SuperpixelToBody(Superpixel) BodyId
+	SuperpixelToBodyChecked(Superpixel) (BodyId, bool)
 	GetBodyToSuperpixelsMap(BodySet) BodyToSuperpixelsMap
 	GetSuperpixelToBodyMap() SuperpixelToBodyMap
 }
 
 // Stack is a directory that has a base set of capabilities
 // shared by all types of stacks (base, session, exported, etc)
+// Superpixel->body maps are loaded at most once and may be used from
+// concurrent goroutines; other stack data should not.
 type Stack struct {
 	Directory    string
+	mapLock      sync.RWMutex // Guards maps and their load state
 	mapLoaded    bool
+	mapErr       error // Failed map load, kept until ClearTxtMaps
 	spToBodyMap  SuperpixelToBodyMap
 	boundsLoaded bool
+	boundsTime   time.Time // Modification time of loaded bounds file
 	spBoundsMap  SuperpixelBoundsMap
+	spBodyFile   *SuperpixelBodyFile
+	mapFallback  string        // Directory with maps missing from stack
+	mapSources   TxtMapSources // Directories maps were loaded from
+	err          error         // Last error from a deferred map or bounds load
+	mapWarnings  Warnings      // Anomalies found in the loaded maps
+	progress     ProgressFunc  // Optional reporter for map and bounds loads
+	Tiles        TileLayout
+
+	// LowMemory makes single superpixel lookups use the stack's binary
+	// map cache, if present, instead of loading the full map.
+	LowMemory bool
+
+	// EdgeBodyZero marks legacy stacks that use body 0 for membranes and
+	// edges, so points on zero superpixels are expected and resolved to
+	// the nearest body without per-point warnings.
+	EdgeBodyZero bool
 }
 
 // String returns the path of this stack
@@ -462,57 +1398,202 @@ func (stack *Stack) String() string {
 	return stack.Directory
 }
 
-// MapLoaded returns true if a superpixel->body mapping is available.
-func (stack *Stack) MapLoaded() bool {
-	return stack.mapLoaded
+// MapLoaded returns true if a superpixel->body mapping is available.
+func (stack *Stack) MapLoaded() bool {
+	stack.mapLock.RLock()
+	defer stack.mapLock.RUnlock()
+	return stack.mapLoaded
+}
+
+// Err returns the error from the last load of maps or bounds, including
+// loads deferred until a lookup like SuperpixelToBody.  It is nil after
+// a successful load or ClearTxtMaps.
+func (stack *Stack) Err() error {
+	stack.mapLock.RLock()
+	defer stack.mapLock.RUnlock()
+	return stack.err
+}
+
+// setErr keeps an error for Err() and returns it.
+func (stack *Stack) setErr(err error) error {
+	stack.mapLock.Lock()
+	stack.err = err
+	stack.mapLock.Unlock()
+	return err
+}
+
+// ReadTxtMaps loads superpixel->body maps, preferring the stack's binary
+// map cache if it is newer than the .txt maps.  Any error is also kept
+// for Err().  Concurrent callers wait for a single load.  A failed load
+// is not retried until ClearTxtMaps, so lookups on a bad stack return
+// quickly.
+func (stack *Stack) ReadTxtMaps() error {
+	_, err := stack.loadedMap()
+	return err
+}
+
+// loadedMap loads the superpixel->body map if necessary and returns it.
+// The returned map is never modified, so it may be read without locks.
+// A failed load is not retried, and its error is returned, until
+// ClearTxtMaps is called.
+func (stack *Stack) loadedMap() (SuperpixelToBodyMap, error) {
+	stack.mapLock.RLock()
+	if stack.mapLoaded || stack.mapErr != nil {
+		spToBodyMap, err := stack.spToBodyMap, stack.mapErr
+		stack.mapLock.RUnlock()
+		return spToBodyMap, err
+	}
+	stack.mapLock.RUnlock()
+
+	stack.mapLock.Lock()
+	defer stack.mapLock.Unlock()
+	if stack.mapLoaded || stack.mapErr != nil {
+		return stack.spToBodyMap, stack.mapErr
+	}
+	if !stack.readCachedMap(stack.String()) {
+		spToBodyMap, sources, warnings, err := readTxtMaps(stack.String(),
+			stack.mapFallback, stack.progress)
+		warnings.Log()
+		stack.mapWarnings = warnings
+		if err != nil {
+			stack.mapErr = err
+			stack.err = err
+			return nil, err
+		}
+		stack.spToBodyMap = spToBodyMap
+		stack.mapSources = sources
+		stack.mapLoaded = true
+	}
+	stack.err = nil
+	return stack.spToBodyMap, nil
+}
+
+// MapWarnings returns the anomalies found in Lenient mode while loading
+// the stack's .txt maps.
+func (stack *Stack) MapWarnings() Warnings {
+	stack.mapLock.RLock()
+	defer stack.mapLock.RUnlock()
+	return stack.mapWarnings
+}
+
+// SetProgress sets a function called periodically while the stack's
+// .txt maps and superpixel bounds are loaded.  A nil function disables
+// progress reporting.
+func (stack *Stack) SetProgress(progress ProgressFunc) {
+	stack.mapLock.Lock()
+	stack.progress = progress
+	stack.mapLock.Unlock()
 }
 
-// ReadTxtMaps loads superpixel->body maps.
-func (stack *Stack) ReadTxtMaps() {
-	if !stack.mapLoaded {
-		stack.spToBodyMap = ReadTxtMaps(stack.String())
-		stack.mapLoaded = true
-	}
+// MapSources returns the directories the loaded maps were read from.
+func (stack *Stack) MapSources() TxtMapSources {
+	stack.mapLock.RLock()
+	defer stack.mapLock.RUnlock()
+	return stack.mapSources
 }
 
-// ClearTxtMaps removes the superpixel->body maps.
+// ClearTxtMaps removes the superpixel->body maps.  It is safe to call
+// while other goroutines use the maps: maps already returned stay valid
+// and later lookups reload them.  It also forgets a failed load so the
+// maps can be read again, e.g., after fixing the map files.
 func (stack *Stack) ClearTxtMaps() {
+	stack.mapLock.Lock()
+	defer stack.mapLock.Unlock()
+	if stack.spBodyFile != nil {
+		stack.spBodyFile.Close()
+		stack.spBodyFile = nil
+	}
 	if stack.mapLoaded {
 		stack.spToBodyMap = nil
+		stack.mapSources = TxtMapSources{}
+		stack.mapWarnings = Warnings{}
 		stack.mapLoaded = false
 	}
+	stack.mapErr = nil
+	stack.err = nil
 }
 
 // StackSuperpixelBoundsFilename returns the file name of the
 // synapse annotation file for a given stack
-func (stack Stack) StackSuperpixelBoundsFilename() string {
+func (stack *Stack) StackSuperpixelBoundsFilename() string {
 	return filepath.Join(stack.String(), SuperpixelBoundsFilename)
 }
 
 // ReadSuperpixelBounds sets a stack's superpixel bounds based on
-// the superpixel bounds file in the stack's directory.
-func (stack *Stack) ReadSuperpixelBounds() {
-	if !stack.boundsLoaded {
-		emptySet := map[Superpixel]bool{}
-		var err error
-		stack.spBoundsMap, err = ReadSuperpixelBounds(
-			stack.StackSuperpixelBoundsFilename(), emptySet)
-		if err == nil {
-			stack.boundsLoaded = true
+// the superpixel bounds file in the stack's directory.  Bounds are
+// reloaded if the file has been modified since it was last read.
+// Any error is also kept for Err().
+func (stack *Stack) ReadSuperpixelBounds() error {
+	filename := textFilename(stack.StackSuperpixelBoundsFilename())
+	if stack.boundsLoaded {
+		info, err := os.Stat(filename)
+		if err != nil || info.ModTime().Equal(stack.boundsTime) {
+			return nil
 		}
+		stack.ClearSuperpixelBounds()
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return stack.setErr(
+			fmt.Errorf("could not open superpixel bounds: %s", err))
+	}
+	emptySet := map[Superpixel]bool{}
+	stack.mapLock.RLock()
+	progress := stack.progress
+	stack.mapLock.RUnlock()
+	spBoundsMap, err := ReadSuperpixelBoundsWithProgress(filename, emptySet,
+		progress)
+	if err != nil {
+		return stack.setErr(err)
+	}
+	stack.spBoundsMap = spBoundsMap
+	stack.boundsLoaded = true
+	stack.boundsTime = info.ModTime()
+	return stack.setErr(nil)
+}
+
+// ClearSuperpixelBounds removes the superpixel bounds so they will be
+// reloaded on next use.
+func (stack *Stack) ClearSuperpixelBounds() {
+	if stack.boundsLoaded {
+		stack.spBoundsMap = nil
+		stack.boundsLoaded = false
 	}
 }
 
-// SuperpixelToBody returns a body id for a given superpixel.
+// GetSuperpixelBoundsMap returns the superpixel bounds of the stack.
+func (stack *Stack) GetSuperpixelBoundsMap() SuperpixelBoundsMap {
+	stack.ReadSuperpixelBounds()
+	return stack.spBoundsMap
+}
+
+// SuperpixelToBody returns a body id for a given superpixel.  If the
+// maps cannot be loaded, body 0 is returned and Err() is set.
 func (stack *Stack) SuperpixelToBody(s Superpixel) BodyId {
-	stack.ReadTxtMaps()
-	return stack.spToBodyMap[s]
+	if store := stack.lowMemoryStore(); store != nil {
+		bodyId, _ := store.Get(s)
+		return bodyId
+	}
+	spToBodyMap, _ := stack.loadedMap()
+	return spToBodyMap[s]
+}
+
+// SuperpixelToBodyChecked returns a body id for a given superpixel and
+// whether the superpixel was in the map, which distinguishes missing
+// superpixels from those explicitly mapped to body 0.
+func (stack *Stack) SuperpixelToBodyChecked(s Superpixel) (BodyId, bool) {
+	if store := stack.lowMemoryStore(); store != nil {
+		return store.Get(s)
+	}
+	spToBodyMap, _ := stack.loadedMap()
+	bodyId, found := spToBodyMap[s]
+	return bodyId, found
 }
 
 // GetSuperpixelToBodyMap returns a superpixel->body map.
 func (stack *Stack) GetSuperpixelToBodyMap() SuperpixelToBodyMap {
-	stack.ReadTxtMaps()
-	return stack.spToBodyMap
+	spToBodyMap, _ := stack.loadedMap()
+	return spToBodyMap
 }
 
 // GetBodyToSuperpixelsMap returns a body->(superpixel set) map 
@@ -520,63 +1601,183 @@ func (stack *Stack) GetSuperpixelToBodyMap() SuperpixelToBodyMap {
 func (stack *Stack) GetBodyToSuperpixelsMap(bodySet BodySet) (
 	bodyToSpMap BodyToSuperpixelsMap) {
 
-	stack.ReadTxtMaps()
-	bodyToSpMap = make(BodyToSuperpixelsMap)
-	for superpixel, bodyId := range stack.spToBodyMap {
-		_, found := bodySet[bodyId]
-		if found {
-			bodyToSpMap[bodyId] = append(bodyToSpMap[bodyId], superpixel)
-		}
-	}
-	return bodyToSpMap
+	spToBodyMap, _ := stack.loadedMap()
+	return spToBodyMap.InvertWhere(func(_ Superpixel, bodyId BodyId) bool {
+		return bodySet.Contains(bodyId)
+	})
 }
 
-// SuperpixelBoundsChanged looks at the superpixel bounds of two stacks
-// for a given set of superpixels and sees if there are any 
-// significant changes in the superpixels.
-func (stack1 *Stack) SuperpixelBoundsChanged(stack2 *Stack,
-	superpixelSet map[Superpixel]bool) bool {
+// BoundsDiffOptions controls the comparison of superpixel bounds
+// between two stacks.
+type BoundsDiffOptions struct {
+	// MaxFraction is the fraction of voxels that may differ before
+	// superpixels are considered significantly changed.
+	MaxFraction float64
 
-	spBounds1, err1 := ReadSuperpixelBounds(
-		stack1.StackSuperpixelBoundsFilename(), superpixelSet)
-	if err1 != nil {
-		log.Println("** Not able to check if superpixels changed",
-			"using superpixel bounds - not available for stack:\n", stack1)
-		return false
+	// Region, if non-nil, restricts the comparison to superpixels in
+	// its Z range whose bounding rectangle intersects its XY extent.
+	Region *Bounds3d
+
+	// DeltaThreshold, if positive, lists each superpixel in both stacks
+	// whose volume differs by more than this many voxels.
+	DeltaThreshold int
+}
+
+// DefaultBoundsDiffOptions allows a 10% voxel difference over all slices.
+var DefaultBoundsDiffOptions = BoundsDiffOptions{MaxFraction: 0.10}
+
+// BoundsDiff summarizes the voxel differences of superpixels between
+// two stacks.  The total voxels is the sum of the larger volume of each
+// superpixel present in either stack, so the comparison is symmetric.
+type BoundsDiff struct {
+	VoxelsTotal int
+	VoxelsDiff  int
+	Fraction    float64 // VoxelsDiff / VoxelsTotal
+	Changed     bool    // Fraction exceeds the allowed fraction
+
+	// Superpixels exceeding the options' DeltaThreshold, sorted
+	Deltas []SuperpixelDelta
+
+	// Sorted superpixels in the first stack but not the second
+	Missing Superpixels
+}
+
+// SuperpixelDelta gives the volume of a superpixel in two stacks.
+type SuperpixelDelta struct {
+	Superpixel Superpixel
+	Volume1    int
+	Volume2    int
+}
+
+// Exceeds returns true if the fraction of differing voxels is above
+// the given threshold.
+func (diff BoundsDiff) Exceeds(threshold float64) bool {
+	return diff.Fraction > threshold
+}
+
+// inRegion returns true if the superpixel's slice is within the Z range
+// of the region and its bounds intersect the region in XY.
+func (bound SuperpixelBound) inRegion(slice uint32, region *Bounds3d) bool {
+	if region == nil {
+		return true
 	}
-	spBounds2, err2 := ReadSuperpixelBounds(
-		stack2.StackSuperpixelBoundsFilename(), superpixelSet)
-	if err2 != nil {
-		log.Println("** Not able to check if superpixels changed",
-			"using superpixel bounds - not available for stack:\n", stack2)
+	z := VoxelCoord(slice)
+	if z < region.MinPt.Z() || z > region.MaxPt.Z() {
 		return false
 	}
+	maxX := VoxelCoord(bound.MinX + bound.Width - 1)
+	maxY := VoxelCoord(bound.MinY + bound.Height - 1)
+	return VoxelCoord(bound.MinX) <= region.MaxPt.X() &&
+		maxX >= region.MinPt.X() &&
+		VoxelCoord(bound.MinY) <= region.MaxPt.Y() &&
+		maxY >= region.MinPt.Y()
+}
+
+// CompareSuperpixelBounds computes the voxel difference between two
+// superpixel bounds maps.  Superpixels missing from one map count their
+// entire volume as different.
+func CompareSuperpixelBounds(spBounds1, spBounds2 SuperpixelBoundsMap,
+	options BoundsDiffOptions) (diff BoundsDiff) {
 
-	voxelsTotal := 0
-	voxelsDiff := 0
+	var changed Superpixels
 	for superpixel, bounds1 := range spBounds1 {
-		voxelsTotal += bounds1.Volume
 		bounds2, found := spBounds2[superpixel]
+		if !bounds1.inRegion(superpixel.Slice, options.Region) &&
+			!(found && bounds2.inRegion(superpixel.Slice, options.Region)) {
+			continue
+		}
 		if !found {
-			voxelsDiff += bounds1.Volume
+			diff.VoxelsTotal += bounds1.Volume
+			diff.VoxelsDiff += bounds1.Volume
+			diff.Missing = append(diff.Missing, superpixel)
+			continue
+		}
+		delta := bounds1.Volume - bounds2.Volume
+		if bounds2.Volume > bounds1.Volume {
+			diff.VoxelsTotal += bounds2.Volume
+			delta = -delta
 		} else {
-			if bounds2.Volume > bounds1.Volume {
-				voxelsDiff += bounds2.Volume - bounds1.Volume
-			} else {
-				voxelsDiff += bounds1.Volume - bounds2.Volume
-			}
+			diff.VoxelsTotal += bounds1.Volume
+		}
+		diff.VoxelsDiff += delta
+		if options.DeltaThreshold > 0 && delta > options.DeltaThreshold {
+			changed = append(changed, superpixel)
+		}
+	}
+	sort.Sort(diff.Missing)
+	sort.Sort(changed)
+	for _, superpixel := range changed {
+		diff.Deltas = append(diff.Deltas, SuperpixelDelta{superpixel,
+			spBounds1[superpixel].Volume, spBounds2[superpixel].Volume})
+	}
+	for superpixel, bounds2 := range spBounds2 {
+		if _, found := spBounds1[superpixel]; found ||
+			!bounds2.inRegion(superpixel.Slice, options.Region) {
+			continue
 		}
+		diff.VoxelsTotal += bounds2.Volume
+		diff.VoxelsDiff += bounds2.Volume
+	}
+	if diff.VoxelsTotal > 0 {
+		diff.Fraction = float64(diff.VoxelsDiff) / float64(diff.VoxelsTotal)
+	}
+	diff.Changed = diff.Fraction > options.MaxFraction
+	return
+}
+
+// CompareSuperpixelBounds reads the superpixel bounds of two stacks
+// for a given set of superpixels and computes their voxel difference.
+// An error is returned if either stack's bounds are not available.
+func (stack1 *Stack) CompareSuperpixelBounds(stack2 *Stack,
+	superpixelSet map[Superpixel]bool, options BoundsDiffOptions) (
+	diff BoundsDiff, err error) {
+
+	spBounds1, err := ReadSuperpixelBounds(
+		stack1.StackSuperpixelBoundsFilename(), superpixelSet)
+	if err != nil {
+		return
+	}
+	spBounds2, err := ReadSuperpixelBounds(
+		stack2.StackSuperpixelBoundsFilename(), superpixelSet)
+	if err != nil {
+		return
 	}
-	percentDiff := float32(voxelsDiff) / float32(voxelsTotal)
-	log.Println(percentDiff, "% voxel difference in superpixels used",
-		"to compute overlap analysis between stacks")
+	diff = CompareSuperpixelBounds(spBounds1, spBounds2, options)
+	log.Printf("%.2f%% voxel difference in superpixels used to compute "+
+		"overlap analysis between stacks\n", 100.0*diff.Fraction)
+	return
+}
+
+// SuperpixelBoundsDiff is like CompareSuperpixelBounds but uses
+// DefaultBoundsDiffOptions.
+func (stack1 *Stack) SuperpixelBoundsDiff(stack2 *Stack,
+	superpixelSet map[Superpixel]bool) (BoundsDiff, error) {
+
+	return stack1.CompareSuperpixelBounds(stack2, superpixelSet,
+		DefaultBoundsDiffOptions)
+}
+
+// SuperpixelBoundsChanged looks at the superpixel bounds of two stacks
+// for a given set of superpixels and returns true if more than
+// DefaultBoundsDiffOptions allows have changed.  If bounds are not
+// available for either stack, false is returned.
+func (stack1 *Stack) SuperpixelBoundsChanged(stack2 *Stack,
+	superpixelSet map[Superpixel]bool) bool {
 
-	if percentDiff > 0.10 {
-		log.Fatalln("FATAL ERROR: More than 10%% voxel difference in",
-			"superpixels between stacks:", percentDiff*100.0, "%% of total",
-			voxelsTotal, "voxels\n", stack1, "\n", stack2)
+	diff, err := stack1.CompareSuperpixelBounds(stack2, superpixelSet,
+		DefaultBoundsDiffOptions)
+	if err != nil {
+		log.Println("** Not able to check if superpixels changed",
+			"using superpixel bounds:", err)
+		return false
 	}
-	return false
+	if diff.Changed {
+		log.Printf("** Warning: %.2f%% voxel difference in superpixels "+
+			"between stacks (%d of %d voxels)\n  %s\n  %s\n",
+			100.0*diff.Fraction, diff.VoxelsDiff, diff.VoxelsTotal,
+			stack1, stack2)
+	}
+	return diff.Changed
 }
 
 // CreateBaseStack initializes a BaseStack from a directory
@@ -603,39 +1804,70 @@ func (stack *BaseStack) StackBodiesJsonFilename() string {
 // TilesMetadata retrieves the 3d bounding box and superpixel format 
 // of a stack from the tiles/metadata.txt file.
 func (stack *BaseStack) TilesMetadata() (Bounds3d, SuperpixelFormat) {
+	bounds, superpixelFormat, err := stack.ReadTilesMetadata()
+	if err != nil {
+		log.Fatalf("FATAL ERROR: %s", err)
+	}
+	return bounds, superpixelFormat
+}
+
+// ReadTilesMetadata is like TilesMetadata but returns an error instead
+// of exiting if the metadata file is missing or malformed.
+func (stack *BaseStack) ReadTilesMetadata() (Bounds3d, SuperpixelFormat,
+	error) {
 
 	filename := filepath.Join(stack.Directory, "tiles", "metadata.txt")
 	file, err := os.Open(filename)
 	if err != nil {
-		log.Fatalf("FATAL ERROR: Could not open tiles/metadata.txt file: %s",
-			filename)
+		return Bounds3d{}, SuperpixelNone,
+			fmt.Errorf("Could not open tiles/metadata.txt file: %s", filename)
 	}
 	defer file.Close()
-	var bounds Bounds3d
-	var superpixelFormat SuperpixelFormat = SuperpixelNone
+	bounds, superpixelFormat, err := ReadTilesMetadataFrom(file)
+	if err != nil {
+		err = fmt.Errorf("Error in reading %s: %s", filename, err)
+	}
+	return bounds, superpixelFormat, err
+}
+
+// ReadTilesMetadataFrom parses tiles metadata of "keyword = value" lines
+// giving the stack width, height, zmin, zmax, and superpixel format.
+func ReadTilesMetadataFrom(reader io.Reader) (bounds Bounds3d,
+	superpixelFormat SuperpixelFormat, err error) {
+
+	superpixelFormat = SuperpixelNone
 	minZUnset := true
 	maxZUnset := true
 	bounds.MinPt[0] = 0
 	bounds.MinPt[1] = 0
-	lineReader := bufio.NewReader(file)
-	for line, err := lineReader.ReadString('\n'); err == nil; line,
-		err = lineReader.ReadString('\n') {
-
+	lineReader := bufio.NewReader(reader)
+	for {
+		line, readErr := readTextLine(lineReader)
+		if readErr == io.EOF {
+			break
+		} else if readErr != nil {
+			err = readErr
+			return
+		}
 		items := strings.Split(line, "=")
+		if len(items) < 2 {
+			continue
+		}
 		keyword, value := strings.TrimSpace(items[0]),
 			strings.TrimSpace(items[1])
+		var coordErr error
 		switch keyword {
 		case "width":
-			bounds.MaxPt[0].SetWithString(value)
+			coordErr = bounds.MaxPt[0].SetWithString(value)
 			bounds.MaxPt[0]--
 		case "height":
-			bounds.MaxPt[1].SetWithString(value)
+			coordErr = bounds.MaxPt[1].SetWithString(value)
 			bounds.MaxPt[1]--
 		case "zmin":
-			bounds.MinPt[2].SetWithString(value)
+			coordErr = bounds.MinPt[2].SetWithString(value)
 			minZUnset = false
 		case "zmax":
-			bounds.MaxPt[2].SetWithString(value)
+			coordErr = bounds.MaxPt[2].SetWithString(value)
 			maxZUnset = false
 		case "superpixel-format":
 			if value == "RGBA" {
@@ -643,10 +1875,14 @@ func (stack *BaseStack) TilesMetadata() (Bounds3d, SuperpixelFormat) {
 			} else if value == "I" {
 				superpixelFormat = Superpixel16Bits
 			} else {
-				log.Fatalf("FATAL ERROR: Illegal superpixel format (%s): %s",
-					value, filename)
+				err = fmt.Errorf("Illegal superpixel format (%s)", value)
+				return
 			}
 		}
+		if coordErr != nil {
+			err = fmt.Errorf("Illegal %s (%s)", keyword, value)
+			return
+		}
 	}
 	if minZUnset || maxZUnset {
 		var errors []string
@@ -656,61 +1892,131 @@ func (stack *BaseStack) TilesMetadata() (Bounds3d, SuperpixelFormat) {
 		if maxZUnset {
 			errors = append(errors, "zmax not provided")
 		}
-		log.Fatalf("FATAL ERROR: Error in reading %s: %s",
-			filename, strings.Join(errors, ", "))
+		err = fmt.Errorf("%s", strings.Join(errors, ", "))
 	}
-	return bounds, superpixelFormat
+	return
 }
 
+// Overlaps is the amount of overlap of a body with each other body.
 type Overlaps map[BodyId]int
 
+// OverlapsMap holds the overlaps of each body with bodies in another
+// stack or segmentation.
 type OverlapsMap map[BodyId]Overlaps
 
 type BestOverlap struct {
 	MatchedBody BodyId
 	OverlapSize int
-	MaxOverlap  int // What is maximum size of OverlapSize (100% overlap)
+	MaxOverlap  int     // What is maximum size of OverlapSize (100% overlap)
+	ZeroOverlap int     // # of superpixels (voxels if weighted) in body 0
+	ZeroMatch   bool    // True if no non-zero body could be matched
+	Fraction    float64 // OverlapSize / MaxOverlap, or 0 if MaxOverlap is 0
+
+	SecondBody        BodyId // Runner-up body, or 0 if there is none
+	SecondOverlapSize int    // Overlap of the runner-up body
+}
+
+// AmbiguousOverlapRatio is the ratio of second-best to best overlap at
+// which TransformBodies warns that a match is ambiguous.
+var AmbiguousOverlapRatio = 0.9
+
+// Ambiguous returns true if the runner-up body overlaps at least ratio
+// times as much as the matched body.
+func (match BestOverlap) Ambiguous(ratio float64) bool {
+	return match.SecondOverlapSize > 0 &&
+		float64(match.SecondOverlapSize) >= ratio*float64(match.OverlapSize)
 }
 
 type BestOverlapMap map[BodyId]BestOverlap
 
+// OverlapOptions controls how superpixel overlaps are tallied.
+type OverlapOptions struct {
+	// IncludeZeroBody allows body 0 (unassigned or edge superpixels) to
+	// compete as a best match.  Any match to body 0 is flagged regardless.
+	IncludeZeroBody bool
+
+	// CheckBounds, if non-nil, compares the superpixel bounds of the
+	// superpixels used for overlap.  Significant changes are anomalies
+	// handled according to DefaultStrictness.  Both stacks must provide
+	// superpixel bounds files.
+	CheckBounds *BoundsDiffOptions
+
+	// WeightByVolume weights each overlapping superpixel by its volume
+	// instead of counting it once, so MaxOverlap is a body's total
+	// voxel volume and ZeroOverlap is in voxels.  Superpixels without
+	// bounds or a volume, e.g., from a bounds file lacking volumes, are
+	// counted as one voxel so that their bodies can still be matched.
+	WeightByVolume bool
+
+	// Bounds1 gives the superpixel bounds of stack1 used by
+	// WeightByVolume.  If nil, stack1's superpixel bounds file is read.
+	Bounds1 SuperpixelBoundsMap
+
+	// Workers is the # of goroutines tallying overlaps of stack1 bodies.
+	// If 0, runtime.GOMAXPROCS(0) goroutines are used.
+	Workers int
+}
+
+// DefaultOverlapOptions excludes body 0 from overlap tallies so that a
+// real body can be matched even if most superpixels fall in body 0.
+var DefaultOverlapOptions = OverlapOptions{IncludeZeroBody: false}
+
 // OverlapAnalysis returns a body->body mapping between two stacks
-// determined by maximal superpixel overlap.  It assumes that the
-// superpixel IDs refer to the same areas.
+// determined by maximal superpixel overlap using DefaultOverlapOptions.
 func OverlapAnalysis(stack1 MappedStack, stack2 MappedStack, bodySet BodySet) (
-	matchingMap BestOverlapMap) {
+	matchingMap BestOverlapMap, warnings Warnings, err error) {
+
+	return OverlapAnalysisWithOptions(stack1, stack2, bodySet,
+		DefaultOverlapOptions)
+}
+
+// OverlapAnalysisWithOptions returns a body->body mapping between two
+// stacks determined by maximal superpixel overlap.  It assumes that the
+// superpixel IDs refer to the same areas.  Bodies missing from stack1
+// or without any overlapping body are anomalies handled according to
+// DefaultStrictness.  Bodies without a non-zero match are stored with
+// ZeroMatch set.  Ties go to a non-zero body, then the lower body id.
+func OverlapAnalysisWithOptions(stack1 MappedStack, stack2 MappedStack,
+	bodySet BodySet, options OverlapOptions) (
+	matchingMap BestOverlapMap, warnings Warnings, err error) {
 
 	// Get the superpixels for stack1 bodies.
 	body1ToSpMap := stack1.GetBodyToSuperpixelsMap(bodySet)
 	for bodyId, _ := range bodySet {
 		_, found := body1ToSpMap[bodyId]
 		if !found {
-			log.Println("** Warning: Body", bodyId, "is not present",
-				"in stack:\n  ", stack1)
+			err = DefaultStrictness.Note(&warnings, "body not in stack",
+				"body %d is not present in stack %s", bodyId, stack1)
+			if err != nil {
+				return
+			}
 		}
 	}
 
 	// Get the superpixel->body map for stack2.
 	sp2ToBodyMap := stack2.GetSuperpixelToBodyMap()
 
-	// Go through all superpixels in the body set and track overlap.
-	overlapsMap := make(OverlapsMap)
-	superpixelsFound := 0
-	superpixelsNotFound := 0
-	for bodyId1, superpixels1 := range body1ToSpMap {
-		for _, superpixel1 := range superpixels1 {
-			bodyId2, found := sp2ToBodyMap[superpixel1]
-			if found {
-				if len(overlapsMap[bodyId1]) == 0 {
-					overlapsMap[bodyId1] = make(Overlaps)
-				}
-				overlapsMap[bodyId1][bodyId2] += 1
-				superpixelsFound++
-			} else {
-				superpixelsNotFound++
+	// Weight superpixels by volume if requested.
+	var weight func(Superpixel) int
+	if options.WeightByVolume {
+		spBounds := options.Bounds1
+		if spBounds == nil {
+			spBounds, err = readStackBounds(stack1, body1ToSpMap)
+			if err != nil {
+				return
+			}
+		}
+		weight = func(superpixel Superpixel) int {
+			if volume := spBounds[superpixel].Volume; volume > 0 {
+				return volume
 			}
+			return 1
 		}
 	}
+
+	// Go through all superpixels in the body set and track overlap.
+	overlapsMap, superpixelsFound, superpixelsNotFound :=
+		tallyOverlaps(body1ToSpMap, sp2ToBodyMap, weight, options.Workers)
 	if superpixelsNotFound > 0 {
 		total := superpixelsNotFound + superpixelsFound
 		log.Println("\nOverlap analysis: ", superpixelsFound, " of ",
@@ -718,38 +2024,367 @@ func OverlapAnalysis(stack1 MappedStack, stack2 MappedStack, bodySet BodySet) (
 			filepath.Base(stack2.String()), ")")
 	}
 
-	/*
-		// Quality control: make sure superpixels have not changed a lot
-		// from our target stack, else superpixel overlap fails.
-		if stack.SuperpixelsChanged(&(exportedStack.Stack), superpixelSet) {
-			log.Fatalln("\n*** ERROR: Superpixels changed significantly ",
-				"between exported stack (", filepath.Base(exportedStack.String()),
-				") and target stack (", filepath.Base(stack.String()), ")")
+	// Quality control: make sure superpixels have not changed a lot
+	// from our target stack, else superpixel overlap fails.
+	if options.CheckBounds != nil {
+		var diff BoundsDiff
+		diff, err = compareStackBounds(stack1, stack2, body1ToSpMap,
+			*options.CheckBounds)
+		if err != nil {
+			return
+		}
+		if diff.Changed {
+			err = DefaultStrictness.Note(&warnings, "superpixel bounds changed",
+				"%.2f%% voxel difference in superpixels between %s and %s",
+				100.0*diff.Fraction, stack1, stack2)
+			if err != nil {
+				return
+			}
 		}
-	*/
+	}
 
 	// Construct matching map from maximal overlaps
-	matchingMap = make(BestOverlapMap)
-	for bodyId1, overlaps := range overlapsMap {
-		maximumOverlap := len(body1ToSpMap[bodyId1])
-		var largest int
-		var matchedBodyId BodyId
-		for bodyId2, count := range overlaps {
-			if count > largest {
-				largest = count
-				matchedBodyId = bodyId2
+	matchingMap = overlapsMap.BestWithOptions(func(bodyId BodyId) int {
+		if weight == nil {
+			return len(body1ToSpMap[bodyId])
+		}
+		total := 0
+		for _, superpixel := range body1ToSpMap[bodyId] {
+			total += weight(superpixel)
+		}
+		return total
+	}, options)
+	for bodyId1, bestOverlap := range matchingMap {
+		if bestOverlap.ZeroMatch {
+			err = DefaultStrictness.Note(&warnings, "no overlapping body",
+				"could not find non-zero overlapping body for body %d", bodyId1)
+			if err != nil {
+				matchingMap = nil
+				return
+			}
+		}
+	}
+	return
+}
+
+// OverlapAnalysisMutual is like OverlapAnalysis but only keeps matches
+// that are reciprocal: body A of stack1 best matches body B of stack2 and
+// B best matches A in stack1.  The reverse overlap is only computed for
+// the bodies matched in stack2.  If A matches B but B best matches
+// another body C, A is left out of the matching map and returned in
+// nonMutual, as are bodies without a non-zero match.
+func OverlapAnalysisMutual(stack1, stack2 MappedStack, bodySet BodySet) (
+	matchingMap BestOverlapMap, nonMutual BodySet, warnings Warnings,
+	err error) {
+
+	forwardMap, warnings, err := OverlapAnalysis(stack1, stack2, bodySet)
+	if err != nil {
+		return
+	}
+	candidates := make(BodySet)
+	for _, match := range forwardMap {
+		if !match.ZeroMatch {
+			candidates[match.MatchedBody] = true
+		}
+	}
+	body2ToSpMap := stack2.GetBodyToSuperpixelsMap(candidates)
+	reverseOverlaps, _, _ := tallyOverlaps(body2ToSpMap,
+		stack1.GetSuperpixelToBodyMap(), nil, 0)
+	reverseMap := reverseOverlaps.BestWithOptions(func(bodyId BodyId) int {
+		return len(body2ToSpMap[bodyId])
+	}, DefaultOverlapOptions)
+
+	matchingMap = make(BestOverlapMap, len(forwardMap))
+	nonMutual = make(BodySet)
+	for bodyId1, match := range forwardMap {
+		reverse, found := reverseMap[match.MatchedBody]
+		if match.ZeroMatch || !found || reverse.MatchedBody != bodyId1 {
+			nonMutual[bodyId1] = true
+		} else {
+			matchingMap[bodyId1] = match
+		}
+	}
+	return
+}
+
+// tallyOverlaps counts, for each body in stack1, the # of its superpixels
+// that belong to each body in stack2, including body 0.  If weight is
+// non-nil, each superpixel adds its weight rather than 1.  Bodies with no
+// superpixels found in stack2 are not present in the returned map.
+// Bodies are divided among the given # of workers, or GOMAXPROCS workers
+// if 0.  Each body is tallied by a single worker, so results do not
+// depend on the # of workers.
+func tallyOverlaps(body1ToSpMap BodyToSuperpixelsMap,
+	sp2ToBodyMap SuperpixelToBodyMap, weight func(Superpixel) int,
+	workers int) (overlapsMap OverlapsMap, superpixelsFound,
+	superpixelsNotFound int) {
+
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers == 1 || len(body1ToSpMap) < 2 {
+		overlapsMap = make(OverlapsMap)
+		for bodyId1, superpixels1 := range body1ToSpMap {
+			found, notFound := tallyBodyOverlaps(overlapsMap, bodyId1,
+				superpixels1, sp2ToBodyMap, weight)
+			superpixelsFound += found
+			superpixelsNotFound += notFound
+		}
+		return
+	}
+
+	// The stack2 map is only read, so workers share it without locks.
+	type partialTally struct {
+		overlapsMap     OverlapsMap
+		found, notFound int
+	}
+	bodyChan := make(chan BodyId)
+	results := make(chan partialTally, workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			partial := partialTally{overlapsMap: make(OverlapsMap)}
+			for bodyId1 := range bodyChan {
+				found, notFound := tallyBodyOverlaps(partial.overlapsMap,
+					bodyId1, body1ToSpMap[bodyId1], sp2ToBodyMap, weight)
+				partial.found += found
+				partial.notFound += notFound
+			}
+			results <- partial
+		}()
+	}
+	for bodyId1, _ := range body1ToSpMap {
+		bodyChan <- bodyId1
+	}
+	close(bodyChan)
+
+	overlapsMap = make(OverlapsMap, len(body1ToSpMap))
+	for i := 0; i < workers; i++ {
+		partial := <-results
+		for bodyId1, overlaps := range partial.overlapsMap {
+			overlapsMap[bodyId1] = overlaps
+		}
+		superpixelsFound += partial.found
+		superpixelsNotFound += partial.notFound
+	}
+	return
+}
+
+// tallyBodyOverlaps adds the overlaps of one stack1 body to overlapsMap
+// and returns the # of its superpixels found and not found in stack2.
+func tallyBodyOverlaps(overlapsMap OverlapsMap, bodyId1 BodyId,
+	superpixels1 Superpixels, sp2ToBodyMap SuperpixelToBodyMap,
+	weight func(Superpixel) int) (found, notFound int) {
+
+	for _, superpixel1 := range superpixels1 {
+		bodyId2, mapped := sp2ToBodyMap[superpixel1]
+		if mapped {
+			count := 1
+			if weight != nil {
+				count = weight(superpixel1)
+			}
+			overlapsMap.Add(bodyId1, bodyId2, count)
+			found++
+		} else {
+			notFound++
+		}
+	}
+	return
+}
+
+// SuperpixelOverlaps returns the # of superpixels of each body in the
+// body set of stack1 that belong to each body in stack2, including
+// body 0.  This is the raw data used by OverlapAnalysis.
+func SuperpixelOverlaps(stack1 MappedStack, stack2 MappedStack,
+	bodySet BodySet) OverlapsMap {
+
+	body1ToSpMap := stack1.GetBodyToSuperpixelsMap(bodySet)
+	overlapsMap, _, _ := tallyOverlaps(body1ToSpMap,
+		stack2.GetSuperpixelToBodyMap(), nil, 0)
+	return overlapsMap
+}
+
+// BodyOverlap is one entry of a sparse body x body overlap matrix.
+// Fractions are relative to the total voxels of each body if superpixel
+// bounds were provided, else relative to the total # of superpixels.
+type BodyOverlap struct {
+	BodyA       BodyId // Body in stack1
+	BodyB       BodyId // Body in stack2
+	Superpixels int    // # of superpixels in common
+	Voxels      int    // # of voxels in common if bounds provided
+	FractionA   float64
+	FractionB   float64
+}
+
+// BodyOverlapList is a list of body overlaps sorted by descending
+// overlap, then by body A and body B.
+type BodyOverlapList []BodyOverlap
+
+func (list BodyOverlapList) Len() int {
+	return len(list)
+}
+
+func (list BodyOverlapList) Swap(i, j int) {
+	list[i], list[j] = list[j], list[i]
+}
+
+func (list BodyOverlapList) Less(i, j int) bool {
+	if list[i].Voxels != list[j].Voxels {
+		return list[i].Voxels > list[j].Voxels
+	}
+	if list[i].Superpixels != list[j].Superpixels {
+		return list[i].Superpixels > list[j].Superpixels
+	}
+	if list[i].BodyA != list[j].BodyA {
+		return list[i].BodyA < list[j].BodyA
+	}
+	return list[i].BodyB < list[j].BodyB
+}
+
+// OverlapMatrix returns the sparse overlap matrix between the bodies
+// in a body set of stack1 and any non-zero bodies of stack2.  If
+// spBoundsMap is non-nil, voxel overlaps are computed from superpixel
+// volumes.  Overlaps with fewer than minOverlap superpixels are dropped.
+func OverlapMatrix(stack1 MappedStack, stack2 MappedStack, bodySet BodySet,
+	spBoundsMap SuperpixelBoundsMap, minOverlap int) (matrix BodyOverlapList) {
+
+	body1ToSpMap := stack1.GetBodyToSuperpixelsMap(bodySet)
+	sp2ToBodyMap := stack2.GetSuperpixelToBodyMap()
+	overlapsMap, _, _ := tallyOverlaps(body1ToSpMap, sp2ToBodyMap, nil, 0)
+
+	// Weight is either the superpixel volume or 1 for each superpixel.
+	weight := func(superpixel Superpixel) int {
+		if spBoundsMap == nil {
+			return 1
+		}
+		return spBoundsMap[superpixel].Volume
+	}
+
+	// Compute totals for each body and voxel overlaps.
+	type bodyPair struct {
+		a, b BodyId
+	}
+	totalA := make(map[BodyId]int, len(body1ToSpMap))
+	totalB := make(map[BodyId]int)
+	voxels := make(map[bodyPair]int)
+	for bodyA, superpixels := range body1ToSpMap {
+		for _, superpixel := range superpixels {
+			totalA[bodyA] += weight(superpixel)
+			if bodyB, found := sp2ToBodyMap[superpixel]; found {
+				totalB[bodyB] = 0
+				voxels[bodyPair{bodyA, bodyB}] += weight(superpixel)
+			}
+		}
+	}
+	for superpixel, bodyB := range sp2ToBodyMap {
+		if _, found := totalB[bodyB]; found {
+			totalB[bodyB] += weight(superpixel)
+		}
+	}
+
+	for bodyA, overlaps := range overlapsMap {
+		for bodyB, count := range overlaps {
+			if bodyB == 0 || count < minOverlap {
+				continue
+			}
+			overlap := BodyOverlap{BodyA: bodyA, BodyB: bodyB,
+				Superpixels: count}
+			common := count
+			if spBoundsMap != nil {
+				overlap.Voxels = voxels[bodyPair{bodyA, bodyB}]
+				common = overlap.Voxels
+			}
+			if totalA[bodyA] > 0 {
+				overlap.FractionA = float64(common) / float64(totalA[bodyA])
+			}
+			if totalB[bodyB] > 0 {
+				overlap.FractionB = float64(common) / float64(totalB[bodyB])
 			}
+			matrix = append(matrix, overlap)
 		}
-		if matchedBodyId == 0 {
-			log.Println("** Warning: Could not find overlapping body ",
-				"for body ", bodyId1)
+	}
+	sort.Sort(matrix)
+	return
+}
+
+// WriteCsv writes the overlap matrix as a sparse CSV with one line per
+// overlapping pair of bodies.
+func (matrix BodyOverlapList) WriteCsv(writer io.Writer) {
+	csvWriter := csv.NewWriter(writer)
+	record := []string{"Body A", "Body B", "Overlapping superpixels",
+		"Overlapping voxels", "Fraction of A", "Fraction of B"}
+	err := csvWriter.Write(record)
+	if err != nil {
+		log.Fatalln("ERROR: Unable to write header to CSV:", err)
+	}
+	for _, overlap := range matrix {
+		record := []string{
+			overlap.BodyA.String(),
+			overlap.BodyB.String(),
+			strconv.Itoa(overlap.Superpixels),
+			strconv.Itoa(overlap.Voxels),
+			strconv.FormatFloat(overlap.FractionA, 'f', 4, 64),
+			strconv.FormatFloat(overlap.FractionB, 'f', 4, 64),
+		}
+		err := csvWriter.Write(record)
+		if err != nil {
+			log.Fatalln("ERROR: Unable to write line of CSV for bodies",
+				overlap.BodyA, overlap.BodyB, ":", err)
 		}
-		matchingMap[bodyId1] = BestOverlap{matchedBodyId, largest,
-			maximumOverlap}
 	}
+	csvWriter.Flush()
+}
+
+// WriteCsvFile writes the overlap matrix into a CSV file.
+func (matrix BodyOverlapList) WriteCsvFile(filename string) {
+	file, err := os.Create(filename)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to create overlap matrix csv file: %s [%s]\n",
+			filename, err)
+	}
+	matrix.WriteCsv(file)
+	file.Close()
+}
+
+// compareStackBounds compares the superpixel bounds of the superpixels
+// in a body->superpixels map between two stacks that have bounds files.
+func compareStackBounds(stack1, stack2 MappedStack,
+	bodyToSpMap BodyToSuperpixelsMap, options BoundsDiffOptions) (
+	diff BoundsDiff, err error) {
+
+	spBounds1, err := readStackBounds(stack1, bodyToSpMap)
+	if err != nil {
+		return
+	}
+	spBounds2, err := readStackBounds(stack2, bodyToSpMap)
+	if err != nil {
+		return
+	}
+	diff = CompareSuperpixelBounds(spBounds1, spBounds2, options)
 	return
 }
 
+// readStackBounds reads a stack's superpixel bounds for the superpixels
+// of the given bodies.
+func readStackBounds(stack MappedStack,
+	bodyToSpMap BodyToSuperpixelsMap) (SuperpixelBoundsMap, error) {
+
+	type boundedStack interface {
+		StackSuperpixelBoundsFilename() string
+	}
+	bounded, ok := stack.(boundedStack)
+	if !ok {
+		return nil, fmt.Errorf("cannot read superpixel bounds of %s", stack)
+	}
+	superpixelSet := make(map[Superpixel]bool)
+	for _, superpixels := range bodyToSpMap {
+		for _, superpixel := range superpixels {
+			superpixelSet[superpixel] = true
+		}
+	}
+	return ReadSuperpixelBounds(bounded.StackSuperpixelBoundsFilename(),
+		superpixelSet)
+}
+
 // SessionDir is a directory path to a session, which implies data
 // must be also retrieved from its base stack.
 type Session struct {
@@ -771,6 +2406,70 @@ type ExportedStack struct {
 	Base BaseStack
 }
 
+// ZeroIsEdge returns true if body 0 marks membranes and edges in the
+// stack rather than failed lookups.
+func (stack *Stack) ZeroIsEdge() bool {
+	return stack.EdgeBodyZero
+}
+
+// ZeroIsEdge returns true if the exported stack or its base stack use
+// body 0 for membranes and edges.
+func (stack *ExportedStack) ZeroIsEdge() bool {
+	return stack.EdgeBodyZero || stack.Base.ZeroIsEdge()
+}
+
+// useBaseMaps makes the export read any map file it lacks from its base.
+func (stack *ExportedStack) useBaseMaps() {
+	baseDir := stack.Base.String()
+	stack.mapLock.RLock()
+	set := stack.mapFallback == baseDir
+	stack.mapLock.RUnlock()
+	if !set {
+		stack.mapLock.Lock()
+		stack.mapFallback = baseDir
+		stack.mapLock.Unlock()
+	}
+}
+
+// ReadTxtMaps loads superpixel->body maps, reading either map file from
+// the base stack if the export doesn't have it.
+func (stack *ExportedStack) ReadTxtMaps() error {
+	stack.useBaseMaps()
+	return stack.Stack.ReadTxtMaps()
+}
+
+// SuperpixelToBody returns a body id for a given superpixel using maps
+// from the export or, if missing, its base stack.
+func (stack *ExportedStack) SuperpixelToBody(s Superpixel) BodyId {
+	stack.useBaseMaps()
+	return stack.Stack.SuperpixelToBody(s)
+}
+
+// SuperpixelToBodyChecked is like SuperpixelToBody but also returns
+// whether the superpixel was in the map.
+func (stack *ExportedStack) SuperpixelToBodyChecked(s Superpixel) (
+	BodyId, bool) {
+
+	stack.useBaseMaps()
+	return stack.Stack.SuperpixelToBodyChecked(s)
+}
+
+// GetSuperpixelToBodyMap returns a superpixel->body map using maps from
+// the export or, if missing, its base stack.
+func (stack *ExportedStack) GetSuperpixelToBodyMap() SuperpixelToBodyMap {
+	stack.useBaseMaps()
+	return stack.Stack.GetSuperpixelToBodyMap()
+}
+
+// GetBodyToSuperpixelsMap returns a body->(superpixel set) map for a set
+// of bodies using maps from the export or, if missing, its base stack.
+func (stack *ExportedStack) GetBodyToSuperpixelsMap(bodySet BodySet) (
+	bodyToSpMap BodyToSuperpixelsMap) {
+
+	stack.useBaseMaps()
+	return stack.Stack.GetBodyToSuperpixelsMap(bodySet)
+}
+
 func (stack *ExportedStack) StackSynapsesJsonFilename() string {
 	return StackSynapsesJsonFilename(stack.Directory)
 }
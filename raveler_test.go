@@ -0,0 +1,1197 @@
+// Copyright 2012 HHMI.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//     * Neither the name of HHMI nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+// Author: katzw@janelia.hhmi.org (Bill Katz)
+//  Written as part of the FlyEM Project at Janelia Farm Research Center.
+
+package emdata
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// setStrictness sets DefaultStrictness for the duration of a test.
+func setStrictness(t *testing.T, strictness Strictness) {
+	saved := DefaultStrictness
+	DefaultStrictness = strictness
+	t.Cleanup(func() { DefaultStrictness = saved })
+}
+
+func TestStrictnessOfCorruptMap(t *testing.T) {
+	const corrupt = "1 10\n2 x\n3 30\n"
+
+	setStrictness(t, Strict)
+	_, _, err := ReadSegmentToBodyMapFromWithWarnings(strings.NewReader(corrupt))
+	if _, ok := err.(*AnomalyError); !ok {
+		t.Fatalf("Strict: expected *AnomalyError, got %v", err)
+	}
+
+	DefaultStrictness = Lenient
+	segmentToBodyMap, warnings, err := ReadSegmentToBodyMapFromWithWarnings(
+		strings.NewReader(corrupt))
+	if err != nil {
+		t.Fatalf("Lenient: unexpected error: %s", err)
+	}
+	if len(segmentToBodyMap) != 2 || segmentToBodyMap[3] != 30 {
+		t.Errorf("Lenient: got map %v", segmentToBodyMap)
+	}
+	if warnings.Count("malformed map line") != 1 {
+		t.Errorf("Lenient: got warnings %s", warnings)
+	}
+}
+
+func TestStackErrReset(t *testing.T) {
+	synth, err := CreateSyntheticStack(t.TempDir(), DefaultSyntheticStackParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stack := &synth.Stack
+	mapFilename := filepath.Join(stack.String(), SegmentToBodyFilename)
+	if err = os.Rename(mapFilename, mapFilename+".moved"); err != nil {
+		t.Fatal(err)
+	}
+	if stack.ReadTxtMaps() == nil || stack.Err() == nil {
+		t.Fatal("expected error loading stack without segment->body map")
+	}
+	if err = os.Rename(mapFilename+".moved", mapFilename); err != nil {
+		t.Fatal(err)
+	}
+	// The failed load is kept until ClearTxtMaps.
+	if stack.ReadTxtMaps() == nil || stack.MapLoaded() {
+		t.Fatal("expected failed load to be kept until ClearTxtMaps")
+	}
+	stack.ClearTxtMaps()
+	if stack.Err() != nil {
+		t.Errorf("Err() after ClearTxtMaps: %s", stack.Err())
+	}
+	if err = stack.ReadTxtMaps(); err != nil {
+		t.Fatal(err)
+	}
+	if stack.Err() != nil {
+		t.Errorf("Err() after successful load: %s", stack.Err())
+	}
+
+	boundsFilename := stack.StackSuperpixelBoundsFilename()
+	if err = os.Rename(boundsFilename, boundsFilename+".moved"); err != nil {
+		t.Fatal(err)
+	}
+	if stack.ReadSuperpixelBounds() == nil || stack.Err() == nil {
+		t.Fatal("expected error loading missing superpixel bounds")
+	}
+	if err = os.Rename(boundsFilename+".moved", boundsFilename); err != nil {
+		t.Fatal(err)
+	}
+	if err = stack.ReadSuperpixelBounds(); err != nil {
+		t.Fatal(err)
+	}
+	if stack.Err() != nil {
+		t.Errorf("Err() after successful bounds load: %s", stack.Err())
+	}
+}
+
+func TestMalformedMapsReturnErrors(t *testing.T) {
+	setStrictness(t, Strict)
+	readSpToSegment := func(text string) error {
+		_, err := ReadSuperpixelToSegmentMapFrom(strings.NewReader(text))
+		return err
+	}
+	readSegmentToBody := func(text string) error {
+		_, err := ReadSegmentToBodyMapFrom(strings.NewReader(text))
+		return err
+	}
+	readBounds := func(text string) error {
+		_, err := ReadSuperpixelBoundsFrom(strings.NewReader(text), nil)
+		return err
+	}
+	tests := []struct {
+		name   string
+		read   func(string) error
+		text   string
+		failed bool
+	}{
+		{"sp->seg ok", readSpToSegment, "# comment\n1 2 3\n", false},
+		{"sp->seg too few fields", readSpToSegment, "1 2\n", true},
+		{"sp->seg letters", readSpToSegment, "1 two 3\n", true},
+		{"sp->seg negative label", readSpToSegment, "1 -2 3\n", true},
+		{"sp->seg huge slice", readSpToSegment, "4294967296 2 3\n", true},
+		{"seg->body ok", readSegmentToBody, "3 30\n4 40", false},
+		{"seg->body one field", readSegmentToBody, "3\n", true},
+		{"seg->body separator", readSegmentToBody, "3,30\n", true},
+		{"bounds ok", readBounds, "1 2 0 0 10 10 50\n", false},
+		{"bounds short", readBounds, "1 2 0 0 10 10\n", true},
+		{"bounds float", readBounds, "1 2 0.5 0 10 10 50\n", true},
+
+		// Indented comments and blank lines are skipped.  Fields after
+		// those needed are ignored in maps, but bounds need exactly 7.
+		{"sp->seg indented comment", readSpToSegment, "\t# c\n", false},
+		{"sp->seg blank lines", readSpToSegment, "\n  \n", false},
+		{"sp->seg extra field", readSpToSegment, "1 2 3 4\n", false},
+		{"seg->body indented comment", readSegmentToBody, "\t# c\n", false},
+		{"seg->body blank lines", readSegmentToBody, "\n  \n", false},
+		{"seg->body extra fields", readSegmentToBody, "1 2 3 4\n", false},
+		{"bounds indented comment", readBounds, "\t# c\n", false},
+		{"bounds blank lines", readBounds, "\n  \n", false},
+		{"bounds 4 fields", readBounds, "1 2 3 4\n", true},
+	}
+	for _, test := range tests {
+		err := test.read(test.text)
+		if test.failed && err == nil {
+			t.Errorf("%s: expected error", test.name)
+		} else if !test.failed && err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		}
+	}
+}
+
+func TestMalformedFilesReturnErrors(t *testing.T) {
+	setStrictness(t, Strict)
+	dir := t.TempDir()
+	writeFile := func(name, text string) string {
+		filename := filepath.Join(dir, name)
+		if err := os.WriteFile(filename, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+	writeFile(SuperpixelToSegmentFilename, "1 2 3\n1 2\n")
+	writeFile(SegmentToBodyFilename, "3 30\n")
+	if _, err := ReadTxtMaps(dir); err == nil {
+		t.Error("ReadTxtMaps: expected error for malformed map")
+	}
+	if _, err := ReadTxtMaps(filepath.Join(dir, "missing")); err == nil {
+		t.Error("ReadTxtMaps: expected error for missing stack")
+	}
+	bounds := writeFile(SuperpixelBoundsFilename, "1 2 x\n")
+	if _, err := ReadSuperpixelBounds(bounds, nil); err == nil {
+		t.Error("ReadSuperpixelBounds: expected error for malformed bounds")
+	}
+	for _, text := range []string{"", "{", `{"data": 5}`} {
+		filename := writeFile("annotations.json", text)
+		if _, err := ReadBodiesJson(filename); err == nil {
+			t.Errorf("ReadBodiesJson(%q): expected error", text)
+		}
+		if _, err := ReadSynapsesJson(filename); err == nil {
+			t.Errorf("ReadSynapsesJson(%q): expected error", text)
+		}
+	}
+}
+
+func TestReadMapLineEndings(t *testing.T) {
+	tests := []struct {
+		name        string
+		spToSegment string
+		segToBody   string
+		bounds      string
+	}{
+		{"LF terminated", "1 1 10\n1 2 20\n", "10 100\n20 200\n",
+			"1 1 0 0 5 5 25\n1 2 5 5 5 5 25\n"},
+		{"unterminated", "1 1 10\n1 2 20", "10 100\n20 200",
+			"1 1 0 0 5 5 25\n1 2 5 5 5 5 25"},
+		{"CRLF", "1 1 10\r\n\r\n1 2 20\r\n", "10 100\r\n20 200\r\n",
+			"1 1 0 0 5 5 25\r\n1 2 5 5 5 5 25\r\n"},
+		{"CRLF unterminated", "1 1 10\r\n1 2 20", "10 100\r\n20 200",
+			"1 1 0 0 5 5 25\r\n1 2 5 5 5 5 25"},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		files := map[string]string{
+			SuperpixelToSegmentFilename: test.spToSegment,
+			SegmentToBodyFilename:       test.segToBody,
+			SuperpixelBoundsFilename:    test.bounds,
+		}
+		for name, text := range files {
+			err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		spToBodyMap, err := ReadTxtMaps(dir)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if len(spToBodyMap) != 2 || spToBodyMap[Superpixel{1, 2}] != 200 {
+			t.Errorf("%s: got map %v", test.name, spToBodyMap)
+		}
+		spBoundsMap, err := ReadSuperpixelBounds(
+			filepath.Join(dir, SuperpixelBoundsFilename), nil)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if len(spBoundsMap) != 2 || spBoundsMap[Superpixel{1, 2}].MinX != 5 {
+			t.Errorf("%s: got bounds %v", test.name, spBoundsMap)
+		}
+	}
+}
+
+// testSpToBodyMap returns a small superpixel->body map with bodies that
+// span slices, including body 0.
+func testSpToBodyMap() SuperpixelToBodyMap {
+	return SuperpixelToBodyMap{
+		{1, 0}: 0,
+		{1, 1}: 10,
+		{1, 2}: 10,
+		{1, 3}: 20,
+		{2, 1}: 10,
+		{2, 2}: 20,
+		{2, 5}: 0,
+		{3, 7}: 30,
+	}
+}
+
+func TestWriteTxtMapsRoundTrip(t *testing.T) {
+	spToBodyMap := testSpToBodyMap()
+	for _, gzipped := range []bool{false, true} {
+		dir := t.TempDir()
+		err := spToBodyMap.WriteTxtMapsWithOptions(dir,
+			TxtMapOptions{Gzip: gzipped})
+		if err != nil {
+			t.Fatal(err)
+		}
+		read, err := ReadTxtMaps(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(read) != len(spToBodyMap) {
+			t.Errorf("gzip %t: expected %d superpixels, got %d", gzipped,
+				len(spToBodyMap), len(read))
+		}
+		for superpixel, bodyId := range spToBodyMap {
+			if readId, found := read[superpixel]; !found || readId != bodyId {
+				t.Errorf("gzip %t: superpixel %v expected body %d, got %d",
+					gzipped, superpixel, bodyId, readId)
+			}
+		}
+	}
+}
+
+// gzipTextFile replaces a text file with a gzipped copy.
+func gzipTextFile(t *testing.T, filename string) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, err := createTextFile(filename + GzipSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Remove(filename); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadTxtMapsMixedGzip(t *testing.T) {
+	spToBodyMap := testSpToBodyMap()
+	for _, gzipped := range []string{SuperpixelToSegmentFilename,
+		SegmentToBodyFilename} {
+
+		dir := t.TempDir()
+		if err := spToBodyMap.WriteTxtMaps(dir); err != nil {
+			t.Fatal(err)
+		}
+		gzipTextFile(t, filepath.Join(dir, gzipped))
+		read, err := ReadTxtMaps(dir)
+		if err != nil {
+			t.Fatalf("gzipped %s: %s", gzipped, err)
+		}
+		if !reflect.DeepEqual(read, spToBodyMap) {
+			t.Errorf("gzipped %s: expected %v, got %v", gzipped, spToBodyMap,
+				read)
+		}
+	}
+
+	spBoundsMap := SuperpixelBoundsMap{
+		{1, 2}: {5, 6, 10, 11, 50},
+		{3, 7}: {0, 0, 1, 1, 1},
+	}
+	filename := filepath.Join(t.TempDir(), SuperpixelBoundsFilename)
+	spBoundsMap.WriteBoundsFile(filename)
+	gzipTextFile(t, filename)
+	for _, name := range []string{filename, filename + GzipSuffix} {
+		read, err := ReadSuperpixelBounds(name, nil)
+		if err != nil {
+			t.Fatalf("%s: %s", filepath.Base(name), err)
+		}
+		if !reflect.DeepEqual(read, spBoundsMap) {
+			t.Errorf("%s: expected bounds %v, got %v", filepath.Base(name),
+				spBoundsMap, read)
+		}
+	}
+}
+
+func TestWriteTxtMapsDeterministic(t *testing.T) {
+	spToBodyMap := testSpToBodyMap()
+	for superpixel, bodyId := range testSpToBodyMap() {
+		superpixel.Slice += 4
+		spToBodyMap[superpixel] = bodyId + 1
+	}
+	for _, gzipped := range []bool{false, true} {
+		options := TxtMapOptions{Gzip: gzipped}
+		var files [][]byte
+		for run := 0; run < 2; run++ {
+			dir := t.TempDir()
+			err := spToBodyMap.WriteTxtMapsWithOptions(dir, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{SuperpixelToSegmentFilename,
+				SegmentToBodyFilename} {
+				if gzipped {
+					name += GzipSuffix
+				}
+				data, err := os.ReadFile(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				files = append(files, data)
+			}
+		}
+		if !bytes.Equal(files[0], files[2]) {
+			t.Errorf("gzip %t: superpixel->segment files differ:\n%s\n%s",
+				gzipped, files[0], files[2])
+		}
+		if !bytes.Equal(files[1], files[3]) {
+			t.Errorf("gzip %t: segment->body files differ:\n%s\n%s",
+				gzipped, files[1], files[3])
+		}
+	}
+
+	// Lines are sorted by slice and label.
+	dir := t.TempDir()
+	if err := spToBodyMap.WriteTxtMaps(dir); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, SuperpixelToSegmentFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var prior Superpixel
+	numLines := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		var superpixel Superpixel
+		var segment int
+		_, err := fmt.Sscanf(line, "%d %d %d", &superpixel.Slice,
+			&superpixel.Label, &segment)
+		if err != nil {
+			continue
+		}
+		if numLines > 0 && (superpixel.Slice < prior.Slice ||
+			superpixel.Slice == prior.Slice &&
+				superpixel.Label <= prior.Label) {
+			t.Errorf("superpixel %v written after %v", superpixel, prior)
+		}
+		prior = superpixel
+		numLines++
+	}
+	if numLines != len(spToBodyMap) {
+		t.Errorf("expected %d superpixel lines, got %d", len(spToBodyMap),
+			numLines)
+	}
+}
+
+func TestReadTxtMapsWithFallback(t *testing.T) {
+	baseDir := t.TempDir()
+	writeFile := func(dir, name, text string) {
+		filename := filepath.Join(dir, name)
+		if err := os.WriteFile(filename, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(baseDir, SuperpixelToSegmentFilename, "1 1 1\n1 2 2\n2 1 3\n")
+	writeFile(baseDir, SegmentToBodyFilename, "1 10\n2 20\n3 10\n")
+
+	// The export's files replace the base's, file by file.
+	tests := []struct {
+		name      string
+		spToSeg   string
+		segToBody string
+		sources   TxtMapSources
+		expected  SuperpixelToBodyMap
+	}{
+		{"only base", "", "", TxtMapSources{baseDir, baseDir},
+			SuperpixelToBodyMap{{1, 1}: 10, {1, 2}: 20, {2, 1}: 10}},
+		{"local segment->body", "", "1 11\n2 20\n3 30\n",
+			TxtMapSources{baseDir, "export"},
+			SuperpixelToBodyMap{{1, 1}: 11, {1, 2}: 20, {2, 1}: 30}},
+		{"local superpixel->segment", "1 1 2\n1 2 1\n2 1 3\n", "",
+			TxtMapSources{"export", baseDir},
+			SuperpixelToBodyMap{{1, 1}: 20, {1, 2}: 10, {2, 1}: 10}},
+		{"all local", "2 1 1\n", "1 40\n", TxtMapSources{"export", "export"},
+			SuperpixelToBodyMap{{2, 1}: 40}},
+	}
+	for _, test := range tests {
+		exportDir := t.TempDir()
+		if test.spToSeg != "" {
+			writeFile(exportDir, SuperpixelToSegmentFilename, test.spToSeg)
+		}
+		if test.segToBody != "" {
+			writeFile(exportDir, SegmentToBodyFilename, test.segToBody)
+		}
+		expectedSources := test.sources
+		for _, source := range []*string{&expectedSources.SuperpixelToSegment,
+			&expectedSources.SegmentToBody} {
+			if *source == "export" {
+				*source = exportDir
+			}
+		}
+		spToBodyMap, sources, err := ReadTxtMapsWithFallback(exportDir,
+			baseDir)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if sources != expectedSources {
+			t.Errorf("%s: expected sources %+v, got %+v", test.name,
+				expectedSources, sources)
+		}
+		if !reflect.DeepEqual(spToBodyMap, test.expected) {
+			t.Errorf("%s: expected map %v, got %v", test.name,
+				test.expected, spToBodyMap)
+		}
+	}
+
+	// Without a fallback a bare export has no maps.
+	if _, _, err := ReadTxtMapsWithFallback(t.TempDir(), ""); err == nil {
+		t.Error("expected error reading maps without a fallback")
+	}
+}
+
+func TestExportedStackUsesBaseMaps(t *testing.T) {
+	synth, err := CreateSyntheticStack(t.TempDir(), DefaultSyntheticStackParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported := CreateExportedStack(t.TempDir(), synth.Directory)
+	exported.Base.Tiles = synth.Tiles
+	for _, superpixel := range []Superpixel{{0, 1}, {2, 20}, {3, 64}} {
+		bodyId, _ := GetBodyOfLocation(exported, synth.Center(superpixel))
+		if bodyId != synth.SpToBodyMap[superpixel] {
+			t.Errorf("superpixel %v: expected body %d, got %d", superpixel,
+				synth.SpToBodyMap[superpixel], bodyId)
+		}
+	}
+	baseDir := exported.Base.String()
+	expected := TxtMapSources{baseDir, baseDir}
+	if sources := exported.MapSources(); sources != expected {
+		t.Errorf("expected map sources %+v, got %+v", expected, sources)
+	}
+}
+
+func TestStreamTxtMaps(t *testing.T) {
+	spToBodyMap := testSpToBodyMap()
+	dir := t.TempDir()
+	if err := spToBodyMap.WriteTxtMaps(dir); err != nil {
+		t.Fatal(err)
+	}
+	streamed := make(SuperpixelToBodyMap)
+	err := StreamTxtMaps(dir, func(superpixel Superpixel, body BodyId) error {
+		streamed[superpixel] = body
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(streamed, spToBodyMap) {
+		t.Errorf("expected streamed map %v, got %v", spToBodyMap, streamed)
+	}
+
+	// The first error from fn stops iteration and is returned.
+	stop := errors.New("stop")
+	calls := 0
+	err = StreamTxtMaps(dir, func(superpixel Superpixel, body BodyId) error {
+		calls++
+		if calls == 3 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || calls != 3 {
+		t.Errorf("expected stop error after 3 calls, got %v after %d",
+			err, calls)
+	}
+
+	// A superpixel listed twice is passed twice, in file order.
+	dir = t.TempDir()
+	files := map[string]string{
+		SuperpixelToSegmentFilename: "1 1 1\n1 2 2\n1 1 2\n",
+		SegmentToBodyFilename:       "1 10\n2 20\n",
+	}
+	for name, text := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	type pair struct {
+		superpixel Superpixel
+		body       BodyId
+	}
+	var pairs []pair
+	err = StreamTxtMaps(dir, func(superpixel Superpixel, body BodyId) error {
+		pairs = append(pairs, pair{superpixel, body})
+		return nil
+	})
+	expected := []pair{{Superpixel{1, 1}, 10}, {Superpixel{1, 2}, 20},
+		{Superpixel{1, 1}, 20}}
+	if err != nil || !reflect.DeepEqual(pairs, expected) {
+		t.Errorf("expected %v, got %v (%v)", expected, pairs, err)
+	}
+}
+
+func TestInvertSuperpixelToBodyMap(t *testing.T) {
+	spToBodyMap := testSpToBodyMap()
+	spToBodyMap[Superpixel{1, 9}] = 30
+	spToBodyMap[Superpixel{2, 8}] = 10
+	expected := BodyToSuperpixelsMap{
+		0:  {{1, 0}, {2, 5}},
+		10: {{1, 1}, {1, 2}, {2, 1}, {2, 8}},
+		20: {{1, 3}, {2, 2}},
+		30: {{1, 9}, {3, 7}},
+	}
+	inverted := spToBodyMap.Invert()
+	if !reflect.DeepEqual(inverted, expected) {
+		t.Errorf("expected inverted map %v, got %v", expected, inverted)
+	}
+	if bodies := inverted.Bodies(); !reflect.DeepEqual(bodies,
+		[]BodyId{0, 10, 20, 30}) {
+		t.Errorf("expected sorted bodies, got %v", bodies)
+	}
+	if all := spToBodyMap.InvertWhere(nil); !reflect.DeepEqual(all,
+		inverted) {
+		t.Errorf("nil predicate: expected %v, got %v", inverted, all)
+	}
+
+	// Inverting back gives the original map.
+	roundTrip := make(SuperpixelToBodyMap)
+	for bodyId, superpixels := range inverted {
+		for _, superpixel := range superpixels {
+			roundTrip[superpixel] = bodyId
+		}
+	}
+	if !reflect.DeepEqual(roundTrip, spToBodyMap) {
+		t.Errorf("expected %v after round trip, got %v", spToBodyMap,
+			roundTrip)
+	}
+
+	const minZ, maxZ = 2, 3
+	zRange := spToBodyMap.InvertWhere(func(superpixel Superpixel,
+		bodyId BodyId) bool {
+
+		return superpixel.Slice >= minZ && superpixel.Slice <= maxZ
+	})
+	expected = BodyToSuperpixelsMap{
+		0:  {{2, 5}},
+		10: {{2, 1}, {2, 8}},
+		20: {{2, 2}},
+		30: {{3, 7}},
+	}
+	if !reflect.DeepEqual(zRange, expected) {
+		t.Errorf("slices 2-3: expected %v, got %v", expected, zRange)
+	}
+	bodyOnly := spToBodyMap.InvertWhere(func(superpixel Superpixel,
+		bodyId BodyId) bool {
+
+		return bodyId == 30
+	})
+	if bodies := bodyOnly.Bodies(); !reflect.DeepEqual(bodies,
+		[]BodyId{30}) {
+		t.Errorf("body 30 predicate: expected body 30, got %v", bodies)
+	}
+	none := spToBodyMap.InvertWhere(func(Superpixel, BodyId) bool {
+		return false
+	})
+	if len(none) != 0 || len(none.Bodies()) != 0 {
+		t.Errorf("expected empty map, got %v", none)
+	}
+}
+
+// writeReadTxtMaps writes a map as .txt files and reads it back.
+func writeReadTxtMaps(t *testing.T,
+	spToBodyMap SuperpixelToBodyMap) SuperpixelToBodyMap {
+
+	dir := t.TempDir()
+	if err := spToBodyMap.WriteTxtMaps(dir); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadTxtMaps(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return read
+}
+
+func TestApplyMerges(t *testing.T) {
+	tests := []struct {
+		name    string
+		merges  map[BodyId]BodyId
+		changed int
+		failed  bool
+		bodies  map[BodyId]BodyId // Expected final body of each body
+	}{
+		{"chain", map[BodyId]BodyId{10: 20, 20: 30}, 5, false,
+			map[BodyId]BodyId{0: 0, 10: 30, 20: 30, 30: 30}},
+		{"self merge", map[BodyId]BodyId{10: 10}, 0, false,
+			map[BodyId]BodyId{0: 0, 10: 10, 20: 20, 30: 30}},
+		{"new body", map[BodyId]BodyId{30: 40}, 1, false,
+			map[BodyId]BodyId{0: 0, 10: 10, 20: 20, 30: 40}},
+		{"cycle", map[BodyId]BodyId{10: 20, 20: 10}, 0, true,
+			map[BodyId]BodyId{0: 0, 10: 10, 20: 20, 30: 30}},
+		{"long cycle", map[BodyId]BodyId{10: 20, 20: 30, 30: 10}, 0, true,
+			map[BodyId]BodyId{0: 0, 10: 10, 20: 20, 30: 30}},
+	}
+	for _, test := range tests {
+		spToBodyMap := testSpToBodyMap()
+		changed, err := spToBodyMap.ApplyMerges(test.merges)
+		if test.failed != (err != nil) || changed != test.changed {
+			t.Errorf("%s: expected %d changed (error %t), got %d (%v)",
+				test.name, test.changed, test.failed, changed, err)
+		}
+		expected := testSpToBodyMap()
+		for superpixel, bodyId := range expected {
+			expected[superpixel] = test.bodies[bodyId]
+		}
+		if !reflect.DeepEqual(spToBodyMap, expected) {
+			t.Errorf("%s: expected map %v, got %v", test.name, expected,
+				spToBodyMap)
+		}
+		if read := writeReadTxtMaps(t, spToBodyMap); !reflect.DeepEqual(read,
+			expected) {
+			t.Errorf("%s: expected %v after writing, got %v", test.name,
+				expected, read)
+		}
+	}
+}
+
+func TestApplySplit(t *testing.T) {
+	spToBodyMap := testSpToBodyMap()
+
+	// Every superpixel of body 20 moves, so body 20 disappears.
+	changedBodies := spToBodyMap.ApplySplit(SuperpixelToBodyMap{
+		{1, 3}: 40,
+		{2, 2}: 40,
+		{1, 1}: 10, // Unchanged
+		{4, 1}: 50, // New superpixel
+	})
+	expectedChanged := BodySet{20: true, 40: true, 50: true}
+	if !reflect.DeepEqual(changedBodies, expectedChanged) {
+		t.Errorf("expected changed bodies %v, got %v", expectedChanged,
+			changedBodies)
+	}
+	expected := testSpToBodyMap()
+	expected[Superpixel{1, 3}] = 40
+	expected[Superpixel{2, 2}] = 40
+	expected[Superpixel{4, 1}] = 50
+	if !reflect.DeepEqual(spToBodyMap, expected) {
+		t.Errorf("expected map %v, got %v", expected, spToBodyMap)
+	}
+	if _, found := spToBodyMap.Invert()[20]; found {
+		t.Errorf("body 20 still has superpixels after split")
+	}
+	if read := writeReadTxtMaps(t, spToBodyMap); !reflect.DeepEqual(read,
+		expected) {
+		t.Errorf("expected %v after writing, got %v", expected, read)
+	}
+
+	if changedBodies = spToBodyMap.ApplySplit(nil); len(changedBodies) != 0 {
+		t.Errorf("expected no changed bodies, got %v", changedBodies)
+	}
+}
+
+func TestWriteTxtMapsError(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "missing")
+	if err := testSpToBodyMap().WriteTxtMaps(dir); err == nil {
+		t.Error("expected error writing into a missing directory")
+	}
+}
+
+func TestConcurrentSuperpixelToBody(t *testing.T) {
+	synth, err := CreateSyntheticStack(t.TempDir(), DefaultSyntheticStackParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stack := &synth.Stack
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for superpixel, bodyId := range synth.SpToBodyMap {
+				if got := stack.SuperpixelToBody(superpixel); got != bodyId {
+					t.Errorf("goroutine %d: superpixel %v expected body %d, "+
+						"got %d", i, superpixel, bodyId, got)
+					return
+				}
+			}
+			if i%4 == 0 {
+				stack.ClearTxtMaps()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if err = stack.Err(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReadMapsFromMatchFiles(t *testing.T) {
+	tests := []struct {
+		name        string
+		spToSegment string
+		segToBody   string
+		bounds      string
+		superpixels map[Superpixel]bool
+	}{
+		{"simple", "1 1 10\n1 2 20\n", "10 100\n20 200\n",
+			"1 1 0 0 5 5 25\n1 2 5 5 5 5 25\n", nil},
+		{"comments and blanks", "# sp->seg\n\n1 1 10\n  2 1 10  \n",
+			"# seg->body\n10 100\n\n", "# bounds\n1 1 0 0 5 5 25\n\n", nil},
+		{"unmapped segment", "1 1 10\n1 2 30\n", "10 100\n",
+			"1 1 0 0 5 5 25\n", nil},
+		{"bounds subset", "1 1 10\n1 2 20\n", "10 100\n20 200\n",
+			"1 1 0 0 5 5 25\n1 2 5 5 5 5 25\n",
+			map[Superpixel]bool{{1, 2}: true}},
+	}
+	for _, test := range tests {
+		spToSegmentMap, err := ReadSuperpixelToSegmentMapFrom(
+			strings.NewReader(test.spToSegment))
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		segmentToBodyMap, err := ReadSegmentToBodyMapFrom(
+			strings.NewReader(test.segToBody))
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		spBoundsMap, err := ReadSuperpixelBoundsFrom(
+			strings.NewReader(test.bounds), test.superpixels)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+
+		dir := t.TempDir()
+		files := map[string]string{
+			SuperpixelToSegmentFilename: test.spToSegment,
+			SegmentToBodyFilename:       test.segToBody,
+			SuperpixelBoundsFilename:    test.bounds,
+		}
+		for name, text := range files {
+			err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		spToBodyMap, err := ReadTxtMaps(dir)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if len(spToBodyMap) != len(spToSegmentMap) {
+			t.Errorf("%s: file map has %d superpixels, reader map has %d",
+				test.name, len(spToBodyMap), len(spToSegmentMap))
+		}
+		for superpixel, segment := range spToSegmentMap {
+			if body := segmentToBodyMap[segment]; spToBodyMap[superpixel] != body {
+				t.Errorf("%s: superpixel %v -> body %d from file, %d from reader",
+					test.name, superpixel, spToBodyMap[superpixel], body)
+			}
+		}
+		fileBoundsMap, err := ReadSuperpixelBounds(
+			filepath.Join(dir, SuperpixelBoundsFilename), test.superpixels)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if !reflect.DeepEqual(fileBoundsMap, spBoundsMap) {
+			t.Errorf("%s: bounds %v from file, %v from reader", test.name,
+				fileBoundsMap, spBoundsMap)
+		}
+	}
+}
+
+// writeBenchmarkTxtMaps writes a stack directory whose superpixel->segment
+// map has the given number of lines and silences logging while the
+// benchmark runs.
+func writeBenchmarkTxtMaps(b *testing.B, lines int) string {
+	dir := b.TempDir()
+	const superpixelsPerSlice = 10000
+	var spToSegment, segToBody bytes.Buffer
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(&spToSegment, "%d %d %d\n", i/superpixelsPerSlice,
+			i%superpixelsPerSlice+1, i+1)
+		fmt.Fprintf(&segToBody, "%d %d\n", i+1, i/100+1)
+	}
+	files := map[string][]byte{
+		SuperpixelToSegmentFilename: spToSegment.Bytes(),
+		SegmentToBodyFilename:       segToBody.Bytes(),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return dir
+}
+
+func BenchmarkReadTxtMapsProgress(b *testing.B) {
+	dir := writeBenchmarkTxtMaps(b, 200000)
+	progressFuncs := []struct {
+		name string
+		fn   ProgressFunc
+	}{
+		{"nil", nil},
+		{"func", func(stage string, linesRead, approxTotal int) {}},
+	}
+	for _, progress := range progressFuncs {
+		b.Run(progress.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := ReadTxtMapsWithProgress(dir, progress.fn); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestScanIntFieldsMatchesSscanf(t *testing.T) {
+	lines := []string{
+		"1 2 3", "1\t2\t3", "  1  2  3  ", "+1 -2 3", "1 2 3 4",
+		"1 2 3abc", "1 2 3,4", "1 2 3.5", "1 2 3#comment", "1 2-3",
+		"1 2", "", "1 2abc 3", "1,2,3", "1 2 x", "1 2 3_4", "1 - 3",
+		"1 2 99999999999999999999", "1 2 -", "0x1 2 3",
+	}
+	for _, line := range lines {
+		var expected [3]int64
+		_, sscanfErr := fmt.Sscanf(line, "%d %d %d",
+			&expected[0], &expected[1], &expected[2])
+		var values [3]int64
+		err := scanIntFields([]byte(line), values[:])
+		if (err == nil) != (sscanfErr == nil) {
+			t.Errorf("%q: got error %v, fmt.Sscanf error %v", line, err,
+				sscanfErr)
+		} else if err == nil && values != expected {
+			t.Errorf("%q: got %v, fmt.Sscanf got %v", line, values, expected)
+		}
+	}
+}
+
+func BenchmarkReadTxtMaps(b *testing.B) {
+	dir := writeBenchmarkTxtMaps(b, 1000000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ReadTxtMaps(dir); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStreamTxtMaps(b *testing.B) {
+	dir := writeBenchmarkTxtMaps(b, 1000000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		numSuperpixels := 0
+		err := StreamTxtMaps(dir, func(superpixel Superpixel,
+			body BodyId) error {
+
+			numSuperpixels++
+			return nil
+		})
+		if err != nil || numSuperpixels != 1000000 {
+			b.Fatalf("streamed %d superpixels: %v", numSuperpixels, err)
+		}
+	}
+}
+
+func BenchmarkScanIntFields(b *testing.B) {
+	line := []byte("1234 56789 1011121314")
+	b.Run("scanIntFields", func(b *testing.B) {
+		var values [3]int64
+		for i := 0; i < b.N; i++ {
+			if err := scanIntFields(line, values[:]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Sscanf", func(b *testing.B) {
+		var values [3]int64
+		for i := 0; i < b.N; i++ {
+			_, err := fmt.Sscanf(string(line), "%d %d %d",
+				&values[0], &values[1], &values[2])
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestWriteTxtMapsPreservingSegments(t *testing.T) {
+	// Segment ids are sparse and not in body order, as in an edited stack.
+	const spToSegment = "1 0 0\n1 1 107\n1 2 107\n1 3 12\n1 4 0\n" +
+		"2 1 55\n2 2 9\n2 3 9\n"
+	const segToBody = "0 0\n9 20\n12 20\n55 10\n107 10\n"
+	dir := t.TempDir()
+	files := map[string]string{
+		SuperpixelToSegmentFilename: spToSegment,
+		SegmentToBodyFilename:       segToBody,
+	}
+	for name, text := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	spToBodyMap, err := ReadTxtMaps(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing, err := ReadSuperpixelToSegmentMapFrom(
+		strings.NewReader(spToSegment))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outputDir := t.TempDir()
+	report, err := spToBodyMap.WriteTxtMapsPreservingSegments(outputDir,
+		existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Preserved != 4 || report.Allocated != 0 {
+		t.Errorf("expected 4 preserved and 0 allocated ids, got %+v", report)
+	}
+	data, err := os.ReadFile(filepath.Join(outputDir,
+		SuperpixelToSegmentFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	written, err := ReadSuperpixelToSegmentMapFrom(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(written, existing) {
+		t.Errorf("expected segments %v, got %v", existing, written)
+	}
+	rewritten, err := ReadTxtMaps(outputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rewritten, spToBodyMap) {
+		t.Errorf("expected map %v, got %v", spToBodyMap, rewritten)
+	}
+}
+
+func TestCompareSuperpixelBounds(t *testing.T) {
+	spBounds1 := SuperpixelBoundsMap{
+		Superpixel{1, 1}: SuperpixelBound{0, 0, 10, 10, 100},
+		Superpixel{2, 1}: SuperpixelBound{0, 0, 10, 10, 100},
+		Superpixel{5, 3}: SuperpixelBound{0, 0, 40, 40, 1000},
+	}
+	spBounds2 := SuperpixelBoundsMap{
+		Superpixel{1, 1}: SuperpixelBound{0, 0, 10, 10, 100},
+		Superpixel{2, 1}: SuperpixelBound{0, 0, 10, 10, 80},
+		Superpixel{2, 2}: SuperpixelBound{20, 20, 5, 10, 50},
+	}
+	window := &Bounds3d{Point3d{0, 0, 1}, Point3d{100, 100, 2}}
+	corner := &Bounds3d{Point3d{15, 15, 1}, Point3d{100, 100, 2}}
+	tests := []struct {
+		name        string
+		options     BoundsDiffOptions
+		total, diff int
+		changed     bool
+	}{
+		{"all slices", BoundsDiffOptions{MaxFraction: 0.5}, 1250, 1070, true},
+		{"z window", BoundsDiffOptions{MaxFraction: 0.10, Region: window},
+			250, 70, true},
+		{"z window tolerant", BoundsDiffOptions{MaxFraction: 0.30, Region: window},
+			250, 70, false},
+		{"xy window", BoundsDiffOptions{MaxFraction: 0.10, Region: corner},
+			50, 50, true},
+	}
+	for _, test := range tests {
+		for _, swapped := range []bool{false, true} {
+			var diff BoundsDiff
+			if swapped {
+				diff = CompareSuperpixelBounds(spBounds2, spBounds1, test.options)
+			} else {
+				diff = CompareSuperpixelBounds(spBounds1, spBounds2, test.options)
+			}
+			if diff.VoxelsTotal != test.total || diff.VoxelsDiff != test.diff ||
+				diff.Changed != test.changed {
+				t.Errorf("%s (swapped %t): expected %d of %d voxels changed %t, "+
+					"got %+v", test.name, swapped, test.diff, test.total,
+					test.changed, diff)
+			}
+		}
+	}
+
+	diff := CompareSuperpixelBounds(SuperpixelBoundsMap{},
+		SuperpixelBoundsMap{}, DefaultBoundsDiffOptions)
+	if diff.Fraction != 0 || diff.Changed {
+		t.Errorf("empty maps: expected no change, got %+v", diff)
+	}
+}
+
+func TestStackCompareSuperpixelBounds(t *testing.T) {
+	setStrictness(t, Lenient)
+	params := DefaultSyntheticStackParams
+	params.Slices = 2
+	synth1, err := CreateSyntheticStack(t.TempDir(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	synth2, err := CreateSyntheticStack(t.TempDir(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	superpixelSet := make(map[Superpixel]bool, len(synth1.SpToBodyMap))
+	for superpixel, _ := range synth1.SpToBodyMap {
+		superpixelSet[superpixel] = true
+	}
+	diff, err := synth1.Stack.CompareSuperpixelBounds(&synth2.Stack,
+		superpixelSet, DefaultBoundsDiffOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff.VoxelsTotal == 0 || diff.VoxelsDiff != 0 || diff.Changed {
+		t.Errorf("identical stacks: expected no voxel difference, got %+v",
+			diff)
+	}
+	if synth1.Stack.SuperpixelBoundsChanged(&synth2.Stack, superpixelSet) {
+		t.Errorf("identical stacks reported as changed")
+	}
+
+	boundless := &Stack{Directory: t.TempDir()}
+	if _, err := synth1.Stack.CompareSuperpixelBounds(boundless,
+		superpixelSet, DefaultBoundsDiffOptions); err == nil {
+		t.Errorf("expected error for stack without bounds file")
+	}
+	if synth1.Stack.SuperpixelBoundsChanged(boundless, superpixelSet) {
+		t.Errorf("stack without bounds file reported as changed")
+	}
+}
+
+func TestSpliceSuperpixelBounds(t *testing.T) {
+	synth, err := CreateSyntheticStack(t.TempDir(), DefaultSyntheticStackParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stack := &synth.Stack
+	spBoundsMap := stack.GetSuperpixelBoundsMap()
+	if !reflect.DeepEqual(spBoundsMap, synth.SpBoundsMap) {
+		t.Fatalf("read bounds differ from synthetic bounds")
+	}
+
+	// Regenerate slices 1 and 2 with new volumes and an extra superpixel.
+	resegmented := make(SuperpixelBoundsMap)
+	for superpixel, bounds := range synth.SpBoundsMap {
+		if superpixel.Slice == 1 || superpixel.Slice == 2 {
+			bounds.Volume -= int(superpixel.Label)
+			resegmented[superpixel] = bounds
+		}
+	}
+	resegmented[Superpixel{2, 1000}] = SuperpixelBound{0, 0, 2, 2, 4}
+	spliced := make(SuperpixelBoundsMap)
+	spliced.MergeFrom(synth.SpBoundsMap, false)
+	spliced.RemoveSlices([]uint32{1, 2})
+	for superpixel, _ := range spliced {
+		if superpixel.Slice == 1 || superpixel.Slice == 2 {
+			t.Fatalf("superpixel %v not removed", superpixel)
+		}
+	}
+	spliced.MergeFrom(resegmented, false)
+	if len(spliced) != len(synth.SpBoundsMap)+1 {
+		t.Errorf("expected %d spliced bounds, got %d",
+			len(synth.SpBoundsMap)+1, len(spliced))
+	}
+
+	// Without overwrite existing bounds are kept.
+	kept := SuperpixelBoundsMap{Superpixel{0, 1}: SuperpixelBound{1, 1, 1, 1, 1}}
+	kept.MergeFrom(spliced, false)
+	if kept[Superpixel{0, 1}].Volume != 1 {
+		t.Errorf("MergeFrom without overwrite replaced existing bounds")
+	}
+	kept.MergeFrom(spliced, true)
+	if kept[Superpixel{0, 1}] != spliced[Superpixel{0, 1}] {
+		t.Errorf("MergeFrom with overwrite kept existing bounds")
+	}
+
+	// Output must be sorted numerically by slice then label.
+	var buf bytes.Buffer
+	spliced.WriteBounds(&buf)
+	var previous Superpixel
+	for i, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var superpixel Superpixel
+		if _, err := fmt.Sscanf(line, "%d %d", &superpixel.Slice,
+			&superpixel.Label); err != nil {
+			t.Fatalf("bad line %q: %s", line, err)
+		}
+		if i > 0 && !(Superpixels{previous, superpixel}).Less(0, 1) {
+			t.Fatalf("line %d: %v does not sort after %v", i+1, superpixel,
+				previous)
+		}
+		previous = superpixel
+	}
+	reread, err := ReadSuperpixelBoundsFrom(&buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reread, spliced) {
+		t.Errorf("spliced bounds did not round trip")
+	}
+
+	// The stack reloads the bounds once the file changes.
+	filename := stack.StackSuperpixelBoundsFilename()
+	spliced.WriteBoundsFile(filename)
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filename, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stack.GetSuperpixelBoundsMap(), spliced) {
+		t.Errorf("stack did not reload modified bounds file")
+	}
+	stack.ClearSuperpixelBounds()
+	if len(stack.GetSuperpixelBoundsMap()) != len(spliced) {
+		t.Errorf("stack did not reload bounds after ClearSuperpixelBounds")
+	}
+}
@@ -0,0 +1,413 @@
+// Copyright 2012 HHMI.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//     * Neither the name of HHMI nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+// Author: katzw@janelia.hhmi.org (Bill Katz)
+//  Written as part of the FlyEM Project at Janelia Farm Research Center.
+
+package emdata
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// QCIndexFilename is the name of the index written by RunQC.
+const QCIndexFilename = "qc-index.json"
+
+// QCOptions selects the checks run by RunQC and their parameters.
+type QCOptions struct {
+	SkipMaps       bool // Superpixel map vs. bounds consistency
+	SkipSliceStats bool // Superpixels and bodies per slice
+	SkipTiles      bool // Presence of every superpixel tile
+	SkipSynapses   bool // Synapse annotation validation
+	SkipBounds     bool // Superpixel bounds change vs. CompareStack
+
+	// CompareStack, if non-nil, is compared with the stack's superpixel
+	// bounds using the Bounds options.
+	CompareStack *BaseStack
+	Bounds       BoundsDiffOptions
+
+	// Partner counts outside [MinPartners, MaxPartners] are flagged.
+	MinPartners int
+	MaxPartners int
+
+	// RavelerVersion is the file version synapses must be compatible with.
+	RavelerVersion int
+
+	// Connectome, if non-nil, is checked for release so that connections
+	// of at least LockMinStrength only involve locked NamedBodies or
+	// bodies in LockOverrides.
+	Connectome      *Connectome
+	NamedBodies     NamedBodyMap
+	LockMinStrength int
+	LockOverrides   BodySet
+}
+
+// DefaultQCOptions runs all checks.
+var DefaultQCOptions = QCOptions{
+	Bounds:         DefaultBoundsDiffOptions,
+	MinPartners:    1,
+	MaxPartners:    12,
+	RavelerVersion: DefaultFileVersion,
+}
+
+// QCCheck is the outcome of one check run by RunQC.
+type QCCheck struct {
+	Name    string   `json:"name"`
+	Skipped bool     `json:"skipped,omitempty"`
+	Passed  bool     `json:"passed"`
+	Summary string   `json:"summary"`
+	Reports []string `json:"reports,omitempty"` // Relative to output dir
+}
+
+// QCSummary is the machine-readable result of RunQC that is also
+// written as the QC index file.
+type QCSummary struct {
+	Stack  string    `json:"stack"`
+	Passed bool      `json:"passed"`
+	Checks []QCCheck `json:"checks"`
+}
+
+// Check returns the named check and whether it was run.
+func (summary QCSummary) Check(name string) (check QCCheck, found bool) {
+	for _, check = range summary.Checks {
+		if check.Name == name {
+			return check, !check.Skipped
+		}
+	}
+	return QCCheck{}, false
+}
+
+// RunQC runs all selected QC checks on a stack, writing each check's
+// reports and a QC index summarizing pass/fail into the output
+// directory.  Maps and bounds are loaded once and shared across checks.
+// An error is returned only if reports could not be written.
+func (stack *BaseStack) RunQC(options QCOptions, outputDir string) (
+	summary QCSummary, err error) {
+
+	if err = os.MkdirAll(outputDir, 0755); err != nil {
+		return
+	}
+	summary.Stack = stack.String()
+	summary.Passed = true
+
+	tilesBounds, _, metadataErr := stack.ReadTilesMetadata()
+	haveTiles := metadataErr == nil
+
+	checks := []struct {
+		name string
+		skip bool
+		run  func() (QCCheck, error)
+	}{
+		{"maps", options.SkipMaps, func() (QCCheck, error) {
+			return stack.qcMaps(outputDir)
+		}},
+		{"slices", options.SkipSliceStats, func() (QCCheck, error) {
+			return stack.qcSlices(outputDir, tilesBounds, haveTiles)
+		}},
+		{"tiles", options.SkipTiles, func() (QCCheck, error) {
+			return stack.qcTiles(outputDir, tilesBounds, haveTiles)
+		}},
+		{"synapses", options.SkipSynapses, func() (QCCheck, error) {
+			return stack.qcSynapses(outputDir, options, tilesBounds, haveTiles)
+		}},
+		{"bounds", options.SkipBounds || options.CompareStack == nil,
+			func() (QCCheck, error) {
+				return stack.qcBounds(outputDir, options)
+			}},
+		{"locks", options.Connectome == nil, func() (QCCheck, error) {
+			return qcLocks(outputDir, options)
+		}},
+	}
+	for _, c := range checks {
+		var check QCCheck
+		if c.skip {
+			check.Skipped = true
+			check.Passed = true
+		} else if check, err = c.run(); err != nil {
+			return
+		}
+		check.Name = c.name
+		summary.Passed = summary.Passed && check.Passed
+		summary.Checks = append(summary.Checks, check)
+	}
+
+	m, err := json.Marshal(summary)
+	if err != nil {
+		return
+	}
+	var buf bytes.Buffer
+	json.Indent(&buf, m, "", "    ")
+	file, err := os.Create(filepath.Join(outputDir, QCIndexFilename))
+	if err != nil {
+		return
+	}
+	_, err = buf.WriteTo(file)
+	file.Close()
+	return
+}
+
+// writeQCCsv writes CSV records into a report file in the output directory.
+func writeQCCsv(outputDir, name string, records [][]string) error {
+	file, err := os.Create(filepath.Join(outputDir, name))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	csvWriter := csv.NewWriter(file)
+	csvWriter.WriteAll(records)
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// qcMaps checks that every superpixel with bounds is in the superpixel
+// to body map and vice versa.
+func (stack *BaseStack) qcMaps(outputDir string) (check QCCheck, err error) {
+	if err = stack.ReadTxtMaps(); err != nil {
+		return
+	}
+	spToBodyMap := stack.GetSuperpixelToBodyMap()
+	spBoundsMap := stack.GetSuperpixelBoundsMap()
+	if spBoundsMap == nil {
+		check.Summary = "no superpixel bounds to compare with maps"
+		return
+	}
+	var problems Superpixels
+	problem := make(map[Superpixel]string)
+	for superpixel, _ := range spBoundsMap {
+		if _, found := spToBodyMap[superpixel]; !found &&
+			superpixel.Label != 0 {
+			problems = append(problems, superpixel)
+			problem[superpixel] = "not in map"
+		}
+	}
+	for superpixel, _ := range spToBodyMap {
+		if _, found := spBoundsMap[superpixel]; !found &&
+			superpixel.Label != 0 {
+			problems = append(problems, superpixel)
+			problem[superpixel] = "no bounds"
+		}
+	}
+	sort.Sort(problems)
+	records := [][]string{{"Slice", "Label", "Problem"}}
+	for _, superpixel := range problems {
+		records = append(records, []string{
+			strconv.FormatUint(uint64(superpixel.Slice), 10),
+			strconv.FormatUint(uint64(superpixel.Label), 10),
+			problem[superpixel]})
+	}
+	check.Reports = []string{"qc-maps.csv"}
+	err = writeQCCsv(outputDir, check.Reports[0], records)
+	check.Passed = len(problems) == 0
+	check.Summary = fmt.Sprintf("%d mapped superpixels, %d with bounds, "+
+		"%d inconsistent", len(spToBodyMap), len(spBoundsMap), len(problems))
+	return
+}
+
+// qcSlices tallies superpixels and bodies per slice and fails if any
+// slice within the tiles metadata has no mapped superpixels.
+func (stack *BaseStack) qcSlices(outputDir string, tilesBounds Bounds3d,
+	haveTiles bool) (check QCCheck, err error) {
+
+	if err = stack.ReadTxtMaps(); err != nil {
+		return
+	}
+	superpixels := make(map[uint32]int)
+	bodies := make(map[uint32]BodySet)
+	for superpixel, bodyId := range stack.GetSuperpixelToBodyMap() {
+		superpixels[superpixel.Slice]++
+		if bodies[superpixel.Slice] == nil {
+			bodies[superpixel.Slice] = make(BodySet)
+		}
+		bodies[superpixel.Slice][bodyId] = true
+	}
+	slices := make([]int, 0, len(superpixels))
+	for slice, _ := range superpixels {
+		slices = append(slices, int(slice))
+	}
+	var empty []int
+	if haveTiles {
+		for z := tilesBounds.MinPt.Z(); z <= tilesBounds.MaxPt.Z(); z++ {
+			if superpixels[uint32(z)] == 0 {
+				empty = append(empty, int(z))
+				slices = append(slices, int(z))
+			}
+		}
+	}
+	sort.Ints(slices)
+	records := [][]string{{"Slice", "# superpixels", "# bodies"}}
+	for _, slice := range slices {
+		records = append(records, []string{strconv.Itoa(slice),
+			strconv.Itoa(superpixels[uint32(slice)]),
+			strconv.Itoa(len(bodies[uint32(slice)]))})
+	}
+	check.Reports = []string{"qc-slices.csv"}
+	err = writeQCCsv(outputDir, check.Reports[0], records)
+	check.Passed = len(empty) == 0
+	check.Summary = fmt.Sprintf("%d slices with mapped superpixels, "+
+		"%d slices without", len(superpixels), len(empty))
+	return
+}
+
+// qcTiles checks that every superpixel tile within the tiles metadata
+// bounds exists.
+func (stack *BaseStack) qcTiles(outputDir string, tilesBounds Bounds3d,
+	haveTiles bool) (check QCCheck, err error) {
+
+	if !haveTiles {
+		check.Summary = "no tiles metadata"
+		return
+	}
+	layout := stack.TileLayout()
+	size := VoxelCoord(layout.Size())
+	rows := int(tilesBounds.MaxPt.Y()/size) + 1
+	cols := int(tilesBounds.MaxPt.X()/size) + 1
+	records := [][]string{{"Row", "Column", "Slice", "Path"}}
+	total := 0
+	for z := tilesBounds.MinPt.Z(); z <= tilesBounds.MaxPt.Z(); z++ {
+		for row := 0; row < rows; row++ {
+			for col := 0; col < cols; col++ {
+				total++
+				relPath := layout.Filename(TileKey{row, col, z})
+				if !tileExists(stack, relPath) {
+					records = append(records, []string{strconv.Itoa(row),
+						strconv.Itoa(col), z.String(), relPath})
+				}
+			}
+		}
+	}
+	missing := len(records) - 1
+	check.Reports = []string{"qc-tiles.csv"}
+	err = writeQCCsv(outputDir, check.Reports[0], records)
+	check.Passed = missing == 0
+	check.Summary = fmt.Sprintf("%d of %d tiles missing", missing, total)
+	return
+}
+
+// qcSynapses validates the stack's synapse annotations for Raveler
+// compatibility, locations within the stack, and partner counts.
+func (stack *BaseStack) qcSynapses(outputDir string, options QCOptions,
+	tilesBounds Bounds3d, haveTiles bool) (check QCCheck, err error) {
+
+	filename := stack.StackSynapsesJsonFilename()
+	file, err := os.Open(filename)
+	if err != nil {
+		check.Summary = fmt.Sprintf("no synapse annotations: %s", err)
+		return check, nil
+	}
+	synapses, readErr := ReadSynapsesJsonFrom(file)
+	file.Close()
+	if readErr != nil {
+		check.Summary = fmt.Sprintf("cannot read synapse annotations: %s",
+			readErr)
+		return
+	}
+
+	violations := synapses.CheckRavelerCompatibility(options.RavelerVersion)
+	if haveTiles {
+		for _, synapse := range synapses.Data {
+			if !tilesBounds.Include(synapse.Tbar.Location) {
+				violations.Add("outside stack", "T-bar %s",
+					synapse.Tbar.Location)
+			}
+			for _, psd := range synapse.Psds {
+				if !tilesBounds.Include(psd.Location) {
+					violations.Add("outside stack", "PSD %s", psd.Location)
+				}
+			}
+		}
+	}
+	counts := synapses.PartnerCounts(options.MinPartners, options.MaxPartners)
+
+	check.Reports = []string{"qc-synapses.txt", "qc-partners.csv",
+		"qc-partner-outliers.csv"}
+	file, err = os.Create(filepath.Join(outputDir, check.Reports[0]))
+	if err != nil {
+		return
+	}
+	_, err = fmt.Fprintln(file, violations.String())
+	file.Close()
+	if err != nil {
+		return
+	}
+	counts.WriteCsvFile(filepath.Join(outputDir, check.Reports[1]))
+	counts.WriteOutliersCsvFile(filepath.Join(outputDir, check.Reports[2]))
+
+	check.Passed = violations.Len() == 0 && len(counts.Outliers) == 0
+	check.Summary = fmt.Sprintf("%d T-bars, %d violations, %d T-bars with "+
+		"partners outside [%d, %d]", len(synapses.Data), violations.Len(),
+		len(counts.Outliers), options.MinPartners, options.MaxPartners)
+	return
+}
+
+// qcBounds compares the stack's superpixel bounds with the comparison
+// stack.
+func (stack *BaseStack) qcBounds(outputDir string, options QCOptions) (
+	check QCCheck, err error) {
+
+	diff, diffErr := stack.CompareSuperpixelBounds(
+		&options.CompareStack.Stack, map[Superpixel]bool{}, options.Bounds)
+	if diffErr != nil {
+		check.Summary = fmt.Sprintf("cannot compare bounds: %s", diffErr)
+		return
+	}
+	check.Reports = []string{"qc-bounds.txt"}
+	file, err := os.Create(filepath.Join(outputDir, check.Reports[0]))
+	if err != nil {
+		return
+	}
+	_, err = fmt.Fprintf(file, "%s\n%s\n%s\n", stack, options.CompareStack,
+		diff.Summary())
+	file.Close()
+	check.Passed = !diff.Changed
+	check.Summary = diff.Summary()
+	return
+}
+
+// qcLocks checks that a connectome only has strong connections between
+// locked bodies.
+func qcLocks(outputDir string, options QCOptions) (check QCCheck, err error) {
+	lockCheck := options.Connectome.CheckLocks(options.NamedBodies,
+		options.LockMinStrength, options.LockOverrides)
+	check.Reports = []string{"qc-locks.csv"}
+	file, err := os.Create(filepath.Join(outputDir, check.Reports[0]))
+	if err != nil {
+		return
+	}
+	lockCheck.WriteCsv(file)
+	file.Close()
+	check.Passed = lockCheck.Passed
+	check.Summary = lockCheck.Summary()
+	return
+}
@@ -0,0 +1,239 @@
+// Copyright 2012 HHMI.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//     * Neither the name of HHMI nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+// Author: katzw@janelia.hhmi.org (Bill Katz)
+//  Written as part of the FlyEM Project at Janelia Farm Research Center.
+
+package emdata
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// qcCheckNames are the checks run by RunQC in index order.
+var qcCheckNames = []string{"maps", "slices", "tiles", "synapses", "bounds",
+	"locks"}
+
+// runQCWith runs all QC checks on a synthetic stack after a corruption
+// is applied and verifies the returned summary matches the index file.
+// A clean copy of the stack is used for bounds comparison, and bodies 1
+// and 2 are locked with a strong connection between them.
+func runQCWith(t *testing.T, corrupt func(*SyntheticStack, *QCOptions)) (
+	summary QCSummary) {
+
+	dir := t.TempDir()
+	synth, err := CreateSyntheticStack(filepath.Join(dir, "stack"),
+		DefaultSyntheticStackParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compare, err := CreateSyntheticStack(filepath.Join(dir, "compare"),
+		DefaultSyntheticStackParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := DefaultQCOptions
+	options.CompareStack = &compare.BaseStack
+	options.NamedBodies = NamedBodyMap{
+		1: {Body: 1, Name: "A", Locked: true},
+		2: {Body: 2, Name: "B", Locked: true},
+		3: {Body: 3, Name: "C"},
+	}
+	options.Connectome = NewConnectome(options.NamedBodies)
+	options.Connectome.addStrength(1, 2, 10)
+	options.LockMinStrength = 5
+	if corrupt != nil {
+		corrupt(synth, &options)
+	}
+
+	outputDir := filepath.Join(dir, "qc")
+	summary, err = synth.BaseStack.RunQC(options, outputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(filepath.Join(outputDir, QCIndexFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var index QCSummary
+	if err := json.NewDecoder(file).Decode(&index); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(index, summary) {
+		t.Errorf("index %+v differs from returned summary %+v", index, summary)
+	}
+	for _, check := range index.Checks {
+		for _, report := range check.Reports {
+			if _, err := os.Stat(filepath.Join(outputDir, report)); err != nil {
+				t.Errorf("%s: report not written: %s", check.Name, err)
+			}
+		}
+	}
+	return
+}
+
+// copyFile copies a file, creating the destination directory.
+func copyFile(t *testing.T, src, dst string) {
+	in, err := os.Open(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, in); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunQCClean(t *testing.T) {
+	summary := runQCWith(t, nil)
+	if !summary.Passed {
+		t.Errorf("expected clean stack to pass: %+v", summary)
+	}
+	if len(summary.Checks) != len(qcCheckNames) {
+		t.Fatalf("expected %d checks, got %+v", len(qcCheckNames),
+			summary.Checks)
+	}
+	for i, name := range qcCheckNames {
+		check, found := summary.Check(name)
+		if !found || !check.Passed || summary.Checks[i].Name != name {
+			t.Errorf("%s: expected check %d to run and pass, got %+v", name,
+				i, summary.Checks[i])
+		}
+	}
+	if _, found := summary.Check("unknown"); found {
+		t.Errorf("found unknown check")
+	}
+}
+
+func TestRunQCSkipped(t *testing.T) {
+	summary := runQCWith(t, func(synth *SyntheticStack, options *QCOptions) {
+		options.SkipTiles = true
+		options.Connectome = nil
+		os.Remove(filepath.Join(synth.Directory,
+			synth.Tiles.TileFilename(0, 0, 0)))
+	})
+	if !summary.Passed {
+		t.Errorf("expected skipped tile check to pass: %+v", summary)
+	}
+	for _, name := range []string{"tiles", "locks"} {
+		if check, found := summary.Check(name); found || !check.Skipped {
+			t.Errorf("%s: expected skipped check, got %+v", name, check)
+		}
+	}
+}
+
+func TestRunQCDefects(t *testing.T) {
+	setStrictness(t, Lenient)
+	tests := []struct {
+		check   string
+		corrupt func(*SyntheticStack, *QCOptions)
+	}{
+		{"maps", func(synth *SyntheticStack, options *QCOptions) {
+			delete(synth.SpToBodyMap, Superpixel{1, 5})
+			err := synth.SpToBodyMap.WriteTxtMaps(synth.Directory)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"slices", func(synth *SyntheticStack, options *QCOptions) {
+			// Tiles for an extra slice without any mapped superpixels.
+			params := synth.Params
+			size := params.TilesPerSide * params.TileSize
+			metadata := fmt.Sprintf("width=%d\nheight=%d\nzmin=0\nzmax=%d\n"+
+				"superpixel-format=I\n", size, size, params.Slices)
+			filename := filepath.Join(synth.Directory, "tiles", "metadata.txt")
+			if err := os.WriteFile(filename, []byte(metadata), 0644); err != nil {
+				t.Fatal(err)
+			}
+			last := VoxelCoord(params.Slices - 1)
+			for row := 0; row < params.TilesPerSide; row++ {
+				for col := 0; col < params.TilesPerSide; col++ {
+					copyFile(t, filepath.Join(synth.Directory,
+						synth.Tiles.TileFilename(row, col, last)),
+						filepath.Join(synth.Directory,
+							synth.Tiles.TileFilename(row, col, last+1)))
+				}
+			}
+		}},
+		{"tiles", func(synth *SyntheticStack, options *QCOptions) {
+			err := os.Remove(filepath.Join(synth.Directory,
+				synth.Tiles.TileFilename(1, 0, 2)))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"synapses", func(synth *SyntheticStack, options *QCOptions) {
+			synth.Synapses.Data[0].Psds = nil
+			filename := synth.StackSynapsesJsonFilename()
+			if err := synth.Synapses.WriteJsonFileE(filename); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"bounds", func(synth *SyntheticStack, options *QCOptions) {
+			for superpixel, bounds := range synth.SpBoundsMap {
+				bounds.Volume /= 2
+				synth.SpBoundsMap[superpixel] = bounds
+			}
+			synth.SpBoundsMap.WriteBoundsFile(
+				synth.StackSuperpixelBoundsFilename())
+		}},
+		{"locks", func(synth *SyntheticStack, options *QCOptions) {
+			options.Connectome.addStrength(1, 3, 10)
+		}},
+	}
+	for _, test := range tests {
+		summary := runQCWith(t, test.corrupt)
+		if summary.Passed {
+			t.Errorf("%s: expected QC to fail", test.check)
+		}
+		for _, name := range qcCheckNames {
+			check, found := summary.Check(name)
+			if !found {
+				t.Errorf("%s: check %s did not run", test.check, name)
+			} else if check.Passed != (name != test.check) {
+				t.Errorf("%s: check %s passed = %t: %s", test.check, name,
+					check.Passed, check.Summary)
+			}
+		}
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright 2012 HHMI.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//     * Neither the name of HHMI nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+// Author: katzw@janelia.hhmi.org (Bill Katz)
+//  Written as part of the FlyEM Project at Janelia Farm Research Center.
+
+package emdata
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// SynapseShardKey identifies a block of tiles: a tile row and column
+// and a block of consecutive slices.
+type SynapseShardKey struct {
+	Row   int
+	Col   int
+	Block int
+}
+
+// SynapseShard is a subset of synapse annotations whose T-bars fall
+// within one tile row and column over a range of slices.
+type SynapseShard struct {
+	SynapseShardKey
+	MinSlice VoxelCoord
+	MaxSlice VoxelCoord
+	Synapses *JsonSynapses
+}
+
+type synapseShardList []SynapseShard
+
+func (list synapseShardList) Len() int {
+	return len(list)
+}
+
+func (list synapseShardList) Swap(i, j int) {
+	list[i], list[j] = list[j], list[i]
+}
+
+func (list synapseShardList) Less(i, j int) bool {
+	a, b := list[i].SynapseShardKey, list[j].SynapseShardKey
+	if a.Block != b.Block {
+		return a.Block < b.Block
+	}
+	if a.Row != b.Row {
+		return a.Row < b.Row
+	}
+	return a.Col < b.Col
+}
+
+// floorDiv divides rounding toward negative infinity.
+func floorDiv(a, b int) int {
+	if a < 0 {
+		return -((-a + b - 1) / b)
+	}
+	return a / b
+}
+
+// ShardKey returns the shard of a location for a tile layout and a
+// number of slices per shard.
+func (layout TileLayout) ShardKey(pt Point3d, sliceDepth int) SynapseShardKey {
+	size := layout.Size()
+	return SynapseShardKey{
+		Row:   floorDiv(int(pt.Y()), size),
+		Col:   floorDiv(int(pt.X()), size),
+		Block: floorDiv(int(pt.Z()), sliceDepth),
+	}
+}
+
+// PartitionByTiles splits synapses into shards by the tile and block
+// of slices containing each T-bar, so shards can be processed in
+// parallel without sharing tiles.  PSDs stay with their T-bar.  Each
+// shard's metadata records its tile coverage.  Shards are returned
+// sorted by slice block, row, and column.
+func (synapses *JsonSynapses) PartitionByTiles(layout TileLayout,
+	sliceDepth int) []SynapseShard {
+
+	if sliceDepth < 1 {
+		sliceDepth = 1
+	}
+	shardMap := make(map[SynapseShardKey]*JsonSynapses)
+	for _, synapse := range synapses.Data {
+		key := layout.ShardKey(synapse.Tbar.Location, sliceDepth)
+		shard, found := shardMap[key]
+		if !found {
+			shard = new(JsonSynapses)
+			shardMap[key] = shard
+		}
+		shard.Data = append(shard.Data, synapse)
+	}
+
+	shards := make(synapseShardList, 0, len(shardMap))
+	for key, shardSynapses := range shardMap {
+		shard := SynapseShard{
+			SynapseShardKey: key,
+			MinSlice:        VoxelCoord(key.Block * sliceDepth),
+			MaxSlice:        VoxelCoord((key.Block+1)*sliceDepth - 1),
+			Synapses:        shardSynapses,
+		}
+		description := fmt.Sprintf("Synapse shard at tile row %d, col %d, "+
+			"slices %d-%d", key.Row, key.Col, shard.MinSlice, shard.MaxSlice)
+		shardSynapses.Metadata = UpdateMetadata(synapses.Metadata,
+			description)
+		shardSynapses.Metadata["shard"] = map[string]interface{}{
+			"tile size": layout.Size(),
+			"tile row":  key.Row,
+			"tile col":  key.Col,
+			"zmin":      shard.MinSlice,
+			"zmax":      shard.MaxSlice,
+			"tbars":     len(shardSynapses.Data),
+		}
+		shards = append(shards, shard)
+	}
+	sort.Sort(shards)
+	return shards
+}
+
+// Filename returns the name of a shard's annotation file.
+func (shard SynapseShard) Filename(baseName string) string {
+	return fmt.Sprintf("%s-r%d-c%d-z%d.json", baseName, shard.Row,
+		shard.Col, shard.Block)
+}
+
+// WriteSynapseShards partitions synapses by tiles and writes each shard
+// into the output directory, returning the shard file names.
+func WriteSynapseShards(synapses *JsonSynapses, layout TileLayout,
+	sliceDepth int, outputDir, baseName string) (
+	filenames []string, err error) {
+
+	if err = os.MkdirAll(outputDir, 0755); err != nil {
+		return
+	}
+	for _, shard := range synapses.PartitionByTiles(layout, sliceDepth) {
+		filename := filepath.Join(outputDir, shard.Filename(baseName))
+		if err = shard.Synapses.WriteJsonFileE(filename); err != nil {
+			return
+		}
+		filenames = append(filenames, filename)
+	}
+	return
+}
+
+// synapseKey returns the uid of a T-bar, or a uid derived from its
+// location if it has none.
+func synapseKey(synapse JsonSynapse) string {
+	if synapse.Tbar.Uid != "" {
+		return synapse.Tbar.Uid
+	}
+	return TbarUid(synapse.Tbar.Location)
+}
+
+// MergeSynapseShards recombines shards of synapse annotations.  An
+// error is returned if any T-bar is in more than one shard or, if the
+// original synapses are given, any original T-bar is missing from or
+// was added to the shards.  T-bars are compared by uid.
+func MergeSynapseShards(shards []*JsonSynapses, original *JsonSynapses) (
+	merged *JsonSynapses, err error) {
+
+	merged = new(JsonSynapses)
+	var problems Warnings
+	seen := make(map[string]bool)
+	for _, shard := range shards {
+		for _, synapse := range shard.Data {
+			key := synapseKey(synapse)
+			if seen[key] {
+				problems.Add("duplicated", "T-bar %s", key)
+				continue
+			}
+			seen[key] = true
+			merged.Data = append(merged.Data, synapse)
+		}
+	}
+	if original != nil {
+		merged.Metadata = UpdateMetadata(original.Metadata,
+			"Merged synapse shards")
+		originalSet := make(map[string]bool, len(original.Data))
+		for _, synapse := range original.Data {
+			key := synapseKey(synapse)
+			originalSet[key] = true
+			if !seen[key] {
+				problems.Add("lost", "T-bar %s", key)
+			}
+		}
+		for key, _ := range seen {
+			if !originalSet[key] {
+				problems.Add("added", "T-bar %s", key)
+			}
+		}
+	} else {
+		merged.Metadata = CreateMetadata("Merged synapse shards")
+	}
+	if problems.Len() != 0 {
+		err = fmt.Errorf("Could not merge synapse shards: %s", problems)
+	}
+	return
+}
+
+// MergeSynapseShardFiles reads and recombines synapse shard files.
+// See MergeSynapseShards.
+func MergeSynapseShardFiles(filenames []string, original *JsonSynapses) (
+	merged *JsonSynapses, err error) {
+
+	shards := make([]*JsonSynapses, 0, len(filenames))
+	for _, filename := range filenames {
+		file, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		shard, err := ReadSynapsesJsonFrom(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", filename, err)
+		}
+		shards = append(shards, shard)
+	}
+	return MergeSynapseShards(shards, original)
+}
@@ -0,0 +1,207 @@
+// Copyright 2012 HHMI.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//     * Neither the name of HHMI nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+// Author: katzw@janelia.hhmi.org (Bill Katz)
+//  Written as part of the FlyEM Project at Janelia Farm Research Center.
+
+package emdata
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// shardFixture has T-bars in each tile of a 2x2 grid of 100 pixel tiles
+// over two blocks of two slices, T-bars at negative coordinates, and a
+// T-bar without a uid.
+func shardFixture() *JsonSynapses {
+	synapse := func(uid string, x, y, z VoxelCoord) JsonSynapse {
+		return JsonSynapse{
+			Tbar: JsonTbar{Location: Point3d{x, y, z}, Uid: uid},
+			Psds: []JsonPsd{{Location: Point3d{x + 1, y + 1, z}}},
+		}
+	}
+	return &JsonSynapses{
+		Metadata: map[string]interface{}{"file version": 2},
+		Data: []JsonSynapse{
+			synapse("t1", 10, 10, 0),
+			synapse("t2", 150, 20, 0),
+			synapse("t3", 30, 160, 1),
+			synapse("t4", 120, 180, 1),
+			synapse("t5", 50, 50, 2),
+			synapse("t6", 199, 199, 3),
+			synapse("t7", -5, 20, 0),
+			synapse("t8", 10, -1, -1),
+			synapse("", 60, 70, 3),
+		},
+	}
+}
+
+func TestFloorDiv(t *testing.T) {
+	tests := []struct{ a, b, q int }{
+		{0, 2, 0}, {3, 2, 1}, {4, 2, 2}, {-1, 2, -1}, {-2, 2, -1},
+		{-3, 2, -2}, {-100, 100, -1}, {-101, 100, -2},
+	}
+	for _, test := range tests {
+		if q := floorDiv(test.a, test.b); q != test.q {
+			t.Errorf("floorDiv(%d, %d): expected %d, got %d", test.a, test.b,
+				test.q, q)
+		}
+	}
+}
+
+func TestPartitionByTiles(t *testing.T) {
+	synapses := shardFixture()
+	layout := TileLayout{TileSize: 100}
+	shards := synapses.PartitionByTiles(layout, 2)
+
+	expected := []SynapseShardKey{
+		{-1, 0, -1},
+		{0, -1, 0}, {0, 0, 0}, {0, 1, 0}, {1, 0, 0}, {1, 1, 0},
+		{0, 0, 1}, {1, 1, 1},
+	}
+	keys := make([]SynapseShardKey, len(shards))
+	total := 0
+	for i, shard := range shards {
+		keys[i] = shard.SynapseShardKey
+		if shard.MinSlice != VoxelCoord(2*shard.Block) ||
+			shard.MaxSlice != VoxelCoord(2*shard.Block+1) {
+			t.Errorf("shard %v: bad slice range %d-%d", keys[i],
+				shard.MinSlice, shard.MaxSlice)
+		}
+		for _, synapse := range shard.Synapses.Data {
+			total++
+			key := layout.ShardKey(synapse.Tbar.Location, 2)
+			if key != keys[i] {
+				t.Errorf("T-bar %s in shard %v, expected %v",
+					synapse.Tbar.Location, keys[i], key)
+			}
+		}
+		metadata := shard.Synapses.Metadata
+		coverage, found := metadata["shard"].(map[string]interface{})
+		if !found || coverage["tile row"] != shard.Row ||
+			coverage["tile col"] != shard.Col ||
+			coverage["tbars"] != len(shard.Synapses.Data) {
+			t.Errorf("shard %v: bad coverage metadata %v", keys[i],
+				shard.Synapses.Metadata)
+		}
+		if version, _ := GetFileVersion(shard.Synapses.Metadata); version != 2 {
+			t.Errorf("shard %v: file version %d not preserved", keys[i],
+				version)
+		}
+	}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected shards %v, got %v", expected, keys)
+	}
+	if total != len(synapses.Data) {
+		t.Errorf("expected %d T-bars in shards, got %d", len(synapses.Data),
+			total)
+	}
+}
+
+func TestMergeSynapseShards(t *testing.T) {
+	synapses := shardFixture()
+	dir := t.TempDir()
+	filenames, err := WriteSynapseShards(synapses, TileLayout{TileSize: 100},
+		2, dir, "synapses")
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, err := MergeSynapseShardFiles(filenames, synapses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mergedKeys := make([]string, len(merged.Data))
+	for i, synapse := range merged.Data {
+		mergedKeys[i] = synapseKey(synapse)
+		x, y, z := synapse.Tbar.Location.XYZ()
+		if len(synapse.Psds) != 1 ||
+			synapse.Psds[0].Location != (Point3d{x + 1, y + 1, z}) {
+			t.Errorf("T-bar %s lost its PSD: %v", mergedKeys[i], synapse.Psds)
+		}
+	}
+	originalKeys := make([]string, len(synapses.Data))
+	for i, synapse := range synapses.Data {
+		originalKeys[i] = synapseKey(synapse)
+	}
+	sort.Strings(mergedKeys)
+	sort.Strings(originalKeys)
+	if !reflect.DeepEqual(mergedKeys, originalKeys) {
+		t.Errorf("expected merged T-bars %v, got %v", originalKeys, mergedKeys)
+	}
+
+	shards := make([]*JsonSynapses, 0)
+	for _, shard := range synapses.PartitionByTiles(TileLayout{TileSize: 100},
+		2) {
+		shards = append(shards, shard.Synapses)
+	}
+	with := func(extra *JsonSynapses) []*JsonSynapses {
+		return append(append([]*JsonSynapses{}, shards...), extra)
+	}
+	duplicated := &JsonSynapses{Data: []JsonSynapse{synapses.Data[0]}}
+	dropped := func(uid string) []*JsonSynapses {
+		var result []*JsonSynapses
+		for _, shard := range shards {
+			kept := new(JsonSynapses)
+			for _, synapse := range shard.Data {
+				if synapse.Tbar.Uid != uid {
+					kept.Data = append(kept.Data, synapse)
+				}
+			}
+			result = append(result, kept)
+		}
+		return result
+	}
+	tests := []struct {
+		name    string
+		shards  []*JsonSynapses
+		problem string
+	}{
+		{"duplicated", with(duplicated), "duplicated"},
+		{"dropped", dropped("t4"), "lost"},
+		{"dropped without uid", dropped(""), "lost"},
+	}
+	for _, test := range tests {
+		_, err := MergeSynapseShards(test.shards, synapses)
+		if err == nil || !strings.Contains(err.Error(), test.problem) {
+			t.Errorf("%s: expected %q error, got %v", test.name, test.problem,
+				err)
+		}
+	}
+	extra := &JsonSynapses{Data: []JsonSynapse{{Tbar: JsonTbar{
+		Location: Point3d{1, 2, 3}, Uid: "extra"}}}}
+	_, err = MergeSynapseShards(with(extra), synapses)
+	if err == nil || !strings.Contains(err.Error(), "added") {
+		t.Errorf("expected added T-bar error, got %v", err)
+	}
+	if _, err := MergeSynapseShards(with(duplicated), nil); err == nil {
+		t.Errorf("expected duplicated T-bar error without original")
+	}
+}
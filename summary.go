@@ -0,0 +1,243 @@
+// Copyright 2012 HHMI.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//     * Neither the name of HHMI nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+// Author: katzw@janelia.hhmi.org (Bill Katz)
+//  Written as part of the FlyEM Project at Janelia Farm Research Center.
+
+package emdata
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// maxSummaryItems is the maximum # of worst cases listed in a summary.
+const maxSummaryItems = 5
+
+// percent returns 100 * n / total or 0 if total is zero.
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0.0
+	}
+	return 100.0 * float64(n) / float64(total)
+}
+
+// WriteTable writes a header and rows of text with aligned columns.
+func WriteTable(writer io.Writer, header []string, rows [][]string) {
+	tableWriter := tabwriter.NewWriter(writer, 0, 8, 2, ' ', 0)
+	lines := append([][]string{header}, rows...)
+	for _, line := range lines {
+		_, err := fmt.Fprintln(tableWriter, strings.Join(line, "\t"))
+		if err != nil {
+			log.Fatalln("ERROR: Unable to write table:", err)
+		}
+	}
+	if err := tableWriter.Flush(); err != nil {
+		log.Fatalln("ERROR: Unable to write table:", err)
+	}
+}
+
+// Summary returns a one line description of synapse counts.
+func (stats SynapseStats) Summary() string {
+	perTbar := 0.0
+	if stats.NumTbars > 0 {
+		perTbar = float64(stats.NumPsds) / float64(stats.NumTbars)
+	}
+	return fmt.Sprintf("%d T-bars, %d PSDs (%.1f PSDs per T-bar)",
+		stats.NumTbars, stats.NumPsds, perTbar)
+}
+
+// Summary returns a one line description of tracing results.
+func (stats TracingStats) Summary() string {
+	total := stats.TracedAnchors + stats.TracedOrphans + stats.TracedLeaves
+	return fmt.Sprintf("Traced %d T-bars and %d PSDs: "+
+		"%.1f%% anchors (%d), %.1f%% orphans (%d), %.1f%% leaves (%d)",
+		stats.TracedTbars, stats.TracedPsds,
+		percent(stats.TracedAnchors, total), stats.TracedAnchors,
+		percent(stats.TracedOrphans, total), stats.TracedOrphans,
+		percent(stats.TracedLeaves, total), stats.TracedLeaves)
+}
+
+// Summary returns a description of body matches including the matches
+// with the smallest fraction of overlap.
+func (matchingMap BestOverlapMap) Summary() string {
+	var changed, zeroMatches, overlapSum, maxSum int
+	bodies := make(BodyIdList, 0, len(matchingMap))
+	for bodyId, match := range matchingMap {
+		bodies = append(bodies, bodyId)
+		if match.ZeroMatch {
+			zeroMatches++
+		} else if match.MatchedBody != bodyId {
+			changed++
+		}
+		overlapSum += match.OverlapSize
+		maxSum += match.MaxOverlap
+	}
+	sort.Sort(bodies)
+	fraction := func(match BestOverlap) float64 {
+		return percent(match.OverlapSize, match.MaxOverlap)
+	}
+	weakest := make(BodyIdList, len(bodies))
+	copy(weakest, bodies)
+	sort.Stable(byWeakestOverlap{weakest, matchingMap, fraction})
+
+	text := fmt.Sprintf("%d bodies matched: %d changed id, %d zero matches, "+
+		"%.1f%% of superpixels overlap", len(matchingMap), changed,
+		zeroMatches, percent(overlapSum, maxSum))
+	if len(weakest) > maxSummaryItems {
+		weakest = weakest[:maxSummaryItems]
+	}
+	if len(weakest) > 0 {
+		items := make([]string, len(weakest))
+		for i, bodyId := range weakest {
+			match := matchingMap[bodyId]
+			items[i] = fmt.Sprintf("%d -> %d (%.1f%%)", bodyId,
+				match.MatchedBody, fraction(match))
+		}
+		text += ".  Weakest: " + strings.Join(items, ", ")
+	}
+	return text
+}
+
+// byWeakestOverlap sorts body ids by increasing fraction of overlap.
+type byWeakestOverlap struct {
+	bodies      BodyIdList
+	matchingMap BestOverlapMap
+	fraction    func(BestOverlap) float64
+}
+
+func (list byWeakestOverlap) Len() int {
+	return len(list.bodies)
+}
+
+func (list byWeakestOverlap) Swap(i, j int) {
+	list.bodies[i], list.bodies[j] = list.bodies[j], list.bodies[i]
+}
+
+func (list byWeakestOverlap) Less(i, j int) bool {
+	return list.fraction(list.matchingMap[list.bodies[i]]) <
+		list.fraction(list.matchingMap[list.bodies[j]])
+}
+
+// Summary returns a one line description of a superpixel bounds diff.
+func (diff BoundsDiff) Summary() string {
+	text := fmt.Sprintf("%.1f%% voxel difference in superpixels "+
+		"(%d of %d voxels)", 100.0*diff.Fraction, diff.VoxelsDiff,
+		diff.VoxelsTotal)
+	if len(diff.Missing) > 0 {
+		text += fmt.Sprintf(", %d superpixels missing", len(diff.Missing))
+	}
+	if diff.Changed {
+		text += ", exceeding allowed difference"
+	}
+	return text
+}
+
+// Summary returns a description of a PSD tracing run.
+func (summary PsdTracingSummary) Summary() string {
+	text := fmt.Sprintf("%d PSDs: %d of %d compared changed (%.1f%%), "+
+		"%d failed base lookup, %d on zero superpixels, "+
+		"%d edge hits resolved, %d unresolved, %d unmapped, "+
+		"%d not annotated, %d empty T-bars skipped, %.1f%% export coverage",
+		summary.TotalPsds, summary.PsdsChanged, summary.ComparedPsds,
+		100.0*summary.ChangedFraction, summary.BaseLookupFailed,
+		summary.ZeroSuperpixel, summary.EdgeHitsResolved,
+		summary.UnresolvedPsds, summary.UnmappedPsds,
+		summary.NoBodyAnnotated, summary.EmptyTbars,
+		100.0*summary.ExportCoverage)
+	if summary.Suspect {
+		text += ".  SUSPECT run"
+	}
+	if summary.Warnings.Len() > 0 {
+		text += fmt.Sprintf(".  %d warnings", summary.Warnings.Len())
+	}
+	return text
+}
+
+// Summary returns a description of an overlap matrix with its largest
+// overlaps.
+func (matrix BodyOverlapList) Summary() string {
+	text := fmt.Sprintf("%d overlapping body pairs", len(matrix))
+	n := len(matrix)
+	if n > maxSummaryItems {
+		n = maxSummaryItems
+	}
+	if n > 0 {
+		items := make([]string, n)
+		for i, overlap := range matrix[:n] {
+			items[i] = fmt.Sprintf("%d -> %d (%.1f%% of A, %.1f%% of B)",
+				overlap.BodyA, overlap.BodyB, 100.0*overlap.FractionA,
+				100.0*overlap.FractionB)
+		}
+		text += ".  Largest: " + strings.Join(items, ", ")
+	}
+	return text
+}
+
+// WriteText writes the overlap matrix as an aligned text table.
+func (matrix BodyOverlapList) WriteText(writer io.Writer) {
+	rows := make([][]string, len(matrix))
+	for i, overlap := range matrix {
+		rows[i] = []string{
+			overlap.BodyA.String(),
+			overlap.BodyB.String(),
+			fmt.Sprintf("%d", overlap.Superpixels),
+			fmt.Sprintf("%d", overlap.Voxels),
+			fmt.Sprintf("%.4f", overlap.FractionA),
+			fmt.Sprintf("%.4f", overlap.FractionB),
+		}
+	}
+	WriteTable(writer, []string{"Body A", "Body B", "Superpixels", "Voxels",
+		"Fraction A", "Fraction B"}, rows)
+}
+
+// Summary returns a one line description of synapse tile coverage.
+func (coverage TileCoverage) Summary() string {
+	affected := 0
+	for _, pts := range coverage.Affected {
+		affected += len(pts)
+	}
+	return fmt.Sprintf("%d tiles needed, %d missing (%.1f%%), "+
+		"%d locations affected", len(coverage.Needed), len(coverage.Missing),
+		percent(len(coverage.Missing), len(coverage.Needed)), affected)
+}
+
+// Summary returns a one line pass/fail description of a lock check.
+func (check LockCheck) Summary() string {
+	status := "PASSED"
+	if !check.Passed {
+		status = "FAILED"
+	}
+	return fmt.Sprintf("%s: %d of %d connections with strength >= %d "+
+		"involve unlocked bodies", status, len(check.Violations),
+		check.Connections, check.MinStrength)
+}
@@ -0,0 +1,122 @@
+// Copyright 2012 HHMI.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//     * Neither the name of HHMI nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+// Author: katzw@janelia.hhmi.org (Bill Katz)
+//  Written as part of the FlyEM Project at Janelia Farm Research Center.
+
+package emdata
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBestOverlapMapSummary(t *testing.T) {
+	matchingMap := BestOverlapMap{
+		1: {MatchedBody: 1, OverlapSize: 10, MaxOverlap: 10},
+		2: {MatchedBody: 20, OverlapSize: 5, MaxOverlap: 10},
+		3: {MatchedBody: 3, OverlapSize: 9, MaxOverlap: 10},
+		4: {MatchedBody: 0, OverlapSize: 2, MaxOverlap: 10, ZeroMatch: true},
+		5: {MatchedBody: 5, OverlapSize: 7, MaxOverlap: 10},
+		6: {MatchedBody: 6, OverlapSize: 8, MaxOverlap: 10},
+		7: {MatchedBody: 70, OverlapSize: 6, MaxOverlap: 10},
+	}
+	expected := "7 bodies matched: 2 changed id, 1 zero matches, " +
+		"67.1% of superpixels overlap.  Weakest: 4 -> 0 (20.0%), " +
+		"2 -> 20 (50.0%), 7 -> 70 (60.0%), 5 -> 5 (70.0%), 6 -> 6 (80.0%)"
+	if summary := matchingMap.Summary(); summary != expected {
+		t.Errorf("expected summary:\n%s\ngot:\n%s", expected, summary)
+	}
+
+	expected = "0 bodies matched: 0 changed id, 0 zero matches, " +
+		"0.0% of superpixels overlap"
+	if summary := (BestOverlapMap{}).Summary(); summary != expected {
+		t.Errorf("expected summary:\n%s\ngot:\n%s", expected, summary)
+	}
+}
+
+func TestStatsSummary(t *testing.T) {
+	tracing := TracingStats{TracedTbars: 3, TracedPsds: 8, TracedAnchors: 6,
+		TracedOrphans: 1, TracedLeaves: 1}
+	expected := "Traced 3 T-bars and 8 PSDs: 75.0% anchors (6), " +
+		"12.5% orphans (1), 12.5% leaves (1)"
+	if summary := tracing.Summary(); summary != expected {
+		t.Errorf("expected summary:\n%s\ngot:\n%s", expected, summary)
+	}
+	expected = "Traced 0 T-bars and 0 PSDs: 0.0% anchors (0), " +
+		"0.0% orphans (0), 0.0% leaves (0)"
+	if summary := (TracingStats{}).Summary(); summary != expected {
+		t.Errorf("expected summary:\n%s\ngot:\n%s", expected, summary)
+	}
+
+	synapses := SynapseStats{NumTbars: 4, NumPsds: 10}
+	expected = "4 T-bars, 10 PSDs (2.5 PSDs per T-bar)"
+	if summary := synapses.Summary(); summary != expected {
+		t.Errorf("expected summary:\n%s\ngot:\n%s", expected, summary)
+	}
+}
+
+func TestBodyOverlapListSummary(t *testing.T) {
+	var matrix BodyOverlapList
+	for i := 1; i <= 6; i++ {
+		matrix = append(matrix, BodyOverlap{BodyA: BodyId(i),
+			BodyB: BodyId(10 * i), Superpixels: 7 - i, FractionA: 0.5,
+			FractionB: 0.25})
+	}
+	expected := "6 overlapping body pairs.  Largest: " +
+		"1 -> 10 (50.0% of A, 25.0% of B), 2 -> 20 (50.0% of A, 25.0% of B), " +
+		"3 -> 30 (50.0% of A, 25.0% of B), 4 -> 40 (50.0% of A, 25.0% of B), " +
+		"5 -> 50 (50.0% of A, 25.0% of B)"
+	if summary := matrix.Summary(); summary != expected {
+		t.Errorf("expected summary:\n%s\ngot:\n%s", expected, summary)
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	var buf bytes.Buffer
+	WriteTable(&buf, []string{"Body", "Name", "PSDs"}, [][]string{
+		{"7", "Mi1", "120"},
+		{"12345", "L1-long", "3"},
+	})
+	expected := "Body   Name     PSDs\n" +
+		"7      Mi1      120\n" +
+		"12345  L1-long  3\n"
+	if buf.String() != expected {
+		t.Errorf("expected table:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	buf.Reset()
+	matrix := BodyOverlapList{{BodyA: 1, BodyB: 10, Superpixels: 2,
+		Voxels: 40, FractionA: 0.4, FractionB: 2.0 / 3.0}}
+	matrix.WriteText(&buf)
+	expected = "Body A  Body B  Superpixels  Voxels  Fraction A  Fraction B\n" +
+		"1       10      2            40      0.4000      0.6667\n"
+	if buf.String() != expected {
+		t.Errorf("expected table:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
@@ -0,0 +1,394 @@
+// Copyright 2012 HHMI.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//     * Neither the name of HHMI nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+// Author: katzw@janelia.hhmi.org (Bill Katz)
+//  Written as part of the FlyEM Project at Janelia Farm Research Center.
+
+package emdata
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+)
+
+// SyntheticStackParams controls the size and content of a synthetic
+// stack.  Superpixels within each tile are laid out on a square grid
+// and assigned round-robin to bodies, so a body's superpixels share the
+// same label in every slice.
+type SyntheticStackParams struct {
+	FirstSlice         VoxelCoord
+	Slices             int  // # of slices
+	TilesPerSide       int  // # of tiles along both X and Y
+	TileSize           int  // Width and height of tile in pixels; 0 = TileSize
+	SuperpixelsPerTile int  // Rounded up to a square grid
+	Bodies             int  // # of bodies superpixels are assigned to
+	SynapsesPerSlice   int  // # of T-bars per slice, each with one PSD
+	ZeroBorders        bool // Outline each superpixel with zero pixels
+}
+
+// DefaultSyntheticStackParams describes a small stack that can be
+// generated in well under a second.
+var DefaultSyntheticStackParams = SyntheticStackParams{
+	FirstSlice:         0,
+	Slices:             4,
+	TilesPerSide:       2,
+	TileSize:           128,
+	SuperpixelsPerTile: 16,
+	Bodies:             8,
+	SynapsesPerSlice:   4,
+}
+
+// SyntheticStack is a procedurally generated base stack along with the
+// ground truth used to generate it.
+type SyntheticStack struct {
+	BaseStack
+	Params      SyntheticStackParams
+	SpToBodyMap SuperpixelToBodyMap
+	SpBoundsMap SuperpixelBoundsMap
+	Synapses    *JsonSynapses
+	Bodies      *JsonBodies
+
+	gridSize int // # of superpixels along each side of a tile
+}
+
+// CreateSyntheticStack writes a Raveler base stack into the given
+// directory: 16-bit superpixel tiles and their metadata, superpixel
+// and segment maps, superpixel bounds, and synapse and body annotations.
+// Every synapse lies at the center of a superpixel so its body is known.
+func CreateSyntheticStack(directory string, params SyntheticStackParams) (
+	synth *SyntheticStack, err error) {
+
+	if params.TileSize <= 0 {
+		params.TileSize = TileSize
+	}
+	if params.Slices <= 0 || params.TilesPerSide <= 0 ||
+		params.SuperpixelsPerTile <= 0 || params.Bodies <= 0 {
+		err = fmt.Errorf("synthetic stack needs positive # of slices, tiles, " +
+			"superpixels and bodies")
+		return
+	}
+	synth = &SyntheticStack{Params: params}
+	synth.Directory = directory
+	synth.Tiles = TileLayout{
+		SlicePadding: DefaultTileLayout.SlicePadding,
+		Extension:    DefaultTileLayout.Extension,
+		TileSize:     params.TileSize,
+	}
+	synth.gridSize = 1
+	for synth.gridSize*synth.gridSize < params.SuperpixelsPerTile {
+		synth.gridSize++
+	}
+	minCell := 1
+	if params.ZeroBorders {
+		minCell = 3
+	}
+	if params.TileSize/synth.gridSize < minCell {
+		err = fmt.Errorf("tile size %d too small for %d superpixels per tile",
+			params.TileSize, params.SuperpixelsPerTile)
+		return
+	}
+	if synth.labelsPerSlice() > 0xFFFF {
+		err = fmt.Errorf("%d superpixels per slice exceeds 16-bit labels",
+			synth.labelsPerSlice())
+		return
+	}
+
+	synth.makeGroundTruth()
+	if err = os.MkdirAll(filepath.Join(directory, "tiles"), 0755); err != nil {
+		return
+	}
+	if err = synth.writeTiles(); err != nil {
+		return
+	}
+	if err = synth.writeMaps(); err != nil {
+		return
+	}
+	if err = synth.writeBounds(); err != nil {
+		return
+	}
+	err = synth.Synapses.WriteJsonFileE(synth.StackSynapsesJsonFilename())
+	if err != nil {
+		return
+	}
+	synth.Bodies.WriteJsonFile(synth.StackBodiesJsonFilename())
+	return
+}
+
+// labelsPerSlice returns the # of non-zero superpixel labels in a slice.
+func (synth *SyntheticStack) labelsPerSlice() int {
+	tiles := synth.Params.TilesPerSide * synth.Params.TilesPerSide
+	return tiles * synth.gridSize * synth.gridSize
+}
+
+// cellStart returns the first tile coordinate of the i-th grid cell.
+func (synth *SyntheticStack) cellStart(i int) int {
+	return (i*synth.Params.TileSize + synth.gridSize - 1) / synth.gridSize
+}
+
+// BodyOfLabel returns the body to which a superpixel label is assigned.
+func (synth *SyntheticStack) BodyOfLabel(label uint32) BodyId {
+	if label == 0 {
+		return BodyId(0)
+	}
+	return BodyId(1 + (int(label)-1)%synth.Params.Bodies)
+}
+
+// SuperpixelAt returns the superpixel generated at a point in stack space.
+func (synth *SyntheticStack) SuperpixelAt(pt Point3d) Superpixel {
+	size := synth.Params.TileSize
+	x, y := pt.IntX(), pt.IntY()
+	row, col := y/size, x/size
+	tileX, tileY := x-col*size, y-row*size
+	i := tileX * synth.gridSize / size
+	j := tileY * synth.gridSize / size
+	superpixel := Superpixel{Slice: uint32(pt.Z())}
+	if synth.Params.ZeroBorders &&
+		(tileX == synth.cellStart(i) || tileY == synth.cellStart(j)) {
+		return superpixel
+	}
+	tile := row*synth.Params.TilesPerSide + col
+	label := 1 + tile*synth.gridSize*synth.gridSize + j*synth.gridSize + i
+	superpixel.Label = uint32(label)
+	return superpixel
+}
+
+// Center returns a point in stack space near the center of a superpixel
+// that is guaranteed to lie within the superpixel.
+func (synth *SyntheticStack) Center(superpixel Superpixel) Point3d {
+	bounds := synth.SpBoundsMap[superpixel]
+	return Point3d{
+		VoxelCoord(bounds.MinX + bounds.Width/2),
+		VoxelCoord(bounds.MinY + bounds.Height/2),
+		VoxelCoord(superpixel.Slice),
+	}
+}
+
+// makeGroundTruth computes the superpixel bounds, superpixel to body map,
+// and annotations without touching the disk.
+func (synth *SyntheticStack) makeGroundTruth() {
+	params := synth.Params
+	synth.SpToBodyMap = make(SuperpixelToBodyMap)
+	synth.SpBoundsMap = make(SuperpixelBoundsMap)
+	border := 0
+	if params.ZeroBorders {
+		border = 1
+	}
+	for s := 0; s < params.Slices; s++ {
+		slice := uint32(params.FirstSlice) + uint32(s)
+		for row := 0; row < params.TilesPerSide; row++ {
+			for col := 0; col < params.TilesPerSide; col++ {
+				for j := 0; j < synth.gridSize; j++ {
+					for i := 0; i < synth.gridSize; i++ {
+						minX := col*params.TileSize + synth.cellStart(i)
+						minY := row*params.TileSize + synth.cellStart(j)
+						width := synth.cellStart(i+1) - synth.cellStart(i) - border
+						height := synth.cellStart(j+1) - synth.cellStart(j) - border
+						pt := Point3d{VoxelCoord(minX + border),
+							VoxelCoord(minY + border), VoxelCoord(slice)}
+						superpixel := synth.SuperpixelAt(pt)
+						synth.SpToBodyMap[superpixel] =
+							synth.BodyOfLabel(superpixel.Label)
+						synth.SpBoundsMap[superpixel] = SuperpixelBound{
+							MinX:   minX + border,
+							MinY:   minY + border,
+							Width:  width,
+							Height: height,
+							Volume: width * height,
+						}
+					}
+				}
+			}
+		}
+	}
+
+	synth.Bodies = &JsonBodies{
+		Metadata: CreateMetadata("Synthetic body annotations"),
+	}
+	for body := 1; body <= params.Bodies; body++ {
+		synth.Bodies.Data = append(synth.Bodies.Data, JsonBody{
+			Body:    BodyId(body),
+			Status:  "Anchor",
+			Name:    fmt.Sprintf("synthetic-%d", body),
+			Comment: "Anchor body",
+		})
+	}
+
+	synth.Synapses = &JsonSynapses{
+		Metadata: CreateMetadata("Synthetic synapse annotations"),
+	}
+	numLabels := synth.labelsPerSlice()
+	for s := 0; s < params.Slices; s++ {
+		slice := uint32(params.FirstSlice) + uint32(s)
+		for n := 0; n < params.SynapsesPerSlice; n++ {
+			tbarSp := Superpixel{slice, uint32(1 + (2*n)%numLabels)}
+			psdSp := Superpixel{slice, uint32(1 + (2*n+1)%numLabels)}
+			var synapse JsonSynapse
+			synapse.Tbar.Location = synth.Center(tbarSp)
+			synapse.Tbar.Body = synth.SpToBodyMap[tbarSp]
+			synapse.Tbar.Uid = TbarUid(synapse.Tbar.Location)
+			synapse.Tbar.Confidence = 1.0
+			var psd JsonPsd
+			psd.Location = synth.Center(psdSp)
+			psd.Body = synth.SpToBodyMap[psdSp]
+			psd.Uid = PsdUid(synapse.Tbar.Uid, psd.Location)
+			psd.Confidence = 1.0
+			synapse.Psds = []JsonPsd{psd}
+			synth.Synapses.Data = append(synth.Synapses.Data, synapse)
+		}
+	}
+}
+
+// writeTiles writes the tiles metadata and 16-bit superpixel tiles.
+// Tiles are flipped in Y relative to stack space.
+func (synth *SyntheticStack) writeTiles() error {
+	params := synth.Params
+	size := params.TileSize
+	width := params.TilesPerSide * size
+	lastSlice := int(params.FirstSlice) + params.Slices - 1
+	filename := filepath.Join(synth.Directory, "tiles", "metadata.txt")
+	metadata := fmt.Sprintf("width=%d\nheight=%d\nzmin=%d\nzmax=%d\n"+
+		"superpixel-format=I\n", width, width, params.FirstSlice, lastSlice)
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString(metadata)
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	tile := image.NewGray16(image.Rect(0, 0, size, size))
+	for s := 0; s < params.Slices; s++ {
+		slice := params.FirstSlice + VoxelCoord(s)
+		for row := 0; row < params.TilesPerSide; row++ {
+			for col := 0; col < params.TilesPerSide; col++ {
+				for tileY := 0; tileY < size; tileY++ {
+					y := VoxelCoord(row*size + size - tileY - 1)
+					for tileX := 0; tileX < size; tileX++ {
+						x := VoxelCoord(col*size + tileX)
+						superpixel := synth.SuperpixelAt(Point3d{x, y, slice})
+						tile.SetGray16(tileX, tileY,
+							color.Gray16{uint16(superpixel.Label)})
+					}
+				}
+				relPath := synth.Tiles.TileFilename(row, col, slice)
+				filename := filepath.Join(synth.Directory, relPath)
+				if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+					return err
+				}
+				file, err := os.Create(filename)
+				if err != nil {
+					return err
+				}
+				err = png.Encode(file, tile)
+				file.Close()
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// writeMaps writes the superpixel->segment and segment->body maps where
+// each body gets one segment per slice.  The maps are written directly
+// rather than through WriteTxtMaps so the generator does not depend on
+// the code it is used to check.
+func (synth *SyntheticStack) writeMaps() error {
+	params := synth.Params
+	segment := func(slice uint32, body BodyId) int {
+		return int(slice-uint32(params.FirstSlice))*params.Bodies + int(body)
+	}
+
+	filename := filepath.Join(synth.Directory, SuperpixelToSegmentFilename)
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	lineWriter := bufio.NewWriter(file)
+	numLabels := uint32(synth.labelsPerSlice())
+	for s := 0; s < params.Slices; s++ {
+		slice := uint32(params.FirstSlice) + uint32(s)
+		for label := uint32(1); label <= numLabels; label++ {
+			fmt.Fprintf(lineWriter, "%d %d %d\n", slice, label,
+				segment(slice, synth.BodyOfLabel(label)))
+		}
+	}
+	err = lineWriter.Flush()
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	filename = filepath.Join(synth.Directory, SegmentToBodyFilename)
+	if file, err = os.Create(filename); err != nil {
+		return err
+	}
+	lineWriter = bufio.NewWriter(file)
+	fmt.Fprintf(lineWriter, "%d %d\n", 0, 0)
+	for s := 0; s < params.Slices; s++ {
+		slice := uint32(params.FirstSlice) + uint32(s)
+		for body := 1; body <= params.Bodies; body++ {
+			fmt.Fprintf(lineWriter, "%d %d\n",
+				segment(slice, BodyId(body)), body)
+		}
+	}
+	err = lineWriter.Flush()
+	file.Close()
+	return err
+}
+
+// writeBounds writes the superpixel bounds file in slice, label order.
+func (synth *SyntheticStack) writeBounds() error {
+	filename := filepath.Join(synth.Directory, SuperpixelBoundsFilename)
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	lineWriter := bufio.NewWriter(file)
+	numLabels := uint32(synth.labelsPerSlice())
+	for s := 0; s < synth.Params.Slices; s++ {
+		slice := uint32(synth.Params.FirstSlice) + uint32(s)
+		for label := uint32(1); label <= numLabels; label++ {
+			bounds := synth.SpBoundsMap[Superpixel{slice, label}]
+			fmt.Fprintf(lineWriter, "%d %d %d %d %d %d %d\n", slice, label,
+				bounds.MinX, bounds.MinY, bounds.Width, bounds.Height,
+				bounds.Volume)
+		}
+	}
+	err = lineWriter.Flush()
+	file.Close()
+	return err
+}
@@ -32,11 +32,15 @@
 package emdata
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 
 	"image"
 	_ "image/png"
@@ -104,42 +108,281 @@ func ReadSuperpixelTile(stack TiledJsonStack, relTilePath string) (
 	return
 }
 
+// MaxPreloadTiles is the most tiles PreloadTiles will hold in the tile
+// cache.  A decoded 1024 x 1024 tile takes 2 to 4 MB.
+var MaxPreloadTiles = 64
+
+// PreloadTiles reads superpixel tiles overlapping the given bounds into
+// the tile cache using the given # of goroutines, growing the cache if
+// necessary to hold them.  At most MaxPreloadTiles tiles are read,
+// starting at the lowest slice, so the cache never grows beyond that.
+// Tiles missing from the stack are skipped.  If ctx is canceled, tiles
+// not yet started are skipped and ctx.Err() is returned.
+func PreloadTiles(ctx context.Context, stack TiledJsonStack, bounds Bounds3d,
+	concurrency int) error {
+
+	stackBounds, _ := stack.TilesMetadata()
+	bounds, ok := bounds.Intersect(stackBounds)
+	if !ok {
+		return nil
+	}
+	layout := stackTileLayout(stack)
+	minTile := layout.TileOf(bounds.MinPt)
+	maxTile := layout.TileOf(bounds.MaxPt)
+	var relTilePaths []string
+	for z := minTile.Slice; z <= maxTile.Slice; z++ {
+		for row := minTile.Row; row <= maxTile.Row; row++ {
+			for col := minTile.Col; col <= maxTile.Col; col++ {
+				relTilePath := layout.TileFilename(row, col, z)
+				if tileExists(stack, relTilePath) {
+					relTilePaths = append(relTilePaths, relTilePath)
+				}
+			}
+		}
+	}
+	if len(relTilePaths) > MaxPreloadTiles {
+		log.Printf("Preloading only %d of %d tiles in %s\n", MaxPreloadTiles,
+			len(relTilePaths), bounds)
+		relTilePaths = relTilePaths[:MaxPreloadTiles]
+	}
+	superpixelCache.Grow(len(relTilePaths))
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	tileChan := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for relTilePath := range tileChan {
+				ReadSuperpixelTile(stack, relTilePath)
+			}
+		}()
+	}
+	var err error
+feed:
+	for _, relTilePath := range relTilePaths {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		select {
+		case tileChan <- relTilePath:
+		case <-ctx.Done():
+			err = ctx.Err()
+			break feed
+		}
+	}
+	close(tileChan)
+	wg.Wait()
+	return err
+}
+
+// PreloadSlice reads the superpixel tiles of a slice into the tile
+// cache, up to MaxPreloadTiles tiles.
+func PreloadSlice(ctx context.Context, stack TiledJsonStack, z VoxelCoord,
+	concurrency int) error {
+
+	bounds, _ := stack.TilesMetadata()
+	bounds.MinPt[2] = z
+	bounds.MaxPt[2] = z
+	return PreloadTiles(ctx, stack, bounds, concurrency)
+}
+
 type TiledJsonStack interface {
 	TilesMetadata() (Bounds3d, SuperpixelFormat)
 	JsonStack
 	MappedStack
 }
 
+// TileLayout describes how tile files are named within a stack.  Slices
+// of 1000 or more are always placed within a directory named after the
+// slice rounded down to the nearest thousand.
+type TileLayout struct {
+	SlicePadding int    // Minimum # of digits for slice, zero-padded
+	Extension    string // File extension without the dot, e.g., "png"
+	TileSize     int    // Width and height of tile in pixels; 0 = TileSize
+}
+
+// Size returns the width and height of tiles in this layout.
+func (layout TileLayout) Size() int {
+	if layout.TileSize <= 0 {
+		return TileSize
+	}
+	return layout.TileSize
+}
+
+// DefaultTileLayout is the legacy Raveler naming, e.g., "s/005.png" and
+// "s/1000/1234.png".
+var DefaultTileLayout = TileLayout{SlicePadding: 3, Extension: "png"}
+
+// TileLayout returns the stack's tile layout or the default layout
+// if none was set.
+func (stack *Stack) TileLayout() TileLayout {
+	if stack.Tiles.Extension == "" {
+		return DefaultTileLayout
+	}
+	return stack.Tiles
+}
+
+// TileLayout returns the tile layout of the base stack since an
+// exported stack falls back on its base stack tiles.
+func (stack *ExportedStack) TileLayout() TileLayout {
+	return stack.Base.TileLayout()
+}
+
+// stackTileLayout returns the tile layout of a stack if it has one.
+func stackTileLayout(stack TiledJsonStack) TileLayout {
+	if layoutStack, ok := stack.(interface {
+		TileLayout() TileLayout
+	}); ok {
+		return layoutStack.TileLayout()
+	}
+	return DefaultTileLayout
+}
+
+// stackZeroIsEdge returns true if a stack uses body 0 for membranes
+// and edges.
+func stackZeroIsEdge(stack TiledJsonStack) bool {
+	if edgeStack, ok := stack.(interface {
+		ZeroIsEdge() bool
+	}); ok {
+		return edgeStack.ZeroIsEdge()
+	}
+	return false
+}
+
 // TileFilename returns the path to a given tile relative to a stack root.
-func TileFilename(row int, col int, slice VoxelCoord) string {
+func (layout TileLayout) TileFilename(row int, col int,
+	slice VoxelCoord) string {
 
 	var filename string
 	if slice >= 1000 {
 		sliceDir := (slice / 1000) * 1000
-		filename = fmt.Sprintf("tiles/%d/0/%d/%d/s/%d/%d.png", TileSize,
-			row, col, sliceDir, slice)
+		filename = fmt.Sprintf("tiles/%d/0/%d/%d/s/%d/%0*d.%s", layout.Size(),
+			row, col, sliceDir, layout.SlicePadding, slice, layout.Extension)
 	} else {
-		filename = fmt.Sprintf("tiles/%d/0/%d/%d/s/%03d.png", TileSize,
-			row, col, slice)
+		filename = fmt.Sprintf("tiles/%d/0/%d/%d/s/%0*d.%s", layout.Size(),
+			row, col, layout.SlicePadding, slice, layout.Extension)
 	}
 	return filename
 }
 
+// TileFilename returns the path to a given tile relative to a stack root
+// using the default tile layout.
+func TileFilename(row int, col int, slice VoxelCoord) string {
+	return DefaultTileLayout.TileFilename(row, col, slice)
+}
+
+// TileKey identifies a superpixel tile by row, column, and slice.
+type TileKey struct {
+	Row   int
+	Col   int
+	Slice VoxelCoord
+}
+
+// TileOf returns the tile containing a point in stack space.
+func (layout TileLayout) TileOf(pt Point3d) TileKey {
+	size := VoxelCoord(layout.Size())
+	return TileKey{int(pt.Y() / size), int(pt.X() / size), pt.Z()}
+}
+
+// Filename returns the path to a tile relative to a stack root.
+func (layout TileLayout) Filename(key TileKey) string {
+	return layout.TileFilename(key.Row, key.Col, key.Slice)
+}
+
+// tileExists returns true if a tile is present in a stack, or for an
+// exported stack, in its base stack.
+func tileExists(stack TiledJsonStack, relTilePath string) bool {
+	_, err := os.Stat(filepath.Join(stack.String(), relTilePath))
+	if err == nil {
+		return true
+	}
+	if exported, ok := stack.(*ExportedStack); ok {
+		_, err = os.Stat(filepath.Join(exported.Base.String(), relTilePath))
+		return err == nil
+	}
+	return false
+}
+
+// ParseTileFilename recovers the row, column, and slice from a tile path
+// in either the legacy or zero-padded layouts.  The path may be relative
+// to the stack root or include any leading directories.
+func ParseTileFilename(path string) (row, col int, slice VoxelCoord,
+	err error) {
+
+	parts := strings.Split(filepath.ToSlash(path), "/")
+	start := -1
+	for i := len(parts) - 1; i >= 0; i-- {
+		if parts[i] == "tiles" {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		err = fmt.Errorf("tile path has no 'tiles' directory: %s", path)
+		return
+	}
+	parts = parts[start:]
+	if len(parts) != 7 && len(parts) != 8 {
+		err = fmt.Errorf("tile path has unexpected # of elements: %s", path)
+		return
+	}
+	if _, e := strconv.Atoi(parts[1]); e != nil || parts[2] != "0" ||
+		parts[5] != "s" {
+		err = fmt.Errorf("tile path is not a full-resolution tile: %s", path)
+		return
+	}
+	if row, err = strconv.Atoi(parts[3]); err != nil {
+		err = fmt.Errorf("bad tile row in %s: %s", path, err)
+		return
+	}
+	if col, err = strconv.Atoi(parts[4]); err != nil {
+		err = fmt.Errorf("bad tile column in %s: %s", path, err)
+		return
+	}
+	base := parts[len(parts)-1]
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+	sliceNum, e := strconv.Atoi(base)
+	if e != nil || sliceNum < 0 {
+		err = fmt.Errorf("bad tile slice in %s", path)
+		return
+	}
+	slice = VoxelCoord(sliceNum)
+	if len(parts) == 8 {
+		sliceDir, e := strconv.Atoi(parts[6])
+		if e != nil || sliceDir != (sliceNum/1000)*1000 {
+			err = fmt.Errorf("tile slice %d does not match directory in %s",
+				sliceNum, path)
+			return
+		}
+	} else if sliceNum >= 1000 {
+		err = fmt.Errorf("tile slice %d should be in a slice directory: %s",
+			sliceNum, path)
+		return
+	}
+	return
+}
+
 // GetSuperpixelTilePt returns a superpixel tile and tile coordinates
 // for a given 3d voxel point in a stack.
 func GetSuperpixelTilePt(stack TiledJsonStack, pt Point3d) (
 	superpixels SuperpixelImage, tilePt Point2d) {
 
 	// Compute which tile this point falls within
-	col := pt.X() / TileSize
-	row := pt.Y() / TileSize
+	layout := stackTileLayout(stack)
+	tileSize := VoxelCoord(layout.Size())
+	col := pt.X() / tileSize
+	row := pt.Y() / tileSize
 
-	relTilePath := TileFilename(int(row), int(col), pt.Z())
+	relTilePath := layout.TileFilename(int(row), int(col), pt.Z())
 	superpixels, _, _ = ReadSuperpixelTile(stack, relTilePath)
 
 	// Determine relative point within this tile
-	tileX := pt.X() - col*TileSize
-	tileY := VoxelCoord(superpixels.Bounds().Max.Y) - (pt.Y() - row*TileSize) - 1
+	tileX := pt.X() - col*tileSize
+	tileY := VoxelCoord(superpixels.Bounds().Max.Y) - (pt.Y() - row*tileSize) - 1
 	tilePt = Point2d{tileX, tileY}
 	return
 }
@@ -149,6 +392,17 @@ func GetSuperpixelTilePt(stack TiledJsonStack, pt Point3d) (
 func GetBodyOfLocation(stack TiledJsonStack, pt Point3d) (bodyId BodyId,
 	superpixel Superpixel) {
 
+	bodyId, superpixel, _ = CheckBodyOfLocation(stack, pt)
+	return
+}
+
+// CheckBodyOfLocation is like GetBodyOfLocation but also returns whether
+// the point's superpixel was in the stack's superpixel->body map.  A zero
+// superpixel in the tile is returned with a zero Label and mapped true,
+// and is only logged if the stack doesn't use body 0 for edges.
+func CheckBodyOfLocation(stack TiledJsonStack, pt Point3d) (bodyId BodyId,
+	superpixel Superpixel, mapped bool) {
+
 	bounds, format := stack.TilesMetadata()
 	if !bounds.Include(pt) {
 		log.Fatalf("FATAL ERROR: PSD falls outside stack: %s > %s",
@@ -164,71 +418,217 @@ func GetBodyOfLocation(stack TiledJsonStack, pt Point3d) (bodyId BodyId,
 		tilePt.IntX(), tilePt.IntY(), format)
 
 	if superpixel.Label == 0 {
-		log.Println("** Warning: PSD falls in ZERO SUPERPIXEL: ", pt)
+		if !stackZeroIsEdge(stack) {
+			log.Println("** Warning: PSD falls in ZERO SUPERPIXEL: ", pt)
+		}
 		bodyId = BodyId(0)
+		mapped = true
 	} else {
-		bodyId = stack.SuperpixelToBody(superpixel)
+		bodyId, mapped = stack.SuperpixelToBodyChecked(superpixel)
+		if !mapped {
+			log.Println("** Warning: Superpixel", superpixel, "at", pt,
+				"is missing from superpixel->body map of", stack)
+		}
 	}
 	return
 }
 
 // GetNearestBodyOfLocation reads the superpixel tile that contains the given
-// point in stack space and return the nearest non-zero body id.
+// point in stack space and return the nearest non-zero body id.  Superpixels
+// missing from the stack's superpixel->body map are ignored.  The search
+// uses DefaultNearestBodyOptions.
 func GetNearestBodyOfLocation(stack TiledJsonStack, pt Point3d,
 	excludeBodies BodySet, avoidBodies BodySet) (bodyId BodyId,
 	superpixel Superpixel, radius int, finalLocation Point3d) {
 
-	bounds, format := stack.TilesMetadata()
+	bounds, _ := stack.TilesMetadata()
 	if !bounds.Include(pt) {
 		log.Fatalf("FATAL ERROR: PSD falls outside stack: %s > %s",
 			pt, bounds)
 	}
+	bodyId, superpixel, radius, finalLocation, err :=
+		GetNearestBodyOfLocationOpts(stack, pt, excludeBodies, avoidBodies,
+			DefaultNearestBodyOptions)
+	if err != nil {
+		log.Println("** Error: Still unable to resolve PSD", pt,
+			"even checking pixels at radius", radius)
+		log.Println("  Stack:", stack)
+	}
+	return
+}
+
+// NearestBodyOptions controls the search for the nearest body of a
+// location.
+type NearestBodyOptions struct {
+	// Pixels within the slice are checked at radii below MaxRadius.
+	MaxRadius int
+
+	// If no body is found in the location's slice, search the slices
+	// up to MaxSliceOffset above and below it, nearest first.  A
+	// MaxSliceOffset of 0 searches only the adjacent slices.
+	SearchNeighboringSlices bool
+	MaxSliceOffset          int
+}
+
+// DefaultNearestBodyOptions searches only the location's slice up to
+// radius 6.
+var DefaultNearestBodyOptions = NearestBodyOptions{MaxRadius: 6}
+
+// GetNearestBodyOfLocationOpts is like GetNearestBodyOfLocation but
+// searches as directed by opts and returns an error instead of logging
+// if the location is outside the stack or no body could be found.  A
+// body found in a neighboring slice is returned with a radius of at
+// least its slice offset.
+func GetNearestBodyOfLocationOpts(stack TiledJsonStack, pt Point3d,
+	excludeBodies, avoidBodies BodySet, opts NearestBodyOptions) (
+	BodyId, Superpixel, int, Point3d, error) {
+
+	return nearestBodyOfLocation(stack, pt, excludeBodies, avoidBodies,
+		opts, nil)
+}
+
+// NearestCandidate is a superpixel examined during a nearest-body search.
+type NearestCandidate struct {
+	Location   Point3d
+	Radius     int
+	Superpixel Superpixel
+	Body       BodyId
+	Outcome    string // Why the candidate was or wasn't chosen
+}
+
+// tileToStack returns the stack location of a tile pixel given a point
+// and its corresponding tile pixel.  Tile rows run opposite to stack Y.
+func tileToStack(pt Point3d, tilePt, pixel Point2d) Point3d {
+	dx := pixel.IntX() - tilePt.IntX()
+	dy := pixel.IntY() - tilePt.IntY()
+	x := VoxelCoord(pt.IntX() + dx)
+	y := VoxelCoord(pt.IntY() - dy)
+	return Point3d{x, y, pt.Z()}
+}
+
+// nearestBodyOfLocation implements GetNearestBodyOfLocationOpts, passing
+// each non-zero superpixel examined to record if it is non-nil.
+func nearestBodyOfLocation(stack TiledJsonStack, pt Point3d,
+	excludeBodies BodySet, avoidBodies BodySet, opts NearestBodyOptions,
+	record func(NearestCandidate)) (bodyId BodyId, superpixel Superpixel,
+	radius int, finalLocation Point3d, err error) {
+
+	bounds, _ := stack.TilesMetadata()
+	if !bounds.Include(pt) {
+		err = fmt.Errorf("location %s falls outside stack %s", pt, bounds)
+		return
+	}
+	if record == nil {
+		record = func(NearestCandidate) {}
+	}
+
+	bodyId, superpixel, radius, finalLocation = nearestBodyInSlice(stack,
+		pt, excludeBodies, avoidBodies, opts.MaxRadius, 0, record)
+	if superpixel.Label != 0 || !opts.SearchNeighboringSlices {
+		if superpixel.Label == 0 {
+			err = fmt.Errorf("no body within radius %d of %s",
+				opts.MaxRadius, pt)
+		}
+		return
+	}
+	maxOffset := opts.MaxSliceOffset
+	if maxOffset < 1 {
+		maxOffset = 1
+	}
+	for offset := 1; offset <= maxOffset; offset++ {
+		for _, dz := range []int{-offset, offset} {
+			slicePt := Point3d{pt.X(), pt.Y(), pt.Z() + VoxelCoord(dz)}
+			if !bounds.Include(slicePt) {
+				continue
+			}
+			bodyId, superpixel, radius, finalLocation = nearestBodyInSlice(
+				stack, slicePt, excludeBodies, avoidBodies, opts.MaxRadius,
+				offset, record)
+			if superpixel.Label != 0 {
+				return
+			}
+		}
+	}
+	err = fmt.Errorf("no body within radius %d of %s or within %d slices",
+		opts.MaxRadius, pt, maxOffset)
+	return
+}
+
+// nearestBodyInSlice searches for the nearest body within the slice of
+// the given point, returning a zero superpixel label if none is found.
+// Radii are reported as at least the given slice offset.
+func nearestBodyInSlice(stack TiledJsonStack, pt Point3d,
+	excludeBodies BodySet, avoidBodies BodySet, checkRadius int,
+	sliceOffset int, record func(NearestCandidate)) (bodyId BodyId,
+	superpixel Superpixel, radius int, finalLocation Point3d) {
+
+	_, format := stack.TilesMetadata()
+	sliceRadius := func(r int) int {
+		if r < sliceOffset {
+			return sliceOffset
+		}
+		return r
+	}
 
 	// Get superpixel tile data
 	superpixels, tilePt := GetSuperpixelTilePt(stack, pt)
 
 	// Check for body using increasing radii
 	superpixel.Slice = uint32(pt.Z())
+	maxX := superpixels.Bounds().Max.X - 1
+	maxY := superpixels.Bounds().Max.Y - 1
 
-	checkRadius := 6
 	nextBestRadius := checkRadius
 	nextBestSuperpixel := uint32(0)
 	for radius = 0; radius < checkRadius; radius++ {
-		for _, pixel := range tilePt.PixelsAtRadius(radius, TileSize-1, TileSize-1) {
+		for _, pixel := range tilePt.PixelsAtRadius(radius, maxX, maxY) {
 			spid := GetSuperpixelId(superpixels, pixel.IntX(), pixel.IntY(), format)
 			if spid != 0 {
 				superpixel.Label = spid
-				bodyId = stack.SuperpixelToBody(superpixel)
+				candidate := NearestCandidate{
+					Location:   tileToStack(pt, tilePt, pixel),
+					Radius:     sliceRadius(radius),
+					Superpixel: superpixel,
+				}
+				var mapped bool
+				bodyId, mapped = stack.SuperpixelToBodyChecked(superpixel)
+				candidate.Body = bodyId
+				if !mapped {
+					candidate.Outcome = "not in superpixel->body map"
+					record(candidate)
+					continue
+				}
 				_, found := excludeBodies[bodyId]
 				if !found {
 					if nextBestRadius > radius {
 						nextBestSuperpixel = spid
 						nextBestRadius = radius
-						dx := pixel.IntX() - tilePt.IntX()
-						dy := pixel.IntY() - tilePt.IntY()
-						x := VoxelCoord(pt.IntX() + dx)
-						y := VoxelCoord(pt.IntY() + dy)
-						finalLocation = Point3d{x, y, pt.Z()}
+						finalLocation = candidate.Location
 					}
 					_, found = avoidBodies[bodyId]
 					if !found {
+						candidate.Outcome = "selected"
+						record(candidate)
+						radius = candidate.Radius
 						return
 					}
+					candidate.Outcome = "avoided: body used by another PSD"
+				} else {
+					candidate.Outcome = "excluded"
 				}
+				record(candidate)
 			}
 		}
 	}
 
-	if superpixel.Label == 0 {
-		log.Println("** Error: Still unable to resolve PSD", pt,
-			"even checking pixels at radius", checkRadius)
-		log.Println("  Stack:", stack)
+	if nextBestSuperpixel == 0 {
+		superpixel.Label = 0
 		bodyId = BodyId(0)
-		radius = checkRadius
+		radius = sliceRadius(checkRadius)
 		return
 	}
 	superpixel.Label = nextBestSuperpixel
 	bodyId = stack.SuperpixelToBody(superpixel)
-	radius = nextBestRadius
+	radius = sliceRadius(nextBestRadius)
 	return
 }
@@ -0,0 +1,239 @@
+// Copyright 2012 HHMI.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//     * Neither the name of HHMI nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+// Author: katzw@janelia.hhmi.org (Bill Katz)
+//  Written as part of the FlyEM Project at Janelia Farm Research Center.
+
+package emdata
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPreloadTiles(t *testing.T) {
+	synth, err := CreateSyntheticStack(t.TempDir(), DefaultSyntheticStackParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stack := &synth.BaseStack
+	savedMax := MaxPreloadTiles
+	MaxPreloadTiles = 5
+	defer func() { MaxPreloadTiles = savedMax }()
+
+	superpixelCache.Clear()
+	bounds, _ := stack.TilesMetadata()
+	if err = PreloadTiles(context.Background(), stack, bounds, 4); err != nil {
+		t.Fatal(err)
+	}
+	if n := superpixelCache.Len(); n != MaxPreloadTiles {
+		t.Errorf("expected %d preloaded tiles, got %d", MaxPreloadTiles, n)
+	}
+
+	superpixelCache.Clear()
+	if err = PreloadSlice(context.Background(), stack, 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	tilesPerSlice := synth.Params.TilesPerSide * synth.Params.TilesPerSide
+	if n := superpixelCache.Len(); n != tilesPerSlice {
+		t.Errorf("expected %d tiles in slice, got %d", tilesPerSlice, n)
+	}
+
+	superpixelCache.Clear()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = PreloadTiles(ctx, stack, bounds, 2); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if n := superpixelCache.Len(); n != 0 {
+		t.Errorf("expected no tiles after cancel, got %d", n)
+	}
+}
+
+func TestTileFilename(t *testing.T) {
+	fourDigit := TileLayout{SlicePadding: 4, Extension: "jpg"}
+	tests := []struct {
+		layout   TileLayout
+		row, col int
+		slice    VoxelCoord
+		filename string
+	}{
+		{DefaultTileLayout, 1, 2, 5, "tiles/1024/0/1/2/s/005.png"},
+		{DefaultTileLayout, 0, 3, 1234, "tiles/1024/0/0/3/s/1000/1234.png"},
+		{fourDigit, 1, 2, 5, "tiles/1024/0/1/2/s/0005.jpg"},
+		{fourDigit, 4, 0, 2001, "tiles/1024/0/4/0/s/2000/2001.jpg"},
+	}
+	for _, test := range tests {
+		filename := test.layout.TileFilename(test.row, test.col, test.slice)
+		if filename != test.filename {
+			t.Errorf("expected %s, got %s", test.filename, filename)
+			continue
+		}
+		row, col, slice, err := ParseTileFilename("/data/stack/" + filename)
+		if err != nil {
+			t.Errorf("%s: %s", filename, err)
+		} else if row != test.row || col != test.col || slice != test.slice {
+			t.Errorf("%s: parsed row %d, col %d, slice %d", filename, row,
+				col, slice)
+		}
+	}
+}
+
+func TestParseTileFilenameRejects(t *testing.T) {
+	paths := []string{
+		"stack/s/005.png",                    // no tiles directory
+		"tiles/1024/1/1/2/s/005.png",         // not full resolution
+		"tiles/1024/0/x/2/s/005.png",         // bad row
+		"tiles/1024/0/1/2/s/abc.png",         // bad slice
+		"tiles/1024/0/1/2/s/1234.png",        // missing slice directory
+		"tiles/1024/0/1/2/s/2000/1234.png",   // wrong slice directory
+		"tiles/1024/0/1/2/s/1000/1234/x.png", // too many elements
+	}
+	for _, path := range paths {
+		if _, _, _, err := ParseTileFilename(path); err == nil {
+			t.Errorf("%s: expected error", path)
+		}
+	}
+}
+
+func TestNearestBodyFinalLocation(t *testing.T) {
+	params := DefaultSyntheticStackParams
+	params.ZeroBorders = true
+	synth, err := CreateSyntheticStack(t.TempDir(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stack := &synth.BaseStack
+
+	// Move from inside superpixel 6, away from tile edges, up onto the
+	// zero border it shares with the superpixel above.
+	below := Superpixel{Slice: 2, Label: 6}
+	pt := synth.Center(below)
+	for synth.SuperpixelAt(pt).Label != 0 {
+		pt[1]--
+	}
+	above := synth.SuperpixelAt(Point3d{pt[0], pt[1] - 1, pt[2]})
+	if above.Label == 0 || synth.SpToBodyMap[above] ==
+		synth.SpToBodyMap[below] {
+		t.Fatalf("bad fixture: %v above %v", above, below)
+	}
+	tests := []struct {
+		name     string
+		exclude  Superpixel
+		expected Superpixel
+		dy       VoxelCoord
+	}{
+		{"above", below, above, -1},
+		{"below", above, below, 1},
+	}
+	for _, test := range tests {
+		exclude := BodySet{synth.SpToBodyMap[test.exclude]: true}
+		bodyId, superpixel, radius, location, err :=
+			GetNearestBodyOfLocationOpts(stack, pt, exclude, BodySet{},
+				DefaultNearestBodyOptions)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		// The first pixel found on the ring may be diagonal to pt.
+		dx := location[0] - pt[0]
+		if superpixel != test.expected || radius != 1 ||
+			bodyId != synth.SpToBodyMap[test.expected] ||
+			location[1] != pt[1]+test.dy || location[2] != pt[2] ||
+			dx < -1 || dx > 1 {
+			t.Errorf("%s: expected %v at y = %d, got %v (body %d) at %s "+
+				"radius %d", test.name, test.expected, pt[1]+test.dy,
+				superpixel, bodyId, location, radius)
+		}
+		if synth.SuperpixelAt(location) != superpixel {
+			t.Errorf("%s: location %s is not on superpixel %v", test.name,
+				location, superpixel)
+		}
+	}
+}
+
+func TestNearestBodyNeighboringSlices(t *testing.T) {
+	synth, err := CreateSyntheticStack(t.TempDir(), DefaultSyntheticStackParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An export that maps no superpixels in slices 1 to 3.
+	exportDir := t.TempDir()
+	spToBodyMap := make(SuperpixelToBodyMap)
+	for superpixel, bodyId := range synth.SpToBodyMap {
+		if superpixel.Slice == 0 {
+			spToBodyMap[superpixel] = bodyId
+		}
+	}
+	if err = spToBodyMap.WriteTxtMaps(exportDir); err != nil {
+		t.Fatal(err)
+	}
+	exported := CreateExportedStack(exportDir, synth.Directory)
+	exported.Base.Tiles = synth.Tiles
+
+	label := uint32(20)
+	tests := []struct {
+		z      VoxelCoord
+		opts   NearestBodyOptions
+		found  bool
+		radius int
+	}{
+		{1, NearestBodyOptions{MaxRadius: 6}, false, 0},
+		{1, NearestBodyOptions{MaxRadius: 6, SearchNeighboringSlices: true},
+			true, 1},
+		{2, NearestBodyOptions{MaxRadius: 6, SearchNeighboringSlices: true,
+			MaxSliceOffset: 1}, false, 0},
+		{2, NearestBodyOptions{MaxRadius: 6, SearchNeighboringSlices: true,
+			MaxSliceOffset: 2}, true, 2},
+		{3, NearestBodyOptions{MaxRadius: 6, SearchNeighboringSlices: true,
+			MaxSliceOffset: 3}, true, 3},
+	}
+	for _, test := range tests {
+		pt := synth.Center(Superpixel{Slice: uint32(test.z), Label: label})
+		bodyId, superpixel, radius, location, err :=
+			GetNearestBodyOfLocationOpts(exported, pt, BodySet{}, BodySet{},
+				test.opts)
+		if !test.found {
+			if err == nil || superpixel.Label != 0 {
+				t.Errorf("z %d, %+v: expected no body, got %v (%v)", test.z,
+					test.opts, superpixel, err)
+			}
+			continue
+		}
+		expected := Superpixel{Slice: 0, Label: label}
+		expectedLocation := Point3d{pt[0], pt[1], 0}
+		if err != nil || superpixel != expected ||
+			bodyId != synth.SpToBodyMap[expected] ||
+			radius != test.radius || location != expectedLocation {
+			t.Errorf("z %d, %+v: expected %v at %s radius %d, got %v "+
+				"(body %d) at %s radius %d (%v)", test.z, test.opts,
+				expected, expectedLocation, test.radius, superpixel, bodyId,
+				location, radius, err)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2012 HHMI.  All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//     * Neither the name of HHMI nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+// Author: katzw@janelia.hhmi.org (Bill Katz)
+//  Written as part of the FlyEM Project at Janelia Farm Research Center.
+
+package emdata
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Strictness determines how anomalies in input data are handled.
+type Strictness int
+
+const (
+	// Lenient collects anomalies as Warnings and continues processing.
+	Lenient Strictness = iota
+
+	// Strict stops at the first anomaly.  Functions that return errors
+	// return an *AnomalyError; others exit via log.Fatal.
+	Strict
+)
+
+// DefaultStrictness is the package-level setting honored by the map
+// readers, synapse readers, CreatePsdTracing, TransformBodies, and
+// OverlapAnalysis.
+var DefaultStrictness = Lenient
+
+// MaxWarningExamples is the maximum number of example messages kept
+// for each warning category.
+var MaxWarningExamples = 5
+
+func (strictness Strictness) String() string {
+	if strictness == Strict {
+		return "Strict"
+	}
+	return "Lenient"
+}
+
+// AnomalyError is returned in Strict mode for the first anomaly found.
+type AnomalyError struct {
+	Category string
+	Message  string
+}
+
+func (e *AnomalyError) Error() string {
+	return e.Category + ": " + e.Message
+}
+
+// Warnings accumulates anomalies by category, counting every anomaly
+// but storing only up to MaxWarningExamples messages per category.
+// The zero value is ready to use.
+type Warnings struct {
+	counts   map[string]int
+	examples map[string][]string
+}
+
+// Add records an anomaly in the given category.
+func (w *Warnings) Add(category string, format string, args ...interface{}) {
+	if w.counts == nil {
+		w.counts = make(map[string]int)
+		w.examples = make(map[string][]string)
+	}
+	w.counts[category]++
+	if len(w.examples[category]) < MaxWarningExamples {
+		w.examples[category] = append(w.examples[category],
+			fmt.Sprintf(format, args...))
+	}
+}
+
+// Merge adds all anomalies from other into these warnings.
+func (w *Warnings) Merge(other Warnings) {
+	for category, count := range other.counts {
+		if w.counts == nil {
+			w.counts = make(map[string]int)
+			w.examples = make(map[string][]string)
+		}
+		w.counts[category] += count
+		for _, example := range other.examples[category] {
+			if len(w.examples[category]) < MaxWarningExamples {
+				w.examples[category] = append(w.examples[category], example)
+			}
+		}
+	}
+}
+
+// Len returns the total number of anomalies recorded.
+func (w Warnings) Len() (total int) {
+	for _, count := range w.counts {
+		total += count
+	}
+	return
+}
+
+// Count returns the number of anomalies recorded for a category.
+func (w Warnings) Count(category string) int {
+	return w.counts[category]
+}
+
+// Examples returns the stored example messages for a category.
+func (w Warnings) Examples(category string) []string {
+	return w.examples[category]
+}
+
+// Categories returns the sorted categories with recorded anomalies.
+func (w Warnings) Categories() []string {
+	categories := make([]string, 0, len(w.counts))
+	for category, _ := range w.counts {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	return categories
+}
+
+// String returns a multi-line summary with counts and examples for
+// each category.
+func (w Warnings) String() string {
+	if w.Len() == 0 {
+		return "No warnings"
+	}
+	lines := []string{fmt.Sprintf("%d warnings:", w.Len())}
+	for _, category := range w.Categories() {
+		count := w.counts[category]
+		lines = append(lines, fmt.Sprintf("  %s: %d", category, count))
+		for _, example := range w.examples[category] {
+			lines = append(lines, "    "+example)
+		}
+		if count > len(w.examples[category]) {
+			lines = append(lines, fmt.Sprintf("    ... and %d more",
+				count-len(w.examples[category])))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Log prints the warnings summary if any anomalies were recorded.
+func (w Warnings) Log() {
+	if w.Len() > 0 {
+		log.Println("** " + w.String())
+	}
+}
+
+// Note handles an anomaly according to the strictness.  In Strict mode,
+// an *AnomalyError is returned.  In Lenient mode, the anomaly is added
+// to the warnings and nil is returned.
+func (strictness Strictness) Note(w *Warnings, category string,
+	format string, args ...interface{}) error {
+
+	if strictness == Strict {
+		return &AnomalyError{category, fmt.Sprintf(format, args...)}
+	}
+	w.Add(category, format, args...)
+	return nil
+}